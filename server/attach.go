@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/gotenberg"
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// attachCommandUsage is the usage of attach slash command.
+const attachCommandUsage = "usage: /topdf attach <post-id|permalink>"
+
+var (
+	// errAttachWatermarked returned when PDFs are requested to be attached while watermarking is
+	// enabled, attached PDFs are shared with the channel so they cannot carry a viewer's watermark.
+	errAttachWatermarked = errors.New("PDFs cannot be attached to posts while watermarking is enabled")
+
+	// errAttachNoFiles returned when a post has no attachments that can be converted to PDF.
+	errAttachNoFiles = errors.New("post has no attachments that can be converted to PDF")
+
+	// errAttachNotAllowed returned when a user cannot post to the channel of a post.
+	errAttachNotAllowed = errors.New("you cannot post to the channel of this post")
+
+	// errAttachPostNotFound returned when the post that PDFs requested to be attached to is not found.
+	errAttachPostNotFound = errors.New("post not found")
+)
+
+// executeAttachCommand executes attach slash command with params and returns its output.
+func (p *Plugin) executeAttachCommand(args *model.CommandArgs, params []string) string {
+	if len(params) != 1 {
+		return attachCommandUsage
+	}
+	ctx := context.Background()
+	log := p.logger(ctx).With("userId", args.UserId)
+	result, err := p.attachPDFs(ctx, p.load(), args.UserId, parsePostID(params[0]))
+	switch err {
+	case nil:
+	case errAttachWatermarked, errAttachNoFiles, errAttachNotAllowed, errAttachPostNotFound:
+		return err.Error()
+	default:
+		log.Error("cannot attach PDFs", "postId", params[0], "err", err.Error())
+		return "cannot attach PDFs: " + err.Error()
+	}
+	text := "No PDFs are posted to the thread"
+	if result.reply != nil {
+		log.Info("PDFs attached", "postId", result.reply.RootId, "replyId", result.reply.Id, "files", len(result.reply.FileIds))
+		text = fmt.Sprintf("PDFs of %d attachments are posted to the thread", len(result.reply.FileIds))
+	}
+	if len(result.failures) == 0 {
+		return text + "."
+	}
+	text += fmt.Sprintf(", %d attachments cannot be converted:\n", len(result.failures))
+	for _, f := range result.failures {
+		text += fmt.Sprintf("- %s: %s\n", f.name, f.err)
+	}
+	return text
+}
+
+// attachResult is the result of attaching PDFs to a post.
+type attachResult struct {
+	// reply is the reply posted with the PDFs, it's nil when none of the attachments can be
+	// converted.
+	reply *model.Post
+
+	// failures are the attachments that cannot be converted.
+	failures []attachFailure
+}
+
+// attachFailure is an attachment that cannot be converted to PDF.
+type attachFailure struct {
+	// name is the name of attachment.
+	name string
+
+	// err is the reason of failure.
+	err error
+}
+
+// attachPDFs converts the attachments of post with postID to PDFs for userID with comps and replies
+// in the thread of post with them by the bot.
+// PDFs are converted with the permissions of userID so cached ones are reused and the ones that
+// user cannot access are left out. infos of cached PDF files are copied for the bot since files
+// can only be attached to posts by their creators, copies share the content of cached PDFs so
+// deleting the reply doesn't affect the cache. cached PDFs are shared by files with the same
+// content, PDFs that are cached with the name of another file are uploaded again with the name
+// of attachment.
+func (p *Plugin) attachPDFs(ctx context.Context, comps *components, userID, postID string) (result attachResult, err error) {
+	if comps.watermark != nil {
+		return result, errAttachWatermarked
+	}
+	post, aerr := p.API.GetPost(postID)
+	if aerr != nil {
+		return result, errAttachPostNotFound
+	}
+	if !p.API.HasPermissionToChannel(userID, post.ChannelId, model.PERMISSION_CREATE_POST) {
+		return result, errAttachNotAllowed
+	}
+	var fileIDs []string
+	for _, fileID := range post.FileIds {
+		fileInfo, aerr := p.API.GetFileInfo(fileID)
+		if aerr != nil {
+			result.failures = append(result.failures, attachFailure{name: fileID, err: aerr})
+			continue
+		}
+		if !gotenberg.IsSupported(fileInfo.Extension) {
+			continue
+		}
+		pdfFileID, err := p.copyPDF(ctx, comps, userID, fileID, post.ChannelId)
+		if err != nil {
+			result.failures = append(result.failures, attachFailure{name: fileInfo.Name, err: err})
+			continue
+		}
+		fileIDs = append(fileIDs, pdfFileID)
+	}
+	if len(fileIDs) == 0 {
+		if len(result.failures) != 0 {
+			return result, nil
+		}
+		return result, errAttachNoFiles
+	}
+	rootID := post.RootId
+	if rootID == "" {
+		rootID = post.Id
+	}
+	result.reply, aerr = p.API.CreatePost(&model.Post{
+		UserId:    p.botUserID,
+		ChannelId: post.ChannelId,
+		RootId:    rootID,
+		Message:   "PDF versions of the attachments.",
+		FileIds:   fileIDs,
+	})
+	if aerr != nil {
+		return result, aerr
+	}
+	return result, nil
+}
+
+// copyPDF gets the cached PDF file of fileID for userID and copies its info for the bot. PDF is
+// uploaded to channelID first when the cached file is named after another file.
+func (p *Plugin) copyPDF(ctx context.Context, comps *components, userID, fileID, channelID string) (pdfFileID string, err error) {
+	file, err := comps.app.GetPDFFile(ctx, userID, fileID)
+	if err != nil {
+		return "", err
+	}
+	pdfFileID = file.ID
+	info, aerr := p.API.GetFileInfo(file.ID)
+	if aerr != nil {
+		return "", aerr
+	}
+	if info.Name != file.Name {
+		data, aerr := p.API.GetFile(file.ID)
+		if aerr != nil {
+			return "", aerr
+		}
+		// files uploaded by plugins have no creator so the upload is copied for the bot too.
+		info, aerr = p.API.UploadFile(data, channelID, file.Name)
+		if aerr != nil {
+			return "", aerr
+		}
+		pdfFileID = info.Id
+	}
+	ids, aerr := p.API.CopyFileInfos(p.botUserID, []string{pdfFileID})
+	if aerr != nil {
+		return "", aerr
+	}
+	return ids[0], nil
+}
+
+// parsePostID parses the id of a post from idOrPermalink, permalinks end with the id of post.
+func parsePostID(idOrPermalink string) string {
+	idOrPermalink = strings.TrimSuffix(idOrPermalink, "/")
+	return idOrPermalink[strings.LastIndex(idOrPermalink, "/")+1:]
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/watermark"
+	pMock "github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/mocks"
+	tMock "github.com/ilgooz/mattermost-plugin-topdf/server/x/xtopdf/mocks"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttachCommand(t *testing.T) {
+	topdfMock := &tMock.TOPDF{}
+	apiMock := &pMock.API{}
+	p := newTestPlugin(apiMock, &components{app: topdfMock})
+	p.botUserID = "bot-id"
+	apiMock.On("GetPost", "p2").Once().Return(&model.Post{Id: "p2", RootId: "p1", ChannelId: "c1", FileIds: []string{"f1", "f2", "f3", "f4"}}, nil)
+	apiMock.On("HasPermissionToChannel", "u1", "c1", model.PERMISSION_CREATE_POST).Once().Return(true)
+	apiMock.On("GetFileInfo", "f1").Once().Return(&model.FileInfo{Id: "f1", Name: "report.docx", Extension: "docx"}, nil)
+	apiMock.On("GetFileInfo", "f2").Once().Return(&model.FileInfo{Id: "f2", Name: "photo.png", Extension: "png"}, nil)
+	apiMock.On("GetFileInfo", "f3").Once().Return(&model.FileInfo{Id: "f3", Name: "plan.xlsx", Extension: "xlsx"}, nil)
+	apiMock.On("GetFileInfo", "f4").Once().Return(&model.FileInfo{Id: "f4", Name: "copy.docx", Extension: "docx"}, nil)
+	// PDFs are served from cache or converted by the app with the permissions of user.
+	topdfMock.On("GetPDFFile", mock.Anything, "u1", "f1").Once().Return(&topdf.PDFFile{ID: "pdf1", Name: "report.pdf"}, nil)
+	topdfMock.On("GetPDFFile", mock.Anything, "u1", "f3").Once().Return(nil, topdf.ErrUnauthorizedUser)
+	topdfMock.On("GetPDFFile", mock.Anything, "u1", "f4").Once().Return(&topdf.PDFFile{ID: "pdf1", Name: "copy.pdf"}, nil)
+	apiMock.On("GetFileInfo", "pdf1").Twice().Return(&model.FileInfo{Id: "pdf1", Name: "report.pdf"}, nil)
+	// cached PDFs are copied for the bot instead of being uploaded again when they have the
+	// names of attachments.
+	apiMock.On("CopyFileInfos", "bot-id", []string{"pdf1"}).Once().Return([]string{"pdf2"}, nil)
+	// the same content is cached with the name of another attachment.
+	apiMock.On("GetFile", "pdf1").Once().Return([]byte{3}, nil)
+	apiMock.On("UploadFile", []byte{3}, "c1", "copy.pdf").Once().Return(&model.FileInfo{Id: "pdf3"}, nil)
+	apiMock.On("CopyFileInfos", "bot-id", []string{"pdf3"}).Once().Return([]string{"pdf4"}, nil)
+	apiMock.On("CreatePost", &model.Post{
+		UserId:    "bot-id",
+		ChannelId: "c1",
+		RootId:    "p1",
+		Message:   "PDF versions of the attachments.",
+		FileIds:   []string{"pdf2", "pdf4"},
+	}).Once().Return(&model.Post{Id: "r1", RootId: "p1", FileIds: []string{"pdf2", "pdf4"}}, nil)
+	apiMock.On("LogInfo", "PDFs attached", "userId", "u1", "postId", "p1", "replyId", "r1", "files", 2).Once()
+
+	resp, aerr := p.ExecuteCommand(nil, &model.CommandArgs{UserId: "u1", Command: "/topdf attach https://mattermost.example.com/team/pl/p2"})
+	require.Nil(t, aerr)
+	require.Equal(t, "PDFs of 2 attachments are posted to the thread, 1 attachments cannot be converted:\n"+
+		"- plan.xlsx: user is not authorized to access pdf\n", resp.Text)
+	topdfMock.AssertExpectations(t)
+	apiMock.AssertExpectations(t)
+}
+
+func TestAttachCommandErrors(t *testing.T) {
+	topdfMock := &tMock.TOPDF{}
+	apiMock := &pMock.API{}
+	p := newTestPlugin(apiMock, &components{app: topdfMock})
+	apiMock.On("GetPost", "missing").Once().Return(nil, &model.AppError{})
+	apiMock.On("GetPost", "p1").Return(&model.Post{Id: "p1", ChannelId: "c1", FileIds: []string{"f1"}}, nil)
+	apiMock.On("HasPermissionToChannel", "u1", "c1", model.PERMISSION_CREATE_POST).Return(true)
+	apiMock.On("HasPermissionToChannel", "u2", "c1", model.PERMISSION_CREATE_POST).Return(false)
+	apiMock.On("GetFileInfo", "f1").Return(&model.FileInfo{Id: "f1", Name: "photo.png", Extension: "png"}, nil)
+
+	for _, tt := range []struct {
+		userID, command, text string
+	}{
+		{"u1", "/topdf attach", attachCommandUsage},
+		{"u1", "/topdf attach missing", errAttachPostNotFound.Error()},
+		{"u2", "/topdf attach p1", errAttachNotAllowed.Error()},
+		{"u1", "/topdf attach p1", errAttachNoFiles.Error()},
+	} {
+		resp, _ := p.ExecuteCommand(nil, &model.CommandArgs{UserId: tt.userID, Command: tt.command})
+		require.Equal(t, tt.text, resp.Text, tt.command)
+	}
+
+	// PDFs with viewers' watermarks cannot be shared with the channel.
+	p.load().watermark = watermark.New()
+	resp, _ := p.ExecuteCommand(nil, &model.CommandArgs{UserId: "u1", Command: "/topdf attach p1"})
+	require.Equal(t, errAttachWatermarked.Error(), resp.Text)
+	topdfMock.AssertExpectations(t)
+	apiMock.AssertExpectations(t)
+}
+
+func TestParsePostID(t *testing.T) {
+	require.Equal(t, "p1", parsePostID("p1"))
+	require.Equal(t, "p1", parsePostID("https://mattermost.example.com/team/pl/p1/"))
+}
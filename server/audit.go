@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/audit"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xhttp"
+	"github.com/mattermost/mattermost-server/model"
+)
+
+const (
+	// defaultAuditLimit is the default number of audit events listed.
+	defaultAuditLimit = 100
+
+	// maxAuditLimit is the maximum number of audit events that can be listed at once.
+	maxAuditLimit = 1000
+
+	// defaultAuditCommandLimit is the default number of audit events listed by slash command.
+	defaultAuditCommandLimit = 20
+)
+
+// errForbidden returned when a non-admin user tries to access admin only features.
+var errForbidden = errors.New("only system admins can access this resource")
+
+// auditResponse is audit events response sent to client.
+type auditResponse struct {
+	Events []audit.Event `json:"events"`
+}
+
+// isAdmin checks if userID is a system admin.
+func (p *Plugin) isAdmin(userID string) bool {
+	return userID != "" && p.API.HasPermissionTo(userID, model.PERMISSION_MANAGE_SYSTEM)
+}
+
+// handleAudit handles audit event list requests.
+// events can be filtered with userId, fileId, channelId, outcome, since and until query params
+// where since and until are in milliseconds since epoch. limit sets the maximum number of events.
+func (p *Plugin) handleAudit(w http.ResponseWriter, r *http.Request) {
+	if !p.isAdmin(r.Header.Get("Mattermost-User-Id")) {
+		xhttp.ResponseJSON(w, http.StatusForbidden, createErrorResponse(errForbidden))
+		return
+	}
+	q := r.URL.Query()
+	filter := audit.Filter{
+		UserID:    q.Get("userId"),
+		FileID:    q.Get("fileId"),
+		ChannelID: q.Get("channelId"),
+		Outcome:   q.Get("outcome"),
+		Limit:     defaultAuditLimit,
+	}
+	for _, param := range []struct {
+		name  string
+		value *int64
+	}{
+		{"since", &filter.Since},
+		{"until", &filter.Until},
+	} {
+		if v := q.Get(param.name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				xhttp.ResponseJSON(w, http.StatusBadRequest, createErrorResponse(fmt.Errorf("invalid %s", param.name)))
+				return
+			}
+			*param.value = n
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxAuditLimit {
+			xhttp.ResponseJSON(w, http.StatusBadRequest, createErrorResponse(fmt.Errorf("limit must be between 1 and %d", maxAuditLimit)))
+			return
+		}
+		filter.Limit = n
+	}
+	events, err := p.load().audit.Query(filter)
+	if err != nil {
+		xhttp.ResponseJSON(w, http.StatusInternalServerError, createErrorResponse(err))
+		p.logError(r.Context(), "cannot query audit log", err)
+		return
+	}
+	xhttp.ResponseJSON(w, http.StatusOK, auditResponse{Events: events})
+}
+
+// executeAuditCommand executes `/topdf audit` command and returns its output.
+func (p *Plugin) executeAuditCommand(args *model.CommandArgs, params []string) string {
+	if !p.isAdmin(args.UserId) {
+		return errForbidden.Error()
+	}
+	filter := audit.Filter{Limit: defaultAuditCommandLimit}
+	for key, value := range parseCommandParams(params) {
+		switch key {
+		case "user":
+			if strings.HasPrefix(value, "@") {
+				user, aerr := p.API.GetUserByUsername(strings.TrimPrefix(value, "@"))
+				if aerr != nil {
+					return fmt.Sprintf("user %s not found", value)
+				}
+				value = user.Id
+			}
+			filter.UserID = value
+		case "file":
+			filter.FileID = value
+		case "channel":
+			filter.ChannelID = value
+		case "outcome":
+			filter.Outcome = value
+		case "since":
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Sprintf("invalid since %q, it must be a duration like 24h", value)
+			}
+			filter.Since = model.GetMillis() - int64(d/time.Millisecond)
+		case "limit":
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 || n > maxAuditLimit {
+				return fmt.Sprintf("limit must be between 1 and %d", maxAuditLimit)
+			}
+			filter.Limit = n
+		default:
+			return fmt.Sprintf("unknown parameter %q\n%s", key, commandHelp)
+		}
+	}
+	events, err := p.load().audit.Query(filter)
+	if err != nil {
+		p.logError(context.Background(), "cannot query audit log", err)
+		return "cannot query audit log: " + err.Error()
+	}
+	if len(events) == 0 {
+		return "no audit events found"
+	}
+	var b strings.Builder
+	b.WriteString("| Time | User | File | Channel | Cache | Outcome | Client IP |\n")
+	b.WriteString("|---|---|---|---|---|---|---|\n")
+	for _, e := range events {
+		cache := "miss"
+		if e.CacheHit {
+			cache = "hit"
+		}
+		outcome := e.Outcome
+		if e.Error != "" {
+			outcome += ": " + e.Error
+		}
+		fmt.Fprintf(&b, "| %s | %s | %s | %s | %s | %s | %s |\n",
+			time.Unix(0, e.Time*int64(time.Millisecond)).UTC().Format(time.RFC3339),
+			e.UserID, e.FileID, e.ChannelID, cache, outcome, e.ClientIP)
+	}
+	return b.String()
+}
@@ -0,0 +1,433 @@
+// Package audit keeps a rolling log of audit events in Mattermost's KV store.
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin"
+)
+
+const (
+	// keyPrefix used as a prefix for all audit keys in KV store.
+	keyPrefix = "audit:"
+
+	// shards is the number of shards that events are recorded into. each Log records into one of
+	// them so plugin instances on different nodes of a cluster mostly don't contend on the same keys.
+	shards = 16
+
+	// legacyShard is the shard of events that recorded before the log is sharded, it's only queried.
+	legacyShard = -1
+
+	// bucketSize is the number of events kept in each bucket.
+	bucketSize = 100
+
+	// defaultMaxBuckets is the default number of buckets kept in each shard before the oldest
+	// ones are dropped.
+	defaultMaxBuckets = 100
+
+	// queueSize is the maximum number of events waiting to be recorded, events are dropped when
+	// KV store cannot keep up.
+	queueSize = 4 * bucketSize
+
+	// maxRetries is the number of attempts made to record events when they're
+	// concurrently modified by other plugin instances.
+	maxRetries = 10
+)
+
+// errConflict returned when log modified concurrently while recording an event.
+var errConflict = errors.New("audit log is modified concurrently")
+
+// errQueueFull returned when an event cannot be queued to be recorded.
+var errQueueFull = errors.New("audit queue is full")
+
+// Outcomes of accesses.
+const (
+	// OutcomeSuccess is the outcome of accesses that successfully served.
+	OutcomeSuccess = "success"
+
+	// OutcomeUnauthorized is the outcome of accesses that rejected by permission checks.
+	OutcomeUnauthorized = "unauthorized"
+
+	// OutcomeRateLimited is the outcome of accesses that rejected by rate limits.
+	OutcomeRateLimited = "rate_limited"
+
+	// OutcomeFailure is the outcome of accesses that failed because of other errors.
+	OutcomeFailure = "failure"
+)
+
+// Event is an audit event.
+type Event struct {
+	// Time is the time of event in milliseconds since epoch.
+	Time int64 `json:"time"`
+
+	// UserID is the user who accessed to file, it's empty for anonymous accesses through public
+	// links.
+	UserID string `json:"userId"`
+
+	// PublicLink is true when file accessed through its public link.
+	PublicLink bool `json:"publicLink,omitempty"`
+
+	// FileID is the accessed file.
+	FileID string `json:"fileId"`
+
+	// ChannelID is the channel where file posted in.
+	// it's empty when access failed before file's channel is known.
+	ChannelID string `json:"channelId,omitempty"`
+
+	// CacheHit is true when the PDF served from cache.
+	CacheHit bool `json:"cacheHit"`
+
+	// Outcome is the outcome of access.
+	Outcome string `json:"outcome"`
+
+	// Error is the error message of failed accesses.
+	Error string `json:"error,omitempty"`
+
+	// ClientIP is the IP address of client.
+	ClientIP string `json:"clientIp,omitempty"`
+}
+
+// Filter filters audit events.
+// zero valued fields match with any event.
+type Filter struct {
+	UserID    string
+	FileID    string
+	ChannelID string
+	Outcome   string
+
+	// Since and Until are the time range of events in milliseconds since epoch.
+	Since int64
+	Until int64
+
+	// Limit is the maximum number of events to return.
+	Limit int
+}
+
+// match checks if e matches with the filter.
+func (f Filter) match(e Event) bool {
+	return (f.UserID == "" || f.UserID == e.UserID) &&
+		(f.FileID == "" || f.FileID == e.FileID) &&
+		(f.ChannelID == "" || f.ChannelID == e.ChannelID) &&
+		(f.Outcome == "" || f.Outcome == e.Outcome) &&
+		(f.Since == 0 || e.Time >= f.Since) &&
+		(f.Until == 0 || e.Time <= f.Until)
+}
+
+// meta keeps the range of buckets that currently exist in the log.
+type meta struct {
+	// Head is the bucket that new events are added to.
+	Head int `json:"head"`
+
+	// Tail is the oldest bucket.
+	Tail int `json:"tail"`
+}
+
+// Log is a rolling audit log.
+// events are recorded in background into a shard of the log where they're kept in fixed size
+// buckets and oldest buckets are dropped as new ones created. all modifications are made with
+// compare-and-set so shards can be shared by plugin instances running on different nodes of a
+// cluster, events of all shards are merged while they're queried.
+type Log struct {
+	// mapi is Mattermost's Plugin API.
+	mapi plugin.API
+
+	// shard is the shard that events are recorded into, it's picked with a seeded source since
+	// nodes would all pick the same shard with the unseeded global one.
+	shard int
+
+	// maxBuckets is the number of buckets kept in each shard.
+	maxBuckets int
+
+	// logEvents enables emitting events as server log lines.
+	logEvents bool
+
+	queue  chan Event
+	done   chan struct{}
+	closed chan struct{}
+
+	// mu guards isClosed, events are only queued while the log is not closed.
+	mu       sync.RWMutex
+	isClosed bool
+}
+
+// New creates a new audit Log with mapi and options and starts recording events in background.
+// it should be closed once it's no longer used.
+func New(mapi plugin.API, options ...Option) *Log {
+	l := &Log{
+		mapi:       mapi,
+		shard:      rand.New(rand.NewSource(time.Now().UnixNano())).Intn(shards),
+		maxBuckets: defaultMaxBuckets,
+		queue:      make(chan Event, queueSize),
+		done:       make(chan struct{}),
+		closed:     make(chan struct{}),
+	}
+	for _, o := range options {
+		o(l)
+	}
+	go l.run()
+	return l
+}
+
+// Option used to customize Log defaults.
+type Option func(*Log)
+
+// MaxEventsOption sets the approximate number of events kept in each shard of the log.
+func MaxEventsOption(n int) Option {
+	return func(l *Log) {
+		l.maxBuckets = (n + bucketSize - 1) / bucketSize
+		if l.maxBuckets < 1 {
+			l.maxBuckets = 1
+		}
+	}
+}
+
+// LogEventsOption enables emitting events as structured server log lines.
+func LogEventsOption(enabled bool) Option {
+	return func(l *Log) {
+		l.logEvents = enabled
+	}
+}
+
+// Record queues e to be recorded into the log. errQueueFull is returned when e is dropped since
+// too many events are waiting to be recorded. events are recorded right away once l is closed.
+func (l *Log) Record(e Event) error {
+	if e.Time == 0 {
+		e.Time = model.GetMillis()
+	}
+	if l.logEvents {
+		l.mapi.LogInfo("topdf audit",
+			"user_id", e.UserID,
+			"file_id", e.FileID,
+			"channel_id", e.ChannelID,
+			"cache_hit", e.CacheHit,
+			"outcome", e.Outcome,
+			"error", e.Error,
+			"client_ip", e.ClientIP,
+		)
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.isClosed {
+		return l.write([]Event{e})
+	}
+	select {
+	case l.queue <- e:
+		return nil
+	default:
+		return errQueueFull
+	}
+}
+
+// Close records the queued events and stops recording in background.
+func (l *Log) Close() {
+	l.mu.Lock()
+	if !l.isClosed {
+		l.isClosed = true
+		close(l.done)
+	}
+	l.mu.Unlock()
+	<-l.closed
+}
+
+// run records queued events in batches until l is closed.
+func (l *Log) run() {
+	defer close(l.closed)
+	for {
+		select {
+		case e := <-l.queue:
+			l.flush(e)
+		case <-l.done:
+			for {
+				select {
+				case e := <-l.queue:
+					l.flush(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush records e together with the events that are already queued, up to a bucket of them.
+// events that cannot be recorded are logged since they're lost.
+func (l *Log) flush(e Event) {
+	batch := []Event{e}
+	for drained := false; !drained && len(batch) < bucketSize; {
+		select {
+		case e := <-l.queue:
+			batch = append(batch, e)
+		default:
+			drained = true
+		}
+	}
+	if err := l.write(batch); err != nil {
+		l.mapi.LogError("cannot record audit events, they're dropped", "events", len(batch), "err", err.Error())
+	}
+}
+
+// write appends events to the head bucket of l's shard.
+func (l *Log) write(events []Event) error {
+	for conflicts := 0; len(events) > 0; {
+		n, err := l.append(events)
+		if err == errConflict {
+			if conflicts++; conflicts >= maxRetries {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		events, conflicts = events[n:], 0
+	}
+	return nil
+}
+
+// append tries to append events to the head bucket once and returns the number of appended ones.
+func (l *Log) append(events []Event) (n int, err error) {
+	m, rawMeta, err := l.meta(l.shard)
+	if err != nil {
+		return 0, err
+	}
+	bucket, rawBucket, err := l.bucket(l.shard, m.Head)
+	if err != nil {
+		return 0, err
+	}
+	// head bucket is full, start a new one and drop the oldest buckets.
+	if len(bucket) >= bucketSize {
+		next := meta{Head: m.Head + 1, Tail: m.Tail}
+		if next.Head-next.Tail >= l.maxBuckets {
+			next.Tail = next.Head - l.maxBuckets + 1
+		}
+		if err := l.compareAndSet(metaKey(l.shard), rawMeta, next); err != nil {
+			return 0, err
+		}
+		for b := m.Tail; b < next.Tail; b++ {
+			if aerr := l.mapi.KVDelete(bucketKey(l.shard, b)); aerr != nil {
+				return 0, aerr
+			}
+		}
+		// retry to append events to the new head bucket.
+		return 0, errConflict
+	}
+	n = bucketSize - len(bucket)
+	if n > len(events) {
+		n = len(events)
+	}
+	return n, l.compareAndSet(bucketKey(l.shard, m.Head), rawBucket, append(bucket, events[:n]...))
+}
+
+// Query returns events that match with f, newest first.
+func (l *Log) Query(f Filter) ([]Event, error) {
+	events := []Event{}
+	for shard := legacyShard; shard < shards; shard++ {
+		matched, err := l.query(shard, f)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, matched...)
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time > events[j].Time })
+	if f.Limit > 0 && len(events) > f.Limit {
+		events = events[:f.Limit]
+	}
+	return events, nil
+}
+
+// query returns events of shard that match with f, newest first.
+func (l *Log) query(shard int, f Filter) ([]Event, error) {
+	m, _, err := l.meta(shard)
+	if err != nil {
+		return nil, err
+	}
+	var events []Event
+	for b := m.Head; b >= m.Tail; b-- {
+		bucket, _, err := l.bucket(shard, b)
+		if err != nil {
+			return nil, err
+		}
+		for i := len(bucket) - 1; i >= 0; i-- {
+			if !f.match(bucket[i]) {
+				continue
+			}
+			events = append(events, bucket[i])
+			if f.Limit > 0 && len(events) >= f.Limit {
+				return events, nil
+			}
+		}
+	}
+	return events, nil
+}
+
+// meta gets the metadata of shard together with its raw value.
+func (l *Log) meta(shard int) (m meta, raw []byte, err error) {
+	raw, aerr := l.mapi.KVGet(metaKey(shard))
+	if aerr != nil {
+		return m, nil, aerr
+	}
+	if len(raw) == 0 {
+		return m, nil, nil
+	}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return m, nil, err
+	}
+	return m, raw, nil
+}
+
+// bucket gets the events in bucket b of shard together with its raw value.
+func (l *Log) bucket(shard, b int) (events []Event, raw []byte, err error) {
+	raw, aerr := l.mapi.KVGet(bucketKey(shard, b))
+	if aerr != nil {
+		return nil, nil, aerr
+	}
+	if len(raw) == 0 {
+		return nil, nil, nil
+	}
+	if err := json.Unmarshal(raw, &events); err != nil {
+		return nil, nil, err
+	}
+	return events, raw, nil
+}
+
+// compareAndSet sets key to v in JSON when its current value is old.
+// errConflict is returned when current value is changed.
+func (l *Log) compareAndSet(key string, old []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	ok, aerr := l.mapi.KVCompareAndSet(key, old, data)
+	if aerr != nil {
+		return aerr
+	}
+	if !ok {
+		return errConflict
+	}
+	return nil
+}
+
+// shardPrefix builds the prefix of KV keys of shard.
+func shardPrefix(shard int) string {
+	if shard == legacyShard {
+		return keyPrefix
+	}
+	return fmt.Sprintf("%s%d:", keyPrefix, shard)
+}
+
+// metaKey builds the KV key of the metadata of shard.
+func metaKey(shard int) string {
+	return shardPrefix(shard) + "meta"
+}
+
+// bucketKey builds the KV key of bucket b of shard.
+func bucketKey(shard, b int) string {
+	return fmt.Sprintf("%sbucket:%d", shardPrefix(shard), b)
+}
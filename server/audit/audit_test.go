@@ -0,0 +1,136 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/memkv"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordAndQuery(t *testing.T) {
+	l := New(memkv.New())
+	require.NoError(t, l.Record(Event{Time: 1, UserID: "u1", FileID: "f1", Outcome: OutcomeSuccess}))
+	require.NoError(t, l.Record(Event{Time: 2, UserID: "u2", FileID: "f1", Outcome: OutcomeUnauthorized}))
+	require.NoError(t, l.Record(Event{Time: 3, UserID: "u1", FileID: "f2", Outcome: OutcomeSuccess, CacheHit: true}))
+	// queued events are recorded once the log is closed.
+	l.Close()
+
+	events, err := l.Query(Filter{})
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	require.Equal(t, int64(3), events[0].Time)
+
+	events, err = l.Query(Filter{UserID: "u1"})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+
+	events, err = l.Query(Filter{FileID: "f1", Outcome: OutcomeUnauthorized})
+	require.NoError(t, err)
+	require.Equal(t, []Event{{Time: 2, UserID: "u2", FileID: "f1", Outcome: OutcomeUnauthorized}}, events)
+
+	events, err = l.Query(Filter{Since: 2, Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.Equal(t, int64(3), events[0].Time)
+}
+
+func TestRolling(t *testing.T) {
+	api := memkv.New()
+	l := New(api, MaxEventsOption(bucketSize*2))
+	total := bucketSize*3 + 10
+	for i := 1; i <= total; i++ {
+		require.NoError(t, l.Record(Event{Time: int64(i), FileID: fmt.Sprint(i)}))
+	}
+	l.Close()
+	events, err := l.Query(Filter{})
+	require.NoError(t, err)
+	// only the latest two buckets are kept.
+	require.Len(t, events, bucketSize+10)
+	require.Equal(t, int64(total), events[0].Time)
+	require.Equal(t, int64(bucketSize*2+1), events[len(events)-1].Time)
+	keys, _ := api.KVList(0, 100)
+	require.ElementsMatch(t, []string{bucketKey(l.shard, 2), bucketKey(l.shard, 3), metaKey(l.shard)}, keys)
+}
+
+func TestRecordLogsEvents(t *testing.T) {
+	api := memkv.New()
+	api.On("LogInfo", "topdf audit", "user_id", "u1", "file_id", "f1", "channel_id", "c1",
+		"cache_hit", true, "outcome", OutcomeSuccess, "error", "", "client_ip", "10.0.0.1").Once()
+	l := New(api, LogEventsOption(true))
+	require.NoError(t, l.Record(Event{UserID: "u1", FileID: "f1", ChannelID: "c1", CacheHit: true,
+		Outcome: OutcomeSuccess, ClientIP: "10.0.0.1"}))
+	l.Close()
+	api.AssertExpectations(t)
+	events, err := l.Query(Filter{})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.NotZero(t, events[0].Time)
+}
+
+func TestRecordConflict(t *testing.T) {
+	api := memkv.New()
+	l := New(api)
+	require.NoError(t, l.write([]Event{{Time: 1}}))
+	// simulate another node that keeps modifying the bucket.
+	conflicting := &conflictingAPI{API: api}
+	conflicting.On("KVCompareAndSet", mock.Anything, mock.Anything, mock.Anything).Return(false, nil)
+	l.mapi = conflicting
+	require.Equal(t, errConflict, l.write([]Event{{Time: 2}}))
+	conflicting.AssertNumberOfCalls(t, "KVCompareAndSet", maxRetries)
+}
+
+func TestRecordDropped(t *testing.T) {
+	api := memkv.New()
+	conflicting := &conflictingAPI{API: api}
+	conflicting.On("KVCompareAndSet", mock.Anything, mock.Anything, mock.Anything).Return(false, nil)
+	api.On("LogError", "cannot record audit events, they're dropped", "events", 2, "err", errConflict.Error()).Once()
+	l := New(conflicting)
+	require.NoError(t, l.Record(Event{Time: 1}))
+	require.NoError(t, l.Record(Event{Time: 2}))
+	l.Close()
+	api.AssertExpectations(t)
+}
+
+func TestRecordQueueFull(t *testing.T) {
+	// events are not recorded in background so the queue is never drained.
+	l := &Log{mapi: memkv.New(), queue: make(chan Event, 1)}
+	require.NoError(t, l.Record(Event{Time: 1}))
+	require.Equal(t, errQueueFull, l.Record(Event{Time: 2}))
+}
+
+func TestQueryShards(t *testing.T) {
+	api := memkv.New()
+	a := New(api)
+	a.shard = 0
+	b := New(api)
+	b.shard = 1
+	a.Close()
+	b.Close()
+	// events of a, b and the ones recorded before sharding are merged.
+	require.NoError(t, a.Record(Event{Time: 1, UserID: "u1"}))
+	require.NoError(t, b.Record(Event{Time: 2, UserID: "u1"}))
+	require.NoError(t, a.Record(Event{Time: 4, UserID: "u2"}))
+	legacy, _ := json.Marshal([]Event{{Time: 3, UserID: "u1"}})
+	api.KVSet("audit:bucket:0", legacy)
+	api.KVSet("audit:meta", []byte(`{"head":0,"tail":0}`))
+
+	events, err := a.Query(Filter{})
+	require.NoError(t, err)
+	require.Equal(t, []Event{{Time: 4, UserID: "u2"}, {Time: 3, UserID: "u1"}, {Time: 2, UserID: "u1"}, {Time: 1, UserID: "u1"}}, events)
+	events, err = b.Query(Filter{UserID: "u1", Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, []Event{{Time: 3, UserID: "u1"}, {Time: 2, UserID: "u1"}}, events)
+}
+
+// conflictingAPI fails every compare-and-set.
+type conflictingAPI struct {
+	*memkv.API
+}
+
+func (a *conflictingAPI) KVCompareAndSet(key string, oldValue, newValue []byte) (bool, *model.AppError) {
+	return a.API.API.KVCompareAndSet(key, oldValue, newValue)
+}
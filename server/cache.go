@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xhttp"
+	"github.com/mattermost/mattermost-server/model"
+)
+
+const (
+	// cacheCommandUsage is the usage of cache slash command.
+	cacheCommandUsage = "usage: /topdf cache invalidate"
+
+	// defaultCachePerPage is the default number of cache entries listed in a page.
+	defaultCachePerPage = 60
+
+	// maxCachePerPage is the maximum number of cache entries that can be listed in a page.
+	maxCachePerPage = 200
+)
+
+// cacheEntriesResponse is the cache entry list response sent to admins.
+type cacheEntriesResponse struct {
+	Entries []topdf.CachedPDF `json:"entries"`
+
+	// Totals are the totals of entries that match with the filters in all pages.
+	Totals topdf.CacheTotals `json:"totals"`
+}
+
+// verifyCacheEntryResponse is the verification result of a cache entry.
+type verifyCacheEntryResponse struct {
+	Key   string `json:"key"`
+	Valid bool   `json:"valid"`
+	Pages int    `json:"pages,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// executeCacheCommand executes cache slash command with params and returns its output.
+func (p *Plugin) executeCacheCommand(args *model.CommandArgs, params []string) string {
+	if !p.isAdmin(args.UserId) {
+		return errForbidden.Error()
+	}
+	if len(params) != 1 || params[0] != "invalidate" {
+		return cacheCommandUsage
+	}
+	generation, err := p.load().app.InvalidateCache()
+	if err != nil {
+		p.API.LogError("cannot invalidate PDF cache", "err", err.Error())
+		return "cannot invalidate PDF cache: " + err.Error()
+	}
+	p.API.LogInfo("PDF cache invalidated", "userId", args.UserId, "generation", generation)
+	return fmt.Sprintf("PDF cache invalidated, files will be converted again when they're accessed next time (cache generation %d).", generation)
+}
+
+// handleCacheEntries handles cache entry list requests.
+// entries can be filtered with channelId, extension, since and until query params where since and
+// until are in milliseconds since epoch. they're listed from the newest to oldest in pages that
+// selected with page and perPage.
+func (p *Plugin) handleCacheEntries(w http.ResponseWriter, r *http.Request) {
+	if !p.isAdmin(r.Header.Get("Mattermost-User-Id")) {
+		xhttp.ResponseJSON(w, http.StatusForbidden, createErrorResponse(errForbidden))
+		return
+	}
+	q := r.URL.Query()
+	filter := topdf.CacheFilter{
+		ChannelID: q.Get("channelId"),
+		Extension: q.Get("extension"),
+	}
+	for _, param := range []struct {
+		name  string
+		value *int64
+	}{
+		{"since", &filter.Since},
+		{"until", &filter.Until},
+	} {
+		if v := q.Get(param.name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				xhttp.ResponseJSON(w, http.StatusBadRequest, createErrorResponse(fmt.Errorf("invalid %s", param.name)))
+				return
+			}
+			*param.value = n
+		}
+	}
+	page, perPage := 0, defaultCachePerPage
+	if v := q.Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			xhttp.ResponseJSON(w, http.StatusBadRequest, createErrorResponse(errors.New("invalid page")))
+			return
+		}
+		page = n
+	}
+	if v := q.Get("perPage"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxCachePerPage {
+			xhttp.ResponseJSON(w, http.StatusBadRequest, createErrorResponse(fmt.Errorf("perPage must be between 1 and %d", maxCachePerPage)))
+			return
+		}
+		perPage = n
+	}
+	entries, totals, err := p.load().app.CacheEntries(filter, page, perPage)
+	if err != nil {
+		xhttp.ResponseJSON(w, http.StatusInternalServerError, createErrorResponse(err))
+		p.logError(r.Context(), "cannot list PDF cache entries", err)
+		return
+	}
+	resp := cacheEntriesResponse{Entries: entries, Totals: totals}
+	xhttp.ResponseJSON(w, http.StatusOK, resp)
+}
+
+// handleCacheEntry handles requests to get the metadata of cache entries.
+func (p *Plugin) handleCacheEntry(w http.ResponseWriter, r *http.Request) {
+	if !p.isAdmin(r.Header.Get("Mattermost-User-Id")) {
+		xhttp.ResponseJSON(w, http.StatusForbidden, createErrorResponse(errForbidden))
+		return
+	}
+	entry, err := p.load().app.GetCacheEntry(mux.Vars(r)["key"])
+	if err != nil {
+		p.respondCacheError(w, r, err)
+		return
+	}
+	xhttp.ResponseJSON(w, http.StatusOK, entry)
+}
+
+// handleVerifyCacheEntry handles verification requests of cache entries.
+// broken entries are not errors, they're reported in the response.
+func (p *Plugin) handleVerifyCacheEntry(w http.ResponseWriter, r *http.Request) {
+	if !p.isAdmin(r.Header.Get("Mattermost-User-Id")) {
+		xhttp.ResponseJSON(w, http.StatusForbidden, createErrorResponse(errForbidden))
+		return
+	}
+	key := mux.Vars(r)["key"]
+	pages, err := p.load().app.VerifyCacheEntry(key)
+	switch err.(type) {
+	case nil:
+		xhttp.ResponseJSON(w, http.StatusOK, verifyCacheEntryResponse{Key: key, Valid: true, Pages: pages})
+	case *topdf.BrokenCacheEntry:
+		xhttp.ResponseJSON(w, http.StatusOK, verifyCacheEntryResponse{Key: key, Error: err.Error()})
+	default:
+		p.respondCacheError(w, r, err)
+	}
+}
+
+// handleDeleteCacheEntry handles deletion requests of cache entries.
+func (p *Plugin) handleDeleteCacheEntry(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if !p.isAdmin(userID) {
+		xhttp.ResponseJSON(w, http.StatusForbidden, createErrorResponse(errForbidden))
+		return
+	}
+	key := mux.Vars(r)["key"]
+	if err := p.load().app.DeleteCacheEntry(key); err != nil {
+		p.respondCacheError(w, r, err)
+		return
+	}
+	p.logger(r.Context()).Info("PDF cache entry deleted", "key", key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// respondCacheError responds to r with err that returned while accessing a cache entry.
+func (p *Plugin) respondCacheError(w http.ResponseWriter, r *http.Request, err error) {
+	if err == topdf.ErrCacheEntryNotFound {
+		xhttp.ResponseJSON(w, http.StatusNotFound, createErrorResponse(err))
+		return
+	}
+	xhttp.ResponseJSON(w, http.StatusInternalServerError, createErrorResponse(err))
+	p.logError(r.Context(), "cannot access PDF cache entry", err, "key", mux.Vars(r)["key"])
+}
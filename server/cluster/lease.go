@@ -0,0 +1,141 @@
+// Package cluster coordinates plugin instances that run on nodes of a Mattermost cluster.
+//
+// Mattermost's Plugin API that this plugin is built against does not expose cluster events, so
+// nodes share their state through the KV store instead: cache generation, jobs and warm-ups are
+// read from KV store on each use, configuration changes are already delivered to every node by
+// Mattermost itself and scheduled work is run by a single node that holds a Lease.
+package cluster
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/mattermost/mattermost-server/plugin"
+)
+
+const (
+	// keyPrefix used as a prefix for lease keys in KV store.
+	keyPrefix = "cluster:lease:"
+
+	// defaultTTL is the default duration that a lease is held without being renewed.
+	defaultTTL = 90 * time.Second
+)
+
+// Lease is a KV store based lease that elects a single node of a cluster.
+// the node that holds it should renew it before its TTL passes, otherwise another node takes it over.
+type Lease struct {
+	// mapi is Mattermost's Plugin API.
+	mapi plugin.API
+
+	// key is the KV key of lease.
+	key string
+
+	// nodeID identifies the node that acquires the lease.
+	nodeID string
+
+	// ttl is the duration that lease is held without being renewed.
+	ttl time.Duration
+
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+// holder is the node holding a lease.
+type holder struct {
+	// NodeID identifies the node.
+	NodeID string `json:"nodeId"`
+
+	// ExpiresAt is the time in milliseconds since epoch when lease expires unless it's renewed.
+	ExpiresAt int64 `json:"expiresAt"`
+}
+
+// Option used to customize Lease defaults.
+type Option func(*Lease)
+
+// TTLOption sets the duration that lease is held without being renewed.
+func TTLOption(ttl time.Duration) Option {
+	return func(l *Lease) {
+		l.ttl = ttl
+	}
+}
+
+// NewLease creates a new lease with name for the node with nodeID by using mapi.
+func NewLease(mapi plugin.API, name, nodeID string, options ...Option) *Lease {
+	l := &Lease{
+		mapi:   mapi,
+		key:    keyPrefix + name,
+		nodeID: nodeID,
+		ttl:    defaultTTL,
+		now:    time.Now,
+	}
+	for _, o := range options {
+		o(l)
+	}
+	return l
+}
+
+// Acquire acquires the lease or renews it if it's already held by this node.
+// acquired is false when another node holds the lease.
+func (l *Lease) Acquire() (acquired bool, err error) {
+	current, aerr := l.mapi.KVGet(l.key)
+	if aerr != nil {
+		return false, aerr
+	}
+	now := l.now()
+	if len(current) != 0 {
+		var h holder
+		if err := json.Unmarshal(current, &h); err != nil {
+			return false, err
+		}
+		if h.NodeID != l.nodeID && h.ExpiresAt > millis(now) {
+			return false, nil
+		}
+	} else {
+		// a nil old value requires the key to be missing.
+		current = nil
+	}
+	data, err := json.Marshal(holder{NodeID: l.nodeID, ExpiresAt: millis(now.Add(l.ttl))})
+	if err != nil {
+		return false, err
+	}
+	// another node may acquire the lease at the same time, only one of them can swap it.
+	acquired, aerr = l.mapi.KVCompareAndSet(l.key, current, data)
+	if aerr != nil {
+		return false, aerr
+	}
+	return acquired, nil
+}
+
+// Release releases the lease if it's held by this node so another node can take it over
+// without waiting it to expire.
+func (l *Lease) Release() error {
+	current, aerr := l.mapi.KVGet(l.key)
+	if aerr != nil {
+		return aerr
+	}
+	if len(current) == 0 {
+		return nil
+	}
+	var h holder
+	if err := json.Unmarshal(current, &h); err != nil {
+		return err
+	}
+	if h.NodeID != l.nodeID {
+		return nil
+	}
+	// an expired holder is saved instead of deleting the key, so the lease is released only if
+	// it's not taken over in the meantime.
+	data, err := json.Marshal(holder{})
+	if err != nil {
+		return err
+	}
+	if _, aerr := l.mapi.KVCompareAndSet(l.key, current, data); aerr != nil {
+		return aerr
+	}
+	return nil
+}
+
+// millis converts t to milliseconds since epoch.
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
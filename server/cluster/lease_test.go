@@ -0,0 +1,70 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/memkv"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestLease creates a lease for nodeID with a fake clock.
+func newTestLease(api *memkv.API, nodeID string, now *time.Time) *Lease {
+	l := NewLease(api, "test", nodeID, TTLOption(time.Minute))
+	l.now = func() time.Time { return *now }
+	return l
+}
+
+func TestLease(t *testing.T) {
+	api := memkv.New()
+	now := time.Unix(1000, 0)
+	a := newTestLease(api, "a", &now)
+	b := newTestLease(api, "b", &now)
+
+	acquired, err := a.Acquire()
+	require.NoError(t, err)
+	require.True(t, acquired)
+	acquired, err = b.Acquire()
+	require.NoError(t, err)
+	require.False(t, acquired)
+
+	// holder renews the lease.
+	now = now.Add(50 * time.Second)
+	acquired, err = a.Acquire()
+	require.NoError(t, err)
+	require.True(t, acquired)
+	now = now.Add(50 * time.Second)
+	acquired, err = b.Acquire()
+	require.NoError(t, err)
+	require.False(t, acquired)
+
+	// lease is taken over once it expires.
+	now = now.Add(time.Minute)
+	acquired, err = b.Acquire()
+	require.NoError(t, err)
+	require.True(t, acquired)
+	acquired, err = a.Acquire()
+	require.NoError(t, err)
+	require.False(t, acquired)
+}
+
+func TestLeaseRelease(t *testing.T) {
+	api := memkv.New()
+	now := time.Unix(1000, 0)
+	a := newTestLease(api, "a", &now)
+	b := newTestLease(api, "b", &now)
+
+	acquired, err := a.Acquire()
+	require.NoError(t, err)
+	require.True(t, acquired)
+	// only the holder can release the lease.
+	require.NoError(t, b.Release())
+	acquired, err = b.Acquire()
+	require.NoError(t, err)
+	require.False(t, acquired)
+
+	require.NoError(t, a.Release())
+	acquired, err = b.Acquire()
+	require.NoError(t, err)
+	require.True(t, acquired)
+}
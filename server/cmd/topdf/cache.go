@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf"
+)
+
+const (
+	// pluginID is the id of TOPDF plugin that its API is served under.
+	pluginID = "topdf"
+
+	// entriesPerPage is the number of cache entries fetched at once, it's the maximum allowed by
+	// the plugin.
+	entriesPerPage = 200
+)
+
+// errBrokenEntries returned when some of the verified cache entries are broken.
+var errBrokenEntries = errors.New("some cache entries are broken")
+
+// client is a client for the cache API of TOPDF plugin.
+type client struct {
+	// url is the base URL of plugin's API.
+	url string
+
+	// token is the access token of a system admin.
+	token string
+
+	// http is the underlying HTTP client.
+	http *http.Client
+}
+
+// newClient creates a new client for the TOPDF plugin of Mattermost server at serverURL.
+func newClient(serverURL, token string) *client {
+	return &client{
+		url:   strings.TrimSuffix(serverURL, "/") + "/plugins/" + pluginID,
+		token: token,
+		http:  &http.Client{Timeout: time.Minute},
+	}
+}
+
+// verification is the verification result of a cache entry.
+type verification struct {
+	Key   string `json:"key"`
+	Valid bool   `json:"valid"`
+	Pages int    `json:"pages"`
+	Error string `json:"error"`
+}
+
+// entries lists all cache entries by walking through their pages.
+func (c *client) entries() (entries []topdf.CachedPDF, err error) {
+	for page := 0; ; page++ {
+		var resp struct {
+			Entries []topdf.CachedPDF `json:"entries"`
+		}
+		if err := c.do("GET", fmt.Sprintf("/admin/cache?page=%d&perPage=%d", page, entriesPerPage), &resp); err != nil {
+			return nil, err
+		}
+		entries = append(entries, resp.Entries...)
+		if len(resp.Entries) < entriesPerPage {
+			return entries, nil
+		}
+	}
+}
+
+// verify verifies the cache entry with key.
+func (c *client) verify(key string) (v verification, err error) {
+	err = c.do("GET", "/admin/cache/"+url.PathEscape(key)+"/verify", &v)
+	return v, err
+}
+
+// delete deletes the cache entry with key.
+func (c *client) delete(key string) error {
+	return c.do("DELETE", "/admin/cache/"+url.PathEscape(key), nil)
+}
+
+// do makes a request with method to path and decodes the response body into out when it's not nil.
+func (c *client) do(method, path string, out interface{}) error {
+	req, err := http.NewRequest(method, c.url+path, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error.Message != "" {
+			return fmt.Errorf("%s %s: %s", method, path, e.Error.Message)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// runCache runs cache commands with args.
+func runCache(args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no cache command given\n%s", usage)
+	}
+	command := args[0]
+	flags := flag.NewFlagSet("cache "+command, flag.ContinueOnError)
+	var (
+		serverURL = flags.String("url", "", "URL of Mattermost server")
+		token     = flags.String("token", "", "access token of a system admin")
+		all       = flags.Bool("all", false, "purge all cache entries")
+	)
+	flags.SetOutput(w)
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *serverURL == "" || *token == "" {
+		return errors.New("-url and -token flags are required")
+	}
+	c := newClient(*serverURL, *token)
+	keys := flags.Args()
+	switch command {
+	case "list":
+		return listCache(c, w)
+	case "verify":
+		return verifyCache(c, keys, w)
+	case "purge":
+		if len(keys) == 0 && !*all {
+			return errors.New("give keys of cache entries to purge or -all to purge all of them")
+		}
+		return purgeCache(c, keys, w)
+	default:
+		return fmt.Errorf("unknown cache command %q\n%s", command, usage)
+	}
+}
+
+// listCache writes cache entries to w as a table.
+func listCache(c *client, w io.Writer) error {
+	entries, err := c.entries()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tSOURCE FILE\tPDF FILE\tBACKEND\tGENERATION\tCREATED AT")
+	for _, e := range entries {
+		created := "-"
+		if e.CreatedAt != 0 {
+			created = time.Unix(0, e.CreatedAt*int64(time.Millisecond)).UTC().Format(time.RFC3339)
+		}
+		backend := e.Backend
+		if e.Legacy {
+			backend = "(legacy)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%s\n", e.Key, e.SourceFileID, e.PDFFileID, backend, e.Generation, created)
+	}
+	return tw.Flush()
+}
+
+// verifyCache verifies cache entries with keys, or all of them when keys is empty, and reports
+// the results to w.
+func verifyCache(c *client, keys []string, w io.Writer) error {
+	keys, err := keysOrAll(c, keys)
+	if err != nil {
+		return err
+	}
+	broken := false
+	for _, key := range keys {
+		v, err := c.verify(key)
+		if err != nil {
+			return err
+		}
+		if !v.Valid {
+			broken = true
+			fmt.Fprintf(w, "BROKEN %s: %s\n", key, v.Error)
+			continue
+		}
+		fmt.Fprintf(w, "OK     %s: %d pages\n", key, v.Pages)
+	}
+	if broken {
+		return errBrokenEntries
+	}
+	return nil
+}
+
+// purgeCache deletes cache entries with keys, or all of them when keys is empty.
+func purgeCache(c *client, keys []string, w io.Writer) error {
+	keys, err := keysOrAll(c, keys)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := c.delete(key); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "deleted %s\n", key)
+	}
+	return nil
+}
+
+// keysOrAll returns keys as is or the keys of all cache entries when it's empty.
+func keysOrAll(c *client, keys []string) ([]string, error) {
+	if len(keys) != 0 {
+		return keys, nil
+	}
+	entries, err := c.entries()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		keys = append(keys, e.Key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// newTestServer creates a fake Mattermost server that serves the cache API of plugin
+// with a broken and a valid entry.
+func newTestServer(t *testing.T, deleted *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == "GET" && r.URL.Path == "/plugins/topdf/admin/cache":
+			w.Write([]byte(`{"entries":[{"key":"pdf:2:abc","pdfFileId":"2","sourceFileId":"1","backend":"Gotenberg","generation":1,"createdAt":1000},{"key":"pdf:3","legacy":true,"pdfFileId":"4","sourceFileId":"3"}]}`))
+		case r.Method == "GET" && r.URL.Path == "/plugins/topdf/admin/cache/pdf:2:abc/verify":
+			w.Write([]byte(`{"key":"pdf:2:abc","valid":true,"pages":3}`))
+		case r.Method == "GET" && r.URL.Path == "/plugins/topdf/admin/cache/pdf:3/verify":
+			w.Write([]byte(`{"key":"pdf:3","valid":false,"error":"cache entry pdf:3 is broken: malformed"}`))
+		case r.Method == "DELETE" && r.URL.Path == "/plugins/topdf/admin/cache/pdf:3":
+			*deleted = append(*deleted, "pdf:3")
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"message":"cache entry not found"}}`))
+		}
+	}))
+}
+
+func TestCacheCommands(t *testing.T) {
+	var deleted []string
+	server := newTestServer(t, &deleted)
+	defer server.Close()
+	flags := []string{"-url", server.URL + "/", "-token", "token"}
+
+	var w bytes.Buffer
+	require.NoError(t, run(append([]string{"cache", "list"}, flags...), &w))
+	require.Contains(t, w.String(), "pdf:2:abc  1            2         Gotenberg  1           1970-01-01T00:00:01Z")
+	require.Contains(t, w.String(), "pdf:3      3            4         (legacy)   0           -")
+
+	w.Reset()
+	require.Equal(t, errBrokenEntries, run(append([]string{"cache", "verify"}, flags...), &w))
+	require.Equal(t, "OK     pdf:2:abc: 3 pages\nBROKEN pdf:3: cache entry pdf:3 is broken: malformed\n", w.String())
+
+	w.Reset()
+	require.Error(t, run(append([]string{"cache", "purge"}, flags...), &w))
+	require.NoError(t, run(append([]string{"cache", "purge"}, append(flags, "pdf:3")...), &w))
+	require.Equal(t, []string{"pdf:3"}, deleted)
+	require.EqualError(t, run(append([]string{"cache", "purge"}, append(flags, "pdf:5")...), &w), "DELETE /admin/cache/pdf:5: cache entry not found")
+
+	require.EqualError(t, run([]string{"cache", "list", "-url", server.URL, "-token", "wrong"}, &w), "GET /admin/cache?page=0&perPage=200: 401 Unauthorized")
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/gotenberg"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver/resilient"
+)
+
+// errConversionsFailed returned when some of the files cannot be converted.
+var errConversionsFailed = errors.New("some files cannot be converted")
+
+// converter converts local files to PDFs.
+type converter struct {
+	// server is the PDF server that converts files.
+	server pdfserver.Server
+
+	// options are the conversion options.
+	options pdfserver.ConvertOptions
+
+	// out is the directory that PDFs saved to, PDFs are saved next to their sources when empty.
+	out string
+
+	// w is where conversion results are reported to.
+	w io.Writer
+}
+
+// runConvert runs convert command with args.
+func runConvert(args []string, w io.Writer) error {
+	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	var (
+		addr      = flags.String("gotenberg", "http://localhost:4798", "full address of Gotenberg server")
+		timeout   = flags.Duration("timeout", 10*time.Minute, "timeout of each conversion")
+		out       = flags.String("out", "", "directory to save PDFs to, PDFs are saved next to their sources by default")
+		landscape = flags.Bool("landscape", false, "use landscape orientation for pages")
+		pages     = flags.String("pages", "", "page ranges to include like 1-3,5, all pages are included by default")
+	)
+	flags.SetOutput(w)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errors.New("no files given to convert")
+	}
+	options := pdfserver.ConvertOptions{
+		Landscape:  *landscape,
+		PageRanges: *pages,
+	}
+	if err := options.Validate(); err != nil {
+		return err
+	}
+	server := resilient.New("Gotenberg", gotenberg.New(*addr, gotenberg.ConvertTimeoutOption(*timeout)))
+	if err := server.Status(context.Background()); err != nil {
+		return err
+	}
+	c := &converter{server: server, options: options, out: *out, w: w}
+	return c.convertPaths(flags.Args())
+}
+
+// convertPaths converts files in paths, directories are walked recursively and only the
+// supported files in them are converted.
+func (c *converter) convertPaths(paths []string) error {
+	failed := false
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			if !c.convertFile(path, filepath.Base(path)) {
+				failed = true
+			}
+			continue
+		}
+		err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() || !gotenberg.IsSupported(extension(file)) {
+				return nil
+			}
+			rel, err := filepath.Rel(path, file)
+			if err != nil {
+				return err
+			}
+			if !c.convertFile(file, rel) {
+				failed = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if failed {
+		return errConversionsFailed
+	}
+	return nil
+}
+
+// convertFile converts file to PDF and saves it with rel path under the output directory.
+// failures are reported and ok is false when file cannot be converted.
+func (c *converter) convertFile(file, rel string) (ok bool) {
+	dst, err := c.convert(file, rel)
+	if err != nil {
+		fmt.Fprintf(c.w, "FAIL %s: %s\n", file, err)
+		return false
+	}
+	fmt.Fprintf(c.w, "OK   %s -> %s\n", file, dst)
+	return true
+}
+
+// convert converts file to PDF and returns the path of saved PDF.
+func (c *converter) convert(file, rel string) (dst string, err error) {
+	src, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	pdf, err := c.server.Convert(context.Background(), filepath.Base(file), extension(file), src, c.options)
+	if err != nil {
+		return "", err
+	}
+	defer pdf.Close()
+	dst = strings.TrimSuffix(file, filepath.Ext(file)) + ".pdf"
+	if c.out != "" {
+		dst = filepath.Join(c.out, strings.TrimSuffix(rel, filepath.Ext(rel))+".pdf")
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return "", err
+		}
+	}
+	f, err := os.Create(dst)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(f, pdf); err != nil {
+		f.Close()
+		return "", err
+	}
+	return dst, f.Close()
+}
+
+// extension gets the extension of file without the dot like Mattermost does.
+func extension(file string) string {
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(file), "."))
+}
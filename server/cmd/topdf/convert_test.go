@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver"
+	sMock "github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver/mocks"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertPaths(t *testing.T) {
+	dir, err := ioutil.TempDir("", "topdf")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	require.NoError(t, os.MkdirAll(filepath.Join(src, "sub"), 0755))
+	for name, data := range map[string]string{
+		"a.docx":      "a",
+		"sub/b.XLSX":  "b",
+		"sub/c.txt":   "c",
+		"broken.pptx": "d",
+	} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(src, name), []byte(data), 0644))
+	}
+	serverMock := &sMock.Server{}
+	options := pdfserver.ConvertOptions{Landscape: true}
+	serverMock.On("Convert", mock.Anything, "a.docx", "docx", mock.Anything, options).Once().Return(ioutil.NopCloser(bytes.NewReader([]byte("pdf a"))), nil)
+	serverMock.On("Convert", mock.Anything, "b.XLSX", "xlsx", mock.Anything, options).Once().Return(ioutil.NopCloser(bytes.NewReader([]byte("pdf b"))), nil)
+	serverMock.On("Convert", mock.Anything, "broken.pptx", "pptx", mock.Anything, options).Once().Return(nil, errors.New("cannot convert"))
+	out := filepath.Join(dir, "out")
+	var w bytes.Buffer
+	c := &converter{server: serverMock, options: options, out: out, w: &w}
+
+	require.Equal(t, errConversionsFailed, c.convertPaths([]string{src}))
+	data, err := ioutil.ReadFile(filepath.Join(out, "a.pdf"))
+	require.NoError(t, err)
+	require.Equal(t, "pdf a", string(data))
+	data, err = ioutil.ReadFile(filepath.Join(out, "sub", "b.pdf"))
+	require.NoError(t, err)
+	require.Equal(t, "pdf b", string(data))
+	require.Contains(t, w.String(), "FAIL "+filepath.Join(src, "broken.pptx")+": cannot convert")
+
+	// single files are saved next to their sources and converted even if their types are unknown.
+	serverMock.On("Convert", mock.Anything, "c.txt", "txt", mock.Anything, options).Once().Return(ioutil.NopCloser(bytes.NewReader([]byte("pdf c"))), nil)
+	c.out = ""
+	require.NoError(t, c.convertPaths([]string{filepath.Join(src, "sub", "c.txt")}))
+	data, err = ioutil.ReadFile(filepath.Join(src, "sub", "c.pdf"))
+	require.NoError(t, err)
+	require.Equal(t, "pdf c", string(data))
+	serverMock.AssertExpectations(t)
+}
+
+func TestRunConvertValidatesOptions(t *testing.T) {
+	err := run([]string{"convert", "-pages", "1-", "a.docx"}, ioutil.Discard)
+	require.EqualError(t, err, `invalid page ranges "1-", it must be like 1-3,5`)
+	require.Error(t, run([]string{"convert"}, ioutil.Discard))
+}
@@ -0,0 +1,50 @@
+// Command topdf converts local Office files to PDFs with a Gotenberg server and manages the PDF
+// cache of TOPDF plugin on a Mattermost server.
+//
+// usage:
+//
+//	topdf convert [-gotenberg addr] [-out dir] [-landscape] <file or directory>...
+//	topdf cache list|verify|purge -url <mattermost url> -token <access token> [key]...
+//
+// cache commands require a personal access token or session token of a system admin.
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// usage is the usage of topdf command.
+const usage = `usage:
+  topdf convert [flags] <file or directory>...   converts files to PDFs
+  topdf cache list [flags]                        lists cached PDFs
+  topdf cache verify [flags] [key]...             verifies cached PDFs, all of them when no key is given
+  topdf cache purge [flags] -all | key...         deletes cache entries
+
+run 'topdf <command> -h' to see flags of a command.`
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "topdf:", err)
+		os.Exit(1)
+	}
+}
+
+// run runs topdf command with args and writes its output to w.
+func run(args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no command given\n%s", usage)
+	}
+	switch args[0] {
+	case "convert":
+		return runConvert(args[1:], w)
+	case "cache":
+		return runCache(args[1:], w)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprintln(w, usage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin"
+)
+
+// commandTrigger is the trigger of Plugin's slash command.
+const commandTrigger = "topdf"
+
+// botUsername is the username of Plugin's bot that posts on behalf of Plugin.
+const botUsername = "topdf"
+
+// commandHelp is the help text of Plugin's slash command.
+const commandHelp = "###### TOPDF slash command\n" +
+	"- `/topdf attach <post-id|permalink>` converts the attachments of a post and replies in its thread with their PDFs. not available while watermarking is enabled.\n" +
+	"- `/topdf audit [user=@username] [file=<file-id>] [channel=<channel-id>] [outcome=success|unauthorized|rate_limited|failure] [since=24h] [limit=20]` lists audit events of PDF accesses. only available to system admins.\n" +
+	"- `/topdf cache invalidate` invalidates all cached PDFs so files are converted again when they're accessed next time. only available to system admins.\n" +
+	"- `/topdf warmup start [team=<team-name>,...] [channel=<channel-id>,...] [since=720h] [until=0h]` converts attachments of posts created in the given period in background, so they're served from cache. public channels of teams and the given channels are walked. only available to system admins.\n" +
+	"- `/topdf warmup status|stop|resume` shows the progress of last warm-up, stops it or resumes it from where it's stopped. only available to system admins.\n"
+
+// OnActivate hook ensures Plugin's bot, registers its slash command and starts scheduled work.
+func (p *Plugin) OnActivate() error {
+	botUserID, err := p.Helpers.EnsureBot(&model.Bot{
+		Username:    botUsername,
+		DisplayName: "TOPDF",
+		Description: "Posts PDF versions of attachments.",
+	})
+	if err != nil {
+		return fmt.Errorf("cannot ensure bot: %s", err)
+	}
+	p.botUserID = botUserID
+	err = p.API.RegisterCommand(&model.Command{
+		Trigger:          commandTrigger,
+		DisplayName:      "TOPDF",
+		Description:      "Manage TOPDF plugin.",
+		AutoComplete:     true,
+		AutoCompleteDesc: "Available commands: attach, audit, cache, warmup, help",
+		AutoCompleteHint: "[command]",
+	})
+	if err != nil {
+		return err
+	}
+	p.startScheduler()
+	return nil
+}
+
+// ExecuteCommand hook executes Plugin's slash command.
+func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
+	// first field is the trigger itself, second one is the sub command and rest are its params.
+	fields := strings.Fields(args.Command)
+	if len(fields) < 2 {
+		return ephemeralResponse(commandHelp), nil
+	}
+	if p.load() == nil {
+		return ephemeralResponse(errNotConfigured.Error()), nil
+	}
+	switch fields[1] {
+	case "attach":
+		return ephemeralResponse(p.executeAttachCommand(args, fields[2:])), nil
+	case "audit":
+		return ephemeralResponse(p.executeAuditCommand(args, fields[2:])), nil
+	case "cache":
+		return ephemeralResponse(p.executeCacheCommand(args, fields[2:])), nil
+	case "warmup":
+		return ephemeralResponse(p.executeWarmUpCommand(args, fields[2:])), nil
+	default:
+		return ephemeralResponse(commandHelp), nil
+	}
+}
+
+// parseCommandParams parses key=value params of a command.
+func parseCommandParams(params []string) map[string]string {
+	m := make(map[string]string)
+	for _, param := range params {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) == 2 {
+			m[kv[0]] = kv[1]
+		} else {
+			m[kv[0]] = ""
+		}
+	}
+	return m
+}
+
+// ephemeralResponse creates a command response that only visible to user who executed the command.
+func ephemeralResponse(text string) *model.CommandResponse {
+	return &model.CommandResponse{
+		ResponseType: model.COMMAND_RESPONSE_TYPE_EPHEMERAL,
+		Text:         text,
+	}
+}
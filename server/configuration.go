@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/signedurl"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/watermark"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xhttp"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xtime"
+)
+
+// configuration holds Plugin's config.
+// see plugin.json at root for more info about all configurations.
+type configuration struct {
+	GotenbergAddress string
+	// GotenbergConvertTimeout is the timeout of conversions, zero uses the default.
+	GotenbergConvertTimeout xtime.Duration
+	// GotenbergWebhookURL is the address of Mattermost that Gotenberg delivers PDFs to,
+	// empty disables webhook mode.
+	GotenbergWebhookURL string
+	// GotenbergVersion is the version of Gotenberg, PDFs are converted again when it's changed.
+	GotenbergVersion  string
+	WatermarkEnabled  bool
+	WatermarkText     string
+	WatermarkOpacity  string
+	WatermarkPosition string
+	AuditLogServerLog bool
+	// rate limits are number of conversions allowed per minute, empty or zero is unlimited.
+	RateLimitUserPerMinute   string
+	RateLimitGlobalPerMinute string
+	// WarmUpConversionsPerMinute throttles warm-ups, empty or zero uses the default rate.
+	WarmUpConversionsPerMinute string
+	// limits are in MB and pages, empty or zero is unlimited.
+	MaxSourceSizeMB string
+	MaxPDFSizeMB    string
+	MaxPages        string
+	LimitOverrides  string
+	// convert options, empty values keep defaults of Gotenberg.
+	Landscape              bool
+	PageRanges             string
+	ConvertOptionOverrides string
+	// sanitizing removes unsafe content from PDFs before they're cached.
+	SanitizeEnabled       bool
+	SanitizeExternalLinks bool
+	// OutageAlertAfter is the duration that PDF server should be unreachable before system admins
+	// are alerted with direct messages, zero disables alerts.
+	OutageAlertAfter xtime.Duration
+	// DebugLogging logs each stage of requests at debug level.
+	DebugLogging bool
+	// TracingOTLPEndpoint is the address of OpenTelemetry collector that spans are exported to,
+	// empty disables tracing.
+	TracingOTLPEndpoint string
+	// SignedURLKey is the key that preview URLs are signed with, empty disables signed URLs.
+	SignedURLKey string
+	// CORS settings are comma separated lists, the site URL is allowed in addition to origins
+	// and empty methods and headers use the defaults.
+	CORSAllowedOrigins string
+	CORSAllowedMethods string
+	CORSAllowedHeaders string
+	// TrustedProxies are the comma separated addresses or CIDR ranges of reverse proxies that
+	// client addresses are taken from X-Forwarded-For and X-Real-IP headers for, empty ignores
+	// the headers.
+	TrustedProxies string
+}
+
+// validate checks if c is a valid configuration and returns a descriptive error if it's not.
+func (c configuration) validate() error {
+	if err := validateURL("Gotenberg address", c.GotenbergAddress); err != nil {
+		return err
+	}
+	if c.GotenbergWebhookURL != "" {
+		if err := validateURL("Gotenberg webhook URL", c.GotenbergWebhookURL); err != nil {
+			return err
+		}
+	}
+	if c.TracingOTLPEndpoint != "" {
+		if err := validateURL("tracing OTLP endpoint", c.TracingOTLPEndpoint); err != nil {
+			return err
+		}
+	}
+	if c.SignedURLKey != "" && len(c.SignedURLKey) < signedurl.MinKeyLength {
+		return fmt.Errorf("signed URL key must be at least %d characters long", signedurl.MinKeyLength)
+	}
+	if _, err := parseCORSOrigins(c.CORSAllowedOrigins); err != nil {
+		return err
+	}
+	if _, err := xhttp.ParseCIDRs(splitList(c.TrustedProxies)); err != nil {
+		return fmt.Errorf("invalid trusted proxies: %s", err)
+	}
+	if c.GotenbergConvertTimeout < 0 {
+		return errors.New("file convert timeout cannot be negative")
+	}
+	if c.OutageAlertAfter < 0 {
+		return errors.New("outage alert duration cannot be negative")
+	}
+	if _, err := parseRateLimit("user", c.RateLimitUserPerMinute); err != nil {
+		return err
+	}
+	if _, err := parseRateLimit("global", c.RateLimitGlobalPerMinute); err != nil {
+		return err
+	}
+	if _, err := parseRateLimit("warm-up", c.WarmUpConversionsPerMinute); err != nil {
+		return err
+	}
+	if _, _, err := parseLimits(c); err != nil {
+		return err
+	}
+	if _, _, err := parseConvertOptions(c); err != nil {
+		return err
+	}
+	if c.WatermarkEnabled {
+		opacity, err := strconv.ParseFloat(c.WatermarkOpacity, 64)
+		if err != nil || opacity < 0 || opacity > 1 {
+			return fmt.Errorf("invalid watermark opacity %q, it must be between 0 and 1", c.WatermarkOpacity)
+		}
+		if !watermark.IsValidPosition(watermark.Position(c.WatermarkPosition)) {
+			return fmt.Errorf("invalid watermark position %q", c.WatermarkPosition)
+		}
+	}
+	return nil
+}
+
+// validateURL checks if value of the named setting is an http or https URL.
+func validateURL(name, value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return fmt.Errorf("invalid %s %q: %s", name, value, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid %s %q, it must start with http:// or https://", name, value)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid %s %q, host is missing", name, value)
+	}
+	return nil
+}
+
+// override is a line of per file extension overrides of settings.
+type override struct {
+	// line is the line number of override.
+	line int
+
+	// extensions are the file extensions that override applies to.
+	extensions []string
+
+	// params are the overridden settings.
+	params map[string]string
+}
+
+// parseOverrides parses per file extension overrides of the named setting from text.
+// each line of text is in `ext1,ext2: key1=value1 key2=value2` format as shown in example,
+// empty lines and the ones start with # are ignored.
+func parseOverrides(text, name, example string) (overrides []override, err error) {
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid %s at line %d, it must be in `%s` format", name, i+1, example)
+		}
+		o := override{line: i + 1, params: parseCommandParams(strings.Fields(parts[1]))}
+		for _, ext := range strings.Split(parts[0], ",") {
+			if ext = strings.TrimPrefix(strings.TrimSpace(ext), "."); ext != "" {
+				o.extensions = append(o.extensions, ext)
+			}
+		}
+		overrides = append(overrides, o)
+	}
+	return overrides, nil
+}
+
+// parseRateLimit parses a per minute rate limit config, empty value means unlimited.
+func parseRateLimit(name, value string) (perMinute int, err error) {
+	if value == "" {
+		return 0, nil
+	}
+	perMinute, err = strconv.Atoi(value)
+	if err != nil || perMinute < 0 {
+		return 0, fmt.Errorf("invalid %s rate limit %q, it must be a non-negative integer", name, value)
+	}
+	return perMinute, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/memkv"
+	pMock "github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/mocks"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xtime"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// validConfiguration returns a configuration that passes validation.
+func validConfiguration() configuration {
+	return configuration{
+		GotenbergAddress:        "http://localhost:3000",
+		GotenbergConvertTimeout: xtime.Duration(time.Minute),
+	}
+}
+
+func TestConfigurationValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *configuration)
+		err    string
+	}{
+		{"valid", func(c *configuration) {}, ""},
+		{"https", func(c *configuration) { c.GotenbergAddress = "https://gotenberg.example.com" }, ""},
+		{"no scheme", func(c *configuration) { c.GotenbergAddress = "localhost:3000" },
+			`invalid Gotenberg address "localhost:3000", it must start with http:// or https://`},
+		{"wrong scheme", func(c *configuration) { c.GotenbergAddress = "ftp://localhost" },
+			`invalid Gotenberg address "ftp://localhost", it must start with http:// or https://`},
+		{"no host", func(c *configuration) { c.GotenbergAddress = "http://" },
+			`invalid Gotenberg address "http://", host is missing`},
+		{"zero timeout", func(c *configuration) { c.GotenbergConvertTimeout = 0 }, ""},
+		{"negative timeout", func(c *configuration) { c.GotenbergConvertTimeout = -1 },
+			"file convert timeout cannot be negative"},
+		{"negative outage alert", func(c *configuration) { c.OutageAlertAfter = -1 },
+			"outage alert duration cannot be negative"},
+		{"invalid rate limit", func(c *configuration) { c.RateLimitUserPerMinute = "x" },
+			`invalid user rate limit "x", it must be a non-negative integer`},
+		{"invalid warm-up rate", func(c *configuration) { c.WarmUpConversionsPerMinute = "-2" },
+			`invalid warm-up rate limit "-2", it must be a non-negative integer`},
+		{"invalid limit", func(c *configuration) { c.MaxPages = "-1" },
+			`invalid pages limit "-1", it must be a non-negative integer`},
+		{"watermark disabled", func(c *configuration) { c.WatermarkOpacity = "2" }, ""},
+		{"invalid tracing endpoint", func(c *configuration) { c.TracingOTLPEndpoint = "localhost:4318" },
+			`invalid tracing OTLP endpoint "localhost:4318", it must start with http:// or https://`},
+		{"short signed URL key", func(c *configuration) { c.SignedURLKey = "secret" },
+			"signed URL key must be at least 32 characters long"},
+		{"CORS origins", func(c *configuration) { c.CORSAllowedOrigins = "https://a.example.com, https://*.example.org/" }, ""},
+		{"all CORS origins", func(c *configuration) { c.CORSAllowedOrigins = "https://a.example.com,*" },
+			"allowing all CORS origins with `*` is not supported, list the origins instead"},
+		{"trusted proxies", func(c *configuration) { c.TrustedProxies = "10.0.0.0/8, 192.168.1.10" }, ""},
+		{"invalid trusted proxy", func(c *configuration) { c.TrustedProxies = "10.0.0.0/8, proxy" },
+			"invalid trusted proxies: invalid IP address: proxy"},
+		{"CORS origin with path", func(c *configuration) { c.CORSAllowedOrigins = "https://a.example.com/app" },
+			`invalid CORS origin "https://a.example.com/app", it must only have a scheme and host`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfiguration()
+			tt.modify(&c)
+			err := c.validate()
+			if tt.err == "" {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseRateLimit(t *testing.T) {
+	n, err := parseRateLimit("user", "")
+	require.NoError(t, err)
+	require.Zero(t, n)
+	n, err = parseRateLimit("user", "30")
+	require.NoError(t, err)
+	require.Equal(t, 30, n)
+	_, err = parseRateLimit("global", "-1")
+	require.EqualError(t, err, `invalid global rate limit "-1", it must be a non-negative integer`)
+}
+
+func TestOnConfigurationChangeInvalidKeepsCurrent(t *testing.T) {
+	apiMock := &pMock.API{}
+	comps := &components{}
+	p := newTestPlugin(apiMock, comps)
+	apiMock.On("LoadPluginConfiguration", mock.Anything).Once().Run(func(args mock.Arguments) {
+		*args.Get(0).(*configuration) = configuration{GotenbergAddress: "localhost"}
+	}).Return(nil)
+	apiMock.On("LogError", mock.Anything).Once()
+	require.Error(t, p.OnConfigurationChange())
+	require.True(t, comps == p.load())
+	apiMock.AssertExpectations(t)
+}
+
+func TestOnConfigurationChangeSwapsAndWarns(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	apiMock := memkv.New()
+	comps := &components{}
+	p := newTestPlugin(apiMock, comps)
+	warned := make(chan struct{})
+	apiMock.On("LoadPluginConfiguration", mock.Anything).Once().Run(func(args mock.Arguments) {
+		c := validConfiguration()
+		c.GotenbergAddress = server.URL
+		*args.Get(0).(*configuration) = c
+	}).Return(nil)
+	apiMock.On("LogWarn", "PDF server is not reachable with the current configuration", "reason", mock.Anything).
+		Once().Run(func(mock.Arguments) { close(warned) })
+	require.NoError(t, p.OnConfigurationChange())
+	require.False(t, comps == p.load())
+	select {
+	case <-warned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no warning logged for unreachable PDF server")
+	}
+	apiMock.AssertExpectations(t)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/rs/cors"
+)
+
+var (
+	// defaultCORSMethods are the methods allowed in cross-origin requests by default, they're
+	// the methods that the API is served with.
+	defaultCORSMethods = []string{"GET", "POST", "DELETE"}
+
+	// defaultCORSHeaders are the non-simple headers allowed in cross-origin requests by default.
+	defaultCORSHeaders = []string{"Authorization", "Content-Type", "X-Requested-With", requestIDHeader}
+
+	// corsExposedHeaders are the response headers that cross-origin clients can read.
+	corsExposedHeaders = []string{requestIDHeader, "Retry-After"}
+)
+
+// errCORSAllOrigins returned when all origins are allowed to make cross-origin requests.
+var errCORSAllOrigins = errors.New("allowing all CORS origins with `*` is not supported, list the origins instead")
+
+// newCORS creates the CORS handler of API that allows origins, methods and headers.
+// the origin of Mattermost's site URL is always allowed in addition to origins, defaults are used
+// for empty methods and headers. credentials are allowed since the API is authenticated with
+// sessions.
+func (p *Plugin) newCORS(origins, methods, headers []string) *cors.Cors {
+	isAllowed := originMatcher(origins)
+	options := cors.Options{
+		// site URL is looked up for each request so changes to it apply immediately.
+		AllowOriginFunc: func(origin string) bool {
+			return isAllowed(origin) || p.isSiteOrigin(origin)
+		},
+		AllowedMethods:   methods,
+		AllowedHeaders:   headers,
+		ExposedHeaders:   corsExposedHeaders,
+		AllowCredentials: true,
+	}
+	if len(methods) == 0 {
+		options.AllowedMethods = defaultCORSMethods
+	}
+	if len(headers) == 0 {
+		options.AllowedHeaders = defaultCORSHeaders
+	}
+	return cors.New(options)
+}
+
+// originMatcher creates a func that checks if an origin is one of origins. origins can have a
+// `*` wildcard, like `https://*.example.org`, and they're matched case insensitively.
+func originMatcher(origins []string) func(origin string) bool {
+	type pattern struct {
+		prefix, suffix string
+		wildcard       bool
+	}
+	var patterns []pattern
+	for _, origin := range origins {
+		origin = strings.ToLower(origin)
+		if i := strings.IndexByte(origin, '*'); i >= 0 {
+			patterns = append(patterns, pattern{prefix: origin[:i], suffix: origin[i+1:], wildcard: true})
+			continue
+		}
+		patterns = append(patterns, pattern{prefix: origin})
+	}
+	return func(origin string) bool {
+		origin = strings.ToLower(origin)
+		for _, p := range patterns {
+			if !p.wildcard && origin == p.prefix {
+				return true
+			}
+			if p.wildcard && len(origin) >= len(p.prefix)+len(p.suffix) &&
+				strings.HasPrefix(origin, p.prefix) && strings.HasSuffix(origin, p.suffix) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// isSiteOrigin checks if origin is the origin of Mattermost's site URL.
+func (p *Plugin) isSiteOrigin(origin string) bool {
+	config := p.API.GetConfig()
+	if config == nil || config.ServiceSettings.SiteURL == nil {
+		return false
+	}
+	u, err := url.Parse(*config.ServiceSettings.SiteURL)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return strings.EqualFold(origin, u.Scheme+"://"+u.Host)
+}
+
+// parseCORSOrigins parses the comma or space separated origins in text.
+func parseCORSOrigins(text string) (origins []string, err error) {
+	for _, origin := range splitList(text) {
+		if origin == "*" {
+			return nil, errCORSAllOrigins
+		}
+		if err := validateURL("CORS origin", origin); err != nil {
+			return nil, err
+		}
+		origin = strings.TrimSuffix(origin, "/")
+		if u, _ := url.Parse(origin); u.Path != "" || u.RawQuery != "" {
+			return nil, fmt.Errorf("invalid CORS origin %q, it must only have a scheme and host", origin)
+		}
+		origins = append(origins, origin)
+	}
+	return origins, nil
+}
+
+// splitList splits the comma or space separated values in text, empty values are dropped.
+func splitList(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n' || r == '\t'
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	pMock "github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/mocks"
+	tMock "github.com/ilgooz/mattermost-plugin-topdf/server/x/xtopdf/mocks"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCORS(t *testing.T) {
+	tests := []struct {
+		name string
+		// origins are the configured origins, site URL is always allowed.
+		origins []string
+		method  string
+		header  http.Header
+		// allowed is the expected Access-Control-Allow-Origin, empty when no CORS headers are expected.
+		allowed string
+	}{
+		{name: "site URL", method: "GET",
+			header: http.Header{"Origin": {"https://mattermost.example.com"}}, allowed: "https://mattermost.example.com"},
+		{name: "other origin", method: "GET",
+			header: http.Header{"Origin": {"https://evil.example.com"}}},
+		{name: "configured origin", origins: []string{"https://*.example.org"}, method: "GET",
+			header: http.Header{"Origin": {"https://app.example.org"}}, allowed: "https://app.example.org"},
+		{name: "site URL with configured origins", origins: []string{"https://*.example.org"}, method: "GET",
+			header: http.Header{"Origin": {"https://mattermost.example.com"}}, allowed: "https://mattermost.example.com"},
+		{name: "exact configured origin", origins: []string{"https://app.example.com"}, method: "GET",
+			header: http.Header{"Origin": {"https://App.example.com"}}, allowed: "https://App.example.com"},
+		{name: "other origin with configured origins", origins: []string{"https://*.example.org", "https://app.example.com"}, method: "GET",
+			header: http.Header{"Origin": {"https://evil.example.com"}}},
+		{name: "preflight", method: "OPTIONS",
+			header: http.Header{
+				"Origin":                         {"https://mattermost.example.com"},
+				"Access-Control-Request-Method":  {"DELETE"},
+				"Access-Control-Request-Headers": {"X-Request-Id"},
+			}, allowed: "https://mattermost.example.com"},
+		{name: "preflight other origin", method: "OPTIONS",
+			header: http.Header{"Origin": {"https://evil.example.com"}, "Access-Control-Request-Method": {"GET"}}},
+		{name: "preflight disallowed method", method: "OPTIONS",
+			header: http.Header{"Origin": {"https://mattermost.example.com"}, "Access-Control-Request-Method": {"PUT"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			topdfMock := &tMock.TOPDF{}
+			apiMock := &pMock.API{}
+			siteURL := "https://mattermost.example.com/"
+			apiMock.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: &siteURL}})
+			p := newTestPlugin(apiMock, &components{app: topdfMock})
+			p.load().cors = p.newCORS(tt.origins, nil, nil)
+			topdfMock.On("CheckServerStatus", mock.Anything).Return(nil)
+			req := httptest.NewRequest(tt.method, "http://localhost.com/status", nil)
+			for key, values := range tt.header {
+				req.Header[key] = values
+			}
+			w := httptest.NewRecorder()
+			p.ServeHTTP(nil, w, req)
+			resp := w.Result()
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			if tt.allowed == "" {
+				for key := range resp.Header {
+					require.NotContains(t, key, "Access-Control-")
+				}
+				return
+			}
+			require.Equal(t, tt.allowed, resp.Header.Get("Access-Control-Allow-Origin"))
+			require.Equal(t, "true", resp.Header.Get("Access-Control-Allow-Credentials"))
+			if tt.method == "OPTIONS" {
+				// preflight requests are answered without reaching to the API.
+				require.Equal(t, "DELETE", resp.Header.Get("Access-Control-Allow-Methods"))
+				require.Equal(t, "X-Request-Id", resp.Header.Get("Access-Control-Allow-Headers"))
+				topdfMock.AssertNotCalled(t, "CheckServerStatus", mock.Anything)
+			} else {
+				require.Equal(t, "X-Request-Id, Retry-After", resp.Header.Get("Access-Control-Expose-Headers"))
+			}
+		})
+	}
+}
+
+func TestIsSiteOrigin(t *testing.T) {
+	apiMock := &pMock.API{}
+	p := newTestPlugin(apiMock, nil)
+	apiMock.On("GetConfig").Once().Return(&model.Config{})
+	require.False(t, p.isSiteOrigin("https://mattermost.example.com"))
+	siteURL := "https://mattermost.example.com:8065/mm"
+	apiMock.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: &siteURL}})
+	require.True(t, p.isSiteOrigin("https://mattermost.example.com:8065"))
+	require.False(t, p.isSiteOrigin("http://mattermost.example.com:8065"))
+	require.False(t, p.isSiteOrigin("https://mattermost.example.com"))
+}
+
+func TestOriginMatcher(t *testing.T) {
+	isAllowed := originMatcher([]string{"https://app.example.com", "https://*.example.org"})
+	require.True(t, isAllowed("https://app.example.com"))
+	require.True(t, isAllowed("https://a.b.example.org"))
+	require.False(t, isAllowed("https://example.org"))
+	require.False(t, isAllowed("http://app.example.com"))
+	require.False(t, isAllowed("https://app.example.com.evil.com"))
+	require.False(t, originMatcher(nil)("https://app.example.com"))
+}
@@ -2,6 +2,7 @@
 package gotenberg
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -9,9 +10,12 @@ import (
 	"mime/multipart"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 
+	"github.com/ilgooz/mattermost-plugin-topdf/server/logger"
 	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/trace"
 	perrors "github.com/pkg/errors"
 )
 
@@ -19,9 +23,9 @@ const (
 	// pingTimeout defines the timeout for ping requests to the Gotenberg server.
 	pingTimeout = time.Second * 5
 
-	// defaultConvertTimeout defines the timeout for file conversion requests to the Gotenberg server
+	// DefaultConvertTimeout defines the timeout for file conversion requests to the Gotenberg server
 	// to Gotenberg server.
-	defaultConvertTimeout = time.Minute * 10
+	DefaultConvertTimeout = time.Minute * 10
 )
 
 // name of the PDF Server.
@@ -37,6 +41,27 @@ const (
 
 const multipartField = "file"
 
+const (
+	// webhookURLField is the form field of the URL that Gotenberg POSTs converted PDFs to.
+	webhookURLField = "webhookURL"
+
+	// webhookURLTimeoutField is the form field of timeout in seconds for webhook requests.
+	webhookURLTimeoutField = "webhookURLTimeout"
+)
+
+// form fields of convert options.
+const (
+	// landscapeField sets landscape orientation.
+	landscapeField = "landscape"
+
+	// pageRangesField is the page ranges to convert, like `1-3,5`.
+	pageRangesField = "pageRanges"
+)
+
+// ErrFormatNotSupported returned when a PDF/A format is requested, Office conversions of
+// Gotenberg 6 only create regular PDFs.
+var ErrFormatNotSupported = errors.New("PDF/A formats are not supported by Gotenberg 6")
+
 // supportedFormats are the supported file formats  that can be converted to PDF by Gotenberg.
 var supportedFormats = []string{"doc", "docx", "odt", "xls", "xlsx", "ods", "ppt", "pptx", "odp"}
 
@@ -49,6 +74,10 @@ type Gotenberg struct {
 	addr string
 	// convertTimout is used during sending file convert requests.
 	convertTimeout time.Duration
+	// version is the version of Gotenberg server, it's set by admins since Gotenberg does not expose it.
+	version string
+	// log logs requests made to Gotenberg server.
+	log *logger.Logger
 }
 
 // New creates new Gotenberg client with given Gotenberg server addr and options.
@@ -64,7 +93,7 @@ func (g *Gotenberg) applyOptions(options ...Option) {
 		o(g)
 	}
 	if g.convertTimeout == 0 {
-		g.convertTimeout = defaultConvertTimeout
+		g.convertTimeout = DefaultConvertTimeout
 	}
 }
 
@@ -79,30 +108,94 @@ func ConvertTimeoutOption(convertTimeout time.Duration) Option {
 	}
 }
 
+// VersionOption sets the version of Gotenberg server, it should be changed when Gotenberg or
+// LibreOffice in it is upgraded.
+func VersionOption(version string) Option {
+	return func(g *Gotenberg) {
+		g.version = version
+	}
+}
+
+// LoggerOption sets the logger that logs requests made to Gotenberg server.
+func LoggerOption(log *logger.Logger) Option {
+	return func(g *Gotenberg) {
+		g.log = log
+	}
+}
+
+// Identity identifies Gotenberg with its version.
+func (g *Gotenberg) Identity() string {
+	if g.version == "" {
+		return name
+	}
+	return name + "/" + g.version
+}
+
 // Status checks if Gotenberg server is running and ready to accept connections.
 // err is returned when Gotenberg server is not running nor ready or can be related
 // to anything else.
-func (g *Gotenberg) Status() (err error) {
+func (g *Gotenberg) Status(ctx context.Context) (err error) {
 	c := &http.Client{Timeout: pingTimeout}
 	url, err := buildGotenbergURL(g.addr, pingEndpoint)
 	if err != nil {
 		return err
 	}
-	resp, err := c.Get(url)
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
+		return err
+	}
+	resp, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		return &pdfserver.NotReachable{ServerName: name, Reason: err}
 	}
+	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
 		return &pdfserver.NotReachable{ServerName: name, Reason: errors.New("received non-OK response code")}
 	}
 	return nil
 }
 
-// Convert converts file with given name and extension to PDF.
-// caller is responsible to Close() PDF stream after done.
-func (g *Gotenberg) Convert(name, extension string, file io.Reader) (pdf io.ReadCloser, err error) {
+// Convert converts file with given name and extension to PDF with options.
+// caller is responsible to Close() PDF stream after done. canceling ctx aborts the request to
+// Gotenberg, including the streaming of PDF.
+func (g *Gotenberg) Convert(ctx context.Context, name, extension string, file io.Reader, options pdfserver.ConvertOptions) (pdf io.ReadCloser, err error) {
+	fields, err := optionFields(options)
+	if err != nil {
+		return nil, err
+	}
+	res, err := g.convert(ctx, name, extension, file, fields)
+	if err != nil {
+		return nil, err
+	}
+	// we have Gotenberg willing to stream PDF data, give it to the caller so it can start reading.
+	return res.Body, nil
+}
+
+// ConvertToWebhook starts converting file with given name and extension to PDF with options.
+// instead of responding with the PDF, Gotenberg POSTs it to webhookURL once the conversion is finished.
+func (g *Gotenberg) ConvertToWebhook(ctx context.Context, name, extension string, file io.Reader, options pdfserver.ConvertOptions, webhookURL string) error {
+	fields, err := optionFields(options)
+	if err != nil {
+		return err
+	}
+	fields[webhookURLField] = webhookURL
+	fields[webhookURLTimeoutField] = strconv.FormatFloat(g.convertTimeout.Seconds(), 'f', -1, 64)
+	res, err := g.convert(ctx, name, extension, file, fields)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// convert makes a convert request to Gotenberg for file with given name, extension and
+// form fields. it returns the response if Gotenberg accepts the request.
+// request is traced as a child of the span in ctx and the trace is propagated to Gotenberg.
+func (g *Gotenberg) convert(ctx context.Context, name, extension string, file io.Reader, fields map[string]string) (res *http.Response, err error) {
 	// check to see if given file extension is supported.
-	if !isSupported(extension) {
+	if !IsSupported(extension) {
 		return nil, fmt.Errorf("file extension `%s` is not supported by the PDF server", extension)
 	}
 	// create a pipe and:
@@ -121,8 +214,15 @@ func (g *Gotenberg) Convert(name, extension string, file io.Reader) (pdf io.Read
 		writer.Close()
 		pw.CloseWithError(err)
 	}
-	// create a 'multipart file' and copy whole content of file as Gotenberg server continues to read.
+	// write form fields and create a 'multipart file' and copy whole content of file as Gotenberg
+	// server continues to read.
 	go func() {
+		for key, value := range fields {
+			if err := writer.WriteField(key, value); err != nil {
+				closer(err)
+				return
+			}
+		}
 		part, err := writer.CreateFormFile(multipartField, fmt.Sprintf("%s.%s", name, extension))
 		if err != nil {
 			closer(err)
@@ -135,17 +235,30 @@ func (g *Gotenberg) Convert(name, extension string, file io.Reader) (pdf io.Read
 	if err != nil {
 		return nil, err
 	}
-	// make an HTTP request to Gotenberg to initialize process.
+	// make an HTTP request to Gotenberg to initialize process. Gotenberg stops working on the
+	// request once its connection is closed by canceling ctx.
 	req, err := http.NewRequest("POST", url, pr)
 	if err != nil {
 		return nil, err
 	}
+	req = req.WithContext(ctx)
 	req.Header.Add("Content-Type", writer.FormDataContentType())
+	ctx, span := trace.Start(ctx, "gotenberg.convert", "http.method", req.Method, "http.url", url)
+	span.SetKind(trace.KindClient)
+	defer func() { span.EndWithError(err) }()
+	trace.Inject(ctx, req.Header)
 	c := &http.Client{Timeout: g.convertTimeout}
-	res, err := c.Do(req)
+	start := time.Now()
+	res, err = c.Do(req)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		g.log.Warn("Gotenberg request failed", "endpoint", convertEndpoint, "err", err.Error(), "durationMs", logger.Millis(start))
 		return nil, err
 	}
+	g.log.Debug("Gotenberg responded", "endpoint", convertEndpoint, "status", res.StatusCode, "durationMs", logger.Millis(start))
+	span.SetAttributes("http.status_code", res.StatusCode)
 	// check if Gotenberg is cool with the file we sent to see if it's gonna response back with a PDF data.
 	if res.StatusCode != http.StatusOK &&
 		res.StatusCode != http.StatusCreated {
@@ -156,10 +269,26 @@ func (g *Gotenberg) Convert(name, extension string, file io.Reader) (pdf io.Read
 		if err != nil {
 			return nil, perrors.Wrap(err, "error while reading error message from Gotenberg")
 		}
-		return nil, fmt.Errorf("error from Gotenberg with '%d' code: %s", res.StatusCode, string(data))
+		g.log.Warn("Gotenberg responded with an error", "endpoint", convertEndpoint, "status", res.StatusCode, "message", string(data))
+		return nil, &pdfserver.ResponseError{ServerName: name, StatusCode: res.StatusCode, Message: string(data)}
 	}
-	// we have Gotenberg willing to stream PDF data, give it to the caller so it can start reading.
-	return res.Body, nil
+	return res, nil
+}
+
+// optionFields creates form fields for options, fields are not set for default options.
+// ErrFormatNotSupported is returned for PDF/A formats.
+func optionFields(options pdfserver.ConvertOptions) (map[string]string, error) {
+	if options.Format != pdfserver.FormatDefault {
+		return nil, ErrFormatNotSupported
+	}
+	fields := make(map[string]string)
+	if options.Landscape {
+		fields[landscapeField] = "true"
+	}
+	if options.PageRanges != "" {
+		fields[pageRangesField] = options.PageRanges
+	}
+	return fields, nil
 }
 
 // buildGotenbergURL generates a Gotenberg API URL from given addr for endpoint.
@@ -172,8 +301,8 @@ func buildGotenbergURL(addr, endpoint string) (string, error) {
 	return u.String(), nil
 }
 
-// isSupported check if file extension is supported.
-func isSupported(extension string) (ok bool) {
+// IsSupported checks if files with extension can be converted to PDF by Gotenberg.
+func IsSupported(extension string) (ok bool) {
 	for _, supext := range supportedFormats {
 		if supext == extension {
 			return true
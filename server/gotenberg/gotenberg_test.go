@@ -1,6 +1,7 @@
 package gotenberg
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -10,8 +11,10 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/trace"
 	"github.com/stretchr/testify/require"
 )
 
@@ -22,7 +25,7 @@ func TestStatusRunning(t *testing.T) {
 	}))
 	defer ts.Close()
 	gt := New(ts.URL)
-	err := gt.Status()
+	err := gt.Status(context.Background())
 	require.NoError(t, err)
 }
 
@@ -37,7 +40,7 @@ func TestStatusNotRunning(t *testing.T) {
 	port := ln.Addr().(*net.TCPAddr).Port
 	urlStr := fmt.Sprintf("http://localhost:%d", port)
 	gt := New(urlStr)
-	err = gt.Status()
+	err = gt.Status(context.Background())
 	require.IsType(t, &pdfserver.NotReachable{}, err)
 	require.True(t, err.(*pdfserver.NotReachable).Reason.(*url.Error).Timeout())
 }
@@ -47,7 +50,7 @@ func TestStatusNotRunningOK(t *testing.T) {
 	}))
 	defer ts.Close()
 	gt := New(ts.URL)
-	err := gt.Status()
+	err := gt.Status(context.Background())
 	require.Equal(t, &pdfserver.NotReachable{"Gotenberg", errors.New("received non-OK response code")}, err)
 }
 
@@ -65,7 +68,7 @@ func TestConvertSupportedFormat(t *testing.T) {
 	}))
 	defer ts.Close()
 	gt := New(ts.URL)
-	pdf, err := gt.Convert("name", "docx", strings.NewReader("docx-file"))
+	pdf, err := gt.Convert(context.Background(), "name", "docx", strings.NewReader("docx-file"), pdfserver.ConvertOptions{})
 	require.NoError(t, err)
 	defer pdf.Close()
 	data, err := ioutil.ReadAll(pdf)
@@ -73,12 +76,102 @@ func TestConvertSupportedFormat(t *testing.T) {
 	require.Equal(t, "pdf-file", string(data))
 }
 
+func TestConvertTraced(t *testing.T) {
+	exporter := trace.NewMemoryExporter()
+	ctx, parent := trace.New(exporter).Start(context.Background(), "parent")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Gotenberg continues the trace from the span of request.
+		span, ok := exporter.Span("gotenberg.convert")
+		require.False(t, ok, span.Name)
+		require.Regexp(t, "^00-"+parent.Data().TraceID.String()+"-[0-9a-f]{16}-01$", r.Header.Get("traceparent"))
+		w.Write([]byte("pdf-file"))
+	}))
+	defer ts.Close()
+	gt := New(ts.URL)
+	pdf, err := gt.Convert(ctx, "name", "docx", strings.NewReader("docx-file"), pdfserver.ConvertOptions{})
+	require.NoError(t, err)
+	pdf.Close()
+	span, ok := exporter.Span("gotenberg.convert")
+	require.True(t, ok)
+	require.Equal(t, trace.KindClient, span.Kind)
+	require.Equal(t, parent.Data().SpanID, span.ParentSpanID)
+	require.Equal(t, http.StatusOK, span.Attribute("http.status_code"))
+	require.Empty(t, span.Error)
+}
+
+func TestConvertCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cancel()
+		<-release
+	}))
+	defer ts.Close()
+	defer close(release)
+	gt := New(ts.URL)
+	// request is aborted without waiting for Gotenberg to respond.
+	_, err := gt.Convert(ctx, "name", "docx", strings.NewReader("docx-file"), pdfserver.ConvertOptions{})
+	require.Equal(t, context.Canceled, err)
+}
+
 func TestConvertUnsupportedFormat(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		require.Fail(t, "should not call server in case of unsupported file extension")
 	}))
 	defer ts.Close()
 	gt := New(ts.URL)
-	_, err := gt.Convert("name", "txt", strings.NewReader("txt-file"))
+	_, err := gt.Convert(context.Background(), "name", "txt", strings.NewReader("txt-file"), pdfserver.ConvertOptions{})
 	require.Equal(t, "file extension `txt` is not supported by the PDF server", err.Error())
 }
+
+func TestConvertToWebhook(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/convert/office", r.URL.Path)
+		require.Equal(t, "http://mattermost/plugins/topdf/webhook/1", r.FormValue("webhookURL"))
+		require.Equal(t, "60", r.FormValue("webhookURLTimeout"))
+		file, _, err := r.FormFile("file")
+		require.NoError(t, err)
+		defer file.Close()
+		data, err := ioutil.ReadAll(file)
+		require.NoError(t, err)
+		require.Equal(t, "docx-file", string(data))
+	}))
+	defer ts.Close()
+	gt := New(ts.URL, ConvertTimeoutOption(time.Minute))
+	err := gt.ConvertToWebhook(context.Background(), "name", "docx", strings.NewReader("docx-file"), pdfserver.ConvertOptions{}, "http://mattermost/plugins/topdf/webhook/1")
+	require.NoError(t, err)
+}
+
+func TestConvertOptions(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "true", r.FormValue("landscape"))
+		require.Equal(t, "1-3", r.FormValue("pageRanges"))
+		w.Write([]byte("pdf-file"))
+	}))
+	defer ts.Close()
+	gt := New(ts.URL)
+	pdf, err := gt.Convert(context.Background(), "name", "xlsx", strings.NewReader("xlsx-file"), pdfserver.ConvertOptions{
+		Landscape:  true,
+		PageRanges: "1-3",
+	})
+	require.NoError(t, err)
+	pdf.Close()
+}
+
+func TestConvertFormatNotSupported(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("Gotenberg should not be requested")
+	}))
+	defer ts.Close()
+	gt := New(ts.URL)
+	options := pdfserver.ConvertOptions{Format: pdfserver.FormatPDFA2b}
+	_, err := gt.Convert(context.Background(), "name", "docx", strings.NewReader("docx-file"), options)
+	require.Equal(t, ErrFormatNotSupported, err)
+	err = gt.ConvertToWebhook(context.Background(), "name", "docx", strings.NewReader("docx-file"), options, "http://mattermost/plugins/topdf/webhook/1")
+	require.Equal(t, ErrFormatNotSupported, err)
+}
+
+func TestIdentity(t *testing.T) {
+	require.Equal(t, "Gotenberg", New("http://localhost").Identity())
+	require.Equal(t, "Gotenberg/6.4.0", New("http://localhost", VersionOption("6.4.0")).Identity())
+}
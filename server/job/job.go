@@ -0,0 +1,273 @@
+// Package job keeps track of asynchronous conversion jobs in Mattermost's KV store.
+package job
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin"
+)
+
+const (
+	// keyPrefix used as a prefix for all job keys in KV store.
+	keyPrefix = "job:"
+
+	// userKeyPrefix used as a prefix for the keys that keep ids of users' unfinished jobs.
+	userKeyPrefix = keyPrefix + "user:"
+
+	// defaultTTL is the default duration that jobs kept in KV store.
+	defaultTTL = 24 * time.Hour
+
+	// maxRetries is the number of attempts made to track a job when the unfinished jobs of its
+	// user are concurrently modified by other plugin instances.
+	maxRetries = 10
+)
+
+// ErrNotFound returned when a job does not exist or it's expired.
+var ErrNotFound = errors.New("job not found")
+
+// ErrTooManyJobs returned when a user already has the maximum number of unfinished jobs.
+var ErrTooManyJobs = errors.New("too many unfinished jobs, try again once they're finished")
+
+// errConflict returned when unfinished jobs of a user modified concurrently while tracking a job.
+var errConflict = errors.New("unfinished jobs are modified concurrently")
+
+// State is the state of a job.
+type State string
+
+// States of jobs.
+const (
+	// StateQueued is the state of jobs that waiting for a worker.
+	StateQueued State = "queued"
+
+	// StateRunning is the state of jobs that being processed.
+	StateRunning State = "running"
+
+	// StateDone is the state of jobs that successfully finished.
+	StateDone State = "done"
+
+	// StateFailed is the state of jobs that finished with an error.
+	StateFailed State = "failed"
+)
+
+// Job is a conversion job.
+// times are in milliseconds since epoch and they're zero until job reaches to the related state.
+type Job struct {
+	// ID is the unique id of job.
+	ID string `json:"id"`
+
+	// UserID is the user who created the job.
+	UserID string `json:"userId"`
+
+	// FileID is the file that converted to PDF.
+	FileID string `json:"fileId"`
+
+	// State is the current state of job.
+	State State `json:"state"`
+
+	// Error is the error message of failed jobs.
+	Error string `json:"error,omitempty"`
+
+	// CreatedAt is the time when job is queued.
+	CreatedAt int64 `json:"createdAt"`
+
+	// StartedAt is the time when job started running.
+	StartedAt int64 `json:"startedAt,omitempty"`
+
+	// FinishedAt is the time when job is done or failed.
+	FinishedAt int64 `json:"finishedAt,omitempty"`
+}
+
+// IsFinished checks if job is done or failed.
+func (j *Job) IsFinished() bool {
+	return j.State == StateDone || j.State == StateFailed
+}
+
+// Store keeps jobs in KV store.
+type Store struct {
+	// mapi is Mattermost's Plugin API.
+	mapi plugin.API
+
+	// ttl is the duration that jobs kept in KV store since their last update.
+	ttl time.Duration
+
+	// maxUnfinished is the maximum number of unfinished jobs that a user can have, zero is unlimited.
+	maxUnfinished int
+
+	// staleAfter is the duration that unfinished jobs are counted for since they're queued or
+	// started, zero counts them until they expire.
+	staleAfter time.Duration
+}
+
+// Option used to customize Store defaults.
+type Option func(*Store)
+
+// TTLOption sets the duration that jobs kept in KV store since their last update.
+func TTLOption(ttl time.Duration) Option {
+	return func(s *Store) {
+		s.ttl = ttl
+	}
+}
+
+// MaxUnfinishedOption sets the maximum number of unfinished jobs that a user can have.
+func MaxUnfinishedOption(max int) Option {
+	return func(s *Store) {
+		s.maxUnfinished = max
+	}
+}
+
+// StaleAfterOption sets the duration that unfinished jobs are counted against the maximum since
+// they're queued or started. jobs are only kept in memory of the node that runs them, so jobs of
+// restarted nodes are never finished and they'd lock their users out until they expire otherwise.
+func StaleAfterOption(d time.Duration) Option {
+	return func(s *Store) {
+		s.staleAfter = d
+	}
+}
+
+// New creates a new job store with mapi and options.
+func New(mapi plugin.API, options ...Option) *Store {
+	s := &Store{
+		mapi: mapi,
+		ttl:  defaultTTL,
+	}
+	for _, o := range options {
+		o(s)
+	}
+	return s
+}
+
+// Create creates a new queued job for converting fileID that requested by userID.
+// ErrTooManyJobs is returned when userID already has the maximum number of unfinished jobs.
+func (s *Store) Create(userID, fileID string) (*Job, error) {
+	j := &Job{
+		ID:        model.NewId(),
+		UserID:    userID,
+		FileID:    fileID,
+		State:     StateQueued,
+		CreatedAt: model.GetMillis(),
+	}
+	if err := s.save(j); err != nil {
+		return nil, err
+	}
+	if s.maxUnfinished == 0 {
+		return j, nil
+	}
+	// job is saved before it's tracked so concurrent creations see it as unfinished.
+	err := errConflict
+	for i := 0; i < maxRetries && err == errConflict; i++ {
+		err = s.track(j)
+	}
+	if err != nil {
+		if aerr := s.mapi.KVDelete(keyPrefix + j.ID); aerr != nil {
+			return nil, aerr
+		}
+		return nil, err
+	}
+	return j, nil
+}
+
+// track adds j to the unfinished jobs of its user when the user doesn't have the maximum number
+// of them already. finished, stale and expired jobs are dropped while they're counted.
+func (s *Store) track(j *Job) error {
+	key := userKeyPrefix + j.UserID
+	raw, aerr := s.mapi.KVGet(key)
+	if aerr != nil {
+		return aerr
+	}
+	var ids, unfinished []string
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &ids); err != nil {
+			return err
+		}
+	} else {
+		raw = nil
+	}
+	for _, id := range ids {
+		other, err := s.Get(id)
+		if err == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !other.IsFinished() && !s.isStale(other) {
+			unfinished = append(unfinished, id)
+		}
+	}
+	if len(unfinished) >= s.maxUnfinished {
+		return ErrTooManyJobs
+	}
+	data, err := json.Marshal(append(unfinished, j.ID))
+	if err != nil {
+		return err
+	}
+	ok, aerr := s.mapi.KVCompareAndSet(key, raw, data)
+	if aerr != nil {
+		return aerr
+	}
+	if !ok {
+		return errConflict
+	}
+	return nil
+}
+
+// isStale checks if unfinished j is queued or started longer than it could possibly take.
+func (s *Store) isStale(j *Job) bool {
+	if s.staleAfter == 0 {
+		return false
+	}
+	since := j.CreatedAt
+	if j.StartedAt > since {
+		since = j.StartedAt
+	}
+	return model.GetMillis()-since > int64(s.staleAfter/time.Millisecond)
+}
+
+// Get gets the job with id.
+func (s *Store) Get(id string) (*Job, error) {
+	raw, aerr := s.mapi.KVGet(keyPrefix + id)
+	if aerr != nil {
+		return nil, aerr
+	}
+	if len(raw) == 0 {
+		return nil, ErrNotFound
+	}
+	var j Job
+	if err := json.Unmarshal(raw, &j); err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// Start marks j as running.
+func (s *Store) Start(j *Job) error {
+	j.State = StateRunning
+	j.StartedAt = model.GetMillis()
+	return s.save(j)
+}
+
+// Finish marks j as done or as failed when err is not nil.
+func (s *Store) Finish(j *Job, err error) error {
+	j.State = StateDone
+	if err != nil {
+		j.State = StateFailed
+		j.Error = err.Error()
+	}
+	j.FinishedAt = model.GetMillis()
+	return s.save(j)
+}
+
+// save saves j to KV store.
+func (s *Store) save(j *Job) error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	if aerr := s.mapi.KVSetWithExpiry(keyPrefix+j.ID, data, int64(s.ttl/time.Second)); aerr != nil {
+		return aerr
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package job
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/memkv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLifecycle(t *testing.T) {
+	s := New(memkv.New())
+	j, err := s.Create("1", "2")
+	require.NoError(t, err)
+	require.Equal(t, StateQueued, j.State)
+	require.NotZero(t, j.CreatedAt)
+
+	require.NoError(t, s.Start(j))
+	got, err := s.Get(j.ID)
+	require.NoError(t, err)
+	require.Equal(t, StateRunning, got.State)
+	require.NotZero(t, got.StartedAt)
+	require.False(t, got.IsFinished())
+
+	require.NoError(t, s.Finish(j, errors.New("a failure")))
+	got, err = s.Get(j.ID)
+	require.NoError(t, err)
+	require.Equal(t, StateFailed, got.State)
+	require.Equal(t, "a failure", got.Error)
+	require.NotZero(t, got.FinishedAt)
+	require.True(t, got.IsFinished())
+}
+
+func TestGetNotFound(t *testing.T) {
+	s := New(memkv.New())
+	_, err := s.Get("1")
+	require.Equal(t, ErrNotFound, err)
+}
+
+func TestMaxUnfinished(t *testing.T) {
+	s := New(memkv.New(), MaxUnfinishedOption(2))
+	j1, err := s.Create("1", "2")
+	require.NoError(t, err)
+	_, err = s.Create("1", "3")
+	require.NoError(t, err)
+	_, err = s.Create("1", "4")
+	require.Equal(t, ErrTooManyJobs, err)
+
+	// other users have their own limits.
+	_, err = s.Create("5", "2")
+	require.NoError(t, err)
+
+	// finished jobs are not counted.
+	require.NoError(t, s.Finish(j1, nil))
+	j, err := s.Create("1", "4")
+	require.NoError(t, err)
+	_, err = s.Get(j.ID)
+	require.NoError(t, err)
+	_, err = s.Create("1", "5")
+	require.Equal(t, ErrTooManyJobs, err)
+}
+
+func TestMaxUnfinishedStale(t *testing.T) {
+	s := New(memkv.New(), MaxUnfinishedOption(2), StaleAfterOption(time.Minute))
+	queued, err := s.Create("1", "2")
+	require.NoError(t, err)
+	running, err := s.Create("1", "3")
+	require.NoError(t, err)
+	require.NoError(t, s.Start(running))
+	_, err = s.Create("1", "4")
+	require.Equal(t, ErrTooManyJobs, err)
+
+	// jobs of a restarted node are not counted once they're stale.
+	queued.CreatedAt -= int64(2 * time.Minute / time.Millisecond)
+	require.NoError(t, s.save(queued))
+	_, err = s.Create("1", "4")
+	require.NoError(t, err)
+	_, err = s.Create("1", "5")
+	require.Equal(t, ErrTooManyJobs, err)
+
+	// running jobs are stale since they're started.
+	running.CreatedAt -= int64(2 * time.Minute / time.Millisecond)
+	require.NoError(t, s.save(running))
+	_, err = s.Create("1", "5")
+	require.Equal(t, ErrTooManyJobs, err)
+	running.StartedAt = running.CreatedAt
+	require.NoError(t, s.save(running))
+	_, err = s.Create("1", "5")
+	require.NoError(t, err)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/job"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/logger"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/webhook"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xhttp"
+	"github.com/mattermost/mattermost-server/model"
+)
+
+const (
+	// maxRunningJobs is the maximum number of conversion jobs that run at the same time,
+	// rest of them are kept in queue until a running one finishes.
+	maxRunningJobs = 4
+
+	// maxUserJobs is the maximum number of unfinished conversion jobs that a user can have.
+	maxUserJobs = 10
+
+	// jobFinishedEvent is the WebSocket event sent to job's user when a job is done or failed.
+	jobFinishedEvent = "job_finished"
+
+	// webhookPath is the path of webhook route that PDF server delivers PDFs to.
+	webhookPath = "/webhook/"
+
+	// webhookGracePeriod is the time waited for PDF server to deliver a PDF after convert timeout.
+	webhookGracePeriod = time.Minute
+)
+
+// errJobNotFound returned when a job does not exist or it's not accessible by the user.
+var errJobNotFound = errors.New("job not found")
+
+// errWebhookTimeout returned when PDF server does not deliver a PDF to webhook in time.
+var errWebhookTimeout = errors.New("pdf is not delivered to webhook in time")
+
+// errWebhookDisabled returned when a webhook request received while webhook mode is disabled.
+var errWebhookDisabled = errors.New("webhook mode is disabled")
+
+// handleCreateJob handles asynchronous convert requests.
+// it creates a conversion job for file and immediately responds with the job, the job's user
+// is notified with a WebSocket event once it's finished and PDF can be fetched from cache.
+// access to file and rate limits are checked before the job is created so rejected requests
+// don't take workers.
+func (p *Plugin) handleCreateJob(w http.ResponseWriter, r *http.Request) {
+	fileID := mux.Vars(r)["id"]
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		xhttp.ResponseJSON(w, http.StatusUnauthorized, createErrorResponse(topdf.ErrUnauthorizedUser))
+		return
+	}
+	comps := p.load()
+	// job outlives the request but its log lines are still correlated with it.
+	ctx, err := comps.app.Admit(logger.NewContext(context.Background(), p.logger(r.Context())), userID, fileID)
+	if err != nil {
+		p.respondJobError(w, r, fileID, err)
+		return
+	}
+	j, err := comps.jobs.Create(userID, fileID)
+	if err != nil {
+		p.respondJobError(w, r, fileID, err)
+		return
+	}
+	// respond with a copy since job is modified while it runs.
+	queued := *j
+	ctx = logger.NewContext(ctx, p.logger(ctx).With("jobId", j.ID))
+	go p.runJob(ctx, comps, j, xhttp.ClientIP(r, comps.trustedProxies))
+	xhttp.ResponseJSON(w, http.StatusAccepted, queued)
+}
+
+// respondJobError responds to a convert request for fileID that cannot be queued because of err.
+func (p *Plugin) respondJobError(w http.ResponseWriter, r *http.Request, fileID string, err error) {
+	code := http.StatusInternalServerError
+	if err == topdf.ErrUnauthorizedUser {
+		code = http.StatusUnauthorized
+	} else if rerr, ok := err.(*topdf.RateLimited); ok {
+		code = http.StatusTooManyRequests
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rerr.RetryAfter.Seconds()))))
+	} else if err == job.ErrTooManyJobs {
+		code = http.StatusTooManyRequests
+	}
+	xhttp.ResponseJSON(w, code, createErrorResponse(err))
+	// errors caused by requests themselves are not errors of plugin.
+	if code >= http.StatusInternalServerError {
+		p.logError(r.Context(), "cannot create job", err, "fileId", fileID)
+	} else {
+		p.logger(r.Context()).Warn("cannot create job", "fileId", fileID, "status", code, "err", err.Error())
+	}
+}
+
+// handleJob handles job status requests, jobs are only accessible by their users and system admins.
+func (p *Plugin) handleJob(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		xhttp.ResponseJSON(w, http.StatusUnauthorized, createErrorResponse(topdf.ErrUnauthorizedUser))
+		return
+	}
+	j, err := p.load().jobs.Get(mux.Vars(r)["id"])
+	if err == job.ErrNotFound || err == nil && j.UserID != userID && !p.isAdmin(userID) {
+		xhttp.ResponseJSON(w, http.StatusNotFound, createErrorResponse(errJobNotFound))
+		return
+	}
+	if err != nil {
+		xhttp.ResponseJSON(w, http.StatusInternalServerError, createErrorResponse(err))
+		p.logError(r.Context(), "cannot get job", err, "jobId", mux.Vars(r)["id"])
+		return
+	}
+	xhttp.ResponseJSON(w, http.StatusOK, j)
+}
+
+// runJob waits for a free worker in comps and converts the file of j.
+// converted PDF is cached by the app so it's not kept here.
+func (p *Plugin) runJob(ctx context.Context, comps *components, j *job.Job, clientIP string) {
+	comps.workers <- struct{}{}
+	defer func() { <-comps.workers }()
+	if err := comps.jobs.Start(j); err != nil {
+		p.logError(ctx, "cannot start job", err)
+	}
+	// in webhook mode, worker is released as soon as conversion is started and the job is
+	// finished when PDF server delivers the PDF to webhook.
+	if comps.webhookURL != "" {
+		p.startWebhookJob(ctx, comps, j, clientIP)
+		return
+	}
+	pdf, err := comps.app.GetPDF(ctx, j.UserID, j.FileID, clientIP)
+	if err == nil {
+		pdf.Close()
+	}
+	p.finishJob(ctx, comps, j, err)
+}
+
+// startWebhookJob starts converting the file of j where the PDF is delivered to webhook with
+// a one-time token that identifies j.
+func (p *Plugin) startWebhookJob(ctx context.Context, comps *components, j *job.Job, clientIP string) {
+	token, err := comps.webhooks.Issue(j.ID)
+	if err != nil {
+		p.finishJob(ctx, comps, j, err)
+		return
+	}
+	cached, err := comps.app.ConvertToWebhook(ctx, j.UserID, j.FileID, clientIP, comps.webhookURL+token)
+	if err != nil || cached {
+		p.finishJob(ctx, comps, j, err)
+		return
+	}
+	// PDF server does not call the webhook when a conversion fails, fail the job when PDF is not
+	// delivered in time. redeeming the token makes sure that job is only finished once.
+	time.AfterFunc(comps.webhookTimeout, func() {
+		if _, err := comps.webhooks.Redeem(token); err == nil {
+			p.finishJob(ctx, comps, j, errWebhookTimeout)
+		}
+	})
+}
+
+// handleWebhook handles PDFs delivered by PDF server for conversion jobs.
+// requests are authenticated by the one-time token in URL that issued for the job.
+func (p *Plugin) handleWebhook(w http.ResponseWriter, r *http.Request) {
+	comps := p.load()
+	if comps.webhooks == nil {
+		xhttp.ResponseJSON(w, http.StatusNotFound, createErrorResponse(errWebhookDisabled))
+		return
+	}
+	id, err := comps.webhooks.Redeem(mux.Vars(r)["token"])
+	if err == webhook.ErrInvalidToken {
+		xhttp.ResponseJSON(w, http.StatusForbidden, createErrorResponse(err))
+		return
+	}
+	if err != nil {
+		xhttp.ResponseJSON(w, http.StatusInternalServerError, createErrorResponse(err))
+		p.logError(r.Context(), "cannot redeem webhook token", err)
+		return
+	}
+	ctx := logger.NewContext(r.Context(), p.logger(r.Context()).With("jobId", id))
+	j, err := comps.jobs.Get(id)
+	if err != nil {
+		xhttp.ResponseJSON(w, http.StatusInternalServerError, createErrorResponse(err))
+		p.logError(ctx, "cannot get job", err)
+		return
+	}
+	err = comps.app.SavePDF(ctx, j.FileID, r.Body)
+	p.finishJob(ctx, comps, j, err)
+	if err != nil {
+		xhttp.ResponseJSON(w, http.StatusInternalServerError, createErrorResponse(err))
+		p.logError(ctx, "cannot save PDF delivered to webhook", err, "fileId", j.FileID)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finishJob finishes j with err and notifies its user.
+func (p *Plugin) finishJob(ctx context.Context, comps *components, j *job.Job, err error) {
+	if err := comps.jobs.Finish(j, err); err != nil {
+		p.logError(ctx, "cannot finish job", err)
+	}
+	if err := p.notifyJobFinished(j); err != nil {
+		p.logError(ctx, "cannot notify user about finished job", err)
+	}
+	p.API.PublishWebSocketEvent(jobFinishedEvent, map[string]interface{}{
+		"jobId":  j.ID,
+		"fileId": j.FileID,
+		"state":  string(j.State),
+		"error":  j.Error,
+	}, &model.WebsocketBroadcast{UserId: j.UserID})
+}
+
+// notifyJobFinished lets the user of finished j know about its outcome with an ephemeral post from
+// Plugin's bot in the channel of converted file. users are not notified until the bot is ensured.
+func (p *Plugin) notifyJobFinished(j *job.Job) error {
+	if p.botUserID == "" {
+		return nil
+	}
+	fileInfo, aerr := p.API.GetFileInfo(j.FileID)
+	if aerr != nil {
+		return aerr
+	}
+	post, aerr := p.API.GetPost(fileInfo.PostId)
+	if aerr != nil {
+		return aerr
+	}
+	message := fmt.Sprintf("PDF of **%s** is ready.", fileInfo.Name)
+	if j.State == job.StateFailed {
+		message = fmt.Sprintf("PDF of **%s** cannot be created: %s", fileInfo.Name, j.Error)
+	}
+	p.API.SendEphemeralPost(j.UserID, &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: post.ChannelId,
+		RootId:    post.RootId,
+		Message:   message,
+	})
+	return nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/job"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/webhook"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/memkv"
+	tMock "github.com/ilgooz/mattermost-plugin-topdf/server/x/xtopdf/mocks"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newJobsTestPlugin creates a Plugin with an in-memory job store for app.
+func newJobsTestPlugin(api *memkv.API, app *tMock.TOPDF) *Plugin {
+	return newTestPlugin(api, &components{
+		app:     app,
+		jobs:    job.New(api, job.MaxUnfinishedOption(maxUserJobs)),
+		workers: make(chan struct{}, maxRunningJobs),
+	})
+}
+
+// admitted returns the context of admitted conversions as is in mocks of TOPDF.Admit.
+func admitted(ctx context.Context, userID, fileID string) context.Context {
+	return ctx
+}
+
+// createJob makes an async convert request for fileID as userID and returns the created job.
+func createJob(t *testing.T, p *Plugin, userID, fileID string) job.Job {
+	if app, ok := p.load().app.(*tMock.TOPDF); ok {
+		app.On("Admit", mock.Anything, userID, fileID).Once().Return(admitted, nil)
+	}
+	req := httptest.NewRequest("POST", "http://localhost.com/files/"+fileID+"/convert", nil)
+	req.Header.Set("Mattermost-User-Id", userID)
+	w := httptest.NewRecorder()
+	p.ServeHTTP(nil, w, req)
+	resp := w.Result()
+	require.Equal(t, http.StatusAccepted, resp.StatusCode)
+	var j job.Job
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&j))
+	require.Equal(t, job.StateQueued, j.State)
+	return j
+}
+
+// getJob makes a job status request for id as userID.
+func getJob(p *Plugin, userID, id string) *http.Response {
+	req := httptest.NewRequest("GET", "http://localhost.com/jobs/"+id, nil)
+	req.Header.Set("Mattermost-User-Id", userID)
+	w := httptest.NewRecorder()
+	p.ServeHTTP(nil, w, req)
+	return w.Result()
+}
+
+// waitJobFinished waits until the job finished event is published.
+func waitJobFinished(t *testing.T, finished chan map[string]interface{}) map[string]interface{} {
+	select {
+	case payload := <-finished:
+		return payload
+	case <-time.After(5 * time.Second):
+		t.Fatal("job is not finished")
+		return nil
+	}
+}
+
+func TestJobDone(t *testing.T) {
+	api := memkv.New()
+	topdfMock := &tMock.TOPDF{}
+	p := newJobsTestPlugin(api, topdfMock)
+	finished := make(chan map[string]interface{}, 1)
+	topdfMock.On("GetPDF", mock.Anything, "2", "1", "192.0.2.1").Once().Return(newTestPDF([]byte("pdf")), nil)
+	api.On("PublishWebSocketEvent", jobFinishedEvent, mock.Anything, &model.WebsocketBroadcast{UserId: "2"}).
+		Once().Run(func(args mock.Arguments) { finished <- args.Get(1).(map[string]interface{}) })
+
+	j := createJob(t, p, "2", "1")
+	payload := waitJobFinished(t, finished)
+	require.Equal(t, j.ID, payload["jobId"])
+	require.Equal(t, "done", payload["state"])
+
+	resp := getJob(p, "2", j.ID)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var got job.Job
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&got))
+	require.Equal(t, job.StateDone, got.State)
+	require.NotZero(t, got.StartedAt)
+	require.NotZero(t, got.FinishedAt)
+	topdfMock.AssertExpectations(t)
+	api.AssertExpectations(t)
+}
+
+func TestJobFailed(t *testing.T) {
+	api := memkv.New()
+	topdfMock := &tMock.TOPDF{}
+	p := newJobsTestPlugin(api, topdfMock)
+	finished := make(chan map[string]interface{}, 1)
+	topdfMock.On("GetPDF", mock.Anything, "2", "1", "192.0.2.1").Once().Return(nil, errors.New("a failure"))
+	api.On("PublishWebSocketEvent", jobFinishedEvent, mock.Anything, &model.WebsocketBroadcast{UserId: "2"}).
+		Once().Run(func(args mock.Arguments) { finished <- args.Get(1).(map[string]interface{}) })
+
+	createJob(t, p, "2", "1")
+	payload := waitJobFinished(t, finished)
+	require.Equal(t, "failed", payload["state"])
+	require.Equal(t, "a failure", payload["error"])
+	topdfMock.AssertExpectations(t)
+	api.AssertExpectations(t)
+}
+
+func TestJobNotified(t *testing.T) {
+	for _, tt := range []struct {
+		err     error
+		message string
+	}{
+		{nil, "PDF of **a.docx** is ready."},
+		{errors.New("a failure"), "PDF of **a.docx** cannot be created: a failure"},
+	} {
+		api := memkv.New()
+		topdfMock := &tMock.TOPDF{}
+		p := newJobsTestPlugin(api, topdfMock)
+		p.botUserID = "bot-id"
+		finished := make(chan map[string]interface{}, 1)
+		var pdf *topdf.PDF
+		if tt.err == nil {
+			pdf = newTestPDF([]byte("pdf"))
+		}
+		topdfMock.On("GetPDF", mock.Anything, "2", "1", "192.0.2.1").Once().Return(pdf, tt.err)
+		api.On("GetFileInfo", "1").Once().Return(&model.FileInfo{Id: "1", PostId: "3", Name: "a.docx"}, nil)
+		api.On("GetPost", "3").Once().Return(&model.Post{Id: "3", ChannelId: "4", RootId: "5"}, nil)
+		api.On("SendEphemeralPost", "2", &model.Post{UserId: "bot-id", ChannelId: "4", RootId: "5", Message: tt.message}).
+			Once().Return(&model.Post{})
+		api.On("PublishWebSocketEvent", jobFinishedEvent, mock.Anything, &model.WebsocketBroadcast{UserId: "2"}).
+			Once().Run(func(args mock.Arguments) { finished <- args.Get(1).(map[string]interface{}) })
+
+		createJob(t, p, "2", "1")
+		waitJobFinished(t, finished)
+		topdfMock.AssertExpectations(t)
+		api.AssertExpectations(t)
+	}
+}
+
+func TestJobNotAccessible(t *testing.T) {
+	api := memkv.New()
+	p := newJobsTestPlugin(api, &tMock.TOPDF{})
+	j, err := p.load().jobs.Create("2", "1")
+	require.NoError(t, err)
+	api.On("HasPermissionTo", "3", model.PERMISSION_MANAGE_SYSTEM).Once().Return(false)
+	require.Equal(t, http.StatusNotFound, getJob(p, "3", j.ID).StatusCode)
+	require.Equal(t, http.StatusNotFound, getJob(p, "2", "missing").StatusCode)
+	api.AssertExpectations(t)
+}
+
+func TestWebhookJob(t *testing.T) {
+	api := memkv.New()
+	p := &Plugin{MattermostPlugin: plugin.MattermostPlugin{API: api}}
+	// fake Gotenberg delivers the PDF to plugin's webhook right after accepting the request.
+	gotenbergServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		webhookURL := r.FormValue("webhookURL")
+		go func() {
+			path := strings.TrimPrefix(webhookURL, "http://mattermost/plugins/topdf")
+			req := httptest.NewRequest("POST", "http://localhost.com"+path, strings.NewReader("pdf"))
+			w := httptest.NewRecorder()
+			p.ServeHTTP(nil, w, req)
+			require.Equal(t, http.StatusNoContent, w.Code)
+		}()
+	}))
+	defer gotenbergServer.Close()
+	c := validConfiguration()
+	c.GotenbergAddress = gotenbergServer.URL
+	c.GotenbergWebhookURL = "http://mattermost/"
+	comps, err := p.newComponents(c)
+	require.NoError(t, err)
+	p.store(comps)
+
+	finished := make(chan map[string]interface{}, 1)
+	api.On("GetFileInfo", "1").Return(&model.FileInfo{Id: "1", PostId: "3", Name: "a", Extension: "docx"}, nil)
+	api.On("GetPost", "3").Return(&model.Post{ChannelId: "4"}, nil)
+	// access is checked once when job is admitted and once when it's converted.
+	api.On("HasPermissionToChannel", "2", "4", model.PERMISSION_READ_CHANNEL).Twice().Return(true)
+	api.On("GetFile", "1").Once().Return([]byte("docx"), nil)
+	api.On("UploadFile", []byte("pdf"), "4", "a.pdf").Once().Return(&model.FileInfo{Id: "5"}, nil)
+	api.On("PublishWebSocketEvent", jobFinishedEvent, mock.Anything, &model.WebsocketBroadcast{UserId: "2"}).
+		Once().Run(func(args mock.Arguments) { finished <- args.Get(1).(map[string]interface{}) })
+
+	createJob(t, p, "2", "1")
+	payload := waitJobFinished(t, finished)
+	require.Equal(t, "done", payload["state"])
+	// PDF is cached under a versioned key.
+	keys, _ := api.KVList(0, 100)
+	var entry topdf.CacheEntry
+	for _, k := range keys {
+		if strings.HasPrefix(k, "pdf:2:") {
+			raw, _ := api.KVGet(k)
+			require.NoError(t, json.Unmarshal(raw, &entry))
+		}
+	}
+	require.Equal(t, "5", entry.PDFFileID)
+	require.Equal(t, "1", entry.SourceFileID)
+	api.AssertExpectations(t)
+}
+
+func TestWebhookInvalidToken(t *testing.T) {
+	api := memkv.New()
+	p := newTestPlugin(api, &components{webhooks: webhook.New(api)})
+	req := httptest.NewRequest("POST", "http://localhost.com/webhook/invalid", strings.NewReader("pdf"))
+	w := httptest.NewRecorder()
+	p.ServeHTTP(nil, w, req)
+	require.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestCreateJobRejected(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		code       int
+		retryAfter string
+	}{
+		{"unauthorized", topdf.ErrUnauthorizedUser, http.StatusUnauthorized, ""},
+		{"rate limited", &topdf.RateLimited{RetryAfter: 1500 * time.Millisecond}, http.StatusTooManyRequests, "2"},
+		{"failure", errors.New("a failure"), http.StatusInternalServerError, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := memkv.New()
+			topdfMock := &tMock.TOPDF{}
+			p := newJobsTestPlugin(api, topdfMock)
+			topdfMock.On("Admit", mock.Anything, "2", "1").Once().Return(nil, tt.err)
+			if tt.code == http.StatusInternalServerError {
+				api.On("LogError", "cannot create job", "requestId", mock.Anything, "userId", "2", "fileId", "1", "err", tt.err.Error()).Once()
+			} else {
+				api.On("LogWarn", "cannot create job", "requestId", mock.Anything, "userId", "2", "fileId", "1", "status", tt.code, "err", tt.err.Error()).Once()
+			}
+
+			req := httptest.NewRequest("POST", "http://localhost.com/files/1/convert", nil)
+			req.Header.Set("Mattermost-User-Id", "2")
+			w := httptest.NewRecorder()
+			p.ServeHTTP(nil, w, req)
+			resp := w.Result()
+			require.Equal(t, tt.code, resp.StatusCode)
+			require.Equal(t, tt.retryAfter, resp.Header.Get("Retry-After"))
+			// no jobs are created for rejected requests.
+			keys, aerr := api.KVList(0, 100)
+			require.Nil(t, aerr)
+			require.Empty(t, keys)
+			topdfMock.AssertExpectations(t)
+			api.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCreateJobTooMany(t *testing.T) {
+	api := memkv.New()
+	topdfMock := &tMock.TOPDF{}
+	p := newJobsTestPlugin(api, topdfMock)
+	// jobs are kept queued by holding all workers.
+	for i := 0; i < maxRunningJobs; i++ {
+		p.load().workers <- struct{}{}
+	}
+	for i := 0; i < maxUserJobs; i++ {
+		createJob(t, p, "2", "1")
+	}
+	topdfMock.On("Admit", mock.Anything, "2", "1").Once().Return(admitted, nil)
+	api.On("LogWarn", "cannot create job", "requestId", mock.Anything, "userId", "2", "fileId", "1", "status", http.StatusTooManyRequests, "err", job.ErrTooManyJobs.Error()).Once()
+	req := httptest.NewRequest("POST", "http://localhost.com/files/1/convert", nil)
+	req.Header.Set("Mattermost-User-Id", "2")
+	w := httptest.NewRecorder()
+	p.ServeHTTP(nil, w, req)
+	require.Equal(t, http.StatusTooManyRequests, w.Result().StatusCode)
+	topdfMock.AssertExpectations(t)
+	api.AssertExpectations(t)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf"
+)
+
+// megabyte is the number of bytes in a MB.
+const megabyte = 1024 * 1024
+
+// parseLimits parses conversion limits from c.
+// defaults are set by MaxSourceSizeMB, MaxPDFSizeMB and MaxPages where LimitOverrides sets limits
+// for file extensions. each line of LimitOverrides is in `ext1,ext2: source=10 pdf=20 pages=100`
+// format where sizes are in MB, unset limits fall back to defaults and 0 means unlimited like it
+// does for defaults.
+func parseLimits(c configuration) (defaults topdf.Limits, perExtension map[string]topdf.Limits, err error) {
+	defaults, err = parseLimitValues(topdf.Limits{}, map[string]string{
+		"source": c.MaxSourceSizeMB,
+		"pdf":    c.MaxPDFSizeMB,
+		"pages":  c.MaxPages,
+	})
+	if err != nil {
+		return defaults, nil, err
+	}
+	overrides, err := parseOverrides(c.LimitOverrides, "limit override", "ext1,ext2: source=10 pdf=20 pages=100")
+	if err != nil {
+		return defaults, nil, err
+	}
+	perExtension = make(map[string]topdf.Limits)
+	for _, o := range overrides {
+		limits, err := parseLimitValues(defaults, o.params)
+		if err != nil {
+			return defaults, nil, fmt.Errorf("invalid limit override at line %d: %s", o.line, err)
+		}
+		for _, ext := range o.extensions {
+			perExtension[ext] = limits
+		}
+	}
+	return defaults, perExtension, nil
+}
+
+// parseLimitValues parses source and pdf sizes in MB and number of pages from values and
+// sets them on limits.
+func parseLimitValues(limits topdf.Limits, values map[string]string) (topdf.Limits, error) {
+	for key, value := range values {
+		if value == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < 0 {
+			return limits, fmt.Errorf("invalid %s limit %q, it must be a non-negative integer", key, value)
+		}
+		switch key {
+		case "source":
+			limits.MaxSourceSize = n * megabyte
+		case "pdf":
+			limits.MaxPDFSize = n * megabyte
+		case "pages":
+			limits.MaxPages = int(n)
+		default:
+			return limits, fmt.Errorf("unknown limit %q", key)
+		}
+	}
+	return limits, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLimits(t *testing.T) {
+	defaults, perExtension, err := parseLimits(configuration{
+		MaxSourceSizeMB: "50",
+		MaxPages:        "500",
+		LimitOverrides:  "# spreadsheets\nxls, .xlsx,ods: source=10 pages=100\n\ndocx: pdf=20\npdf: source=0",
+	})
+	require.NoError(t, err)
+	require.Equal(t, topdf.Limits{MaxSourceSize: 50 * megabyte, MaxPages: 500}, defaults)
+	require.Equal(t, map[string]topdf.Limits{
+		"xls":  {MaxSourceSize: 10 * megabyte, MaxPages: 100},
+		"xlsx": {MaxSourceSize: 10 * megabyte, MaxPages: 100},
+		"ods":  {MaxSourceSize: 10 * megabyte, MaxPages: 100},
+		"docx": {MaxSourceSize: 50 * megabyte, MaxPDFSize: 20 * megabyte, MaxPages: 500},
+		// 0 makes the source size of PDFs unlimited even though there is a default limit.
+		"pdf": {MaxPages: 500},
+	}, perExtension)
+}
+
+func TestParseLimitsInvalid(t *testing.T) {
+	_, _, err := parseLimits(configuration{MaxPages: "many"})
+	require.EqualError(t, err, `invalid pages limit "many", it must be a non-negative integer`)
+	_, _, err = parseLimits(configuration{LimitOverrides: "xls pages=1"})
+	require.EqualError(t, err, "invalid limit override at line 1, it must be in `ext1,ext2: source=10 pdf=20 pages=100` format")
+	_, _, err = parseLimits(configuration{LimitOverrides: "xls: width=1"})
+	require.EqualError(t, err, `invalid limit override at line 1: unknown limit "width"`)
+}
@@ -0,0 +1,122 @@
+// Package logger writes structured, leveled logs with key/value pairs through Mattermost's
+// Plugin API. request scoped loggers are carried in contexts.
+package logger
+
+import (
+	"context"
+	"time"
+)
+
+// API is the logging part of Mattermost's Plugin API.
+type API interface {
+	LogDebug(msg string, keyValuePairs ...interface{})
+	LogInfo(msg string, keyValuePairs ...interface{})
+	LogWarn(msg string, keyValuePairs ...interface{})
+	LogError(msg string, keyValuePairs ...interface{})
+}
+
+// Logger logs messages together with its key/value pairs.
+// a nil Logger discards all messages.
+type Logger struct {
+	// api used to write logs.
+	api API
+
+	// debug enables debug messages.
+	debug bool
+
+	// pairs are the key/value pairs added to each message.
+	pairs []interface{}
+}
+
+// New creates a new Logger that writes logs with api.
+func New(api API, options ...Option) *Logger {
+	l := &Logger{api: api}
+	for _, o := range options {
+		o(l)
+	}
+	return l
+}
+
+// Option used to customize Logger defaults.
+type Option func(*Logger)
+
+// DebugOption enables debug messages. they're disabled by default since each message is sent to
+// Mattermost server even if it's filtered out there.
+func DebugOption(debug bool) Option {
+	return func(l *Logger) {
+		l.debug = debug
+	}
+}
+
+// With creates a child Logger that adds keyValuePairs to messages in addition to the ones of l.
+func (l *Logger) With(keyValuePairs ...interface{}) *Logger {
+	if l == nil {
+		return nil
+	}
+	pairs := make([]interface{}, 0, len(l.pairs)+len(keyValuePairs))
+	pairs = append(pairs, l.pairs...)
+	pairs = append(pairs, keyValuePairs...)
+	return &Logger{api: l.api, debug: l.debug, pairs: pairs}
+}
+
+// IsDebug checks if debug messages are enabled.
+func (l *Logger) IsDebug() bool {
+	return l != nil && l.debug && l.api != nil
+}
+
+// Debug logs msg with keyValuePairs when debug messages are enabled.
+func (l *Logger) Debug(msg string, keyValuePairs ...interface{}) {
+	if l.IsDebug() {
+		l.api.LogDebug(msg, l.merge(keyValuePairs)...)
+	}
+}
+
+// Info logs msg with keyValuePairs.
+func (l *Logger) Info(msg string, keyValuePairs ...interface{}) {
+	if l != nil && l.api != nil {
+		l.api.LogInfo(msg, l.merge(keyValuePairs)...)
+	}
+}
+
+// Warn logs msg with keyValuePairs.
+func (l *Logger) Warn(msg string, keyValuePairs ...interface{}) {
+	if l != nil && l.api != nil {
+		l.api.LogWarn(msg, l.merge(keyValuePairs)...)
+	}
+}
+
+// Error logs msg with keyValuePairs.
+func (l *Logger) Error(msg string, keyValuePairs ...interface{}) {
+	if l != nil && l.api != nil {
+		l.api.LogError(msg, l.merge(keyValuePairs)...)
+	}
+}
+
+// merge merges the key/value pairs of l with keyValuePairs.
+func (l *Logger) merge(keyValuePairs []interface{}) []interface{} {
+	if len(l.pairs) == 0 {
+		return keyValuePairs
+	}
+	pairs := make([]interface{}, 0, len(l.pairs)+len(keyValuePairs))
+	pairs = append(pairs, l.pairs...)
+	return append(pairs, keyValuePairs...)
+}
+
+// contextKey is the key of Logger in contexts.
+type contextKey struct{}
+
+// NewContext returns a copy of ctx that carries l.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext gets the Logger carried by ctx, it's nil when there is none.
+func FromContext(ctx context.Context) *Logger {
+	l, _ := ctx.Value(contextKey{}).(*Logger)
+	return l
+}
+
+// Millis gets the duration since start in milliseconds to log durations in a unit.
+func Millis(start time.Time) int64 {
+	return int64(time.Since(start) / time.Millisecond)
+}
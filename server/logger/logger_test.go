@@ -0,0 +1,51 @@
+package logger
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/mocks"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogger(t *testing.T) {
+	api := &mocks.API{}
+	l := New(api).With("requestId", "1")
+	api.On("LogInfo", "converted", "requestId", "1", "fileId", "2", "bytes", 3).Once()
+	api.On("LogWarn", "rate limited", "requestId", "1", "userId", "4").Once()
+	api.On("LogError", "cannot convert", "requestId", "1", "fileId", "2", "err", "failed").Once()
+	l.With("fileId", "2").Info("converted", "bytes", 3)
+	l.Warn("rate limited", "userId", "4")
+	l.With("fileId", "2").Error("cannot convert", "err", "failed")
+	// debug messages are disabled by default.
+	l.Debug("fetched source")
+	api.AssertExpectations(t)
+}
+
+func TestDebug(t *testing.T) {
+	api := &mocks.API{}
+	l := New(api, DebugOption(true)).With("requestId", "1")
+	require.True(t, l.IsDebug())
+	api.On("LogDebug", "fetched source", "requestId", "1", "bytes", 5).Once()
+	l.Debug("fetched source", "bytes", 5)
+	api.AssertExpectations(t)
+}
+
+func TestNilLogger(t *testing.T) {
+	var l *Logger
+	require.Nil(t, l.With("requestId", "1"))
+	require.False(t, l.IsDebug())
+	l.Debug("discarded")
+	l.Info("discarded")
+	l.Warn("discarded")
+	l.Error("discarded")
+	New(nil).Error("discarded")
+}
+
+func TestContext(t *testing.T) {
+	require.Nil(t, FromContext(context.Background()))
+	l := New(nil)
+	require.Equal(t, l, FromContext(NewContext(context.Background(), l)))
+	require.True(t, Millis(time.Now().Add(-time.Second)) >= 1000)
+}
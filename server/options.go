@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver"
+)
+
+// parseConvertOptions parses conversion options from c.
+// defaults are set by Landscape and PageRanges where ConvertOptionOverrides sets options for file
+// extensions. each line of ConvertOptionOverrides is in `ext1,ext2: landscape=true pages=1-3`
+// format where unset options fall back to defaults. PDF/A formats are not among them since
+// Gotenberg 6 cannot convert Office documents to them.
+func parseConvertOptions(c configuration) (defaults pdfserver.ConvertOptions, perExtension map[string]pdfserver.ConvertOptions, err error) {
+	defaults = pdfserver.ConvertOptions{
+		Landscape:  c.Landscape,
+		PageRanges: c.PageRanges,
+	}
+	if err := defaults.Validate(); err != nil {
+		return defaults, nil, err
+	}
+	overrides, err := parseOverrides(c.ConvertOptionOverrides, "convert option override",
+		"ext1,ext2: landscape=true pages=1-3")
+	if err != nil {
+		return defaults, nil, err
+	}
+	perExtension = make(map[string]pdfserver.ConvertOptions)
+	for _, o := range overrides {
+		options, err := parseConvertOptionValues(defaults, o.params)
+		if err != nil {
+			return defaults, nil, fmt.Errorf("invalid convert option override at line %d: %s", o.line, err)
+		}
+		for _, ext := range o.extensions {
+			perExtension[ext] = options
+		}
+	}
+	return defaults, perExtension, nil
+}
+
+// parseConvertOptionValues parses landscape and pages options from values and sets them on options.
+func parseConvertOptionValues(options pdfserver.ConvertOptions, values map[string]string) (pdfserver.ConvertOptions, error) {
+	for key, value := range values {
+		switch key {
+		case "landscape":
+			landscape, err := strconv.ParseBool(value)
+			if err != nil {
+				return options, fmt.Errorf("invalid landscape %q, it must be true or false", value)
+			}
+			options.Landscape = landscape
+		case "pages":
+			options.PageRanges = value
+		default:
+			return options, fmt.Errorf("unknown option %q", key)
+		}
+	}
+	return options, options.Validate()
+}
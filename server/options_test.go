@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConvertOptions(t *testing.T) {
+	defaults, perExtension, err := parseConvertOptions(configuration{
+		PageRanges:             "1-10",
+		ConvertOptionOverrides: "# spreadsheets\nxls,xlsx: landscape=true pages=1-3\ndocx: landscape=true",
+	})
+	require.NoError(t, err)
+	require.Equal(t, pdfserver.ConvertOptions{PageRanges: "1-10"}, defaults)
+	spreadsheet := pdfserver.ConvertOptions{Landscape: true, PageRanges: "1-3"}
+	require.Equal(t, map[string]pdfserver.ConvertOptions{
+		"xls":  spreadsheet,
+		"xlsx": spreadsheet,
+		"docx": {Landscape: true, PageRanges: "1-10"},
+	}, perExtension)
+}
+
+func TestParseConvertOptionsInvalid(t *testing.T) {
+	_, _, err := parseConvertOptions(configuration{PageRanges: "1-"})
+	require.EqualError(t, err, `invalid page ranges "1-", it must be like 1-3,5`)
+	_, _, err = parseConvertOptions(configuration{ConvertOptionOverrides: "xls landscape=true"})
+	require.EqualError(t, err, "invalid convert option override at line 1, it must be in `ext1,ext2: landscape=true pages=1-3` format")
+	// Gotenberg 6 cannot convert Office documents to PDF/A formats.
+	_, _, err = parseConvertOptions(configuration{ConvertOptionOverrides: "docx: format=PDF/A-2b"})
+	require.EqualError(t, err, `invalid convert option override at line 1: unknown option "format"`)
+	_, _, err = parseConvertOptions(configuration{ConvertOptionOverrides: "docx: paper=A4"})
+	require.EqualError(t, err, `invalid convert option override at line 1: unknown option "paper"`)
+	_, _, err = parseConvertOptions(configuration{ConvertOptionOverrides: "xls: landscape=yes"})
+	require.EqualError(t, err, `invalid convert option override at line 1: invalid landscape "yes", it must be true or false`)
+	_, _, err = parseConvertOptions(configuration{ConvertOptionOverrides: "xls: color=red"})
+	require.EqualError(t, err, `invalid convert option override at line 1: unknown option "color"`)
+}
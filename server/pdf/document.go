@@ -0,0 +1,616 @@
+package pdf
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// ErrEncrypted returned when an encrypted document is about to be modified.
+var ErrEncrypted = errors.New("pdf is encrypted")
+
+// maxResolveDepth limits the length of reference chains to protect against cycles.
+const maxResolveDepth = 32
+
+// xref entry types.
+const (
+	xrefFree = iota
+	xrefInFile
+	xrefCompressed
+)
+
+// xrefEntry is an entry of the cross-reference table.
+type xrefEntry struct {
+	// typ is one of xrefFree, xrefInFile and xrefCompressed.
+	typ int
+
+	// offset is the byte offset of object for xrefInFile entries
+	// and the object number of its object stream for xrefCompressed ones.
+	offset int64
+
+	// index is the generation number of object for xrefInFile entries
+	// and its index inside the object stream for xrefCompressed ones.
+	index int
+}
+
+// Document is a parsed PDF document.
+type Document struct {
+	// Trailer is the trailer dictionary of the latest revision of document.
+	Trailer Dict
+
+	// data is the content of the whole document.
+	data []byte
+
+	// startxref is the offset of the latest cross-reference section.
+	startxref int64
+
+	// xrefStream is true when the latest cross-reference section is a stream.
+	xrefStream bool
+
+	// xref maps object numbers to their locations.
+	xref map[int]xrefEntry
+
+	// objects caches the objects that already parsed.
+	objects map[int]Object
+}
+
+// Parse parses the PDF document in data.
+// objects are parsed lazily as they're accessed.
+func Parse(data []byte) (*Document, error) {
+	d := &Document{
+		data:    data,
+		xref:    make(map[int]xrefEntry),
+		objects: make(map[int]Object),
+	}
+	i := bytes.LastIndex(data, []byte("startxref"))
+	if i == -1 {
+		return nil, fmt.Errorf("%s: startxref not found", ErrMalformed)
+	}
+	p := &parser{data: data, pos: i + len("startxref")}
+	startxref, err := p.integer()
+	if err != nil {
+		return nil, err
+	}
+	d.startxref = startxref
+	if err := d.readXref(startxref); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// IsEncrypted checks if document is encrypted.
+func (d *Document) IsEncrypted() bool {
+	_, ok := d.Trailer["Encrypt"]
+	return ok
+}
+
+// readXref reads all the cross-reference sections starting from the one at offset
+// by following the previous ones. entries from newer sections take precedence.
+func (d *Document) readXref(offset int64) error {
+	visited := make(map[int64]bool)
+	for !visited[offset] {
+		visited[offset] = true
+		if offset < 0 || offset >= int64(len(d.data)) {
+			return fmt.Errorf("%s: invalid xref offset %d", ErrMalformed, offset)
+		}
+		p := &parser{data: d.data, pos: int(offset)}
+		p.skipSpace()
+		var (
+			trailer Dict
+			err     error
+		)
+		if bytes.HasPrefix(d.data[p.pos:], []byte("xref")) {
+			p.pos += len("xref")
+			trailer, err = d.readXrefTable(p)
+			if err != nil {
+				return err
+			}
+			// hybrid files keep their compressed objects in an additional xref stream.
+			if stm, ok := trailer["XRefStm"].(int64); ok {
+				if _, err := d.readXrefStream(stm); err != nil {
+					return err
+				}
+			}
+		} else if trailer, err = d.readXrefStream(offset); err != nil {
+			return err
+		} else if d.Trailer == nil {
+			d.xrefStream = true
+		}
+		if d.Trailer == nil {
+			d.Trailer = trailer
+		}
+		prev, ok := trailer["Prev"].(int64)
+		if !ok {
+			break
+		}
+		offset = prev
+	}
+	if d.Trailer == nil {
+		return fmt.Errorf("%s: trailer not found", ErrMalformed)
+	}
+	return nil
+}
+
+// readXrefTable reads a classic cross-reference table and returns its trailer.
+func (d *Document) readXrefTable(p *parser) (trailer Dict, err error) {
+	for {
+		pos := p.pos
+		if p.keyword() == "trailer" {
+			o, err := p.object()
+			if err != nil {
+				return nil, err
+			}
+			trailer, ok := o.(Dict)
+			if !ok {
+				return nil, p.malformed("invalid trailer")
+			}
+			return trailer, nil
+		}
+		p.pos = pos
+		start, err := p.integer()
+		if err != nil {
+			return nil, err
+		}
+		count, err := p.integer()
+		if err != nil {
+			return nil, err
+		}
+		for i := int64(0); i < count; i++ {
+			offset, err := p.integer()
+			if err != nil {
+				return nil, err
+			}
+			gen, err := p.integer()
+			if err != nil {
+				return nil, err
+			}
+			typ := xrefInFile
+			switch p.keyword() {
+			case "n":
+			case "f":
+				typ = xrefFree
+			default:
+				return nil, p.malformed("invalid xref entry")
+			}
+			d.setXref(int(start+i), xrefEntry{typ: typ, offset: offset, index: int(gen)})
+		}
+	}
+}
+
+// readXrefStream reads the cross-reference stream at offset and returns its dictionary
+// as the trailer.
+func (d *Document) readXrefStream(offset int64) (trailer Dict, err error) {
+	p := &parser{data: d.data, pos: int(offset)}
+	_, o, err := p.indirect()
+	if err != nil {
+		return nil, err
+	}
+	s, ok := o.(*Stream)
+	if !ok || s.Dict["Type"] != Name("XRef") {
+		return nil, fmt.Errorf("%s: invalid xref stream at offset %d", ErrMalformed, offset)
+	}
+	data, err := d.Decode(s)
+	if err != nil {
+		return nil, err
+	}
+	w, ok := s.Dict["W"].(Array)
+	if !ok || len(w) != 3 {
+		return nil, fmt.Errorf("%s: invalid xref stream widths", ErrMalformed)
+	}
+	var widths [3]int
+	for i, v := range w {
+		n, ok := v.(int64)
+		if !ok || n < 0 || n > 8 {
+			return nil, fmt.Errorf("%s: invalid xref stream widths", ErrMalformed)
+		}
+		widths[i] = int(n)
+	}
+	index, ok := s.Dict["Index"].(Array)
+	if !ok {
+		size, _ := s.Dict["Size"].(int64)
+		index = Array{int64(0), size}
+	}
+	for i := 0; i+1 < len(index); i += 2 {
+		start, _ := index[i].(int64)
+		count, _ := index[i+1].(int64)
+		for j := int64(0); j < count; j++ {
+			var fields [3]int64
+			for k, width := range widths {
+				if len(data) < width {
+					return nil, fmt.Errorf("%s: truncated xref stream", ErrMalformed)
+				}
+				for _, b := range data[:width] {
+					fields[k] = fields[k]<<8 | int64(b)
+				}
+				data = data[width:]
+			}
+			// type field defaults to 1 when it's omitted.
+			if widths[0] == 0 {
+				fields[0] = xrefInFile
+			}
+			d.setXref(int(start+j), xrefEntry{typ: int(fields[0]), offset: fields[1], index: int(fields[2])})
+		}
+	}
+	return s.Dict, nil
+}
+
+// setXref sets the xref entry of object num unless it's already set by a newer section.
+func (d *Document) setXref(num int, e xrefEntry) {
+	if _, ok := d.xref[num]; !ok {
+		d.xref[num] = e
+	}
+}
+
+// Size returns the number of objects referenced by the document's cross-reference table,
+// which is one more than the highest object number.
+func (d *Document) Size() int {
+	size := 0
+	if s, ok := d.Trailer["Size"].(int64); ok {
+		size = int(s)
+	}
+	for num := range d.xref {
+		if num >= size {
+			size = num + 1
+		}
+	}
+	return size
+}
+
+// Object gets the indirect object with num.
+// nil is returned for free and missing objects as required by the spec.
+func (d *Document) Object(num int) (Object, error) {
+	if o, ok := d.objects[num]; ok {
+		return o, nil
+	}
+	e, ok := d.xref[num]
+	if !ok || e.typ == xrefFree {
+		return nil, nil
+	}
+	var (
+		o   Object
+		err error
+	)
+	switch e.typ {
+	case xrefInFile:
+		o, err = d.objectAt(num, e.offset)
+	case xrefCompressed:
+		o, err = d.compressedObject(int(e.offset), e.index)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.objects[num] = o
+	return o, nil
+}
+
+// objectAt parses the indirect object num located at offset.
+func (d *Document) objectAt(num int, offset int64) (Object, error) {
+	if offset < 0 || offset >= int64(len(d.data)) {
+		return nil, fmt.Errorf("%s: invalid offset %d for object %d", ErrMalformed, offset, num)
+	}
+	p := &parser{data: d.data, pos: int(offset), resolveLength: d.resolveLength}
+	ref, o, err := p.indirect()
+	if err != nil {
+		return nil, err
+	}
+	if ref.Num != num {
+		return nil, fmt.Errorf("%s: expected object %d at offset %d, found %d", ErrMalformed, num, offset, ref.Num)
+	}
+	return o, nil
+}
+
+// compressedObject parses the object at index of object stream stmNum.
+func (d *Document) compressedObject(stmNum, index int) (Object, error) {
+	o, err := d.Object(stmNum)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := o.(*Stream)
+	if !ok {
+		return nil, fmt.Errorf("%s: object stream %d not found", ErrMalformed, stmNum)
+	}
+	data, err := d.Decode(s)
+	if err != nil {
+		return nil, err
+	}
+	n, _ := s.Dict["N"].(int64)
+	first, _ := s.Dict["First"].(int64)
+	if int64(index) >= n || first > int64(len(data)) {
+		return nil, fmt.Errorf("%s: invalid object stream %d", ErrMalformed, stmNum)
+	}
+	// header of object stream consists of pairs of object numbers and their offsets.
+	p := &parser{data: data}
+	var offset int64
+	for i := 0; i <= index; i++ {
+		if _, err := p.integer(); err != nil {
+			return nil, err
+		}
+		if offset, err = p.integer(); err != nil {
+			return nil, err
+		}
+	}
+	p = &parser{data: data, pos: int(first + offset)}
+	if p.pos > len(data) {
+		return nil, fmt.Errorf("%s: invalid object stream %d", ErrMalformed, stmNum)
+	}
+	return p.object()
+}
+
+// resolveLength resolves the indirect length of a stream.
+func (d *Document) resolveLength(ref Ref) (int64, bool) {
+	o, err := d.Resolve(ref)
+	if err != nil {
+		return 0, false
+	}
+	n, ok := o.(int64)
+	return n, ok
+}
+
+// Resolve follows o until it's a direct object if it's a reference.
+func (d *Document) Resolve(o Object) (Object, error) {
+	for i := 0; i < maxResolveDepth; i++ {
+		ref, ok := o.(Ref)
+		if !ok {
+			return o, nil
+		}
+		var err error
+		if o, err = d.Object(ref.Num); err != nil {
+			return nil, err
+		}
+	}
+	return nil, fmt.Errorf("%s: too deep references", ErrMalformed)
+}
+
+// ResolveDict resolves o and expects it to be a dictionary.
+// nil is returned when o is not a dictionary.
+func (d *Document) ResolveDict(o Object) (Dict, error) {
+	o, err := d.Resolve(o)
+	if err != nil {
+		return nil, err
+	}
+	switch v := o.(type) {
+	case Dict:
+		return v, nil
+	case *Stream:
+		return v.Dict, nil
+	}
+	return nil, nil
+}
+
+// Decode decodes the content of stream s.
+// only FlateDecode filter is supported since that's what PDF producers use in practice.
+func (d *Document) Decode(s *Stream) ([]byte, error) {
+	filters, err := d.Resolve(s.Dict["Filter"])
+	if err != nil {
+		return nil, err
+	}
+	params, err := d.Resolve(s.Dict["DecodeParms"])
+	if err != nil {
+		return nil, err
+	}
+	fs, ok := filters.(Array)
+	if !ok {
+		fs = Array{filters}
+	}
+	ps, ok := params.(Array)
+	if !ok {
+		ps = Array{params}
+	}
+	data := s.Data
+	for i, f := range fs {
+		if f == nil {
+			continue
+		}
+		var p Dict
+		if i < len(ps) {
+			if p, err = d.ResolveDict(ps[i]); err != nil {
+				return nil, err
+			}
+		}
+		switch f {
+		case Name("FlateDecode"), Name("Fl"):
+			if data, err = inflate(data, p); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("pdf: unsupported filter %v", f)
+		}
+	}
+	return data, nil
+}
+
+// inflate decompresses data and reverses the predictor set in params if there is any.
+func inflate(data []byte, params Dict) ([]byte, error) {
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	out, err := ioutil.ReadAll(zr)
+	if err != nil && len(out) == 0 {
+		return nil, err
+	}
+	predictor, _ := params["Predictor"].(int64)
+	if predictor < 10 {
+		return out, nil
+	}
+	// PNG predictors.
+	columns, ok := params["Columns"].(int64)
+	if !ok {
+		columns = 1
+	}
+	colors, ok := params["Colors"].(int64)
+	if !ok {
+		colors = 1
+	}
+	bpc, ok := params["BitsPerComponent"].(int64)
+	if !ok {
+		bpc = 8
+	}
+	bpp := int((colors*bpc + 7) / 8)
+	rowSize := int((columns*colors*bpc + 7) / 8)
+	var (
+		result []byte
+		prev   = make([]byte, rowSize)
+	)
+	for len(out) > rowSize {
+		typ, row := out[0], out[1:rowSize+1]
+		out = out[rowSize+1:]
+		for i := range row {
+			var left, upLeft byte
+			if i >= bpp {
+				left, upLeft = row[i-bpp], prev[i-bpp]
+			}
+			switch typ {
+			case 1:
+				row[i] += left
+			case 2:
+				row[i] += prev[i]
+			case 3:
+				row[i] += byte((int(left) + int(prev[i])) / 2)
+			case 4:
+				row[i] += paeth(left, prev[i], upLeft)
+			}
+		}
+		result = append(result, row...)
+		prev = row
+	}
+	return result, nil
+}
+
+// paeth is the Paeth predictor function of PNG.
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Catalog returns the document catalog.
+func (d *Document) Catalog() (Dict, error) {
+	root, err := d.ResolveDict(d.Trailer["Root"])
+	if err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, fmt.Errorf("%s: document catalog not found", ErrMalformed)
+	}
+	return root, nil
+}
+
+// PageCount returns the number of pages in document.
+func (d *Document) PageCount() (int, error) {
+	catalog, err := d.Catalog()
+	if err != nil {
+		return 0, err
+	}
+	root, err := d.ResolveDict(catalog["Pages"])
+	if err != nil {
+		return 0, err
+	}
+	// root of page tree keeps the total number of pages, walk the tree only when it's missing.
+	if count, ok := root["Count"].(int64); ok && count >= 0 {
+		return int(count), nil
+	}
+	pages, err := d.Pages()
+	if err != nil {
+		return 0, err
+	}
+	return len(pages), nil
+}
+
+// inheritableAttrs are the page attributes that can be inherited from the page tree.
+var inheritableAttrs = []Name{"Resources", "MediaBox", "CropBox", "Rotate"}
+
+// Page is a page of document.
+type Page struct {
+	// Ref is the reference of page object.
+	Ref Ref
+
+	// Dict is the page object.
+	Dict Dict
+
+	// inherited keeps the attributes inherited from page's ancestors.
+	inherited Dict
+}
+
+// Attr gets the page attribute with key by looking up page's ancestors for inheritable attributes.
+func (p *Page) Attr(key Name) Object {
+	if v, ok := p.Dict[key]; ok {
+		return v
+	}
+	return p.inherited[key]
+}
+
+// Pages returns the pages of document in order.
+func (d *Document) Pages() ([]*Page, error) {
+	catalog, err := d.Catalog()
+	if err != nil {
+		return nil, err
+	}
+	ref, ok := catalog["Pages"].(Ref)
+	if !ok {
+		return nil, fmt.Errorf("%s: page tree not found", ErrMalformed)
+	}
+	var pages []*Page
+	visited := make(map[Ref]bool)
+	var walk func(ref Ref, inherited Dict) error
+	walk = func(ref Ref, inherited Dict) error {
+		if visited[ref] {
+			return fmt.Errorf("%s: cycle in page tree", ErrMalformed)
+		}
+		visited[ref] = true
+		node, err := d.ResolveDict(ref)
+		if err != nil {
+			return err
+		}
+		if node == nil {
+			return fmt.Errorf("%s: invalid page tree node %d", ErrMalformed, ref.Num)
+		}
+		kids, err := d.Resolve(node["Kids"])
+		if err != nil {
+			return err
+		}
+		if node["Type"] == Name("Page") || kids == nil {
+			pages = append(pages, &Page{Ref: ref, Dict: node, inherited: inherited})
+			return nil
+		}
+		attrs := inherited.Copy()
+		for _, key := range inheritableAttrs {
+			if v, ok := node[key]; ok {
+				attrs[key] = v
+			}
+		}
+		kidsArr, _ := kids.(Array)
+		for _, kid := range kidsArr {
+			kidRef, ok := kid.(Ref)
+			if !ok {
+				return fmt.Errorf("%s: page tree kids must be indirect", ErrMalformed)
+			}
+			if err := walk(kidRef, attrs); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(ref, Dict{}); err != nil {
+		return nil, err
+	}
+	return pages, nil
+}
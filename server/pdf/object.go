@@ -0,0 +1,151 @@
+// Package pdf is a minimal PDF reader and incremental writer.
+// it understands just enough of the PDF file format (ISO 32000) to let TOPDF inspect and
+// post-process documents produced by PDF servers: resolving objects, walking the page tree
+// and appending changed objects to a document as an incremental update.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// Object is a PDF object. it's one of nil (null), bool, int64, float64, String, Name, Array,
+// Dict, *Stream or Ref.
+type Object interface{}
+
+// Name is a PDF name object without its leading slash.
+type Name string
+
+// String is a PDF string object with its escapes decoded.
+type String []byte
+
+// Array is a PDF array object.
+type Array []Object
+
+// Dict is a PDF dictionary object.
+type Dict map[Name]Object
+
+// Copy returns a shallow copy of d.
+func (d Dict) Copy() Dict {
+	c := make(Dict, len(d))
+	for k, v := range d {
+		c[k] = v
+	}
+	return c
+}
+
+// Ref is a reference to an indirect object.
+type Ref struct {
+	Num int
+	Gen int
+}
+
+// Stream is a PDF stream object.
+type Stream struct {
+	Dict Dict
+
+	// Data is the raw, still encoded content of stream.
+	// use Document.Decode() to get decoded content.
+	Data []byte
+}
+
+// Number converts a numeric object to float64.
+func Number(o Object) (n float64, ok bool) {
+	switch v := o.(type) {
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// writeObject serializes o into buf.
+func writeObject(buf *bytes.Buffer, o Object) {
+	switch v := o.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case int64:
+		buf.WriteString(strconv.FormatInt(v, 10))
+	case int:
+		buf.WriteString(strconv.Itoa(v))
+	case float64:
+		buf.WriteString(strconv.FormatFloat(v, 'f', -1, 64))
+	case Name:
+		writeName(buf, v)
+	case String:
+		writeString(buf, v)
+	case Ref:
+		fmt.Fprintf(buf, "%d %d R", v.Num, v.Gen)
+	case Array:
+		buf.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				buf.WriteByte(' ')
+			}
+			writeObject(buf, e)
+		}
+		buf.WriteByte(']')
+	case Dict:
+		writeDict(buf, v)
+	case *Stream:
+		d := v.Dict.Copy()
+		d["Length"] = int64(len(v.Data))
+		writeDict(buf, d)
+		buf.WriteString("\nstream\n")
+		buf.Write(v.Data)
+		buf.WriteString("\nendstream")
+	default:
+		panic(fmt.Sprintf("pdf: cannot serialize object of type %T", o))
+	}
+}
+
+// writeDict serializes d into buf with its keys sorted to have a stable output.
+func writeDict(buf *bytes.Buffer, d Dict) {
+	keys := make([]string, 0, len(d))
+	for k := range d {
+		keys = append(keys, string(k))
+	}
+	sort.Strings(keys)
+	buf.WriteString("<<")
+	for _, k := range keys {
+		writeName(buf, Name(k))
+		buf.WriteByte(' ')
+		writeObject(buf, d[Name(k)])
+	}
+	buf.WriteString(">>")
+}
+
+// writeName serializes n into buf by escaping irregular characters.
+func writeName(buf *bytes.Buffer, n Name) {
+	buf.WriteByte('/')
+	for i := 0; i < len(n); i++ {
+		c := n[i]
+		if c < '!' || c > '~' || c == '#' || isDelimiter(c) {
+			fmt.Fprintf(buf, "#%02X", c)
+			continue
+		}
+		buf.WriteByte(c)
+	}
+}
+
+// writeString serializes s into buf as a literal string.
+func writeString(buf *bytes.Buffer, s String) {
+	buf.WriteByte('(')
+	for _, c := range s {
+		switch {
+		case c == '(' || c == ')' || c == '\\':
+			buf.WriteByte('\\')
+			buf.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(buf, "\\%03o", c)
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	buf.WriteByte(')')
+}
@@ -0,0 +1,376 @@
+package pdf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// ErrMalformed returned when a PDF document cannot be parsed.
+var ErrMalformed = errors.New("malformed pdf")
+
+// parser parses PDF objects from data starting at pos.
+type parser struct {
+	data []byte
+	pos  int
+
+	// resolveLength used to resolve indirect /Length values of streams.
+	// it's optional, streams with indirect lengths are scanned for their endstream keyword
+	// when it's not provided.
+	resolveLength func(Ref) (int64, bool)
+}
+
+// malformed creates a detailed ErrMalformed error.
+func (p *parser) malformed(format string, a ...interface{}) error {
+	return fmt.Errorf("%s: %s at offset %d", ErrMalformed, fmt.Sprintf(format, a...), p.pos)
+}
+
+// skipSpace skips white-spaces and comments.
+func (p *parser) skipSpace() {
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		if c == '%' {
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' && p.data[p.pos] != '\r' {
+				p.pos++
+			}
+			continue
+		}
+		if !isSpace(c) {
+			return
+		}
+		p.pos++
+	}
+}
+
+// keyword reads a keyword, a sequence of regular characters.
+func (p *parser) keyword() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.data) && !isSpace(p.data[p.pos]) && !isDelimiter(p.data[p.pos]) {
+		p.pos++
+	}
+	return string(p.data[start:p.pos])
+}
+
+// expect reads a keyword and makes sure that it's kw.
+func (p *parser) expect(kw string) error {
+	if k := p.keyword(); k != kw {
+		return p.malformed("expected %q, got %q", kw, k)
+	}
+	return nil
+}
+
+// integer reads an integer.
+func (p *parser) integer() (int64, error) {
+	k := p.keyword()
+	n, err := strconv.ParseInt(k, 10, 64)
+	if err != nil {
+		return 0, p.malformed("expected an integer, got %q", k)
+	}
+	return n, nil
+}
+
+// object parses next object.
+func (p *parser) object() (Object, error) {
+	p.skipSpace()
+	if p.pos >= len(p.data) {
+		return nil, p.malformed("unexpected end of data")
+	}
+	switch c := p.data[p.pos]; {
+	case c == '/':
+		return p.name(), nil
+	case c == '(':
+		return p.literalString()
+	case c == '<' && p.pos+1 < len(p.data) && p.data[p.pos+1] == '<':
+		p.pos += 2
+		return p.dict()
+	case c == '<':
+		return p.hexString()
+	case c == '[':
+		p.pos++
+		return p.array()
+	case c == '+' || c == '-' || c == '.' || isDigit(c):
+		return p.numberOrRef()
+	}
+	switch k := p.keyword(); k {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	default:
+		return nil, p.malformed("unexpected keyword %q", k)
+	}
+}
+
+// name parses a name object.
+func (p *parser) name() Name {
+	p.pos++ // skip '/'.
+	var b []byte
+	for p.pos < len(p.data) && !isSpace(p.data[p.pos]) && !isDelimiter(p.data[p.pos]) {
+		c := p.data[p.pos]
+		if c == '#' && p.pos+2 < len(p.data) {
+			if v, err := strconv.ParseUint(string(p.data[p.pos+1:p.pos+3]), 16, 8); err == nil {
+				b = append(b, byte(v))
+				p.pos += 3
+				continue
+			}
+		}
+		b = append(b, c)
+		p.pos++
+	}
+	return Name(b)
+}
+
+// literalString parses a literal string object.
+func (p *parser) literalString() (String, error) {
+	p.pos++ // skip '('.
+	var (
+		b     []byte
+		depth = 1
+	)
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return String(b), nil
+			}
+		case '\r':
+			// end of lines are always read as \n.
+			if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+				p.pos++
+			}
+			c = '\n'
+		case '\\':
+			if p.pos >= len(p.data) {
+				continue
+			}
+			e := p.data[p.pos]
+			p.pos++
+			switch e {
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			case 't':
+				c = '\t'
+			case 'b':
+				c = '\b'
+			case 'f':
+				c = '\f'
+			case '\r':
+				// line continuation.
+				if p.pos < len(p.data) && p.data[p.pos] == '\n' {
+					p.pos++
+				}
+				continue
+			case '\n':
+				continue
+			default:
+				if e >= '0' && e <= '7' {
+					v := int(e - '0')
+					for i := 0; i < 2 && p.pos < len(p.data) && p.data[p.pos] >= '0' && p.data[p.pos] <= '7'; i++ {
+						v = v*8 + int(p.data[p.pos]-'0')
+						p.pos++
+					}
+					c = byte(v)
+				} else {
+					c = e
+				}
+			}
+		}
+		b = append(b, c)
+	}
+	return nil, p.malformed("unterminated string")
+}
+
+// hexString parses a hexadecimal string object.
+func (p *parser) hexString() (String, error) {
+	p.pos++ // skip '<'.
+	var digits []byte
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		p.pos++
+		if c == '>' {
+			if len(digits)%2 == 1 {
+				digits = append(digits, '0')
+			}
+			b := make([]byte, len(digits)/2)
+			for i := range b {
+				v, err := strconv.ParseUint(string(digits[i*2:i*2+2]), 16, 8)
+				if err != nil {
+					return nil, p.malformed("invalid hex string")
+				}
+				b[i] = byte(v)
+			}
+			return String(b), nil
+		}
+		if !isSpace(c) {
+			digits = append(digits, c)
+		}
+	}
+	return nil, p.malformed("unterminated hex string")
+}
+
+// array parses an array object.
+func (p *parser) array() (Array, error) {
+	a := Array{}
+	for {
+		p.skipSpace()
+		if p.pos < len(p.data) && p.data[p.pos] == ']' {
+			p.pos++
+			return a, nil
+		}
+		o, err := p.object()
+		if err != nil {
+			return nil, err
+		}
+		a = append(a, o)
+	}
+}
+
+// dict parses a dictionary object.
+func (p *parser) dict() (Dict, error) {
+	d := Dict{}
+	for {
+		p.skipSpace()
+		if bytes.HasPrefix(p.data[p.pos:], []byte(">>")) {
+			p.pos += 2
+			return d, nil
+		}
+		if p.pos >= len(p.data) || p.data[p.pos] != '/' {
+			return nil, p.malformed("expected a dictionary key")
+		}
+		key := p.name()
+		value, err := p.object()
+		if err != nil {
+			return nil, err
+		}
+		d[key] = value
+	}
+}
+
+// numberOrRef parses a number or a reference to an indirect object.
+func (p *parser) numberOrRef() (Object, error) {
+	k := p.keyword()
+	n, err := strconv.ParseInt(k, 10, 64)
+	if err != nil {
+		f, err := strconv.ParseFloat(k, 64)
+		if err != nil {
+			// PDF readers are expected to be forgiving about malformed numbers.
+			return int64(0), nil
+		}
+		return f, nil
+	}
+	// an integer followed by another integer and R keyword is a reference.
+	pos := p.pos
+	if gen, err := p.integer(); err == nil && p.keyword() == "R" {
+		return Ref{Num: int(n), Gen: int(gen)}, nil
+	}
+	p.pos = pos
+	return n, nil
+}
+
+// indirect parses an indirect object definition and returns its reference with itself.
+func (p *parser) indirect() (ref Ref, o Object, err error) {
+	num, err := p.integer()
+	if err != nil {
+		return ref, nil, err
+	}
+	gen, err := p.integer()
+	if err != nil {
+		return ref, nil, err
+	}
+	if err := p.expect("obj"); err != nil {
+		return ref, nil, err
+	}
+	ref = Ref{Num: int(num), Gen: int(gen)}
+	if o, err = p.object(); err != nil {
+		return ref, nil, err
+	}
+	d, ok := o.(Dict)
+	if !ok {
+		return ref, o, nil
+	}
+	pos := p.pos
+	if p.keyword() != "stream" {
+		p.pos = pos
+		return ref, o, nil
+	}
+	data, err := p.streamData(d)
+	return ref, &Stream{Dict: d, Data: data}, err
+}
+
+// streamData reads the content of a stream whose dictionary is d.
+func (p *parser) streamData(d Dict) ([]byte, error) {
+	// stream keyword followed by CRLF or LF.
+	if bytes.HasPrefix(p.data[p.pos:], []byte("\r\n")) {
+		p.pos += 2
+	} else if p.pos < len(p.data) && (p.data[p.pos] == '\n' || p.data[p.pos] == '\r') {
+		p.pos++
+	}
+	start := p.pos
+	length := int64(-1)
+	switch l := d["Length"].(type) {
+	case int64:
+		length = l
+	case Ref:
+		if p.resolveLength != nil {
+			if n, ok := p.resolveLength(l); ok {
+				length = n
+			}
+		}
+	}
+	if length >= 0 && int64(start)+length <= int64(len(p.data)) {
+		end := start + int(length)
+		p.pos = end
+		if p.keyword() == "endstream" {
+			return p.data[start:end], nil
+		}
+	}
+	// length is either unknown or wrong, look for the endstream keyword instead.
+	i := bytes.Index(p.data[start:], []byte("endstream"))
+	if i == -1 {
+		p.pos = start
+		return nil, p.malformed("unterminated stream")
+	}
+	end := start + i
+	p.pos = end + len("endstream")
+	if end > start && p.data[end-1] == '\n' {
+		end--
+	}
+	if end > start && p.data[end-1] == '\r' {
+		end--
+	}
+	return p.data[start:end], nil
+}
+
+// isSpace checks if c is a PDF white-space character.
+func isSpace(c byte) bool {
+	switch c {
+	case 0, '\t', '\n', '\f', '\r', ' ':
+		return true
+	}
+	return false
+}
+
+// isDelimiter checks if c is a PDF delimiter character.
+func isDelimiter(c byte) bool {
+	switch c {
+	case '(', ')', '<', '>', '[', ']', '{', '}', '/', '%':
+		return true
+	}
+	return false
+}
+
+// isDigit checks if c is a decimal digit.
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,204 @@
+package pdf
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readTestPDF(t *testing.T, name string) *Document {
+	data, err := ioutil.ReadFile("testdata/" + name)
+	require.NoError(t, err)
+	doc, err := Parse(data)
+	require.NoError(t, err)
+	return doc
+}
+
+func TestParseObjects(t *testing.T) {
+	p := &parser{data: []byte(`<< /Name /A#20B /Int -12 /Real .5 /Str (a\(b\)\101) /Hex <48 49 5> ` +
+		`/Arr [1 0 R 2 true null] /Dict << /K (v) >> >>`)}
+	o, err := p.object()
+	require.NoError(t, err)
+	require.Equal(t, Dict{
+		"Name": Name("A B"),
+		"Int":  int64(-12),
+		"Real": 0.5,
+		"Str":  String("a(b)A"),
+		"Hex":  String("HIP"),
+		"Arr":  Array{Ref{Num: 1}, int64(2), true, nil},
+		"Dict": Dict{"K": String("v")},
+	}, o)
+}
+
+func TestWriteObjectRoundTrip(t *testing.T) {
+	in := Dict{
+		"A": Name("with space"),
+		"B": String("(paren) \\ \n"),
+		"C": Array{int64(1), 2.5, Ref{Num: 3, Gen: 1}, false, nil},
+	}
+	var buf bytes.Buffer
+	writeObject(&buf, in)
+	p := &parser{data: buf.Bytes()}
+	out, err := p.object()
+	require.NoError(t, err)
+	require.Equal(t, in, out)
+}
+
+func TestPagesClassic(t *testing.T) {
+	doc := readTestPDF(t, "classic.pdf")
+	pages, err := doc.Pages()
+	require.NoError(t, err)
+	require.Len(t, pages, 2)
+	require.Equal(t, Ref{Num: 3}, pages[0].Ref)
+	// first page inherits its media box from the page tree.
+	require.Equal(t, Array{int64(0), int64(0), int64(612), int64(792)}, pages[0].Attr("MediaBox"))
+	require.Equal(t, Array{int64(0), int64(0), int64(842), int64(595)}, pages[1].Attr("MediaBox"))
+	require.NotNil(t, pages[1].Attr("Resources"))
+}
+
+func TestPageCount(t *testing.T) {
+	doc := readTestPDF(t, "compressed.pdf")
+	count, err := doc.PageCount()
+	require.NoError(t, err)
+	require.Equal(t, 3, count)
+}
+
+func TestPagesCompressed(t *testing.T) {
+	doc := readTestPDF(t, "compressed.pdf")
+	pages, err := doc.Pages()
+	require.NoError(t, err)
+	require.Len(t, pages, 3)
+	o, err := doc.Resolve(pages[2].Dict["Contents"])
+	require.NoError(t, err)
+	data, err := doc.Decode(o.(*Stream))
+	require.NoError(t, err)
+	require.Equal(t, "BT 72 720 Td (page three) Tj ET", string(data))
+}
+
+func TestParseMalformed(t *testing.T) {
+	_, err := Parse([]byte("not a pdf"))
+	require.Error(t, err)
+}
+
+func TestUpdate(t *testing.T) {
+	for _, name := range []string{"classic.pdf", "compressed.pdf"} {
+		t.Run(name, func(t *testing.T) {
+			doc := readTestPDF(t, name)
+			pages, err := doc.Pages()
+			require.NoError(t, err)
+			u := doc.NewUpdate()
+			content := u.Add(&Stream{Dict: Dict{}, Data: []byte("q Q")})
+			page := pages[0].Dict.Copy()
+			page["Contents"] = content
+			u.Set(pages[0].Ref, page)
+			data, err := u.Bytes()
+			require.NoError(t, err)
+
+			// updated document should be readable with the changes applied.
+			updated, err := Parse(data)
+			require.NoError(t, err)
+			updatedPages, err := updated.Pages()
+			require.NoError(t, err)
+			require.Len(t, updatedPages, len(pages))
+			require.Equal(t, content, updatedPages[0].Dict["Contents"])
+			o, err := updated.Resolve(content)
+			require.NoError(t, err)
+			require.Equal(t, []byte("q Q"), o.(*Stream).Data)
+			require.Equal(t, pages[1].Dict, updatedPages[1].Dict)
+		})
+	}
+}
+
+func TestUpdateXrefStream(t *testing.T) {
+	tests := []struct {
+		name       string
+		xrefStream bool
+	}{
+		{"classic.pdf", false},
+		// compressed.pdf has an xref stream, its updates should be too.
+		{"compressed.pdf", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := readTestPDF(t, tt.name)
+			require.Equal(t, tt.xrefStream, doc.xrefStream)
+			u := doc.NewUpdate()
+			ref := u.Add(String("first"))
+			data, err := u.Bytes()
+			require.NoError(t, err)
+			updated, err := Parse(data)
+			require.NoError(t, err)
+			require.Equal(t, tt.xrefStream, updated.xrefStream)
+			require.Equal(t, doc.startxref, updated.Trailer["Prev"])
+			require.Equal(t, doc.Trailer["Root"], updated.Trailer["Root"])
+			if tt.xrefStream {
+				require.Equal(t, Name("XRef"), updated.Trailer["Type"])
+				// xref stream is an object of update itself.
+				require.Equal(t, int64(ref.Num+2), updated.Trailer["Size"])
+			}
+
+			// updates of updated documents are chained to their previous sections.
+			u = updated.NewUpdate()
+			second := u.Add(String("second"))
+			data, err = u.Bytes()
+			require.NoError(t, err)
+			updated, err = Parse(data)
+			require.NoError(t, err)
+			for ref, expected := range map[Ref]String{ref: String("first"), second: String("second")} {
+				o, err := updated.Object(ref.Num)
+				require.NoError(t, err)
+				require.Equal(t, expected, o)
+			}
+			count, err := updated.PageCount()
+			require.NoError(t, err)
+			pages, err := doc.Pages()
+			require.NoError(t, err)
+			require.Equal(t, len(pages), count)
+		})
+	}
+}
+
+func TestUpdateEncrypted(t *testing.T) {
+	doc := readTestPDF(t, "classic.pdf")
+	doc.Trailer["Encrypt"] = Ref{Num: 9}
+	_, err := doc.NewUpdate().Bytes()
+	require.Equal(t, ErrEncrypted, err)
+}
+
+func TestRewrite(t *testing.T) {
+	for _, name := range []string{"classic.pdf", "compressed.pdf"} {
+		t.Run(name, func(t *testing.T) {
+			doc := readTestPDF(t, name)
+			pages, err := doc.Pages()
+			require.NoError(t, err)
+			// unlink the content of last page.
+			delete(pages[len(pages)-1].Dict, "Contents")
+			data, err := doc.Rewrite()
+			require.NoError(t, err)
+
+			rewritten, err := Parse(data)
+			require.NoError(t, err)
+			rewrittenPages, err := rewritten.Pages()
+			require.NoError(t, err)
+			require.Len(t, rewrittenPages, len(pages))
+			require.NotContains(t, rewrittenPages[len(pages)-1].Dict, Name("Contents"))
+			o, err := rewritten.Resolve(rewrittenPages[0].Dict["Contents"])
+			require.NoError(t, err)
+			require.NotNil(t, o)
+		})
+	}
+	// unlinked objects are dropped.
+	data, err := readTestPDF(t, "classic.pdf").Rewrite()
+	require.NoError(t, err)
+	require.True(t, bytes.HasPrefix(data, []byte("%PDF-1.4\n")))
+	require.Contains(t, string(data), "(Page two)")
+	doc := readTestPDF(t, "classic.pdf")
+	pages, err := doc.Pages()
+	require.NoError(t, err)
+	delete(pages[1].Dict, "Contents")
+	data, err = doc.Rewrite()
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "(Page two)")
+}
@@ -0,0 +1,116 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// defaultHeader is the header written when the original header of document cannot be found.
+const defaultHeader = "%PDF-1.7"
+
+// Rewrite writes d as a new document that only contains the objects reachable from its trailer.
+// unlike incremental updates, the original content is not kept, so objects that are unlinked by
+// modifying the objects returned by Object(), ResolveDict() and Pages() are dropped from the output.
+// object streams are expanded and a classic cross-reference table is written.
+func (d *Document) Rewrite() ([]byte, error) {
+	if d.IsEncrypted() {
+		return nil, ErrEncrypted
+	}
+	objects := make(map[Ref]Object)
+	var queue []Ref
+	// visit queues the references in o to be written.
+	var visit func(o Object)
+	visit = func(o Object) {
+		switch v := o.(type) {
+		case Ref:
+			if _, ok := objects[v]; !ok {
+				objects[v] = nil
+				queue = append(queue, v)
+			}
+		case Array:
+			for _, e := range v {
+				visit(e)
+			}
+		case Dict:
+			for _, e := range v {
+				visit(e)
+			}
+		case *Stream:
+			for key, e := range v.Dict {
+				// length of stream is written directly by writeObject().
+				if key != "Length" {
+					visit(e)
+				}
+			}
+		}
+	}
+	for _, key := range []Name{"Root", "Info"} {
+		visit(d.Trailer[key])
+	}
+	for len(queue) > 0 {
+		ref := queue[0]
+		queue = queue[1:]
+		o, err := d.Object(ref.Num)
+		if err != nil {
+			return nil, err
+		}
+		objects[ref] = o
+		visit(o)
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, len(d.data)))
+	buf.WriteString(d.header())
+	// a comment with binary characters lets file transfer tools know that document is binary.
+	buf.WriteString("\n%\xe2\xe3\xcf\xd3\n")
+	refs := make([]Ref, 0, len(objects))
+	size := 1
+	for ref := range objects {
+		refs = append(refs, ref)
+		if ref.Num >= size {
+			size = ref.Num + 1
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Num < refs[j].Num })
+	offsets := make(map[int]int, len(refs))
+	gens := make(map[int]int, len(refs))
+	for _, ref := range refs {
+		offsets[ref.Num] = buf.Len()
+		gens[ref.Num] = ref.Gen
+		fmt.Fprintf(buf, "%d %d obj\n", ref.Num, ref.Gen)
+		writeObject(buf, objects[ref])
+		buf.WriteString("\nendobj\n")
+	}
+	xref := buf.Len()
+	fmt.Fprintf(buf, "xref\n0 %d\n", size)
+	for num := 0; num < size; num++ {
+		offset, ok := offsets[num]
+		if !ok {
+			buf.WriteString("0000000000 65535 f\r\n")
+			continue
+		}
+		fmt.Fprintf(buf, "%010d %05d n\r\n", offset, gens[num])
+	}
+	trailer := Dict{"Size": int64(size)}
+	for _, key := range []Name{"Root", "Info", "ID"} {
+		if v, ok := d.Trailer[key]; ok {
+			trailer[key] = v
+		}
+	}
+	buf.WriteString("trailer\n")
+	writeObject(buf, trailer)
+	fmt.Fprintf(buf, "\nstartxref\n%d\n%%%%EOF\n", xref)
+	return buf.Bytes(), nil
+}
+
+// header gets the header line of document that keeps its version.
+func (d *Document) header() string {
+	if !bytes.HasPrefix(d.data, []byte("%PDF-")) {
+		return defaultHeader
+	}
+	end := bytes.IndexAny(d.data, "\r\n")
+	if end == -1 || end > len("%PDF-1.7") {
+		return defaultHeader
+	}
+	return string(d.data[:end])
+}
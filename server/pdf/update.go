@@ -0,0 +1,156 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+)
+
+// Update collects changed and new objects of a document to append them as an incremental update.
+// incremental updates keep the original content of documents untouched, new revisions of objects
+// are written after it together with a cross-reference section that points to them.
+type Update struct {
+	doc *Document
+
+	// objects are the changed and new objects by their references.
+	objects map[Ref]Object
+
+	// size is one more than the highest object number.
+	size int
+}
+
+// NewUpdate starts a new incremental update for d.
+func (d *Document) NewUpdate() *Update {
+	return &Update{
+		doc:     d,
+		objects: make(map[Ref]Object),
+		size:    d.Size(),
+	}
+}
+
+// Set replaces the indirect object with ref by o.
+func (u *Update) Set(ref Ref, o Object) {
+	u.objects[ref] = o
+	if ref.Num >= u.size {
+		u.size = ref.Num + 1
+	}
+}
+
+// Add adds o as a new indirect object and returns a reference to it.
+func (u *Update) Add(o Object) Ref {
+	ref := Ref{Num: u.size}
+	u.Set(ref, o)
+	return ref
+}
+
+// Bytes returns the document with the update appended.
+func (u *Update) Bytes() ([]byte, error) {
+	if u.doc.IsEncrypted() {
+		return nil, ErrEncrypted
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, len(u.doc.data)+len(u.objects)*256))
+	buf.Write(u.doc.data)
+	if n := len(u.doc.data); n > 0 && u.doc.data[n-1] != '\n' && u.doc.data[n-1] != '\r' {
+		buf.WriteByte('\n')
+	}
+	refs := make([]Ref, 0, len(u.objects))
+	for ref := range u.objects {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Num < refs[j].Num })
+	offsets := make([]int, len(refs))
+	for i, ref := range refs {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(buf, "%d %d obj\n", ref.Num, ref.Gen)
+		writeObject(buf, u.objects[ref])
+		buf.WriteString("\nendobj\n")
+	}
+	xref := buf.Len()
+	// a section of the other kind cannot follow the latest one, documents with xref streams
+	// are updated with xref streams.
+	if u.doc.xrefStream {
+		u.writeXrefStream(buf, refs, offsets)
+	} else {
+		u.writeXrefTable(buf, refs, offsets)
+	}
+	fmt.Fprintf(buf, "\nstartxref\n%d\n%%%%EOF\n", xref)
+	return buf.Bytes(), nil
+}
+
+// writeXrefTable writes a classic cross-reference table and its trailer for objects with refs
+// that are written at offsets.
+func (u *Update) writeXrefTable(buf *bytes.Buffer, refs []Ref, offsets []int) {
+	buf.WriteString("xref\n")
+	for _, r := range xrefRuns(refs) {
+		fmt.Fprintf(buf, "%d %d\n", refs[r[0]].Num, r[1]-r[0])
+		for k := r[0]; k < r[1]; k++ {
+			fmt.Fprintf(buf, "%010d %05d n\r\n", offsets[k], refs[k].Gen)
+		}
+	}
+	buf.WriteString("trailer\n")
+	writeObject(buf, u.trailer(u.size))
+}
+
+// writeXrefStream writes a cross-reference stream for objects with refs that are written at
+// offsets. the stream is a new object itself and it's written at the current end of buf.
+func (u *Update) writeXrefStream(buf *bytes.Buffer, refs []Ref, offsets []int) {
+	self := Ref{Num: u.size}
+	refs = append(refs[:len(refs):len(refs)], self)
+	offsets = append(offsets[:len(offsets):len(offsets)], buf.Len())
+	// offsets are written with the least number of bytes that fits the largest one.
+	width := 1
+	for last := buf.Len(); last >= 1<<(8*uint(width)); {
+		width++
+	}
+	var (
+		index Array
+		data  []byte
+	)
+	for _, r := range xrefRuns(refs) {
+		index = append(index, int64(refs[r[0]].Num), int64(r[1]-r[0]))
+		for k := r[0]; k < r[1]; k++ {
+			data = append(data, xrefInFile)
+			for b := width - 1; b >= 0; b-- {
+				data = append(data, byte(offsets[k]>>(8*uint(b))))
+			}
+			data = append(data, byte(refs[k].Gen>>8), byte(refs[k].Gen))
+		}
+	}
+	dict := u.trailer(u.size + 1)
+	dict["Type"] = Name("XRef")
+	dict["W"] = Array{int64(1), int64(width), int64(2)}
+	dict["Index"] = index
+	fmt.Fprintf(buf, "%d %d obj\n", self.Num, self.Gen)
+	writeObject(buf, &Stream{Dict: dict, Data: data})
+	buf.WriteString("\nendobj")
+}
+
+// trailer creates the trailer dictionary of update with size that links to the previous
+// cross-reference section.
+func (u *Update) trailer(size int) Dict {
+	trailer := Dict{
+		"Size": int64(size),
+		"Prev": u.doc.startxref,
+	}
+	for _, key := range []Name{"Root", "Info", "ID"} {
+		if v, ok := u.doc.Trailer[key]; ok {
+			trailer[key] = v
+		}
+	}
+	return trailer
+}
+
+// xrefRuns splits sorted refs into runs of consecutive object numbers, each run is the start and
+// end indexes of its refs.
+func xrefRuns(refs []Ref) [][2]int {
+	var runs [][2]int
+	for i := 0; i < len(refs); {
+		j := i + 1
+		for j < len(refs) && refs[j].Num == refs[j-1].Num+1 {
+			j++
+		}
+		runs = append(runs, [2]int{i, j})
+		i = j
+	}
+	return runs
+}
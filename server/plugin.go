@@ -1,134 +1,588 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/audit"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/cluster"
 	"github.com/ilgooz/mattermost-plugin-topdf/server/gotenberg"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/job"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/logger"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/ratelimit"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/sanitize"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/signedurl"
 	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf"
 	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver/resilient"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/trace"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/warmup"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/watermark"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/webhook"
 	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xhttp"
-	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xtime"
+	"github.com/mattermost/mattermost-server/model"
 	"github.com/mattermost/mattermost-server/plugin"
 	"github.com/rs/cors"
 )
 
+// requestIDHeader is the header that carries the ID of requests. an ID set by clients is used when
+// it's valid, otherwise a new one is generated. it's always returned in responses so log lines of
+// requests can be found with it.
+const requestIDHeader = "X-Request-Id"
+
+// maxRequestIDLength is the maximum length of request IDs that accepted from clients.
+const maxRequestIDLength = 64
+
+// statusClientClosedRequest is the non-standard status code of requests that are canceled by
+// their clients, it's only logged since clients are already gone.
+const statusClientClosedRequest = 499
+
+// publicLinkViewer identifies the viewers of PDFs that accessed anonymously through public links in
+// watermarks.
+const publicLinkViewer = "public link"
+
+// errNotConfigured returned when plugin is used before a valid configuration is applied.
+var errNotConfigured = errors.New("plugin is not configured yet, check its configuration")
+
 // Plugin is a TOPDF app that implements plugin.MattermostPlugin.
 type Plugin struct {
 	plugin.MattermostPlugin
 
+	// current keeps the *components created from the current configuration.
+	// components are replaced atomically on configuration changes and requests that are already
+	// in-flight keep using the ones they started with until they're finished.
+	current atomic.Value
+
+	// lease elects the node that runs scheduled work in a cluster.
+	lease *cluster.Lease
+
+	// leader is 1 while this node holds the lease.
+	leader int32
+
+	// stopScheduler is closed to stop scheduled work and schedulerDone is closed once it's stopped.
+	stopScheduler chan struct{}
+	schedulerDone chan struct{}
+
+	// botUserID is the user id of Plugin's bot, it's ensured on activation.
+	botUserID string
+
+	// pdfServerDown is true while PDF server is not reachable by scheduled probes.
+	pdfServerDown bool
+
+	// pdfServerDownSince is the time when PDF server is found to be unreachable.
+	pdfServerDownSince time.Time
+
+	// outageAlerted is true when system admins are alerted about the current outage of PDF server.
+	outageAlerted bool
+
+	// lastEviction is the last time when stale cache entries are evicted by this node.
+	lastEviction time.Time
+}
+
+// components are the parts of Plugin that created from its configuration.
+type components struct {
 	// app is the actual, underlying TOPDF app and its features exposed to
 	// network via Plugin's HTTP API.
 	app interface {
-		CheckServerStatus() (err error)
-		GetPDF(userID, fileID string) (pdf io.ReadCloser, err error)
+		CheckServerStatus(ctx context.Context) (err error)
+		Authorize(ctx context.Context, userID, fileID string) (err error)
+		Admit(ctx context.Context, userID, fileID string) (admitted context.Context, err error)
+		GetPDF(ctx context.Context, userID, fileID, clientIP string) (pdf *topdf.PDF, err error)
+		GetPDFFile(ctx context.Context, userID, fileID string) (file *topdf.PDFFile, err error)
+		GetPublicPDF(ctx context.Context, fileID, hash, clientIP string) (pdf *topdf.PDF, err error)
+		ConvertToWebhook(ctx context.Context, userID, fileID, clientIP, webhookURL string) (cached bool, err error)
+		SavePDF(ctx context.Context, fileID string, pdf io.Reader) (err error)
+		InvalidateCache() (generation int64, err error)
+		WarmUp(fileID string) (converted bool, err error)
+		CacheEntries(filter topdf.CacheFilter, page, perPage int) (entries []topdf.CachedPDF, totals topdf.CacheTotals, err error)
+		GetCacheEntry(key string) (entry topdf.CachedPDF, err error)
+		VerifyCacheEntry(key string) (pages int, err error)
+		DeleteCacheEntry(key string) error
+		EvictStaleCache() (evicted int, err error)
 	} // *topdf.TOPDF
-}
 
-// configuration holds Plugin's config.
-// see plugin.json at root for more info about all configurations.
-type configuration struct {
-	GotenbergAddress        string
-	GotenbergConvertTimeout xtime.Duration
+	// server is the PDF server that app uses, it's kept to expose its circuit breaker status.
+	server interface {
+		CircuitStatus() resilient.Status
+	} // *resilient.Server
+
+	// audit is the log of PDF accesses.
+	audit *audit.Log
+
+	// watermark stamps PDFs with a watermark for each requesting user before they're served.
+	// it's nil when watermarking is disabled.
+	watermark *watermark.Watermark
+
+	// watermarkText is the admin configured text that included in watermarks.
+	watermarkText string
+
+	// jobs keeps track of asynchronous conversion jobs.
+	jobs *job.Store
+
+	// workers limits the number of running conversion jobs.
+	workers chan struct{}
+
+	// webhookURL is the URL, without the token, that PDF server delivers PDFs of conversion jobs to.
+	// it's empty when webhook mode is disabled and jobs wait for conversions to finish.
+	webhookURL string
+
+	// webhooks issues tokens that identify conversion jobs in webhook requests.
+	webhooks *webhook.Tokens
+
+	// webhookTimeout is the duration that PDFs are waited to be delivered to webhook.
+	webhookTimeout time.Duration
+
+	// cors is the CORS policy of API, only the allowed origins can make cross-origin requests
+	// with the credentials of users.
+	cors *cors.Cors
+
+	// trustedProxies are the reverse proxies that forwarded client addresses are honoured for.
+	trustedProxies []*net.IPNet
+
+	// signer signs URLs of PDFs that can be fetched without sessions, it's nil when signed URLs
+	// are disabled.
+	signer *signedurl.Signer
+
+	// warmer converts attachments of historical posts in background.
+	warmer *warmup.Warmer
+
+	// outageAlertAfter is the duration that PDF server should be unreachable before system admins
+	// are alerted, zero disables alerts.
+	outageAlertAfter time.Duration
+
+	// log is the logger that request scoped loggers are derived from.
+	log *logger.Logger
+
+	// tracer traces requests, it's nil when tracing is disabled.
+	tracer *trace.Tracer
+
+	// exporter exports spans of tracer, it's nil when tracing is disabled.
+	exporter *trace.OTLPExporter
 }
 
 func main() {
 	plugin.ClientMain(&Plugin{})
 }
 
-// OnConfigurationChange hook updates underlying Gotenberg configs.
+// getBotUserID gets the user id of Plugin's bot, it's empty until the bot is ensured on activation.
+// components are created before activation so they get it when they need.
+func (p *Plugin) getBotUserID() string {
+	return p.botUserID
+}
+
+// load gets the current components.
+func (p *Plugin) load() *components {
+	comps, _ := p.current.Load().(*components)
+	return comps
+}
+
+// store replaces the current components with comps.
+func (p *Plugin) store(comps *components) {
+	p.current.Store(comps)
+}
+
+// OnConfigurationChange hook validates the new configuration and replaces underlying components
+// with the ones created from it. invalid configurations are rejected and current components are
+// kept in use.
 func (p *Plugin) OnConfigurationChange() error {
 	var conf configuration
 	if err := p.API.LoadPluginConfiguration(&conf); err != nil {
 		return err
 	}
-	p.init(conf)
+	comps, err := p.newComponents(conf)
+	if err != nil {
+		p.API.LogError("invalid configuration, keeping the previous one: " + err.Error())
+		return err
+	}
+	old := p.load()
+	p.store(comps)
+	// spans of requests that are still in-flight with old components are dropped.
+	if old != nil && old.exporter != nil {
+		go old.exporter.Close()
+	}
+	// audit events of requests that are still in-flight with old components are recorded right away.
+	if old != nil && old.audit != nil {
+		go old.audit.Close()
+	}
+	// a running warm-up continues with the new components.
+	go p.handOverWarmUp(old, comps)
+	// reachability of PDF server does not make a configuration invalid, since it might be
+	// started later, but admins should know it as early as possible.
+	go p.warnIfNotReachable(comps)
 	return nil
 }
 
-// init initializes a new topdf with given c.
-func (p *Plugin) init(c configuration) {
+// newComponents validates c and creates new components from it.
+func (p *Plugin) newComponents(c configuration) (*components, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	log := logger.New(p.MattermostPlugin.API, logger.DebugOption(c.DebugLogging))
 	gt := gotenberg.New(c.GotenbergAddress, []gotenberg.Option{
 		gotenberg.ConvertTimeoutOption(time.Duration(c.GotenbergConvertTimeout)),
+		gotenberg.VersionOption(c.GotenbergVersion),
+		gotenberg.LoggerOption(log),
 	}...)
-	p.app = topdf.New(p.MattermostPlugin.API, gt)
+	// retry requests to Gotenberg on transient errors and fail them fast while it's down.
+	server := resilient.New("Gotenberg", gt)
+	// errors are ignored below since values are already validated.
+	userLimit, _ := parseRateLimit("user", c.RateLimitUserPerMinute)
+	globalLimit, _ := parseRateLimit("global", c.RateLimitGlobalPerMinute)
+	warmUpRate, _ := parseRateLimit("warm-up", c.WarmUpConversionsPerMinute)
+	limits, extensionLimits, _ := parseLimits(c)
+	options, extensionOptions, _ := parseConvertOptions(c)
+	corsOrigins, _ := parseCORSOrigins(c.CORSAllowedOrigins)
+	trustedProxies, _ := xhttp.ParseCIDRs(splitList(c.TrustedProxies))
+	convertTimeout := time.Duration(c.GotenbergConvertTimeout)
+	if convertTimeout == 0 {
+		convertTimeout = gotenberg.DefaultConvertTimeout
+	}
+	// jobs that are left unfinished by restarted nodes stop counting once they'd time out.
+	jobs := job.New(p.MattermostPlugin.API, job.MaxUnfinishedOption(maxUserJobs),
+		job.StaleAfterOption(convertTimeout+webhookGracePeriod))
+	comps := &components{
+		server:  server,
+		audit:   audit.New(p.MattermostPlugin.API, audit.LogEventsOption(c.AuditLogServerLog)),
+		jobs:    jobs,
+		workers: make(chan struct{}, maxRunningJobs),
+		log:     log,
+
+		outageAlertAfter: time.Duration(c.OutageAlertAfter),
+		trustedProxies:   trustedProxies,
+	}
+	comps.cors = p.newCORS(corsOrigins, splitList(strings.ToUpper(c.CORSAllowedMethods)), splitList(c.CORSAllowedHeaders))
+	if c.TracingOTLPEndpoint != "" {
+		comps.exporter = trace.NewOTLPExporter(c.TracingOTLPEndpoint, trace.LoggerOption(log))
+		comps.tracer = trace.New(comps.exporter)
+	}
+	if c.GotenbergWebhookURL != "" {
+		comps.webhookURL = strings.TrimSuffix(c.GotenbergWebhookURL, "/") + "/plugins/" + manifest.Id + webhookPath
+		comps.webhookTimeout = convertTimeout + webhookGracePeriod
+		comps.webhooks = webhook.New(p.MattermostPlugin.API, webhook.TTLOption(comps.webhookTimeout+webhookGracePeriod))
+	}
+	if c.SignedURLKey != "" {
+		comps.signer = signedurl.New(c.SignedURLKey)
+		// URLs signed with the previous key stay valid until they expire so rotating the key
+		// doesn't break the ones that are already handed out.
+		if err := comps.signer.Rotate(p.MattermostPlugin.API); err != nil {
+			p.API.LogError("cannot rotate signed URL key, URLs signed with the previous key are rejected", "err", err.Error())
+		}
+	}
+	appOptions := []topdf.Option{
+		topdf.AuditOption(comps.audit),
+		topdf.RateLimitOption(ratelimit.New(p.MattermostPlugin.API, userLimit, globalLimit)),
+		topdf.LimitsOption(limits, extensionLimits),
+		topdf.ConvertOptionsOption(options, extensionOptions),
+		topdf.LoggerOption(log),
+		topdf.TracerOption(comps.tracer),
+		topdf.OwnerOption(p.getBotUserID),
+	}
+	if c.SanitizeEnabled {
+		appOptions = append(appOptions, topdf.SanitizerOption(sanitize.New(sanitize.ExternalLinksOption(c.SanitizeExternalLinks))))
+	}
+	app := topdf.New(p.MattermostPlugin.API, server, appOptions...)
+	comps.app = app
+	warmerOptions := []warmup.Option{
+		warmup.SupportedOption(gotenberg.IsSupported),
+		warmup.LeaderOption(p.isLeader),
+	}
+	if warmUpRate > 0 {
+		warmerOptions = append(warmerOptions, warmup.RateOption(warmUpRate))
+	}
+	comps.warmer = warmup.New(p.MattermostPlugin.API, app, warmerOptions...)
+	if c.WatermarkEnabled {
+		opacity, _ := strconv.ParseFloat(c.WatermarkOpacity, 64)
+		comps.watermark = watermark.New(
+			watermark.OpacityOption(opacity),
+			watermark.PositionOption(watermark.Position(c.WatermarkPosition)),
+		)
+		comps.watermarkText = c.WatermarkText
+	}
+	return comps, nil
+}
+
+// warnIfNotReachable logs a warning if PDF server of comps is not reachable.
+func (p *Plugin) warnIfNotReachable(comps *components) {
+	err := comps.app.CheckServerStatus(context.Background())
+	if err == nil {
+		return
+	}
+	if _, ok := err.(*pdfserver.NotReachable); ok {
+		p.API.LogWarn("PDF server is not reachable with the current configuration", "reason", err.Error())
+		return
+	}
+	p.logError(context.Background(), "cannot check status of PDF server", err)
 }
 
 // ServeHTTP hook exposes a RESTful API for `topdf` plugin.
 func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
+	requestID := getRequestID(r)
+	w.Header().Set(requestIDHeader, requestID)
+	// there are no components to serve requests until a valid configuration is applied.
+	comps := p.load()
+	if comps == nil {
+		xhttp.ResponseJSON(w, http.StatusServiceUnavailable, createErrorResponse(errNotConfigured))
+		return
+	}
+	// log lines of request are correlated with its ID and user.
+	log := p.logger(r.Context()).With("requestId", requestID)
+	if userID := r.Header.Get("Mattermost-User-Id"); userID != "" {
+		log = log.With("userId", userID)
+	}
+	ctx, span := comps.tracer.Start(logger.NewContext(r.Context(), log), "HTTP "+r.Method,
+		"http.method", r.Method, "http.path", logPath(r), "requestId", requestID)
+	span.SetKind(trace.KindServer)
+	r = r.WithContext(ctx)
 	router := mux.NewRouter()
 	// GET /status gives status info about underlying(Gotenberg) PDF server.
 	router.HandleFunc("/status", p.handleStatus).Methods("GET")
 	// GET /files/{id} responses with a PDF version of file that attached to a Mattermost Post.
-	// it caches PDF files that requested for same files.
+	// it caches PDF files that requested for same files. PDFs are downloaded as attachments with
+	// ?download=1.
 	router.HandleFunc("/files/{id}", p.handleConvert).Methods("GET")
-	// allow CORS for the API.
-	handler := cors.AllowAll().Handler(router)
+	// POST /files/{id}/convert starts an asynchronous conversion job for file and responds with
+	// the job immediately.
+	router.HandleFunc("/files/{id}/convert", p.handleCreateJob).Methods("POST")
+	// POST /files/{id}/signed-url creates a short-lived URL to get the PDF of a file without a
+	// session.
+	router.HandleFunc("/files/{id}/signed-url", p.handleCreateSignedURL).Methods("POST")
+	// GET /jobs/{id} responses with state and timings of a conversion job.
+	router.HandleFunc("/jobs/{id}", p.handleJob).Methods("GET")
+	// POST /webhook/{token} receives PDFs of conversion jobs from PDF server in webhook mode.
+	router.HandleFunc(webhookPath+"{token}", p.handleWebhook).Methods("POST")
+	// GET /audit lists audit events of PDF accesses, it's only accessible by system admins.
+	router.HandleFunc("/audit", p.handleAudit).Methods("GET")
+	// GET /admin/cache lists cached PDFs with totals, it's only accessible by system admins like the rest of
+	// cache endpoints.
+	router.HandleFunc("/admin/cache", p.handleCacheEntries).Methods("GET")
+	// GET /admin/cache/{key} gets the metadata of a cache entry.
+	router.HandleFunc("/admin/cache/{key}", p.handleCacheEntry).Methods("GET")
+	// GET /admin/cache/{key}/verify checks that the PDF of a cache entry exists and can be read.
+	router.HandleFunc("/admin/cache/{key}/verify", p.handleVerifyCacheEntry).Methods("GET")
+	// DELETE /admin/cache/{key} deletes a cache entry.
+	router.HandleFunc("/admin/cache/{key}", p.handleDeleteCacheEntry).Methods("DELETE")
+	// answer preflight requests and allow CORS only for the configured origins.
+	handler := comps.cors.Handler(router)
 	// serve request.
-	handler.ServeHTTP(w, r)
+	start := time.Now()
+	rec := xhttp.NewStatusRecorder(w)
+	handler.ServeHTTP(rec, r)
+	span.SetAttributes("http.status_code", rec.Status)
+	if rec.Status >= http.StatusInternalServerError {
+		span.SetError(errors.New(http.StatusText(rec.Status)))
+	}
+	span.End()
+	log.Debug("request served", "method", r.Method, "path", logPath(r), "status", rec.Status,
+		"bytes", rec.Bytes, "durationMs", logger.Millis(start))
+}
+
+// getRequestID gets the request ID set by client that made r, a new one is generated when it's
+// missing or invalid.
+func getRequestID(r *http.Request) string {
+	id := r.Header.Get(requestIDHeader)
+	if id == "" || len(id) > maxRequestIDLength {
+		return model.NewId()
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return model.NewId()
+		}
+	}
+	return id
+}
+
+// logPath gets the path of r to log, secrets in paths are left out.
+func logPath(r *http.Request) string {
+	if strings.HasPrefix(r.URL.Path, webhookPath) {
+		return webhookPath + "{token}"
+	}
+	return r.URL.Path
 }
 
 // handleStatus handles Plugin's status check requests.
 func (p *Plugin) handleStatus(w http.ResponseWriter, r *http.Request) {
-	err := p.app.CheckServerStatus()
+	comps := p.load()
+	resp := statusResponse{IsGotenbergRunning: true}
+	if comps.server != nil {
+		status := comps.server.CircuitStatus()
+		resp.CircuitBreaker = &status
+	}
+	err := comps.app.CheckServerStatus(r.Context())
 	if err != nil {
 		if _, ok := err.(*pdfserver.NotReachable); !ok {
 			xhttp.ResponseJSON(w, http.StatusInternalServerError, createErrorResponse(err))
-			p.logError(err)
+			p.logError(r.Context(), "cannot check status of PDF server", err)
 			return
 		}
-		xhttp.ResponseJSON(w, http.StatusOK, statusResponse{IsGotenbergRunning: false})
-		return
+		resp.IsGotenbergRunning = false
 	}
-	xhttp.ResponseJSON(w, http.StatusOK, statusResponse{IsGotenbergRunning: true})
+	xhttp.ResponseJSON(w, http.StatusOK, resp)
 }
 
 // handlePDF handles file to PDF convert requests.
 func (p *Plugin) handleConvert(w http.ResponseWriter, r *http.Request) {
 	fileID := mux.Vars(r)["id"]
 	userID := r.Header.Get("Mattermost-User-Id")
-	// check if there is authenticated user, otherwise fail request since accessing files always
-	// requires a user.
-	if userID == "" {
+	q := r.URL.Query()
+	// public links carry their hashes in h query param like Mattermost's public file links.
+	hash := q.Get("h")
+	comps := p.load()
+	// signed URLs are served with the permissions of users that they're signed for.
+	if q.Get(signedurl.SignatureParam) != "" {
+		signedUserID, err := verifySignedURL(comps, fileID, q)
+		if err != nil {
+			xhttp.ResponseJSON(w, http.StatusUnauthorized, createErrorResponse(err))
+			p.logger(r.Context()).Warn("cannot serve PDF", "fileId", fileID, "err", err.Error())
+			return
+		}
+		userID = signedUserID
+	}
+	// check if there is authenticated user or a public link, otherwise fail request since
+	// accessing files always requires one of them.
+	if userID == "" && hash == "" {
 		xhttp.ResponseJSON(w, http.StatusUnauthorized, createErrorResponse(topdf.ErrUnauthorizedUser))
-		p.logError(topdf.ErrUnauthorizedUser)
+		p.logger(r.Context()).Warn("cannot serve PDF", "fileId", fileID, "err", topdf.ErrUnauthorizedUser.Error())
 		return
 	}
-	// get pdf for fileID with userID.
+	// get pdf for fileID with userID or through its public link.
 	// if user does not have access to file, requester will be responded with authorization error.
-	pdf, err := p.app.GetPDF(userID, fileID)
+	var (
+		pdf *topdf.PDF
+		err error
+	)
+	if hash != "" {
+		pdf, err = comps.app.GetPublicPDF(r.Context(), fileID, hash, xhttp.ClientIP(r, comps.trustedProxies))
+	} else {
+		pdf, err = comps.app.GetPDF(r.Context(), userID, fileID, xhttp.ClientIP(r, comps.trustedProxies))
+	}
 	if err != nil {
 		code := http.StatusInternalServerError
-		if err == topdf.ErrUnauthorizedUser {
+		if r.Context().Err() != nil {
+			code = statusClientClosedRequest
+		} else if err == topdf.ErrUnauthorizedUser {
 			code = http.StatusUnauthorized
+		} else if rerr, ok := err.(*topdf.RateLimited); ok {
+			code = http.StatusTooManyRequests
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(rerr.RetryAfter.Seconds()))))
+		} else if _, ok := err.(*topdf.LimitExceeded); ok {
+			code = http.StatusRequestEntityTooLarge
+		} else if err == topdf.ErrPasswordProtected {
+			code = http.StatusUnprocessableEntity
+		} else if _, ok := err.(*pdfserver.NotReachable); ok {
+			code = http.StatusServiceUnavailable
 		}
 		xhttp.ResponseJSON(w, code, createErrorResponse(err))
-		p.logError(err)
+		// errors caused by requests themselves are not errors of plugin.
+		if code >= http.StatusInternalServerError {
+			p.logError(r.Context(), "cannot serve PDF", err, "fileId", fileID)
+		} else {
+			p.logger(r.Context()).Warn("cannot serve PDF", "fileId", fileID, "status", code, "err", err.Error())
+		}
 		return
 	}
 	defer pdf.Close()
+	var content io.Reader = pdf
+	size := pdf.Size
+	// watermarks are stamped on the fly for each request so only the clean PDF stays in the cache.
+	if comps.watermark != nil {
+		stamped, err := p.stampPDF(comps, userID, pdf)
+		if err != nil {
+			xhttp.ResponseJSON(w, http.StatusInternalServerError, createErrorResponse(err))
+			p.logError(r.Context(), "cannot stamp watermark", err, "fileId", fileID)
+			return
+		}
+		content, size = bytes.NewReader(stamped), int64(len(stamped))
+		// watermarked PDFs are personal, they should not be kept by shared caches.
+		w.Header().Set("Cache-Control", "private, no-store")
+	}
+	// PDFs are named after their source files, they're saved rather than displayed by browsers
+	// when download is requested.
+	disposition := "inline"
+	if download, _ := strconv.ParseBool(q.Get("download")); download {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Disposition", xhttp.ContentDisposition(disposition, pdf.Name))
 	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
 	// stream PDF content to requester.
-	io.Copy(w, pdf)
+	io.Copy(w, content)
 }
 
-// logError logs errors with Plugin API.
-func (p *Plugin) logError(err error) {
-	p.API.LogError(err.Error())
+// stampPDF stamps each page of pdf with a watermark of comps that identifies userID, PDFs of
+// anonymous public link accesses are marked as public.
+func (p *Plugin) stampPDF(comps *components, userID string, pdf io.Reader) (stamped []byte, err error) {
+	viewer := publicLinkViewer
+	if userID != "" {
+		user, aerr := p.API.GetUser(userID)
+		if aerr != nil {
+			return nil, aerr
+		}
+		viewer = "@" + user.Username
+	}
+	data, err := ioutil.ReadAll(pdf)
+	if err != nil {
+		return nil, err
+	}
+	return comps.watermark.Stamp(data, watermarkText(viewer, time.Now(), comps.watermarkText))
+}
+
+// watermarkText creates the text of a watermark for viewer at t.
+func watermarkText(viewer string, t time.Time, text string) string {
+	mark := fmt.Sprintf("%s · %s", viewer, t.UTC().Format("2006-01-02 15:04 MST"))
+	if text != "" {
+		mark += " · " + text
+	}
+	return mark
+}
+
+// logger gets the request scoped logger carried by ctx, the logger of current components is used
+// when there is none.
+func (p *Plugin) logger(ctx context.Context) *logger.Logger {
+	if l := logger.FromContext(ctx); l != nil {
+		return l
+	}
+	if comps := p.load(); comps != nil && comps.log != nil {
+		return comps.log
+	}
+	return logger.New(p.API)
+}
+
+// logError logs err with msg and keyValuePairs using the logger of ctx.
+func (p *Plugin) logError(ctx context.Context, msg string, err error, keyValuePairs ...interface{}) {
+	p.logger(ctx).Error(msg, append(keyValuePairs, "err", err.Error())...)
 }
 
 // createErrorResponse creates a new error response from err to be sent HTTP client.
 func createErrorResponse(err error) errorResponse {
-	return errorResponse{
-		errorResponseBody{Message: err.Error()},
+	body := errorResponseBody{Message: err.Error()}
+	// let clients know which limit is exceeded so they can show a proper message.
+	if lerr, ok := err.(*topdf.LimitExceeded); ok {
+		body.Code = lerr.Code
+	}
+	if err == topdf.ErrPasswordProtected {
+		body.Code = topdf.CodePasswordProtected
 	}
+	return errorResponse{body}
 }
 
 // statusResponse is status response sent to client.
 type statusResponse struct {
 	IsGotenbergRunning bool `json:"isGotenbergRunning"`
+
+	// CircuitBreaker is the status of circuit breaker around Gotenberg.
+	CircuitBreaker *resilient.Status `json:"circuitBreaker,omitempty"`
 }
 
 // statusResponse is error response sent to client.
@@ -138,4 +592,5 @@ type errorResponse struct {
 
 type errorResponseBody struct {
 	Message string `json:"message"`
+	Code    string `json:"code,omitempty"`
 }
@@ -2,25 +2,54 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
+	"time"
 
+	"github.com/ilgooz/mattermost-plugin-topdf/server/audit"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/logger"
 	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver"
+	sMock "github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver/mocks"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver/resilient"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/trace"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/warmup"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/watermark"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/memkv"
 	pMock "github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/mocks"
 	tMock "github.com/ilgooz/mattermost-plugin-topdf/server/x/xtopdf/mocks"
+	"github.com/mattermost/mattermost-server/model"
 	"github.com/mattermost/mattermost-server/plugin"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
 
+// newTestPlugin creates a Plugin with api and comps as its current components.
+func newTestPlugin(api plugin.API, comps *components) *Plugin {
+	p := &Plugin{MattermostPlugin: plugin.MattermostPlugin{API: api}}
+	if comps != nil && comps.cors == nil {
+		comps.cors = p.newCORS(nil, nil, nil)
+	}
+	p.store(comps)
+	return p
+}
+
+// newTestPDF creates a PDF of report.docx with data.
+func newTestPDF(data []byte) *topdf.PDF {
+	return &topdf.PDF{ReadCloser: ioutil.NopCloser(bytes.NewReader(data)), Name: "report.pdf", Size: int64(len(data))}
+}
+
 func TestHandleStatusRunning(t *testing.T) {
 	topdfMock := &tMock.TOPDF{}
-	p := &Plugin{app: topdfMock}
+	p := newTestPlugin(nil, &components{app: topdfMock})
 	req := httptest.NewRequest("GET", "http://localhost.com/status", nil)
 	w := httptest.NewRecorder()
-	topdfMock.On("CheckServerStatus").Once().Return(true, nil)
+	topdfMock.On("CheckServerStatus", mock.Anything).Once().Return(nil)
 	p.ServeHTTP(nil, w, req)
 	resp := w.Result()
 	body, err := ioutil.ReadAll(resp.Body)
@@ -31,12 +60,26 @@ func TestHandleStatusRunning(t *testing.T) {
 	topdfMock.AssertExpectations(t)
 }
 
+func TestHandleStatusCircuitBreaker(t *testing.T) {
+	topdfMock := &tMock.TOPDF{}
+	p := newTestPlugin(nil, &components{app: topdfMock, server: resilient.New("Gotenberg", &sMock.Server{})})
+	req := httptest.NewRequest("GET", "http://localhost.com/status", nil)
+	w := httptest.NewRecorder()
+	topdfMock.On("CheckServerStatus", mock.Anything).Once().Return(nil)
+	p.ServeHTTP(nil, w, req)
+	resp := w.Result()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, `{"isGotenbergRunning":true,"circuitBreaker":{"state":"closed","failures":0}}`, string(body))
+	topdfMock.AssertExpectations(t)
+}
+
 func TestHandleStatusNotRunning(t *testing.T) {
 	topdfMock := &tMock.TOPDF{}
-	p := &Plugin{app: topdfMock}
+	p := newTestPlugin(nil, &components{app: topdfMock})
 	req := httptest.NewRequest("GET", "http://localhost.com/status", nil)
 	w := httptest.NewRecorder()
-	topdfMock.On("CheckServerStatus").Once().Return(false, nil)
+	topdfMock.On("CheckServerStatus", mock.Anything).Once().Return(&pdfserver.NotReachable{ServerName: "Gotenberg", Reason: errors.New("down")})
 	p.ServeHTTP(nil, w, req)
 	resp := w.Result()
 	body, err := ioutil.ReadAll(resp.Body)
@@ -50,11 +93,11 @@ func TestHandleStatusNotRunning(t *testing.T) {
 func TestHandleStatusInternalServerError(t *testing.T) {
 	topdfMock := &tMock.TOPDF{}
 	apiMock := &pMock.API{}
-	p := &Plugin{MattermostPlugin: plugin.MattermostPlugin{API: apiMock}, app: topdfMock}
+	p := newTestPlugin(apiMock, &components{app: topdfMock})
 	req := httptest.NewRequest("GET", "http://localhost.com/status", nil)
 	w := httptest.NewRecorder()
-	topdfMock.On("CheckServerStatus").Once().Return(false, errors.New("a failure"))
-	apiMock.On("LogError", "a failure").Once()
+	topdfMock.On("CheckServerStatus", mock.Anything).Once().Return(errors.New("a failure"))
+	apiMock.On("LogError", "cannot check status of PDF server", "requestId", mock.Anything, "err", "a failure").Once()
 	p.ServeHTTP(nil, w, req)
 	resp := w.Result()
 	body, err := ioutil.ReadAll(resp.Body)
@@ -67,30 +110,53 @@ func TestHandleStatusInternalServerError(t *testing.T) {
 
 func TestHandleConvert(t *testing.T) {
 	topdfMock := &tMock.TOPDF{}
-	p := &Plugin{app: topdfMock}
+	p := newTestPlugin(nil, &components{app: topdfMock})
 	req := httptest.NewRequest("GET", "http://localhost.com/files/1", nil)
 	req.Header.Set("Mattermost-User-Id", "2")
 	w := httptest.NewRecorder()
-	topdfMock.On("GetPDF", "2", "1").Once().Return(ioutil.NopCloser(bytes.NewReader([]byte{3})), nil)
+	topdfMock.On("GetPDF", mock.Anything, "2", "1", "192.0.2.1").Once().Return(newTestPDF([]byte{3}), nil)
 	p.ServeHTTP(nil, w, req)
 	resp := w.Result()
 	body, err := ioutil.ReadAll(resp.Body)
 	require.NoError(t, err)
 	require.Equal(t, http.StatusOK, resp.StatusCode)
 	require.Equal(t, "application/pdf", resp.Header.Get("Content-Type"))
+	require.Equal(t, `inline; filename="report.pdf"`, resp.Header.Get("Content-Disposition"))
+	require.Equal(t, "1", resp.Header.Get("Content-Length"))
 	require.Equal(t, []byte{3}, body)
 	topdfMock.AssertExpectations(t)
 }
 
+func TestHandleConvertDownload(t *testing.T) {
+	topdfMock := &tMock.TOPDF{}
+	p := newTestPlugin(nil, &components{app: topdfMock})
+	req := httptest.NewRequest("GET", "http://localhost.com/files/1?download=1", nil)
+	req.Header.Set("Mattermost-User-Id", "2")
+	w := httptest.NewRecorder()
+	pdf := newTestPDF([]byte{3, 4})
+	pdf.Name = "çeyrek rapor.pdf"
+	topdfMock.On("GetPDF", mock.Anything, "2", "1", "192.0.2.1").Once().Return(pdf, nil)
+	p.ServeHTTP(nil, w, req)
+	resp := w.Result()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, `attachment; filename="_eyrek rapor.pdf"; filename*=UTF-8''%C3%A7eyrek%20rapor.pdf`,
+		resp.Header.Get("Content-Disposition"))
+	require.Equal(t, "2", resp.Header.Get("Content-Length"))
+	require.Equal(t, []byte{3, 4}, body)
+	topdfMock.AssertExpectations(t)
+}
+
 func TestHandleConvertInternalError(t *testing.T) {
 	topdfMock := &tMock.TOPDF{}
 	apiMock := &pMock.API{}
-	p := &Plugin{MattermostPlugin: plugin.MattermostPlugin{API: apiMock}, app: topdfMock}
+	p := newTestPlugin(apiMock, &components{app: topdfMock})
 	req := httptest.NewRequest("GET", "http://localhost.com/files/1", nil)
 	req.Header.Set("Mattermost-User-Id", "2")
 	w := httptest.NewRecorder()
-	topdfMock.On("GetPDF", "2", "1").Once().Return(nil, errors.New("internal"))
-	apiMock.On("LogError", "internal").Once()
+	topdfMock.On("GetPDF", mock.Anything, "2", "1", "192.0.2.1").Once().Return(nil, errors.New("internal"))
+	apiMock.On("LogError", "cannot serve PDF", "requestId", mock.Anything, "userId", "2", "fileId", "1", "err", "internal").Once()
 	p.ServeHTTP(nil, w, req)
 	resp := w.Result()
 	body, err := ioutil.ReadAll(resp.Body)
@@ -103,12 +169,12 @@ func TestHandleConvertInternalError(t *testing.T) {
 func TestHandleConvertAuthorizationError(t *testing.T) {
 	topdfMock := &tMock.TOPDF{}
 	apiMock := &pMock.API{}
-	p := &Plugin{MattermostPlugin: plugin.MattermostPlugin{API: apiMock}, app: topdfMock}
+	p := newTestPlugin(apiMock, &components{app: topdfMock})
 	req := httptest.NewRequest("GET", "http://localhost.com/files/1", nil)
 	req.Header.Set("Mattermost-User-Id", "2")
 	w := httptest.NewRecorder()
-	topdfMock.On("GetPDF", "2", "1").Once().Return(nil, topdf.ErrUnauthorizedUser)
-	apiMock.On("LogError", "user is not authorized to access pdf").Once()
+	topdfMock.On("GetPDF", mock.Anything, "2", "1", "192.0.2.1").Once().Return(nil, topdf.ErrUnauthorizedUser)
+	apiMock.On("LogWarn", "cannot serve PDF", "requestId", mock.Anything, "userId", "2", "fileId", "1", "status", http.StatusUnauthorized, "err", "user is not authorized to access pdf").Once()
 	p.ServeHTTP(nil, w, req)
 	resp := w.Result()
 	body, err := ioutil.ReadAll(resp.Body)
@@ -121,10 +187,10 @@ func TestHandleConvertAuthorizationError(t *testing.T) {
 
 func TestHandleConvertWithoutAuthentication(t *testing.T) {
 	apiMock := &pMock.API{}
-	p := &Plugin{MattermostPlugin: plugin.MattermostPlugin{API: apiMock}}
+	p := newTestPlugin(apiMock, &components{})
 	req := httptest.NewRequest("GET", "http://localhost.com/files/1", nil)
 	w := httptest.NewRecorder()
-	apiMock.On("LogError", "user is not authorized to access pdf").Once()
+	apiMock.On("LogWarn", "cannot serve PDF", "requestId", mock.Anything, "fileId", "1", "err", "user is not authorized to access pdf").Once()
 	p.ServeHTTP(nil, w, req)
 	resp := w.Result()
 	body, err := ioutil.ReadAll(resp.Body)
@@ -134,3 +200,373 @@ func TestHandleConvertWithoutAuthentication(t *testing.T) {
 	require.Equal(t, `{"error":{"message":"user is not authorized to access pdf"}}`, string(body))
 	apiMock.AssertExpectations(t)
 }
+
+func TestHandleConvertPublicLink(t *testing.T) {
+	data, err := ioutil.ReadFile("pdf/testdata/classic.pdf")
+	require.NoError(t, err)
+	topdfMock := &tMock.TOPDF{}
+	apiMock := &pMock.API{}
+	p := newTestPlugin(apiMock, &components{app: topdfMock, watermark: watermark.New()})
+	// public links are served without users and watermarked anonymously.
+	req := httptest.NewRequest("GET", "http://localhost.com/files/1?h=abc", nil)
+	w := httptest.NewRecorder()
+	topdfMock.On("GetPublicPDF", mock.Anything, "1", "abc", "192.0.2.1").Once().Return(newTestPDF(data), nil)
+	p.ServeHTTP(nil, w, req)
+	resp := w.Result()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "application/pdf", resp.Header.Get("Content-Type"))
+	require.True(t, len(body) > len(data))
+	topdfMock.AssertExpectations(t)
+	apiMock.AssertExpectations(t)
+}
+
+func TestHandleConvertWatermarked(t *testing.T) {
+	data, err := ioutil.ReadFile("pdf/testdata/classic.pdf")
+	require.NoError(t, err)
+	topdfMock := &tMock.TOPDF{}
+	apiMock := &pMock.API{}
+	p := newTestPlugin(apiMock, &components{
+		app:           topdfMock,
+		watermark:     watermark.New(),
+		watermarkText: "CONFIDENTIAL",
+	})
+	req := httptest.NewRequest("GET", "http://localhost.com/files/1", nil)
+	req.Header.Set("Mattermost-User-Id", "2")
+	w := httptest.NewRecorder()
+	topdfMock.On("GetPDF", mock.Anything, "2", "1", "192.0.2.1").Once().Return(newTestPDF(data), nil)
+	apiMock.On("GetUser", "2").Once().Return(&model.User{Username: "john"}, nil)
+	p.ServeHTTP(nil, w, req)
+	resp := w.Result()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "application/pdf", resp.Header.Get("Content-Type"))
+	require.Equal(t, "private, no-store", resp.Header.Get("Cache-Control"))
+	require.True(t, bytes.HasPrefix(body, data))
+	require.True(t, len(body) > len(data))
+	require.Equal(t, strconv.Itoa(len(body)), resp.Header.Get("Content-Length"))
+	topdfMock.AssertExpectations(t)
+	apiMock.AssertExpectations(t)
+}
+
+func TestHandleConvertWatermarkError(t *testing.T) {
+	topdfMock := &tMock.TOPDF{}
+	apiMock := &pMock.API{}
+	p := newTestPlugin(apiMock, &components{
+		app:       topdfMock,
+		watermark: watermark.New(),
+	})
+	req := httptest.NewRequest("GET", "http://localhost.com/files/1", nil)
+	req.Header.Set("Mattermost-User-Id", "2")
+	w := httptest.NewRecorder()
+	topdfMock.On("GetPDF", mock.Anything, "2", "1", "192.0.2.1").Once().Return(newTestPDF([]byte("not a pdf")), nil)
+	apiMock.On("GetUser", "2").Once().Return(&model.User{Username: "john"}, nil)
+	apiMock.On("LogError", "cannot stamp watermark", "requestId", mock.Anything, "userId", "2", "fileId", "1", "err", "malformed pdf: startxref not found").Once()
+	p.ServeHTTP(nil, w, req)
+	resp := w.Result()
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	topdfMock.AssertExpectations(t)
+	apiMock.AssertExpectations(t)
+}
+
+func TestNewComponentsInvalidWatermark(t *testing.T) {
+	p := &Plugin{}
+	c := validConfiguration()
+	c.WatermarkEnabled = true
+	c.WatermarkOpacity = "2"
+	c.WatermarkPosition = "diagonal"
+	_, err := p.newComponents(c)
+	require.EqualError(t, err, `invalid watermark opacity "2", it must be between 0 and 1`)
+	c.WatermarkOpacity = "0.5"
+	c.WatermarkPosition = "left"
+	_, err = p.newComponents(c)
+	require.EqualError(t, err, `invalid watermark position "left"`)
+	c.WatermarkPosition = "top"
+	comps, err := p.newComponents(c)
+	require.NoError(t, err)
+	require.NotNil(t, comps.watermark)
+}
+
+func TestWatermarkText(t *testing.T) {
+	at := time.Date(2019, 10, 1, 9, 30, 0, 0, time.UTC)
+	require.Equal(t, "@john · 2019-10-01 09:30 UTC · SECRET", watermarkText("@john", at, "SECRET"))
+	require.Equal(t, "@john · 2019-10-01 09:30 UTC", watermarkText("@john", at, ""))
+}
+
+func TestHandleAuditForbidden(t *testing.T) {
+	apiMock := &pMock.API{}
+	p := newTestPlugin(apiMock, &components{})
+	req := httptest.NewRequest("GET", "http://localhost.com/audit", nil)
+	req.Header.Set("Mattermost-User-Id", "2")
+	w := httptest.NewRecorder()
+	apiMock.On("HasPermissionTo", "2", model.PERMISSION_MANAGE_SYSTEM).Once().Return(false)
+	p.ServeHTTP(nil, w, req)
+	resp := w.Result()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+	require.Equal(t, `{"error":{"message":"only system admins can access this resource"}}`, string(body))
+	apiMock.AssertExpectations(t)
+}
+
+func TestHandleAudit(t *testing.T) {
+	api := memkv.New()
+	log := audit.New(api)
+	require.NoError(t, log.Record(audit.Event{Time: 1, UserID: "3", FileID: "4", Outcome: audit.OutcomeSuccess}))
+	require.NoError(t, log.Record(audit.Event{Time: 2, UserID: "5", FileID: "4", Outcome: audit.OutcomeFailure}))
+	log.Close()
+	p := newTestPlugin(api, &components{audit: log})
+	req := httptest.NewRequest("GET", "http://localhost.com/audit?userId=3", nil)
+	req.Header.Set("Mattermost-User-Id", "2")
+	w := httptest.NewRecorder()
+	api.On("HasPermissionTo", "2", model.PERMISSION_MANAGE_SYSTEM).Once().Return(true)
+	p.ServeHTTP(nil, w, req)
+	resp := w.Result()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, `{"events":[{"time":1,"userId":"3","fileId":"4","cacheHit":false,"outcome":"success"}]}`, string(body))
+	api.AssertExpectations(t)
+}
+
+func TestExecuteAuditCommand(t *testing.T) {
+	api := memkv.New()
+	log := audit.New(api)
+	require.NoError(t, log.Record(audit.Event{Time: 1, UserID: "3", FileID: "4", Outcome: audit.OutcomeSuccess, CacheHit: true}))
+	require.NoError(t, log.Record(audit.Event{Time: 2, UserID: "5", FileID: "6", Outcome: audit.OutcomeSuccess}))
+	log.Close()
+	p := newTestPlugin(api, &components{audit: log})
+	api.On("HasPermissionTo", "2", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+	api.On("GetUserByUsername", "john").Once().Return(&model.User{Id: "3"}, nil)
+	resp, aerr := p.ExecuteCommand(nil, &model.CommandArgs{UserId: "2", Command: "/topdf audit user=@john"})
+	require.Nil(t, aerr)
+	require.Equal(t, model.COMMAND_RESPONSE_TYPE_EPHEMERAL, resp.ResponseType)
+	require.Contains(t, resp.Text, "| 1970-01-01T00:00:00Z | 3 | 4 |  | hit | success |  |")
+	require.NotContains(t, resp.Text, "| 6 |")
+
+	resp, _ = p.ExecuteCommand(nil, &model.CommandArgs{UserId: "2", Command: "/topdf audit limit=abc"})
+	require.Equal(t, "limit must be between 1 and 1000", resp.Text)
+	api.AssertExpectations(t)
+}
+
+func TestHandleConvertRateLimited(t *testing.T) {
+	topdfMock := &tMock.TOPDF{}
+	apiMock := &pMock.API{}
+	p := newTestPlugin(apiMock, &components{app: topdfMock})
+	req := httptest.NewRequest("GET", "http://localhost.com/files/1", nil)
+	req.Header.Set("Mattermost-User-Id", "2")
+	w := httptest.NewRecorder()
+	topdfMock.On("GetPDF", mock.Anything, "2", "1", "192.0.2.1").Once().Return(nil, &topdf.RateLimited{RetryAfter: 1500 * time.Millisecond})
+	apiMock.On("LogWarn", "cannot serve PDF", "requestId", mock.Anything, "userId", "2", "fileId", "1", "status", http.StatusTooManyRequests, "err", "too many conversion requests, retry after 2 seconds").Once()
+	p.ServeHTTP(nil, w, req)
+	resp := w.Result()
+	require.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	require.Equal(t, "2", resp.Header.Get("Retry-After"))
+	topdfMock.AssertExpectations(t)
+	apiMock.AssertExpectations(t)
+}
+
+func TestHandleConvertLimitExceeded(t *testing.T) {
+	topdfMock := &tMock.TOPDF{}
+	apiMock := &pMock.API{}
+	p := newTestPlugin(apiMock, &components{app: topdfMock})
+	req := httptest.NewRequest("GET", "http://localhost.com/files/1", nil)
+	req.Header.Set("Mattermost-User-Id", "2")
+	w := httptest.NewRecorder()
+	topdfMock.On("GetPDF", mock.Anything, "2", "1", "192.0.2.1").Once().Return(nil, &topdf.LimitExceeded{Code: topdf.CodeTooManyPages, Limit: 10})
+	apiMock.On("LogWarn", "cannot serve PDF", "requestId", mock.Anything, "userId", "2", "fileId", "1", "status", http.StatusRequestEntityTooLarge, "err", "converted pdf has more than the maximum of 10 pages").Once()
+	p.ServeHTTP(nil, w, req)
+	resp := w.Result()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	require.Equal(t, `{"error":{"message":"converted pdf has more than the maximum of 10 pages","code":"too_many_pages"}}`, string(body))
+	topdfMock.AssertExpectations(t)
+	apiMock.AssertExpectations(t)
+}
+
+func TestHandleConvertPasswordProtected(t *testing.T) {
+	topdfMock := &tMock.TOPDF{}
+	apiMock := &pMock.API{}
+	p := newTestPlugin(apiMock, &components{app: topdfMock})
+	req := httptest.NewRequest("GET", "http://localhost.com/files/1", nil)
+	req.Header.Set("Mattermost-User-Id", "2")
+	w := httptest.NewRecorder()
+	topdfMock.On("GetPDF", mock.Anything, "2", "1", "192.0.2.1").Once().Return(nil, topdf.ErrPasswordProtected)
+	apiMock.On("LogWarn", "cannot serve PDF", "requestId", mock.Anything, "userId", "2", "fileId", "1", "status", http.StatusUnprocessableEntity, "err", "document is password protected").Once()
+	p.ServeHTTP(nil, w, req)
+	resp := w.Result()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	require.Equal(t, `{"error":{"message":"document is password protected","code":"password_protected"}}`, string(body))
+	topdfMock.AssertExpectations(t)
+	apiMock.AssertExpectations(t)
+}
+
+func TestServeHTTPNotConfigured(t *testing.T) {
+	p := &Plugin{}
+	req := httptest.NewRequest("GET", "http://localhost.com/status", nil)
+	w := httptest.NewRecorder()
+	p.ServeHTTP(nil, w, req)
+	resp := w.Result()
+	require.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestServeHTTPRequestID(t *testing.T) {
+	topdfMock := &tMock.TOPDF{}
+	apiMock := &pMock.API{}
+	p := newTestPlugin(apiMock, &components{app: topdfMock, log: logger.New(apiMock, logger.DebugOption(true))})
+	topdfMock.On("CheckServerStatus", mock.Anything).Return(nil)
+	apiMock.On("LogDebug", "request served", "requestId", "a-valid_id1", "method", "GET", "path", "/status",
+		"status", http.StatusOK, "bytes", int64(27), "durationMs", mock.Anything).Once()
+	apiMock.On("LogDebug", "request served", "requestId", mock.Anything, "method", "POST", "path", webhookPath+"{token}",
+		"status", http.StatusNotFound, "bytes", mock.Anything, "durationMs", mock.Anything).Once()
+
+	req := httptest.NewRequest("GET", "http://localhost.com/status", nil)
+	req.Header.Set(requestIDHeader, "a-valid_id1")
+	w := httptest.NewRecorder()
+	p.ServeHTTP(nil, w, req)
+	require.Equal(t, "a-valid_id1", w.Result().Header.Get(requestIDHeader))
+
+	req = httptest.NewRequest("POST", "http://localhost.com"+webhookPath+"secret", nil)
+	req.Header.Set(requestIDHeader, "not valid")
+	w = httptest.NewRecorder()
+	p.ServeHTTP(nil, w, req)
+	id := w.Result().Header.Get(requestIDHeader)
+	require.Len(t, id, 26)
+	topdfMock.AssertExpectations(t)
+	apiMock.AssertExpectations(t)
+}
+
+func TestServeHTTPTraced(t *testing.T) {
+	topdfMock := &tMock.TOPDF{}
+	exporter := trace.NewMemoryExporter()
+	p := newTestPlugin(nil, &components{app: topdfMock, tracer: trace.New(exporter)})
+	topdfMock.On("GetPDF", mock.Anything, "2", "1", "192.0.2.1").Once().Return(nil, errors.New("internal")).
+		Run(func(args mock.Arguments) {
+			// the app continues the trace of request.
+			require.NotNil(t, trace.FromContext(args.Get(0).(context.Context)))
+		})
+	req := httptest.NewRequest("GET", "http://localhost.com/files/1", nil)
+	req.Header.Set("Mattermost-User-Id", "2")
+	req.Header.Set(requestIDHeader, "3")
+	p.ServeHTTP(nil, httptest.NewRecorder(), req)
+	span, ok := exporter.Span("HTTP GET")
+	require.True(t, ok)
+	require.Equal(t, trace.KindServer, span.Kind)
+	require.Equal(t, "/files/1", span.Attribute("http.path"))
+	require.Equal(t, "3", span.Attribute("requestId"))
+	require.Equal(t, http.StatusInternalServerError, span.Attribute("http.status_code"))
+	require.Equal(t, http.StatusText(http.StatusInternalServerError), span.Error)
+	topdfMock.AssertExpectations(t)
+}
+
+func TestExecuteCacheCommand(t *testing.T) {
+	topdfMock := &tMock.TOPDF{}
+	api := memkv.New()
+	p := newTestPlugin(api, &components{app: topdfMock})
+	api.On("HasPermissionTo", "2", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+	api.On("HasPermissionTo", "3", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+	api.On("LogInfo", "PDF cache invalidated", "userId", "2", "generation", int64(3)).Once()
+	topdfMock.On("InvalidateCache").Once().Return(int64(3), nil)
+
+	resp, aerr := p.ExecuteCommand(nil, &model.CommandArgs{UserId: "2", Command: "/topdf cache invalidate"})
+	require.Nil(t, aerr)
+	require.Equal(t, "PDF cache invalidated, files will be converted again when they're accessed next time (cache generation 3).", resp.Text)
+
+	resp, _ = p.ExecuteCommand(nil, &model.CommandArgs{UserId: "2", Command: "/topdf cache"})
+	require.Equal(t, cacheCommandUsage, resp.Text)
+
+	resp, _ = p.ExecuteCommand(nil, &model.CommandArgs{UserId: "3", Command: "/topdf cache invalidate"})
+	require.Equal(t, errForbidden.Error(), resp.Text)
+	topdfMock.AssertExpectations(t)
+	api.AssertExpectations(t)
+}
+
+func TestHandleCacheEntries(t *testing.T) {
+	topdfMock := &tMock.TOPDF{}
+	apiMock := &pMock.API{}
+	p := newTestPlugin(apiMock, &components{app: topdfMock})
+	apiMock.On("HasPermissionTo", "2", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+	apiMock.On("HasPermissionTo", "3", model.PERMISSION_MANAGE_SYSTEM).Return(false)
+	apiMock.On("LogInfo", "PDF cache entry deleted", "requestId", mock.Anything, "userId", "2", "key", "pdf:4").Once()
+	topdfMock.On("CacheEntries", topdf.CacheFilter{}, 0, 60).Once().Return([]topdf.CachedPDF{{Key: "pdf:4", Legacy: true, CacheEntry: topdf.CacheEntry{PDFFileID: "5", SourceFileID: "4"}}},
+		topdf.CacheTotals{Entries: 1}, nil)
+	filter := topdf.CacheFilter{ChannelID: "c", Extension: "docx", Since: 1, Until: 3000}
+	topdfMock.On("CacheEntries", filter, 1, 1).Once().Return([]topdf.CachedPDF{
+		{Key: "pdf:2:b", CacheEntry: topdf.CacheEntry{PDFFileID: "7", Size: 20, CreatedAt: 1000}},
+	}, topdf.CacheTotals{Entries: 2, Size: 30}, nil)
+	topdfMock.On("CacheEntries", filter, 2, 1).Once().Return([]topdf.CachedPDF{}, topdf.CacheTotals{Entries: 2, Size: 30}, nil)
+	topdfMock.On("GetCacheEntry", "pdf:4").Once().Return(topdf.CachedPDF{Key: "pdf:4", Legacy: true, CacheEntry: topdf.CacheEntry{PDFFileID: "5", SourceFileID: "4"}}, nil)
+	topdfMock.On("GetCacheEntry", "pdf:6").Once().Return(topdf.CachedPDF{}, topdf.ErrCacheEntryNotFound)
+	topdfMock.On("VerifyCacheEntry", "pdf:4").Once().Return(0, &topdf.BrokenCacheEntry{Key: "pdf:4", Reason: "malformed"})
+	topdfMock.On("VerifyCacheEntry", "pdf:6").Once().Return(0, topdf.ErrCacheEntryNotFound)
+	topdfMock.On("DeleteCacheEntry", "pdf:4").Once().Return(nil)
+
+	for _, tt := range []struct {
+		method, path, userID string
+		status               int
+		body                 string
+	}{
+		{"GET", "/admin/cache", "3", http.StatusForbidden, `{"error":{"message":"only system admins can access this resource"}}`},
+		{"GET", "/admin/cache", "2", http.StatusOK, `{"entries":[{"key":"pdf:4","legacy":true,"pdfFileId":"5","sourceFileId":"4","sourceHash":"","backend":"","generation":0,"createdAt":0}],"totals":{"entries":1,"size":0}}`},
+		{"GET", "/admin/cache?channelId=c&extension=docx&since=1&until=3000&page=1&perPage=1", "2", http.StatusOK, `{"entries":[{"key":"pdf:2:b","pdfFileId":"7","sourceFileId":"","sourceHash":"","backend":"","generation":0,"size":20,"createdAt":1000}],"totals":{"entries":2,"size":30}}`},
+		{"GET", "/admin/cache?channelId=c&extension=docx&since=1&until=3000&page=2&perPage=1", "2", http.StatusOK, `{"entries":[],"totals":{"entries":2,"size":30}}`},
+		{"GET", "/admin/cache?perPage=1000", "2", http.StatusBadRequest, `{"error":{"message":"perPage must be between 1 and 200"}}`},
+		{"GET", "/admin/cache?since=yesterday", "2", http.StatusBadRequest, `{"error":{"message":"invalid since"}}`},
+		{"GET", "/admin/cache/pdf:4", "3", http.StatusForbidden, `{"error":{"message":"only system admins can access this resource"}}`},
+		{"GET", "/admin/cache/pdf:4", "2", http.StatusOK, `{"key":"pdf:4","legacy":true,"pdfFileId":"5","sourceFileId":"4","sourceHash":"","backend":"","generation":0,"createdAt":0}`},
+		{"GET", "/admin/cache/pdf:6", "2", http.StatusNotFound, `{"error":{"message":"cache entry not found"}}`},
+		{"GET", "/admin/cache/pdf:4/verify", "2", http.StatusOK, `{"key":"pdf:4","valid":false,"error":"cache entry pdf:4 is broken: malformed"}`},
+		{"GET", "/admin/cache/pdf:6/verify", "2", http.StatusNotFound, `{"error":{"message":"cache entry not found"}}`},
+		{"DELETE", "/admin/cache/pdf:4", "3", http.StatusForbidden, `{"error":{"message":"only system admins can access this resource"}}`},
+		{"DELETE", "/admin/cache/pdf:4", "2", http.StatusNoContent, ``},
+	} {
+		req := httptest.NewRequest(tt.method, "http://localhost.com"+tt.path, nil)
+		req.Header.Set("Mattermost-User-Id", tt.userID)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, req)
+		resp := w.Result()
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		require.Equal(t, tt.status, resp.StatusCode, "%s %s", tt.method, tt.path)
+		require.Equal(t, tt.body, string(body), "%s %s", tt.method, tt.path)
+	}
+	topdfMock.AssertExpectations(t)
+	apiMock.AssertExpectations(t)
+}
+
+func TestExecuteWarmUpCommand(t *testing.T) {
+	topdfMock := &tMock.TOPDF{}
+	api := memkv.New()
+	converted := make(chan struct{})
+	p := newTestPlugin(api, &components{app: topdfMock, warmer: warmup.New(api, topdfMock)})
+	api.On("HasPermissionTo", "2", model.PERMISSION_MANAGE_SYSTEM).Return(true)
+	api.On("GetTeamByName", "eng").Once().Return(&model.Team{Id: "t1"}, nil)
+	api.On("GetPublicChannelsForTeam", "t1", 0, 100).Once().Return([]*model.Channel{{Id: "c1"}}, nil)
+	api.On("GetPostsForChannel", "c1", 0, 100).Once().Return(&model.PostList{
+		Order: []string{"p1"},
+		Posts: map[string]*model.Post{"p1": {Id: "p1", CreateAt: model.GetMillis() - 1000, FileIds: []string{"f1"}}},
+	}, nil)
+	topdfMock.On("WarmUp", "f1").Once().Return(true, nil).Run(func(mock.Arguments) { close(converted) })
+
+	resp, _ := p.ExecuteCommand(nil, &model.CommandArgs{UserId: "2", Command: "/topdf warmup start team=eng since=24h"})
+	require.Contains(t, resp.Text, "is running: channel 1 of 1, 0 posts walked")
+	<-converted
+
+	// warm-up is waiting to make the next conversion.
+	resp, _ = p.ExecuteCommand(nil, &model.CommandArgs{UserId: "2", Command: "/topdf warmup stop"})
+	require.Contains(t, resp.Text, "is stopped: channel 1 of 1")
+	resp, _ = p.ExecuteCommand(nil, &model.CommandArgs{UserId: "2", Command: "/topdf warmup status"})
+	require.Contains(t, resp.Text, "is stopped")
+
+	resp, _ = p.ExecuteCommand(nil, &model.CommandArgs{UserId: "2", Command: "/topdf warmup start"})
+	require.Equal(t, warmup.ErrNoChannels.Error(), resp.Text)
+	resp, _ = p.ExecuteCommand(nil, &model.CommandArgs{UserId: "2", Command: "/topdf warmup start channel=c1 since=1h until=2h"})
+	require.Equal(t, "until must be more recent than since", resp.Text)
+	topdfMock.AssertExpectations(t)
+	api.AssertExpectations(t)
+}
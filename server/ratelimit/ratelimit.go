@@ -0,0 +1,143 @@
+// Package ratelimit provides token bucket rate limiters whose state is kept in Mattermost's
+// KV store, so limits are shared by plugin instances running on different nodes of a cluster.
+package ratelimit
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/mattermost/mattermost-server/plugin"
+)
+
+const (
+	// keyPrefix used as a prefix for all rate limiter keys in KV store.
+	keyPrefix = "ratelimit:"
+
+	// globalKey is the KV key of the global bucket.
+	globalKey = keyPrefix + "global"
+
+	// maxRetries is the number of attempts made to take a token when a bucket is
+	// concurrently modified by other plugin instances.
+	maxRetries = 10
+)
+
+// errConflict returned when a bucket modified concurrently while taking a token.
+var errConflict = errors.New("rate limit bucket is modified concurrently")
+
+// bucket is the state of a token bucket.
+type bucket struct {
+	// Tokens is the number of tokens left in bucket at Last.
+	Tokens float64 `json:"tokens"`
+
+	// Last is the last time in milliseconds since epoch when bucket is updated.
+	Last int64 `json:"last"`
+}
+
+// Limiter limits requests per user and globally.
+type Limiter struct {
+	// mapi is Mattermost's Plugin API.
+	mapi plugin.API
+
+	// userPerMinute is the number of requests allowed per minute for each user.
+	// it's also the capacity of user buckets. zero means unlimited.
+	userPerMinute int
+
+	// globalPerMinute is the number of requests allowed per minute for all users.
+	// it's also the capacity of the global bucket. zero means unlimited.
+	globalPerMinute int
+
+	// now returns current time.
+	now func() time.Time
+}
+
+// New creates a new Limiter with mapi that allows userPerMinute requests for each user and
+// globalPerMinute requests in total. zero limits are unlimited.
+func New(mapi plugin.API, userPerMinute, globalPerMinute int) *Limiter {
+	return &Limiter{
+		mapi:            mapi,
+		userPerMinute:   userPerMinute,
+		globalPerMinute: globalPerMinute,
+		now:             time.Now,
+	}
+}
+
+// Allow takes a token for userID from its bucket and from the global bucket.
+// a non-zero retryAfter is returned when there are no tokens left, it's the time to wait
+// until the next token is available. the user's token is given back when the global bucket
+// is empty, so rejected requests don't use up users' limits.
+func (l *Limiter) Allow(userID string) (retryAfter time.Duration, err error) {
+	userKey := keyPrefix + "user:" + userID
+	if l.userPerMinute > 0 {
+		retryAfter, err = l.update(userKey, l.userPerMinute, -1)
+		if err != nil || retryAfter > 0 {
+			return retryAfter, err
+		}
+	}
+	if l.globalPerMinute > 0 {
+		retryAfter, err = l.update(globalKey, l.globalPerMinute, -1)
+		if (err != nil || retryAfter > 0) && l.userPerMinute > 0 {
+			if _, rerr := l.update(userKey, l.userPerMinute, 1); rerr != nil && err == nil {
+				err = rerr
+			}
+		}
+		return retryAfter, err
+	}
+	return 0, nil
+}
+
+// update adds tokens to the bucket at key that refills perMinute tokens in a minute,
+// negative tokens are taken from the bucket.
+func (l *Limiter) update(key string, perMinute int, tokens float64) (retryAfter time.Duration, err error) {
+	for i := 0; i < maxRetries; i++ {
+		retryAfter, err = l.tryUpdate(key, perMinute, tokens)
+		if err != errConflict {
+			return retryAfter, err
+		}
+	}
+	return 0, err
+}
+
+// tryUpdate tries to add tokens to the bucket at key once.
+func (l *Limiter) tryUpdate(key string, perMinute int, tokens float64) (retryAfter time.Duration, err error) {
+	raw, aerr := l.mapi.KVGet(key)
+	if aerr != nil {
+		return 0, aerr
+	}
+	var (
+		now      = l.now().UnixNano() / int64(time.Millisecond)
+		capacity = float64(perMinute)
+		perMilli = capacity / float64(time.Minute/time.Millisecond)
+		b        = bucket{Tokens: capacity, Last: now}
+	)
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &b); err != nil {
+			return 0, err
+		}
+		// refill bucket for the time passed since its last update.
+		if elapsed := now - b.Last; elapsed > 0 {
+			b.Tokens = math.Min(capacity, b.Tokens+float64(elapsed)*perMilli)
+		}
+		b.Last = now
+	} else {
+		raw = nil
+	}
+	if b.Tokens+tokens < 0 {
+		wait := math.Ceil((-tokens - b.Tokens) / perMilli)
+		return time.Duration(wait) * time.Millisecond, nil
+	}
+	b.Tokens = math.Min(capacity, b.Tokens+tokens)
+	data, err := json.Marshal(b)
+	if err != nil {
+		return 0, err
+	}
+	ok, aerr := l.mapi.KVCompareAndSet(key, raw, data)
+	if aerr != nil {
+		return 0, aerr
+	}
+	if !ok {
+		return 0, errConflict
+	}
+	return 0, nil
+}
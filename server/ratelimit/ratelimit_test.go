@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/memkv"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestLimiter creates a limiter with a clock that only moves when advanced.
+func newTestLimiter(userPerMinute, globalPerMinute int) (l *Limiter, advance func(time.Duration)) {
+	now := time.Unix(1000, 0)
+	l = New(memkv.New(), userPerMinute, globalPerMinute)
+	l.now = func() time.Time { return now }
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestAllowUser(t *testing.T) {
+	l, advance := newTestLimiter(2, 0)
+	for i := 0; i < 2; i++ {
+		retryAfter, err := l.Allow("u1")
+		require.NoError(t, err)
+		require.Zero(t, retryAfter)
+	}
+	retryAfter, err := l.Allow("u1")
+	require.NoError(t, err)
+	require.Equal(t, 30*time.Second, retryAfter)
+
+	// other users have their own buckets.
+	retryAfter, err = l.Allow("u2")
+	require.NoError(t, err)
+	require.Zero(t, retryAfter)
+
+	// bucket refills in time.
+	advance(20 * time.Second)
+	retryAfter, err = l.Allow("u1")
+	require.NoError(t, err)
+	require.Equal(t, 10*time.Second, retryAfter)
+	advance(10 * time.Second)
+	retryAfter, err = l.Allow("u1")
+	require.NoError(t, err)
+	require.Zero(t, retryAfter)
+}
+
+func TestAllowGlobal(t *testing.T) {
+	l, _ := newTestLimiter(0, 3)
+	for _, user := range []string{"u1", "u2", "u3"} {
+		retryAfter, err := l.Allow(user)
+		require.NoError(t, err)
+		require.Zero(t, retryAfter)
+	}
+	retryAfter, err := l.Allow("u4")
+	require.NoError(t, err)
+	require.Equal(t, 20*time.Second, retryAfter)
+}
+
+func TestAllowUnlimited(t *testing.T) {
+	l, _ := newTestLimiter(0, 0)
+	for i := 0; i < 100; i++ {
+		retryAfter, err := l.Allow("u1")
+		require.NoError(t, err)
+		require.Zero(t, retryAfter)
+	}
+}
+
+func TestAllowGlobalRejectKeepsUserTokens(t *testing.T) {
+	l, _ := newTestLimiter(2, 1)
+	retryAfter, err := l.Allow("u1")
+	require.NoError(t, err)
+	require.Zero(t, retryAfter)
+
+	// requests rejected by the global limit don't use up the user's tokens.
+	for i := 0; i < 5; i++ {
+		retryAfter, err = l.Allow("u2")
+		require.NoError(t, err)
+		require.Equal(t, time.Minute, retryAfter)
+	}
+	l.globalPerMinute = 0
+	for i := 0; i < 2; i++ {
+		retryAfter, err = l.Allow("u2")
+		require.NoError(t, err)
+		require.Zero(t, retryAfter)
+	}
+	retryAfter, err = l.Allow("u2")
+	require.NoError(t, err)
+	require.Equal(t, 30*time.Second, retryAfter)
+}
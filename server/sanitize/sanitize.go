@@ -0,0 +1,395 @@
+// Package sanitize removes active content, embedded files and optionally external links from
+// PDF documents.
+package sanitize
+
+import (
+	"github.com/ilgooz/mattermost-plugin-topdf/server/pdf"
+)
+
+// version is the version of sanitizing rules, it should be bumped when they're changed so PDFs
+// sanitized with the old rules are not used anymore.
+const version = "1"
+
+// Report is the summary of what removed from a PDF.
+type Report struct {
+	// JavaScript is the number of scripts and JavaScript actions removed.
+	JavaScript int `json:"javaScript,omitempty"`
+
+	// OpenAction is true when the action that runs while opening the document is removed.
+	OpenAction bool `json:"openAction,omitempty"`
+
+	// EmbeddedFiles is the number of embedded files and file attachment annotations removed.
+	EmbeddedFiles int `json:"embeddedFiles,omitempty"`
+
+	// LaunchActions is the number of actions removed that launch applications.
+	LaunchActions int `json:"launchActions,omitempty"`
+
+	// ExternalLinks is the number of actions removed that link to external URLs or documents.
+	ExternalLinks int `json:"externalLinks,omitempty"`
+}
+
+// Clean checks if nothing is removed.
+func (r Report) Clean() bool {
+	return r == Report{}
+}
+
+// Sanitizer sanitizes PDF documents.
+type Sanitizer struct {
+	// stripLinks removes links to external URLs and documents when it's true.
+	stripLinks bool
+}
+
+// New creates a new Sanitizer with options.
+func New(options ...Option) *Sanitizer {
+	s := &Sanitizer{}
+	for _, o := range options {
+		o(s)
+	}
+	return s
+}
+
+// Option used to customize Sanitizer defaults.
+type Option func(*Sanitizer)
+
+// ExternalLinksOption sets whether links to external URLs and documents are neutralised.
+// link annotations are kept as is but their actions are removed.
+func ExternalLinksOption(strip bool) Option {
+	return func(s *Sanitizer) {
+		s.stripLinks = strip
+	}
+}
+
+// Identity identifies the sanitizer with its rules and settings. PDFs sanitized differently are
+// cached separately.
+func (s *Sanitizer) Identity() string {
+	if s.stripLinks {
+		return "sanitize/" + version + "+links"
+	}
+	return "sanitize/" + version
+}
+
+// Sanitize removes JavaScript, the open action, embedded files, launch actions and optionally
+// external links from PDF in data. data is returned as is when there is nothing to remove,
+// otherwise the document is rewritten so removed objects do not stay in the file.
+func (s *Sanitizer) Sanitize(data []byte) (sanitized []byte, report Report, err error) {
+	doc, err := pdf.Parse(data)
+	if err != nil {
+		return nil, report, err
+	}
+	if doc.IsEncrypted() {
+		return nil, report, pdf.ErrEncrypted
+	}
+	r := &run{s: s, doc: doc, visited: make(map[pdf.Ref]bool), actions: make(map[pdf.Ref]bool)}
+	if err := r.sanitize(); err != nil {
+		return nil, report, err
+	}
+	if r.report.Clean() {
+		return data, r.report, nil
+	}
+	sanitized, err = doc.Rewrite()
+	return sanitized, r.report, err
+}
+
+// run is a single sanitizing run over a document.
+// objects are modified in place and written by rewriting the document afterwards.
+type run struct {
+	s   *Sanitizer
+	doc *pdf.Document
+
+	// report collects what removed.
+	report Report
+
+	// visited keeps the indirect objects that visited to protect against cycles.
+	visited map[pdf.Ref]bool
+
+	// actions keeps whether indirect actions are unsafe, actions can be shared by many objects.
+	actions map[pdf.Ref]bool
+}
+
+// sanitize sanitizes the document catalog, pages, form fields and outlines.
+func (r *run) sanitize() error {
+	catalog, err := r.doc.Catalog()
+	if err != nil {
+		return err
+	}
+	open, err := r.doc.Resolve(catalog["OpenAction"])
+	if err != nil {
+		return err
+	}
+	// open actions that are destinations only go to a page, they're kept since PDF producers
+	// add them to most documents. actions are removed even if they're harmless.
+	if _, ok := open.(pdf.Dict); ok {
+		if _, err := r.unsafe(catalog["OpenAction"]); err != nil {
+			return err
+		}
+		delete(catalog, "OpenAction")
+		r.report.OpenAction = true
+	}
+	if err := r.sanitizeTriggers(catalog); err != nil {
+		return err
+	}
+	names, err := r.doc.ResolveDict(catalog["Names"])
+	if err != nil {
+		return err
+	}
+	if names != nil {
+		for key, count := range map[pdf.Name]*int{
+			"JavaScript":    &r.report.JavaScript,
+			"EmbeddedFiles": &r.report.EmbeddedFiles,
+		} {
+			if _, ok := names[key]; !ok {
+				continue
+			}
+			n, err := r.countNames(names[key])
+			if err != nil {
+				return err
+			}
+			*count += n
+			delete(names, key)
+		}
+	}
+	pages, err := r.doc.Pages()
+	if err != nil {
+		return err
+	}
+	for _, page := range pages {
+		if err := r.sanitizePage(page.Dict); err != nil {
+			return err
+		}
+	}
+	form, err := r.doc.ResolveDict(catalog["AcroForm"])
+	if err != nil {
+		return err
+	}
+	if form != nil {
+		if err := r.sanitizeFields(form["Fields"]); err != nil {
+			return err
+		}
+	}
+	outlines, err := r.doc.ResolveDict(catalog["Outlines"])
+	if err != nil {
+		return err
+	}
+	if outlines != nil {
+		return r.sanitizeOutline(outlines["First"])
+	}
+	return nil
+}
+
+// sanitizePage removes unsafe actions and file attachments from page.
+func (r *run) sanitizePage(page pdf.Dict) error {
+	if err := r.sanitizeTriggers(page); err != nil {
+		return err
+	}
+	annots, err := r.doc.Resolve(page["Annots"])
+	if err != nil {
+		return err
+	}
+	arr, ok := annots.(pdf.Array)
+	if !ok {
+		return nil
+	}
+	kept := make(pdf.Array, 0, len(arr))
+	for _, o := range arr {
+		annot, err := r.doc.ResolveDict(o)
+		if err != nil {
+			return err
+		}
+		if annot == nil {
+			continue
+		}
+		if annot["Subtype"] == pdf.Name("FileAttachment") {
+			r.report.EmbeddedFiles++
+			continue
+		}
+		if err := r.sanitizeAction(annot, "A"); err != nil {
+			return err
+		}
+		if err := r.sanitizeTriggers(annot); err != nil {
+			return err
+		}
+		kept = append(kept, o)
+	}
+	page["Annots"] = kept
+	return nil
+}
+
+// sanitizeFields removes unsafe actions from form fields in fields and their kids.
+func (r *run) sanitizeFields(fields pdf.Object) error {
+	o, err := r.doc.Resolve(fields)
+	if err != nil {
+		return err
+	}
+	arr, _ := o.(pdf.Array)
+	for _, f := range arr {
+		if r.seen(f) {
+			continue
+		}
+		field, err := r.doc.ResolveDict(f)
+		if err != nil {
+			return err
+		}
+		if field == nil {
+			continue
+		}
+		if err := r.sanitizeAction(field, "A"); err != nil {
+			return err
+		}
+		if err := r.sanitizeTriggers(field); err != nil {
+			return err
+		}
+		if err := r.sanitizeFields(field["Kids"]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sanitizeOutline removes unsafe actions from the outline item and its siblings and children.
+func (r *run) sanitizeOutline(item pdf.Object) error {
+	for item != nil && !r.seen(item) {
+		dict, err := r.doc.ResolveDict(item)
+		if err != nil {
+			return err
+		}
+		if dict == nil {
+			return nil
+		}
+		if err := r.sanitizeAction(dict, "A"); err != nil {
+			return err
+		}
+		if err := r.sanitizeOutline(dict["First"]); err != nil {
+			return err
+		}
+		item = dict["Next"]
+	}
+	return nil
+}
+
+// sanitizeTriggers removes unsafe actions from the additional actions of dict that triggered by
+// events like opening pages or focusing fields.
+func (r *run) sanitizeTriggers(dict pdf.Dict) error {
+	triggers, err := r.doc.ResolveDict(dict["AA"])
+	if err != nil {
+		return err
+	}
+	for key := range triggers {
+		if err := r.sanitizeAction(triggers, key); err != nil {
+			return err
+		}
+	}
+	if triggers != nil && len(triggers) == 0 {
+		delete(dict, "AA")
+	}
+	return nil
+}
+
+// sanitizeAction removes the action with key from dict if it's unsafe.
+func (r *run) sanitizeAction(dict pdf.Dict, key pdf.Name) error {
+	action, ok := dict[key]
+	if !ok {
+		return nil
+	}
+	unsafe, err := r.unsafe(action)
+	if err != nil {
+		return err
+	}
+	if unsafe {
+		delete(dict, key)
+	}
+	return nil
+}
+
+// unsafe checks if action or any of the actions chained to it is unsafe and reports them.
+func (r *run) unsafe(action pdf.Object) (unsafe bool, err error) {
+	if ref, ok := action.(pdf.Ref); ok {
+		if unsafe, ok := r.actions[ref]; ok {
+			return unsafe, nil
+		}
+		// marked as safe until it's checked to protect against cycles in action chains.
+		r.actions[ref] = false
+		defer func() { r.actions[ref] = unsafe }()
+	}
+	o, err := r.doc.Resolve(action)
+	if err != nil {
+		return false, err
+	}
+	switch v := o.(type) {
+	case pdf.Array:
+		// chained actions can be an array.
+		for _, a := range v {
+			u, err := r.unsafe(a)
+			if err != nil {
+				return false, err
+			}
+			unsafe = unsafe || u
+		}
+		return unsafe, nil
+	case pdf.Dict:
+		switch v["S"] {
+		case pdf.Name("JavaScript"):
+			r.report.JavaScript++
+			unsafe = true
+		case pdf.Name("Launch"):
+			r.report.LaunchActions++
+			unsafe = true
+		case pdf.Name("URI"), pdf.Name("GoToR"), pdf.Name("SubmitForm"), pdf.Name("ImportData"):
+			if r.s.stripLinks {
+				r.report.ExternalLinks++
+				unsafe = true
+			}
+		default:
+			// other actions like renditions can run scripts too.
+			if _, ok := v["JS"]; ok {
+				r.report.JavaScript++
+				unsafe = true
+			}
+		}
+		next, err := r.unsafe(v["Next"])
+		if err != nil {
+			return false, err
+		}
+		return unsafe || next, nil
+	}
+	return false, nil
+}
+
+// countNames counts the entries of name tree.
+func (r *run) countNames(tree pdf.Object) (n int, err error) {
+	if r.seen(tree) {
+		return 0, nil
+	}
+	node, err := r.doc.ResolveDict(tree)
+	if err != nil || node == nil {
+		return 0, err
+	}
+	if names, ok := node["Names"].(pdf.Array); ok {
+		n += len(names) / 2
+	}
+	kids, err := r.doc.Resolve(node["Kids"])
+	if err != nil {
+		return 0, err
+	}
+	arr, _ := kids.(pdf.Array)
+	for _, kid := range arr {
+		c, err := r.countNames(kid)
+		if err != nil {
+			return 0, err
+		}
+		n += c
+	}
+	return n, nil
+}
+
+// seen checks if o is a reference that already visited and marks it as visited.
+func (r *run) seen(o pdf.Object) bool {
+	ref, ok := o.(pdf.Ref)
+	if !ok {
+		return false
+	}
+	if r.visited[ref] {
+		return true
+	}
+	r.visited[ref] = true
+	return false
+}
@@ -0,0 +1,120 @@
+package sanitize
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/pdf"
+	"github.com/stretchr/testify/require"
+)
+
+// buildPDF builds a PDF document from objects that numbered starting from 1, the first one is
+// the document catalog.
+func buildPDF(objects ...string) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.7\n")
+	offsets := make([]int, len(objects))
+	for i, o := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, o)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f\r\n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n\r\n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+// unsafePDF is a PDF with an open action, document and page level scripts, an embedded file,
+// a file attachment, a launch action, an external link and a bookmark that runs a script.
+var unsafePDF = buildPDF(
+	`<< /Type /Catalog /Pages 2 0 R /OpenAction 4 0 R /Names << /JavaScript << /Names [(a) 4 0 R (b) 4 0 R] >> /EmbeddedFiles << /Kids [5 0 R] >> >> /Outlines 9 0 R >>`,
+	`<< /Type /Pages /Kids [3 0 R] /Count 1 >>`,
+	`<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /AA << /O 4 0 R /C << /S /GoTo /D [3 0 R /Fit] >> >> /Annots [6 0 R 7 0 R 8 0 R] >>`,
+	`<< /S /JavaScript /JS (app.alert\(1\)) >>`,
+	`<< /Names [(secret.exe) 11 0 R] >>`,
+	`<< /Type /Annot /Subtype /FileAttachment /FS 11 0 R >>`,
+	`<< /Type /Annot /Subtype /Link /A << /S /Launch /F (calc.exe) >> >>`,
+	`<< /Type /Annot /Subtype /Link /A << /S /URI /URI (http://intranet/) /Next 4 0 R >> >>`,
+	`<< /First 10 0 R >>`,
+	`<< /Title (Bookmark) /A 4 0 R >>`,
+	`<< /Type /Filespec /F (secret.exe) /EF << /F 12 0 R >> >>`,
+	"<< /Type /EmbeddedFile /Length 14 >>\nstream\nEMBEDDED-SECRET\nendstream",
+)
+
+func TestSanitize(t *testing.T) {
+	data, report, err := New().Sanitize(unsafePDF)
+	require.NoError(t, err)
+	require.Equal(t, Report{
+		JavaScript:    3,
+		OpenAction:    true,
+		EmbeddedFiles: 2,
+		LaunchActions: 1,
+	}, report)
+	require.NotContains(t, string(data), "JavaScript")
+	require.NotContains(t, string(data), "EMBEDDED-SECRET")
+	require.NotContains(t, string(data), "calc.exe")
+	// external link is chained to a script, so it's removed even if links are kept.
+	require.NotContains(t, string(data), "intranet")
+
+	doc, err := pdf.Parse(data)
+	require.NoError(t, err)
+	pages, err := doc.Pages()
+	require.NoError(t, err)
+	require.Len(t, pages, 1)
+	require.Len(t, pages[0].Dict["Annots"], 2)
+	// harmless page actions are kept.
+	triggers, err := doc.ResolveDict(pages[0].Dict["AA"])
+	require.NoError(t, err)
+	require.Contains(t, triggers, pdf.Name("C"))
+	require.NotContains(t, triggers, pdf.Name("O"))
+}
+
+func TestSanitizeExternalLinks(t *testing.T) {
+	data := buildPDF(
+		`<< /Type /Catalog /Pages 2 0 R >>`,
+		`<< /Type /Pages /Kids [3 0 R] /Count 1 >>`,
+		`<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Annots [<< /Subtype /Link /A << /S /URI /URI (http://intranet/) >> >>] >>`,
+	)
+	sanitized, report, err := New().Sanitize(data)
+	require.NoError(t, err)
+	require.True(t, report.Clean())
+	require.Equal(t, data, sanitized)
+
+	sanitized, report, err = New(ExternalLinksOption(true)).Sanitize(data)
+	require.NoError(t, err)
+	require.Equal(t, Report{ExternalLinks: 1}, report)
+	require.NotContains(t, string(sanitized), "intranet")
+	require.Contains(t, string(sanitized), "/Link")
+}
+
+func TestSanitizeCleanPDF(t *testing.T) {
+	data, err := ioutil.ReadFile("../pdf/testdata/classic.pdf")
+	require.NoError(t, err)
+	sanitized, report, err := New().Sanitize(data)
+	require.NoError(t, err)
+	require.True(t, report.Clean())
+	require.Equal(t, data, sanitized)
+
+	// open actions that go to a page are kept.
+	data = buildPDF(
+		`<< /Type /Catalog /Pages 2 0 R /OpenAction [3 0 R /Fit] >>`,
+		`<< /Type /Pages /Kids [3 0 R] /Count 1 >>`,
+		`<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] >>`,
+	)
+	_, report, err = New().Sanitize(data)
+	require.NoError(t, err)
+	require.True(t, report.Clean())
+
+	_, _, err = New().Sanitize([]byte("not a pdf"))
+	require.Error(t, err)
+}
+
+func TestIdentity(t *testing.T) {
+	require.Equal(t, "sanitize/1", New().Identity())
+	require.Equal(t, "sanitize/1+links", New(ExternalLinksOption(true)).Identity())
+}
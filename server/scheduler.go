@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/cluster"
+	"github.com/mattermost/mattermost-server/model"
+)
+
+const (
+	// schedulerLease is the name of lease that elects the node running scheduled work in a cluster.
+	schedulerLease = "scheduler"
+
+	// scheduleInterval is the interval of scheduled work, lease is renewed at each run.
+	scheduleInterval = 30 * time.Second
+
+	// schedulerLeaseTTL is the duration that lease is kept when its holder stops renewing it.
+	schedulerLeaseTTL = 3 * scheduleInterval
+
+	// evictionInterval is the interval of evicting stale cache entries.
+	evictionInterval = time.Hour
+
+	// adminsPerPage is the number of system admins that fetched at once to alert them.
+	adminsPerPage = 100
+)
+
+// startScheduler starts running scheduled work in background, like resuming warm-ups, evicting
+// stale cache entries and probing PDF server. only one node of a cluster runs it at a time.
+func (p *Plugin) startScheduler() {
+	p.lease = cluster.NewLease(p.API, schedulerLease, model.NewId(), cluster.TTLOption(schedulerLeaseTTL))
+	p.stopScheduler = make(chan struct{})
+	p.schedulerDone = make(chan struct{})
+	go func() {
+		defer close(p.schedulerDone)
+		ticker := time.NewTicker(scheduleInterval)
+		defer ticker.Stop()
+		for {
+			p.schedule()
+			select {
+			case <-ticker.C:
+			case <-p.stopScheduler:
+				return
+			}
+		}
+	}()
+}
+
+// OnDeactivate hook stops scheduled work and hands it over to another node.
+func (p *Plugin) OnDeactivate() error {
+	if p.stopScheduler == nil {
+		return nil
+	}
+	close(p.stopScheduler)
+	<-p.schedulerDone
+	// a running warm-up is continued from its cursor by the next leader.
+	if comps := p.load(); comps != nil {
+		comps.warmer.Close()
+		if comps.exporter != nil {
+			comps.exporter.Close()
+		}
+		if comps.audit != nil {
+			comps.audit.Close()
+		}
+	}
+	if err := p.lease.Release(); err != nil {
+		p.API.LogError("cannot release scheduler lease", "err", err.Error())
+	}
+	return nil
+}
+
+// schedule runs scheduled work if this node is the leader of cluster.
+func (p *Plugin) schedule() {
+	leader, err := p.lease.Acquire()
+	if err != nil {
+		p.API.LogError("cannot acquire scheduler lease", "err", err.Error())
+		leader = false
+	}
+	p.setLeader(leader)
+	comps := p.load()
+	if comps == nil {
+		return
+	}
+	if !leader {
+		// the lease might be lost while a warm-up is running.
+		comps.warmer.Close()
+		return
+	}
+	if err := comps.warmer.ResumeInterrupted(); err != nil {
+		p.API.LogError("cannot resume warm-up", "err", err.Error())
+	}
+	if time.Since(p.lastEviction) >= evictionInterval {
+		p.lastEviction = time.Now()
+		p.evictStaleCache(comps)
+	}
+	p.probePDFServer(comps)
+}
+
+// evictStaleCache deletes cache entries of old cache generations.
+func (p *Plugin) evictStaleCache(comps *components) {
+	evicted, err := comps.app.EvictStaleCache()
+	if err != nil {
+		p.API.LogError("cannot evict stale cache entries", "err", err.Error())
+		return
+	}
+	if evicted > 0 {
+		p.API.LogInfo("stale cache entries are evicted", "count", evicted)
+	}
+}
+
+// probePDFServer logs changes in reachability of PDF server and alerts system admins when it's
+// not reachable for longer than the configured duration.
+func (p *Plugin) probePDFServer(comps *components) {
+	err := comps.app.CheckServerStatus(context.Background())
+	switch {
+	case err != nil && !p.pdfServerDown:
+		p.pdfServerDown = true
+		p.pdfServerDownSince = time.Now()
+		p.API.LogWarn("PDF server is not reachable", "reason", err.Error())
+	case err == nil && p.pdfServerDown:
+		p.pdfServerDown = false
+		p.API.LogInfo("PDF server is reachable again")
+		if p.outageAlerted {
+			p.outageAlerted = false
+			p.alertAdmins(fmt.Sprintf("PDF server is reachable again after %s, previews are available.",
+				time.Since(p.pdfServerDownSince).Round(time.Second)))
+		}
+	}
+	if p.pdfServerDown && !p.outageAlerted && comps.outageAlertAfter > 0 &&
+		time.Since(p.pdfServerDownSince) >= comps.outageAlertAfter {
+		p.outageAlerted = true
+		p.alertAdmins(fmt.Sprintf(":warning: PDF server has not been reachable for %s, previews of files cannot be created: %s",
+			time.Since(p.pdfServerDownSince).Round(time.Second), err))
+	}
+}
+
+// alertAdmins sends message to active system admins from Plugin's bot as a direct message.
+func (p *Plugin) alertAdmins(message string) {
+	for page := 0; ; page++ {
+		admins, aerr := p.API.GetUsers(&model.UserGetOptions{
+			Role:    model.SYSTEM_ADMIN_ROLE_ID,
+			Page:    page,
+			PerPage: adminsPerPage,
+		})
+		if aerr != nil {
+			p.API.LogError("cannot get system admins to alert", "err", aerr.Error())
+			return
+		}
+		for _, admin := range admins {
+			if admin.IsBot || admin.DeleteAt != 0 {
+				continue
+			}
+			if err := p.sendDirectMessage(admin.Id, message); err != nil {
+				p.API.LogError("cannot alert system admin", "userId", admin.Id, "err", err.Error())
+			}
+		}
+		if len(admins) < adminsPerPage {
+			return
+		}
+	}
+}
+
+// sendDirectMessage sends message to userID from Plugin's bot.
+func (p *Plugin) sendDirectMessage(userID, message string) error {
+	channel, aerr := p.API.GetDirectChannel(p.botUserID, userID)
+	if aerr != nil {
+		return aerr
+	}
+	_, aerr = p.API.CreatePost(&model.Post{
+		UserId:    p.botUserID,
+		ChannelId: channel.Id,
+		Message:   message,
+	})
+	if aerr != nil {
+		return aerr
+	}
+	return nil
+}
+
+// isLeader checks if this node runs scheduled work of the cluster.
+func (p *Plugin) isLeader() bool {
+	return atomic.LoadInt32(&p.leader) == 1
+}
+
+// setLeader sets if this node runs scheduled work of the cluster.
+func (p *Plugin) setLeader(leader bool) {
+	var v int32
+	if leader {
+		v = 1
+	}
+	atomic.StoreInt32(&p.leader, v)
+}
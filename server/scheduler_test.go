@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/cluster"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/warmup"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/memkv"
+	pMock "github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/mocks"
+	tMock "github.com/ilgooz/mattermost-plugin-topdf/server/x/xtopdf/mocks"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newSchedulerTestPlugin creates a Plugin for node with nodeID that shares api with other nodes.
+func newSchedulerTestPlugin(api *memkv.API, nodeID string, app *tMock.TOPDF) *Plugin {
+	p := newTestPlugin(api, nil)
+	p.store(&components{app: app, warmer: warmup.New(api, app, warmup.LeaderOption(p.isLeader))})
+	p.lease = cluster.NewLease(api, schedulerLease, nodeID)
+	return p
+}
+
+func TestScheduleSingleLeader(t *testing.T) {
+	api := memkv.New()
+	app := &tMock.TOPDF{}
+	a := newSchedulerTestPlugin(api, "a", app)
+	b := newSchedulerTestPlugin(api, "b", app)
+	// a warm-up interrupted without any channels left to walk.
+	data, err := json.Marshal(warmup.Progress{ID: "1", State: warmup.StateRunning})
+	require.NoError(t, err)
+	api.KVSet("warmup:progress", data)
+	app.On("CheckServerStatus", mock.Anything).Once().Return(errors.New("down"))
+	app.On("CheckServerStatus", mock.Anything).Return(nil)
+	app.On("EvictStaleCache").Once().Return(0, nil)
+	api.On("LogWarn", "PDF server is not reachable", "reason", "down").Once()
+	api.On("LogInfo", "PDF server is reachable again").Once()
+
+	// only the leader resumes the warm-up and probes PDF server.
+	b.setLeader(true)
+	a.schedule()
+	require.True(t, a.isLeader())
+	b.schedule()
+	require.False(t, b.isLeader())
+	// wait for the warm-up to finish.
+	a.load().warmer.Close()
+	progress, err := a.load().warmer.Status()
+	require.NoError(t, err)
+	require.Equal(t, warmup.StateDone, progress.State)
+
+	// recovery of PDF server is logged once.
+	a.schedule()
+	a.schedule()
+	app.AssertExpectations(t)
+	api.AssertExpectations(t)
+}
+
+func TestOnDeactivateReleasesLease(t *testing.T) {
+	api := memkv.New()
+	app := &tMock.TOPDF{}
+	a := newSchedulerTestPlugin(api, "a", app)
+	app.On("CheckServerStatus", mock.Anything).Return(nil)
+	app.On("EvictStaleCache").Return(0, nil)
+	helpers := &pMock.Helpers{}
+	a.Helpers = helpers
+	helpers.On("EnsureBot", mock.Anything).Once().Return("bot-id", nil)
+	api.On("RegisterCommand", &model.Command{
+		Trigger:          commandTrigger,
+		DisplayName:      "TOPDF",
+		Description:      "Manage TOPDF plugin.",
+		AutoComplete:     true,
+		AutoCompleteDesc: "Available commands: attach, audit, cache, warmup, help",
+		AutoCompleteHint: "[command]",
+	}).Once().Return(nil)
+	require.NoError(t, a.OnActivate())
+	require.Equal(t, "bot-id", a.botUserID)
+	require.NoError(t, a.OnDeactivate())
+
+	b := newSchedulerTestPlugin(api, "b", app)
+	b.schedule()
+	require.True(t, b.isLeader())
+	api.AssertExpectations(t)
+}
+
+func TestScheduleEvictsStaleCache(t *testing.T) {
+	api := memkv.New()
+	app := &tMock.TOPDF{}
+	p := newSchedulerTestPlugin(api, "a", app)
+	app.On("CheckServerStatus", mock.Anything).Return(nil)
+	app.On("EvictStaleCache").Once().Return(2, nil)
+	api.On("LogInfo", "stale cache entries are evicted", "count", 2).Once()
+
+	// cache is evicted once in an interval.
+	p.schedule()
+	p.schedule()
+	app.AssertExpectations(t)
+	api.AssertExpectations(t)
+}
+
+func TestOutageAlert(t *testing.T) {
+	api := memkv.New()
+	app := &tMock.TOPDF{}
+	p := newTestPlugin(api, &components{app: app, outageAlertAfter: time.Minute})
+	p.botUserID = "bot-id"
+	app.On("CheckServerStatus", mock.Anything).Times(3).Return(errors.New("down"))
+	app.On("CheckServerStatus", mock.Anything).Once().Return(nil)
+	api.On("LogWarn", "PDF server is not reachable", "reason", "down").Once()
+	api.On("LogInfo", "PDF server is reachable again").Once()
+	api.On("GetUsers", &model.UserGetOptions{Role: model.SYSTEM_ADMIN_ROLE_ID, PerPage: adminsPerPage}).Twice().Return([]*model.User{
+		{Id: "admin-id"},
+		{Id: "other-bot-id", IsBot: true},
+		{Id: "deactivated-id", DeleteAt: 1},
+	}, nil)
+	api.On("GetDirectChannel", "bot-id", "admin-id").Twice().Return(&model.Channel{Id: "dm-id"}, nil)
+	isAlert := func(prefix string) interface{} {
+		return mock.MatchedBy(func(post *model.Post) bool {
+			return post.UserId == "bot-id" && post.ChannelId == "dm-id" && strings.HasPrefix(post.Message, prefix)
+		})
+	}
+	api.On("CreatePost", isAlert(":warning: PDF server has not been reachable for 2m0s")).Once().Return(&model.Post{}, nil)
+	api.On("CreatePost", isAlert("PDF server is reachable again after 2m0s")).Once().Return(&model.Post{}, nil)
+
+	// admins are not alerted until PDF server is down for long enough.
+	p.probePDFServer(p.load())
+	p.pdfServerDownSince = time.Now().Add(-2 * time.Minute)
+	// and they're alerted only once for an outage.
+	p.probePDFServer(p.load())
+	p.probePDFServer(p.load())
+	// recovery is announced to alerted admins.
+	p.probePDFServer(p.load())
+	app.AssertExpectations(t)
+	api.AssertExpectations(t)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xhttp"
+)
+
+// errSignedURLsDisabled returned when signed URLs are used while there is no signing key.
+var errSignedURLsDisabled = errors.New("signed URLs are not enabled")
+
+// signedURLResponse is the signed URL response sent to client.
+type signedURLResponse struct {
+	// URL is the signed URL of PDF, it's relative to Mattermost when site URL is not configured.
+	URL string `json:"url"`
+
+	// ExpiresAt is the time in milliseconds since epoch that URL expires at.
+	ExpiresAt int64 `json:"expiresAt"`
+}
+
+// handleCreateSignedURL handles requests to create signed URLs of PDFs.
+// URLs are only signed for users that can access the files, PDFs are served with the permissions
+// of these users so a URL stops working when the user loses access to its file.
+func (p *Plugin) handleCreateSignedURL(w http.ResponseWriter, r *http.Request) {
+	fileID := mux.Vars(r)["id"]
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		xhttp.ResponseJSON(w, http.StatusUnauthorized, createErrorResponse(topdf.ErrUnauthorizedUser))
+		return
+	}
+	comps := p.load()
+	if comps.signer == nil {
+		xhttp.ResponseJSON(w, http.StatusNotImplemented, createErrorResponse(errSignedURLsDisabled))
+		return
+	}
+	if err := comps.app.Authorize(r.Context(), userID, fileID); err != nil {
+		if err == topdf.ErrUnauthorizedUser {
+			xhttp.ResponseJSON(w, http.StatusUnauthorized, createErrorResponse(err))
+			return
+		}
+		xhttp.ResponseJSON(w, http.StatusInternalServerError, createErrorResponse(err))
+		p.logError(r.Context(), "cannot sign URL", err, "fileId", fileID)
+		return
+	}
+	params, expiresAt := comps.signer.Sign(fileID, userID)
+	u := "/plugins/" + manifest.Id + "/files/" + url.PathEscape(fileID) + "?" + params.Encode()
+	if config := p.API.GetConfig(); config != nil && config.ServiceSettings.SiteURL != nil {
+		u = strings.TrimSuffix(*config.ServiceSettings.SiteURL, "/") + u
+	}
+	p.logger(r.Context()).Info("URL signed", "fileId", fileID, "expiresAt", expiresAt.Unix())
+	xhttp.ResponseJSON(w, http.StatusOK, signedURLResponse{
+		URL:       u,
+		ExpiresAt: expiresAt.UnixNano() / int64(1e6),
+	})
+}
+
+// verifySignedURL verifies the signed URL of fileID that has params with the signer of comps and
+// returns the user that it's signed for.
+func verifySignedURL(comps *components, fileID string, params url.Values) (userID string, err error) {
+	if comps.signer == nil {
+		return "", errSignedURLsDisabled
+	}
+	return comps.signer.Verify(fileID, params)
+}
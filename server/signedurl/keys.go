@@ -0,0 +1,80 @@
+package signedurl
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/mattermost/mattermost-server/plugin"
+)
+
+const (
+	// keysKey is the KV key of signing keys. they're kept in KV store so URLs signed with the
+	// previous key stay valid across restarts and on every node of a cluster.
+	keysKey = "signedurl:keys"
+
+	// maxRotateRetries is the number of attempts made to record a key when signing keys are
+	// concurrently modified by other plugin instances.
+	maxRotateRetries = 10
+)
+
+// errRotateConflict returned when signing keys are modified concurrently too many times.
+var errRotateConflict = errors.New("signing keys are modified concurrently")
+
+// keys are the signing keys kept in KV store.
+type keys struct {
+	// Current is the key that URLs are signed with.
+	Current string `json:"current"`
+
+	// Previous is the key that URLs were signed with before Current.
+	Previous string `json:"previous,omitempty"`
+
+	// RotatedAt is the time in milliseconds since epoch when Previous is replaced by Current.
+	RotatedAt int64 `json:"rotatedAt,omitempty"`
+}
+
+// Rotate records the key of s as the current signing key in KV store of mapi. URLs signed with
+// the key that is replaced by it are verified by s until they're expired.
+func (s *Signer) Rotate(mapi plugin.API) error {
+	for i := 0; i < maxRotateRetries; i++ {
+		raw, aerr := mapi.KVGet(keysKey)
+		if aerr != nil {
+			return aerr
+		}
+		var k keys
+		if len(raw) > 0 {
+			if err := json.Unmarshal(raw, &k); err != nil {
+				return err
+			}
+		} else {
+			// a nil old value requires the key to be missing.
+			raw = nil
+		}
+		now := s.now()
+		if k.Current == string(s.key) {
+			s.previousKey = nil
+			rotatedAt := time.Unix(0, k.RotatedAt*int64(time.Millisecond))
+			if k.Previous != "" && now.Before(rotatedAt.Add(s.ttl)) {
+				s.previousKey = []byte(k.Previous)
+			}
+			return nil
+		}
+		data, err := json.Marshal(keys{
+			Current:   string(s.key),
+			Previous:  k.Current,
+			RotatedAt: now.UnixNano() / int64(time.Millisecond),
+		})
+		if err != nil {
+			return err
+		}
+		ok, aerr := mapi.KVCompareAndSet(keysKey, raw, data)
+		if aerr != nil {
+			return aerr
+		}
+		if ok {
+			s.previousKey = []byte(k.Current)
+			return nil
+		}
+	}
+	return errRotateConflict
+}
@@ -0,0 +1,120 @@
+// Package signedurl signs URLs of PDFs so they can be fetched without a Mattermost session until
+// they expire. URLs are signed for users and they're served with the permissions of these users.
+package signedurl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// UserParam, ExpiresParam and SignatureParam are the query params of signed URLs.
+	UserParam      = "user"
+	ExpiresParam   = "expires"
+	SignatureParam = "signature"
+
+	// MinKeyLength is the minimum length of signing keys.
+	MinKeyLength = 32
+
+	// defaultTTL is the default duration that signed URLs are valid for.
+	defaultTTL = 5 * time.Minute
+)
+
+var (
+	// ErrInvalidSignature returned when a URL is not signed by Signer or it's tampered with.
+	ErrInvalidSignature = errors.New("invalid URL signature")
+
+	// ErrExpired returned when a signed URL is expired.
+	ErrExpired = errors.New("signed URL is expired")
+)
+
+// Signer signs and verifies URLs.
+type Signer struct {
+	// key is the key that URLs are signed with.
+	key []byte
+
+	// previousKey is the key that URLs were signed with before the key is rotated, they're
+	// still verified until they expire. it's set by Rotate.
+	previousKey []byte
+
+	// ttl is the duration that signed URLs are valid for.
+	ttl time.Duration
+
+	// now gets the current time.
+	now func() time.Time
+}
+
+// Option used to customize Signer defaults.
+type Option func(*Signer)
+
+// TTLOption sets the duration that signed URLs are valid for.
+func TTLOption(ttl time.Duration) Option {
+	return func(s *Signer) {
+		s.ttl = ttl
+	}
+}
+
+// New creates a new Signer that signs URLs with key and options.
+func New(key string, options ...Option) *Signer {
+	s := &Signer{
+		key: []byte(key),
+		ttl: defaultTTL,
+		now: time.Now,
+	}
+	for _, o := range options {
+		o(s)
+	}
+	return s
+}
+
+// Sign signs the URL of file with fileID for userID. params should be added to the URL and it's
+// valid until expiresAt.
+func (s *Signer) Sign(fileID, userID string) (params url.Values, expiresAt time.Time) {
+	expiresAt = s.now().Add(s.ttl)
+	expires := strconv.FormatInt(expiresAt.Unix(), 10)
+	return url.Values{
+		UserParam:      {userID},
+		ExpiresParam:   {expires},
+		SignatureParam: {sign(s.key, fileID, userID, expires)},
+	}, expiresAt
+}
+
+// Verify verifies the signed URL of file with fileID that has params and returns the user that
+// URL is signed for.
+func (s *Signer) Verify(fileID string, params url.Values) (userID string, err error) {
+	userID = params.Get(UserParam)
+	expires := params.Get(ExpiresParam)
+	signature := params.Get(SignatureParam)
+	if userID == "" || expires == "" || signature == "" {
+		return "", ErrInvalidSignature
+	}
+	valid := hmac.Equal([]byte(signature), []byte(sign(s.key, fileID, userID, expires)))
+	if !valid && len(s.previousKey) != 0 {
+		valid = hmac.Equal([]byte(signature), []byte(sign(s.previousKey, fileID, userID, expires)))
+	}
+	if !valid {
+		return "", ErrInvalidSignature
+	}
+	// expiry is checked after the signature so it can be trusted.
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return "", ErrInvalidSignature
+	}
+	if !s.now().Before(time.Unix(unix, 0)) {
+		return "", ErrExpired
+	}
+	return userID, nil
+}
+
+// sign signs the URL of file with fileID for userID that expires at expires with key.
+func sign(key []byte, fileID, userID, expires string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(strings.Join([]string{fileID, userID, expires}, "\n")))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
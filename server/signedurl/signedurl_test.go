@@ -0,0 +1,81 @@
+package signedurl
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/memkv"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testKey  = "0123456789abcdef0123456789abcdef"
+	otherKey = "fedcba9876543210fedcba9876543210"
+)
+
+// newTestSigner creates a Signer with a fake clock.
+func newTestSigner(key string, options ...Option) (s *Signer, now *time.Time) {
+	s = New(key, options...)
+	clock := time.Unix(1000, 0)
+	s.now = func() time.Time { return clock }
+	return s, &clock
+}
+
+func TestSignVerify(t *testing.T) {
+	s, now := newTestSigner(testKey, TTLOption(time.Minute))
+	params, expiresAt := s.Sign("file-id", "user-id")
+	require.Equal(t, time.Unix(1060, 0), expiresAt)
+	userID, err := s.Verify("file-id", params)
+	require.NoError(t, err)
+	require.Equal(t, "user-id", userID)
+
+	*now = now.Add(time.Minute)
+	_, err = s.Verify("file-id", params)
+	require.Equal(t, ErrExpired, err)
+}
+
+func TestVerifyTampered(t *testing.T) {
+	s, _ := newTestSigner(testKey)
+	params, _ := s.Sign("file-id", "user-id")
+	for _, tt := range []struct {
+		fileID string
+		params url.Values
+	}{
+		{"other-file-id", params},
+		{"file-id", url.Values{UserParam: {"other-user-id"}, ExpiresParam: params[ExpiresParam], SignatureParam: params[SignatureParam]}},
+		{"file-id", url.Values{UserParam: params[UserParam], ExpiresParam: {"99999999999"}, SignatureParam: params[SignatureParam]}},
+		{"file-id", url.Values{UserParam: params[UserParam], ExpiresParam: params[ExpiresParam]}},
+		{"file-id", url.Values{}},
+	} {
+		_, err := s.Verify(tt.fileID, tt.params)
+		require.Equal(t, ErrInvalidSignature, err, "%s %v", tt.fileID, tt.params)
+	}
+}
+
+func TestVerifyRotatedKey(t *testing.T) {
+	api := memkv.New()
+	old, _ := newTestSigner(otherKey)
+	require.NoError(t, old.Rotate(api))
+	params, _ := old.Sign("file-id", "user-id")
+
+	// URLs signed with the previous key are still valid.
+	s, _ := newTestSigner(testKey)
+	require.NoError(t, s.Rotate(api))
+	userID, err := s.Verify("file-id", params)
+	require.NoError(t, err)
+	require.Equal(t, "user-id", userID)
+
+	// also by signers created later with the same key, like the ones of other nodes.
+	s, now := newTestSigner(testKey)
+	require.NoError(t, s.Rotate(api))
+	userID, err = s.Verify("file-id", params)
+	require.NoError(t, err)
+	require.Equal(t, "user-id", userID)
+
+	// until URLs signed with it are expired.
+	*now = now.Add(defaultTTL)
+	require.NoError(t, s.Rotate(api))
+	_, err = s.Verify("file-id", params)
+	require.Equal(t, ErrInvalidSignature, err)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/signedurl"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/memkv"
+	pMock "github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/mocks"
+	tMock "github.com/ilgooz/mattermost-plugin-topdf/server/x/xtopdf/mocks"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const testSigningKey = "0123456789abcdef0123456789abcdef"
+
+func TestSignedURL(t *testing.T) {
+	topdfMock := &tMock.TOPDF{}
+	apiMock := &pMock.API{}
+	p := newTestPlugin(apiMock, &components{app: topdfMock, signer: signedurl.New(testSigningKey)})
+	siteURL := "https://mattermost.example.com/"
+	apiMock.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: &siteURL}})
+	apiMock.On("LogInfo", "URL signed", "requestId", mock.Anything, "userId", "2", "fileId", "1", "expiresAt", mock.Anything).Once()
+	topdfMock.On("Authorize", mock.Anything, "2", "1").Once().Return(nil)
+	topdfMock.On("Authorize", mock.Anything, "3", "1").Once().Return(topdf.ErrUnauthorizedUser)
+
+	req := httptest.NewRequest("POST", "http://localhost.com/files/1/signed-url", nil)
+	req.Header.Set("Mattermost-User-Id", "3")
+	w := httptest.NewRecorder()
+	p.ServeHTTP(nil, w, req)
+	require.Equal(t, http.StatusUnauthorized, w.Result().StatusCode)
+
+	req = httptest.NewRequest("POST", "http://localhost.com/files/1/signed-url", nil)
+	req.Header.Set("Mattermost-User-Id", "2")
+	w = httptest.NewRecorder()
+	p.ServeHTTP(nil, w, req)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	var resp signedURLResponse
+	require.NoError(t, json.NewDecoder(w.Result().Body).Decode(&resp))
+	require.NotZero(t, resp.ExpiresAt)
+	require.True(t, strings.HasPrefix(resp.URL, "https://mattermost.example.com/plugins/topdf/files/1?"), resp.URL)
+
+	// signed URL is served with the permissions of its user without a session.
+	u, err := url.Parse(resp.URL)
+	require.NoError(t, err)
+	topdfMock.On("GetPDF", mock.Anything, "2", "1", "192.0.2.1").Once().Return(newTestPDF([]byte{3}), nil)
+	req = httptest.NewRequest("GET", "http://localhost.com/files/1?"+u.RawQuery, nil)
+	w = httptest.NewRecorder()
+	p.ServeHTTP(nil, w, req)
+	body, err := ioutil.ReadAll(w.Result().Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusOK, w.Result().StatusCode)
+	require.Equal(t, []byte{3}, body)
+
+	// signature is only valid for the file it's created for.
+	apiMock.On("LogWarn", "cannot serve PDF", "requestId", mock.Anything, "fileId", "4", "err", "invalid URL signature").Once()
+	req = httptest.NewRequest("GET", "http://localhost.com/files/4?"+u.RawQuery, nil)
+	w = httptest.NewRecorder()
+	p.ServeHTTP(nil, w, req)
+	body, err = ioutil.ReadAll(w.Result().Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusUnauthorized, w.Result().StatusCode)
+	require.Equal(t, `{"error":{"message":"invalid URL signature"}}`, string(body))
+	topdfMock.AssertExpectations(t)
+	apiMock.AssertExpectations(t)
+}
+
+func TestSignedURLDisabled(t *testing.T) {
+	p := newTestPlugin(nil, &components{app: &tMock.TOPDF{}})
+	req := httptest.NewRequest("POST", "http://localhost.com/files/1/signed-url", nil)
+	req.Header.Set("Mattermost-User-Id", "2")
+	w := httptest.NewRecorder()
+	p.ServeHTTP(nil, w, req)
+	body, err := ioutil.ReadAll(w.Result().Body)
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotImplemented, w.Result().StatusCode)
+	require.Equal(t, `{"error":{"message":"signed URLs are not enabled"}}`, string(body))
+}
+
+func TestNewComponentsRotatesSigningKey(t *testing.T) {
+	api := memkv.New()
+	p := newTestPlugin(api, nil)
+	c := validConfiguration()
+	c.SignedURLKey = testSigningKey
+	old, err := p.newComponents(c)
+	require.NoError(t, err)
+	params, _ := old.signer.Sign("1", "2")
+
+	// URLs signed with the previous key are still valid after rotation, including the components
+	// rebuilt later with the same key and the ones of other nodes.
+	c.SignedURLKey = "fedcba9876543210fedcba9876543210"
+	for _, node := range []*Plugin{p, p, newTestPlugin(api, nil)} {
+		comps, err := node.newComponents(c)
+		require.NoError(t, err)
+		userID, err := comps.signer.Verify("1", params)
+		require.NoError(t, err)
+		require.Equal(t, "2", userID)
+	}
+}
@@ -0,0 +1,122 @@
+package topdf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/audit"
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// publicLinkLimitPrefix is the prefix of rate limit keys of public link accesses, they're limited
+// by client IPs since there are no users.
+const publicLinkLimitPrefix = "public:"
+
+// publicLinkTimeout is the timeout of requests made to Mattermost to verify public links.
+const publicLinkTimeout = 10 * time.Second
+
+// errNoSiteURL returned when public links cannot be verified since Site URL of Mattermost is not set.
+var errNoSiteURL = errors.New("public links cannot be verified without Site URL of Mattermost")
+
+// access is an access to a file by a user or through a public link.
+type access struct {
+	// userID is the user who accesses to file, it's empty for public links.
+	userID string
+
+	// publicLinkHash is the hash of public link that file accessed through.
+	publicLinkHash string
+
+	// clientIP is the IP address of client.
+	clientIP string
+}
+
+// isPublic checks if a is through a public link.
+func (a access) isPublic() bool {
+	return a.publicLinkHash != ""
+}
+
+// limitKey gets the key that conversions made by a are rate limited with.
+func (a access) limitKey() string {
+	if a.isPublic() {
+		return publicLinkLimitPrefix + a.clientIP
+	}
+	return a.userID
+}
+
+// admittedKey is the context key of conversions whose rate limit tokens are taken by Admit.
+type admittedKey struct{}
+
+// Admit checks if userID can access fileID and takes a rate limit token for converting it before
+// the conversion is made, so background conversions are rejected before they're queued.
+// returned ctx carries the token, conversions made with it don't take another one.
+func (t *TOPDF) Admit(ctx context.Context, userID, fileID string) (context.Context, error) {
+	if err := t.Authorize(ctx, userID, fileID); err != nil {
+		return ctx, err
+	}
+	if err := t.allow(ctx, userID); err != nil {
+		return ctx, err
+	}
+	return context.WithValue(ctx, admittedKey{}, true), nil
+}
+
+// Authorize checks if userID can access fileID without getting its PDF, ErrUnauthorizedUser is
+// returned when it cannot.
+func (t *TOPDF) Authorize(ctx context.Context, userID, fileID string) error {
+	_, _, err := t.authorize(ctx, access{userID: userID}, fileID, &audit.Event{})
+	// return an authorization error if we got an err from Plugin's API.
+	if _, ok := err.(*model.AppError); ok {
+		return ErrUnauthorizedUser
+	}
+	return err
+}
+
+// canAccess checks if userID can read the file with fileInfo that is attached to filePost.
+// rules mirror Mattermost's own file access checks: uploaders can always read their files, users
+// that can read the channel of file can read it, which covers channel members and permissions
+// granted by team and system roles, and system admins and compliance users can read any file.
+func (t *TOPDF) canAccess(userID string, fileInfo *model.FileInfo, filePost *model.Post) bool {
+	if userID == "" {
+		return false
+	}
+	if fileInfo.CreatorId == userID {
+		return true
+	}
+	if t.mapi.HasPermissionToChannel(userID, filePost.ChannelId, model.PERMISSION_READ_CHANNEL) {
+		return true
+	}
+	return t.mapi.HasPermissionTo(userID, model.PERMISSION_MANAGE_SYSTEM) ||
+		t.mapi.HasPermissionTo(userID, model.PERMISSION_DOWNLOAD_COMPLIANCE_EXPORT_RESULT)
+}
+
+// isValidPublicLink checks if hash is the hash of a public link to file with fileID.
+// Plugin API hides the salt that public links are hashed with, so the link is verified by requesting
+// it from Mattermost at its Site URL, it's only valid when Mattermost serves it. this way links stop
+// working as soon as public links are disabled or regenerated in Mattermost's file settings.
+func (t *TOPDF) isValidPublicLink(ctx context.Context, fileID, hash string) (bool, error) {
+	if hash == "" {
+		return false, nil
+	}
+	config := t.mapi.GetConfig()
+	if config == nil || config.ServiceSettings.SiteURL == nil || *config.ServiceSettings.SiteURL == "" {
+		return false, errNoSiteURL
+	}
+	link := fmt.Sprintf("%s/api/v4/files/%s/public?h=%s", strings.TrimSuffix(*config.ServiceSettings.SiteURL, "/"),
+		url.PathEscape(fileID), url.QueryEscape(hash))
+	req, err := http.NewRequest("GET", link, nil)
+	if err != nil {
+		return false, err
+	}
+	c := &http.Client{Timeout: publicLinkTimeout}
+	res, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	// content of file is not needed, only the status tells if the link is valid.
+	res.Body.Close()
+	return res.StatusCode == http.StatusOK, nil
+}
@@ -0,0 +1,173 @@
+package topdf
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	sMock "github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver/mocks"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/memkv"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetPDFAccess(t *testing.T) {
+	tests := []struct {
+		name string
+		// permissions are the permissions of user-id, the ones that are not listed are denied.
+		channelRead, admin, compliance bool
+		creatorID                      string
+		err                            error
+	}{
+		{name: "uploader", creatorID: "user-id"},
+		{name: "channel reader", channelRead: true},
+		{name: "system admin", admin: true},
+		{name: "compliance user", compliance: true},
+		{name: "other user", err: ErrUnauthorizedUser},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiMock := mockCachedPDF(&model.FileInfo{Id: "file-id", PostId: "2", CreatorId: tt.creatorID})
+			apiMock.On("HasPermissionToChannel", "user-id", "5", model.PERMISSION_READ_CHANNEL).Return(tt.channelRead)
+			apiMock.On("HasPermissionTo", "user-id", model.PERMISSION_MANAGE_SYSTEM).Return(tt.admin)
+			apiMock.On("HasPermissionTo", "user-id", model.PERMISSION_DOWNLOAD_COMPLIANCE_EXPORT_RESULT).Return(tt.compliance)
+			app := New(apiMock, newIdentifiedServer())
+			pdf, err := app.GetPDF(context.Background(), "user-id", "file-id", "")
+			requirePDF(t, tt.err, pdf, err)
+		})
+	}
+}
+
+func TestGetPublicPDFAccess(t *testing.T) {
+	// Mattermost only serves the public link of file-id with the valid hash.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/api/v4/files/file-id/public", r.URL.Path)
+		if r.URL.Query().Get("h") != "valid" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte("file"))
+	}))
+	defer ts.Close()
+	siteURL, noSiteURL := ts.URL+"/", ""
+	tests := []struct {
+		name   string
+		config *model.Config
+		hash   string
+		err    error
+	}{
+		{"valid link", &model.Config{ServiceSettings: model.ServiceSettings{SiteURL: &siteURL}}, "valid", nil},
+		{"wrong hash", &model.Config{ServiceSettings: model.ServiceSettings{SiteURL: &siteURL}}, "abc", ErrUnauthorizedUser},
+		{"no site url", &model.Config{ServiceSettings: model.ServiceSettings{SiteURL: &noSiteURL}}, "valid", errNoSiteURL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			apiMock := mockCachedPDF(&model.FileInfo{Id: "file-id", PostId: "2"})
+			apiMock.On("GetConfig").Return(tt.config)
+			auditor := &auditorMock{}
+			app := New(apiMock, newIdentifiedServer(), AuditOption(auditor))
+			pdf, err := app.GetPublicPDF(context.Background(), "file-id", tt.hash, "10.0.0.1")
+			requirePDF(t, tt.err, pdf, err)
+			require.Len(t, auditor.events, 1)
+			require.True(t, auditor.events[0].PublicLink)
+			require.Empty(t, auditor.events[0].UserID)
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	apiMock := mockCachedPDF(&model.FileInfo{Id: "file-id", PostId: "2"})
+	apiMock.On("HasPermissionToChannel", "user-id", "5", model.PERMISSION_READ_CHANNEL).Return(true)
+	apiMock.On("HasPermissionToChannel", "other-user-id", "5", model.PERMISSION_READ_CHANNEL).Return(false)
+	apiMock.On("HasPermissionTo", "other-user-id", mock.Anything).Return(false)
+	apiMock.On("GetFileInfo", "missing-id").Return(nil, &model.AppError{})
+	app := New(apiMock, newIdentifiedServer())
+	require.NoError(t, app.Authorize(context.Background(), "user-id", "file-id"))
+	require.Equal(t, ErrUnauthorizedUser, app.Authorize(context.Background(), "other-user-id", "file-id"))
+	require.Equal(t, ErrUnauthorizedUser, app.Authorize(context.Background(), "user-id", "missing-id"))
+}
+
+// mockCachedPDF creates an API where the file with fileInfo is posted to channel 5 and has a
+// cached PDF.
+func mockCachedPDF(fileInfo *model.FileInfo) *memkv.API {
+	apiMock := memkv.New()
+	apiMock.KVSet("pdf:file-id", []byte("1"))
+	apiMock.On("GetFileInfo", "file-id").Return(fileInfo, nil)
+	apiMock.On("GetPost", "2").Return(&model.Post{ChannelId: "5"}, nil)
+	apiMock.On("GetFile", "1").Return([]byte{2}, nil)
+	return apiMock
+}
+
+// newIdentifiedServer creates a PDF server that is only expected to be identified.
+func newIdentifiedServer() *sMock.Server {
+	serverMock := &sMock.Server{}
+	serverMock.On("Identity").Return("Test")
+	return serverMock
+}
+
+// requirePDF requires the cached PDF of mockCachedPDF to be served unless err is expected.
+func requirePDF(t *testing.T, expected error, pdf io.ReadCloser, err error) {
+	if expected != nil {
+		require.Equal(t, expected, err)
+		return
+	}
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(pdf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{2}, data)
+}
+
+func TestPublicLinkRateLimited(t *testing.T) {
+	require.Equal(t, "public:10.0.0.1", access{publicLinkHash: "h", clientIP: "10.0.0.1"}.limitKey())
+	require.Equal(t, "user-id", access{userID: "user-id", clientIP: "10.0.0.1"}.limitKey())
+}
+
+func TestAdmit(t *testing.T) {
+	serverMock := &sMock.Server{}
+	serverMock.On("Identity").Return("Test")
+	apiMock := memkv.New()
+	apiMock.On("GetFileInfo", "file-id").Return(&model.FileInfo{Id: "file-id", PostId: "2", Name: "3", Extension: "4"}, nil)
+	apiMock.On("GetPost", "2").Return(&model.Post{ChannelId: "5"}, nil)
+	apiMock.On("HasPermissionToChannel", "user-id", "5", model.PERMISSION_READ_CHANNEL).Twice().Return(true)
+	apiMock.On("GetFile", "file-id").Once().Return([]byte{3}, nil)
+	serverMock.On("Convert", mock.Anything, "3", "4", mock.Anything, mock.Anything).Once().
+		Return(ioutil.NopCloser(bytes.NewReader([]byte{6})), nil)
+	apiMock.On("UploadFile", []byte{6}, "5", "3.pdf").Once().Return(&model.FileInfo{Id: "7"}, nil)
+	limiter := &limiterMock{}
+	app := New(apiMock, serverMock, RateLimitOption(limiter))
+	ctx, err := app.Admit(context.Background(), "user-id", "file-id")
+	require.NoError(t, err)
+	require.Equal(t, []string{"user-id"}, limiter.users)
+
+	// admitted conversions don't take another token.
+	_, err = app.GetPDF(ctx, "user-id", "file-id", "")
+	require.NoError(t, err)
+	require.Equal(t, []string{"user-id"}, limiter.users)
+	serverMock.AssertExpectations(t)
+	apiMock.AssertExpectations(t)
+}
+
+func TestAdmitRejected(t *testing.T) {
+	apiMock := memkv.New()
+	apiMock.On("GetFileInfo", "file-id").Return(&model.FileInfo{Id: "file-id", PostId: "2"}, nil)
+	apiMock.On("GetPost", "2").Return(&model.Post{ChannelId: "5"}, nil)
+	apiMock.On("HasPermissionToChannel", "user-id", "5", model.PERMISSION_READ_CHANNEL).Once().Return(true)
+	apiMock.On("HasPermissionToChannel", "other-id", "5", model.PERMISSION_READ_CHANNEL).Once().Return(false)
+	apiMock.On("HasPermissionTo", "other-id", mock.Anything).Return(false)
+	limiter := &limiterMock{retryAfter: time.Second * 5}
+	app := New(apiMock, &sMock.Server{}, RateLimitOption(limiter))
+	_, err := app.Admit(context.Background(), "user-id", "file-id")
+	require.Equal(t, &RateLimited{RetryAfter: time.Second * 5}, err)
+
+	// unauthorized users don't take tokens.
+	_, err = app.Admit(context.Background(), "other-id", "file-id")
+	require.Equal(t, ErrUnauthorizedUser, err)
+	require.Equal(t, []string{"user-id"}, limiter.users)
+	apiMock.AssertExpectations(t)
+}
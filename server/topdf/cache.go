@@ -0,0 +1,494 @@
+package topdf
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/logger"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/pdf"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/sanitize"
+	"github.com/mattermost/mattermost-server/model"
+)
+
+const (
+	// cacheKeyVersion is the version of cache key format.
+	cacheKeyVersion = "2"
+
+	// cachePrefix used as a prefix for versioned cache keys.
+	cachePrefix = toPDFPrefix + cacheKeyVersion + ":"
+
+	// hashPrefix used as a prefix for keys of source files' content hashes.
+	hashPrefix = toPDFPrefix + "hash:"
+
+	// generationKey is the KV key of cache generation.
+	generationKey = toPDFPrefix + "generation"
+
+	// cacheKeyHashSize is the size of hash in cache keys, Mattermost limits sizes of KV keys.
+	cacheKeyHashSize = 40
+
+	// maxGenerationRetries is the number of attempts made to bump cache generation when it's
+	// concurrently bumped by other plugin instances.
+	maxGenerationRetries = 10
+
+	// listPageSize is the number of KV keys fetched at once while indexing the entries that cached
+	// before cache index existed.
+	listPageSize = 1000
+)
+
+// errGenerationConflict returned when cache generation bumped concurrently too many times.
+var errGenerationConflict = errors.New("cache generation is modified concurrently")
+
+// ErrCacheEntryNotFound returned when there is no cache entry with the requested key.
+var ErrCacheEntryNotFound = errors.New("cache entry not found")
+
+// BrokenCacheEntry returned when the PDF of a cache entry is missing or cannot be read.
+type BrokenCacheEntry struct {
+	// Key is the key of cache entry.
+	Key string
+
+	// Reason explains what is wrong with the PDF.
+	Reason string
+}
+
+func (e *BrokenCacheEntry) Error() string {
+	return fmt.Sprintf("cache entry %s is broken: %s", e.Key, e.Reason)
+}
+
+// CacheEntry is the metadata of a cached PDF.
+type CacheEntry struct {
+	// PDFFileID is the id of cached PDF file.
+	PDFFileID string `json:"pdfFileId"`
+
+	// SourceFileID is the id of file that converted to PDF.
+	SourceFileID string `json:"sourceFileId"`
+
+	// SourceHash is the SHA-256 hash of source file's content.
+	SourceHash string `json:"sourceHash"`
+
+	// Backend identifies the PDF server and its version that converted the PDF.
+	Backend string `json:"backend"`
+
+	// Options are the conversion options of PDF.
+	Options string `json:"options,omitempty"`
+
+	// Generation is the cache generation that PDF cached in.
+	Generation int64 `json:"generation"`
+
+	// Extension is the extension of source file.
+	Extension string `json:"extension,omitempty"`
+
+	// Name is the file name of PDF that derived from the name of source file, PDF is uploaded
+	// with it. entries are shared by files with the same content so it's derived from the file
+	// converted first.
+	Name string `json:"name,omitempty"`
+
+	// ChannelID is the channel that PDF is uploaded to. entries are shared by files with the same
+	// content so it's the channel of the file converted first. it's empty for verdicts of password
+	// protected files.
+	ChannelID string `json:"channelId,omitempty"`
+
+	// Size is the size of PDF in bytes.
+	Size int64 `json:"size,omitempty"`
+
+	// Sanitizer identifies the sanitizer and its settings that PDF sanitized with, it's empty
+	// when sanitizing is disabled.
+	Sanitizer string `json:"sanitizer,omitempty"`
+
+	// Sanitized reports what removed from PDF while sanitizing it.
+	Sanitized *sanitize.Report `json:"sanitized,omitempty"`
+
+	// PasswordProtected is true when source file is password protected, there is no PDF for it.
+	PasswordProtected bool `json:"passwordProtected,omitempty"`
+
+	// CreatedAt is the time when PDF cached in milliseconds since epoch.
+	CreatedAt int64 `json:"createdAt"`
+}
+
+// CachedPDF is a cache entry with its KV key.
+type CachedPDF struct {
+	// Key is the KV key of entry.
+	Key string `json:"key"`
+
+	// Legacy is true for entries cached in the old key format, they only know the ids of
+	// source and PDF files.
+	Legacy bool `json:"legacy,omitempty"`
+
+	CacheEntry
+}
+
+// cacheRef refers to the cache entry of a file for the current PDF server, options and cache generation.
+type cacheRef struct {
+	// key is the versioned cache key.
+	key string
+
+	// legacyKey is the cache key in the old format. it's empty once cache generation is bumped
+	// since old PDFs are invalidated too.
+	legacyKey string
+
+	// entry is the entry that cached with key, PDF file's id and creation time are not set.
+	entry CacheEntry
+}
+
+// cacheRefFor builds the cache ref of file with fileInfo. key of the ref is empty when the hash of
+// file's content is not known yet, which means there is no versioned cache entry for the file.
+func (t *TOPDF) cacheRefFor(fileInfo *model.FileInfo) (ref cacheRef, err error) {
+	// files never change in Mattermost so their hashes are calculated once.
+	hash, aerr := t.mapi.KVGet(hashPrefix + fileInfo.Id)
+	if aerr != nil {
+		return ref, normalizeAppErr(aerr)
+	}
+	generation, err := t.CacheGeneration()
+	if err != nil {
+		return ref, err
+	}
+	options := t.optionsFor(fileInfo.Extension)
+	ref.entry = CacheEntry{
+		SourceFileID: fileInfo.Id,
+		SourceHash:   string(hash),
+		Backend:      t.server.Identity(),
+		Options:      options.Key(),
+		Generation:   generation,
+		Extension:    fileInfo.Extension,
+		Name:         pdfName(fileInfo.Name),
+	}
+	if t.sanitizer != nil {
+		ref.entry.Sanitizer = t.sanitizer.Identity()
+	}
+	if len(hash) != 0 {
+		ref.key = ref.entry.key()
+	}
+	// PDFs cached with old format keys are not sanitized.
+	if generation == 0 && t.sanitizer == nil {
+		ref.legacyKey = key(fileInfo.Id, options)
+	}
+	return ref, nil
+}
+
+// getSource gets the content of file with fileInfo and calculates its hash to complete ref
+// when it's not known yet.
+func (t *TOPDF) getSource(ctx context.Context, fileInfo *model.FileInfo, ref *cacheRef) (source []byte, err error) {
+	start := time.Now()
+	_, span := t.tracer.Start(ctx, "topdf.getSource")
+	defer func() { span.EndWithError(err) }()
+	source, aerr := t.mapi.GetFile(fileInfo.Id)
+	if aerr != nil {
+		return nil, normalizeAppErr(aerr)
+	}
+	span.SetAttributes("bytes", len(source))
+	t.logger(ctx).Debug("source fetched", "bytes", len(source), "durationMs", logger.Millis(start))
+	if ref.key != "" {
+		return source, nil
+	}
+	sum := sha256.Sum256(source)
+	hash := hex.EncodeToString(sum[:])
+	if aerr := t.mapi.KVSet(hashPrefix+fileInfo.Id, []byte(hash)); aerr != nil {
+		return nil, normalizeAppErr(aerr)
+	}
+	ref.entry.SourceHash = hash
+	ref.key = ref.entry.key()
+	return source, nil
+}
+
+// key builds the versioned cache key of entry from its source hash, backend, options, generation
+// and sanitizer.
+func (e CacheEntry) key() string {
+	parts := []string{
+		e.SourceHash,
+		e.Backend,
+		e.Options,
+		strconv.FormatInt(e.Generation, 10),
+	}
+	// sanitizer is only included when it's set so keys of PDFs that are not sanitized don't change.
+	if e.Sanitizer != "" {
+		parts = append(parts, e.Sanitizer)
+	}
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\n")))
+	return cachePrefix + hex.EncodeToString(sum[:])[:cacheKeyHashSize]
+}
+
+// getCached gets the id of cached PDF for ref, it's empty if PDF is not cached.
+// ErrPasswordProtected is returned when source file is known to be password protected.
+func (t *TOPDF) getCached(ref cacheRef) (pid string, err error) {
+	if ref.key != "" {
+		raw, aerr := t.mapi.KVGet(ref.key)
+		if aerr != nil {
+			return "", normalizeAppErr(aerr)
+		}
+		if len(raw) != 0 {
+			var entry CacheEntry
+			if err := json.Unmarshal(raw, &entry); err != nil {
+				return "", err
+			}
+			if entry.PasswordProtected {
+				return "", ErrPasswordProtected
+			}
+			return entry.PDFFileID, nil
+		}
+	}
+	if ref.legacyKey == "" {
+		return "", nil
+	}
+	// old format keys keep the id of PDF file as is.
+	raw, aerr := t.mapi.KVGet(ref.legacyKey)
+	if aerr != nil {
+		return "", normalizeAppErr(aerr)
+	}
+	return string(raw), nil
+}
+
+// setCached caches the PDF with pdfFileID that is uploaded to channelID and has size bytes for ref.
+func (t *TOPDF) setCached(ref cacheRef, pdfFileID, channelID string, size int) error {
+	entry := ref.entry
+	entry.PDFFileID = pdfFileID
+	entry.ChannelID = channelID
+	entry.Size = int64(size)
+	entry.CreatedAt = model.GetMillis()
+	return t.setEntry(ref.key, entry)
+}
+
+// setProtected caches the verdict that source file of ref is password protected.
+func (t *TOPDF) setProtected(ref cacheRef) error {
+	entry := ref.entry
+	entry.PasswordProtected = true
+	entry.CreatedAt = model.GetMillis()
+	return t.setEntry(ref.key, entry)
+}
+
+// setEntry saves entry with key and adds it to cache index. it's indexed first so an entry
+// is never left out of listings, items of entries that cannot be saved are skipped while listing.
+func (t *TOPDF) setEntry(key string, entry CacheEntry) error {
+	if err := t.index(newIndexItem(CachedPDF{Key: key, CacheEntry: entry})); err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return normalizeAppErr(t.mapi.KVSet(key, data))
+}
+
+// CacheGeneration gets the current cache generation.
+func (t *TOPDF) CacheGeneration() (generation int64, err error) {
+	raw, aerr := t.mapi.KVGet(generationKey)
+	if aerr != nil {
+		return 0, normalizeAppErr(aerr)
+	}
+	if len(raw) == 0 {
+		return 0, nil
+	}
+	return strconv.ParseInt(string(raw), 10, 64)
+}
+
+// InvalidateCache bumps cache generation so all cached PDFs are converted again when they're
+// accessed next time. it returns the new generation.
+func (t *TOPDF) InvalidateCache() (generation int64, err error) {
+	for i := 0; i < maxGenerationRetries; i++ {
+		raw, aerr := t.mapi.KVGet(generationKey)
+		if aerr != nil {
+			return 0, normalizeAppErr(aerr)
+		}
+		var old []byte
+		if len(raw) != 0 {
+			old = raw
+			if generation, err = strconv.ParseInt(string(raw), 10, 64); err != nil {
+				return 0, err
+			}
+		}
+		generation++
+		ok, aerr := t.mapi.KVCompareAndSet(generationKey, old, []byte(strconv.FormatInt(generation, 10)))
+		if aerr != nil {
+			return 0, normalizeAppErr(aerr)
+		}
+		if ok {
+			return generation, nil
+		}
+		generation = 0
+	}
+	return 0, errGenerationConflict
+}
+
+// isCacheKey checks if key is the KV key of a cache entry in the current or old format.
+func isCacheKey(key string) bool {
+	return strings.HasPrefix(key, toPDFPrefix) &&
+		!strings.HasPrefix(key, hashPrefix) &&
+		!strings.HasPrefix(key, indexPrefix) &&
+		key != generationKey
+}
+
+// CacheFilter filters cache entries.
+// zero valued fields match with any entry.
+type CacheFilter struct {
+	// ChannelID is the channel that PDFs are uploaded to.
+	ChannelID string
+
+	// Extension is the extension of source files, it's case insensitive.
+	Extension string
+
+	// Since and Until are the time range in milliseconds since epoch that PDFs cached in.
+	Since int64
+	Until int64
+}
+
+// match checks if item matches with the filter. legacy entries don't know their channels and
+// extensions, they're considered older than any other entries since their creation times are zero.
+func (f CacheFilter) match(item indexItem) bool {
+	return (f.ChannelID == "" || f.ChannelID == item.ChannelID) &&
+		(f.Extension == "" || strings.EqualFold(f.Extension, item.Extension)) &&
+		(f.Since == 0 || item.CreatedAt >= f.Since) &&
+		(f.Until == 0 || item.CreatedAt <= f.Until)
+}
+
+// CacheTotals are the totals of cache entries.
+type CacheTotals struct {
+	// Entries is the number of entries.
+	Entries int `json:"entries"`
+
+	// Size is the total size of PDFs in bytes.
+	Size int64 `json:"size"`
+}
+
+// CacheEntries lists the page of cache entries that match with filter including the ones from old
+// cache generations and key formats, pages have perPage entries. entries are sorted from the
+// newest to oldest and they're filtered and totaled with cache index, only the entries in page
+// are read.
+func (t *TOPDF) CacheEntries(filter CacheFilter, page, perPage int) (entries []CachedPDF, totals CacheTotals, err error) {
+	items, err := t.indexItems()
+	if err != nil {
+		return nil, totals, err
+	}
+	var matched []indexItem
+	for _, item := range items {
+		if filter.match(item) {
+			matched = append(matched, item)
+			totals.Size += item.Size
+		}
+	}
+	totals.Entries = len(matched)
+	// keys are sorted too so pages of entries are stable when they're created at the same time.
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].CreatedAt != matched[j].CreatedAt {
+			return matched[i].CreatedAt > matched[j].CreatedAt
+		}
+		return matched[i].Key < matched[j].Key
+	})
+	entries = []CachedPDF{}
+	start := page * perPage
+	if start >= len(matched) {
+		return entries, totals, nil
+	}
+	end := start + perPage
+	if end > len(matched) {
+		end = len(matched)
+	}
+	for _, item := range matched[start:end] {
+		entry, err := t.GetCacheEntry(item.Key)
+		if err == ErrCacheEntryNotFound {
+			// deleted in the meantime or it couldn't be saved after it's indexed.
+			continue
+		}
+		if err != nil {
+			return nil, totals, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, totals, nil
+}
+
+// GetCacheEntry gets the cache entry with key.
+func (t *TOPDF) GetCacheEntry(key string) (entry CachedPDF, err error) {
+	if !isCacheKey(key) {
+		return entry, ErrCacheEntryNotFound
+	}
+	raw, aerr := t.mapi.KVGet(key)
+	if aerr != nil {
+		return entry, normalizeAppErr(aerr)
+	}
+	if len(raw) == 0 {
+		return entry, ErrCacheEntryNotFound
+	}
+	entry.Key = key
+	if strings.HasPrefix(key, cachePrefix) {
+		err = json.Unmarshal(raw, &entry.CacheEntry)
+		return entry, err
+	}
+	// old format keys are built from the id of source file and keep the id of PDF file as is.
+	entry.Legacy = true
+	entry.SourceFileID = strings.SplitN(strings.TrimPrefix(key, toPDFPrefix), ":", 2)[0]
+	entry.PDFFileID = string(raw)
+	return entry, nil
+}
+
+// VerifyCacheEntry checks that the PDF of cache entry with key exists and can be parsed.
+// it returns a *BrokenCacheEntry error otherwise. pages is zero for password protected files.
+func (t *TOPDF) VerifyCacheEntry(key string) (pages int, err error) {
+	entry, err := t.GetCacheEntry(key)
+	if err != nil {
+		return 0, err
+	}
+	// verdicts of password protected files have no PDFs.
+	if entry.PasswordProtected {
+		return 0, nil
+	}
+	data, aerr := t.mapi.GetFile(entry.PDFFileID)
+	if aerr != nil {
+		return 0, &BrokenCacheEntry{Key: key, Reason: "cannot get PDF file: " + aerr.Error()}
+	}
+	doc, err := pdf.Parse(data)
+	if err != nil {
+		return 0, &BrokenCacheEntry{Key: key, Reason: err.Error()}
+	}
+	if pages, err = doc.PageCount(); err != nil {
+		return 0, &BrokenCacheEntry{Key: key, Reason: err.Error()}
+	}
+	return pages, nil
+}
+
+// DeleteCacheEntry deletes the cache entry with key so its file is converted again when it's
+// accessed next time. Mattermost's Plugin API cannot delete files, the PDF file is kept.
+func (t *TOPDF) DeleteCacheEntry(key string) error {
+	if _, err := t.GetCacheEntry(key); err != nil {
+		return err
+	}
+	if aerr := t.mapi.KVDelete(key); aerr != nil {
+		return normalizeAppErr(aerr)
+	}
+	return t.unindex(key)
+}
+
+// EvictStaleCache deletes the cache entries of old cache generations, they're never served again
+// once cache generation is bumped. legacy entries are deleted too after the first bump.
+// like DeleteCacheEntry, PDF files are kept.
+func (t *TOPDF) EvictStaleCache() (evicted int, err error) {
+	generation, err := t.CacheGeneration()
+	if err != nil {
+		return 0, err
+	}
+	if generation == 0 {
+		return 0, nil
+	}
+	items, err := t.indexItems()
+	if err != nil {
+		return 0, err
+	}
+	var stale []string
+	for _, item := range items {
+		if item.Legacy || item.Generation < generation {
+			stale = append(stale, item.Key)
+		}
+	}
+	for _, key := range stale {
+		if aerr := t.mapi.KVDelete(key); aerr != nil {
+			return evicted, normalizeAppErr(aerr)
+		}
+		evicted++
+	}
+	return evicted, t.unindex(stale...)
+}
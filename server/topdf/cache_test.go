@@ -0,0 +1,361 @@
+package topdf
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/sanitize"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver"
+	sMock "github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver/mocks"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/memkv"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// sourceHash calculates the hash of source like cache keys do.
+func sourceHash(source []byte) string {
+	sum := sha256.Sum256(source)
+	return hex.EncodeToString(sum[:])
+}
+
+// requireCached requires a cache entry for fileID with source, backend, options and generation
+// and returns it.
+func requireCached(t *testing.T, api *memkv.API, fileID string, source []byte, backend, options string, generation int64) CacheEntry {
+	k := CacheEntry{SourceHash: sourceHash(source), Backend: backend, Options: options, Generation: generation}.key()
+	raw, _ := api.KVGet(k)
+	require.NotEmpty(t, raw, "no cache entry with key %s", k)
+	var entry CacheEntry
+	require.NoError(t, json.Unmarshal(raw, &entry))
+	require.Equal(t, fileID, entry.SourceFileID)
+	require.Equal(t, sourceHash(source), entry.SourceHash)
+	require.Equal(t, backend, entry.Backend)
+	require.Equal(t, options, entry.Options)
+	require.Equal(t, generation, entry.Generation)
+	require.NotZero(t, entry.CreatedAt)
+	return entry
+}
+
+// mockConvertible mocks Plugin API for a convertible file-id that belongs to user-id with source.
+func mockConvertible(api *memkv.API, source []byte) {
+	api.On("GetFileInfo", "file-id").Return(&model.FileInfo{Id: "file-id", PostId: "2", Name: "3", Extension: "docx"}, nil)
+	api.On("GetPost", "2").Return(&model.Post{ChannelId: "5"}, nil)
+	api.On("HasPermissionToChannel", "user-id", "5", model.PERMISSION_READ_CHANNEL).Return(true)
+	api.On("GetFile", "file-id").Return(source, nil)
+}
+
+func TestCacheKey(t *testing.T) {
+	entry := CacheEntry{SourceHash: sourceHash([]byte{1}), Backend: "Gotenberg", Generation: 1}
+	k := entry.key()
+	require.Len(t, k, len(cachePrefix)+cacheKeyHashSize)
+	require.True(t, len(k) <= 50)
+	for _, e := range []CacheEntry{
+		{SourceHash: sourceHash([]byte{2}), Backend: "Gotenberg", Generation: 1},
+		{SourceHash: entry.SourceHash, Backend: "Gotenberg/6.0.0", Generation: 1},
+		{SourceHash: entry.SourceHash, Backend: "Gotenberg", Options: "A4|false||", Generation: 1},
+		{SourceHash: entry.SourceHash, Backend: "Gotenberg", Generation: 2},
+	} {
+		require.NotEqual(t, k, e.key(), "%+v", e)
+	}
+	// PDF's id and creation time are not part of the key.
+	entry.PDFFileID = "1"
+	entry.CreatedAt = 1
+	require.Equal(t, k, entry.key())
+}
+
+func TestGetPDFCachedByContent(t *testing.T) {
+	serverMock := &sMock.Server{}
+	serverMock.On("Identity").Return("Test")
+	apiMock := memkv.New()
+	// another file with the same content is already converted.
+	apiMock.KVSet(hashPrefix+"file-id", []byte(sourceHash([]byte{3})))
+	entry := CacheEntry{PDFFileID: "7", SourceFileID: "other-file-id", SourceHash: sourceHash([]byte{3}), Backend: "Test"}
+	data, err := json.Marshal(entry)
+	require.NoError(t, err)
+	apiMock.KVSet(entry.key(), data)
+	apiMock.On("GetFileInfo", "file-id").Once().Return(&model.FileInfo{Id: "file-id", PostId: "2", Name: "3", Extension: "docx"}, nil)
+	apiMock.On("GetPost", "2").Once().Return(&model.Post{ChannelId: "5"}, nil)
+	apiMock.On("HasPermissionToChannel", "user-id", "5", model.PERMISSION_READ_CHANNEL).Once().Return(true)
+	apiMock.On("GetFile", "7").Once().Return([]byte{6}, nil)
+	app := New(apiMock, serverMock)
+	pdf, err := app.GetPDF(context.Background(), "user-id", "file-id", "")
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(pdf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{6}, content)
+	require.Equal(t, "3.pdf", pdf.Name)
+	serverMock.AssertExpectations(t)
+	apiMock.AssertExpectations(t)
+}
+
+func TestGetPDFFile(t *testing.T) {
+	serverMock := &sMock.Server{}
+	serverMock.On("Identity").Return("Test")
+	apiMock := memkv.New()
+	apiMock.KVSet(hashPrefix+"file-id", []byte(sourceHash([]byte{3})))
+	entry := CacheEntry{PDFFileID: "7", SourceFileID: "other-file-id", SourceHash: sourceHash([]byte{3}), Backend: "Test"}
+	data, err := json.Marshal(entry)
+	require.NoError(t, err)
+	apiMock.KVSet(entry.key(), data)
+	apiMock.On("GetFileInfo", "file-id").Once().Return(&model.FileInfo{Id: "file-id", PostId: "2", Name: "report.docx", Extension: "docx"}, nil)
+	apiMock.On("GetPost", "2").Once().Return(&model.Post{ChannelId: "5"}, nil)
+	apiMock.On("HasPermissionToChannel", "user-id", "5", model.PERMISSION_READ_CHANNEL).Once().Return(true)
+	app := New(apiMock, serverMock)
+	file, err := app.GetPDFFile(context.Background(), "user-id", "file-id")
+	require.NoError(t, err)
+	require.Equal(t, &PDFFile{ID: "7", Name: "report.pdf"}, file)
+	// content of cached PDF is not read.
+	apiMock.AssertNotCalled(t, "GetFile", mock.Anything)
+	serverMock.AssertExpectations(t)
+	apiMock.AssertExpectations(t)
+}
+
+func TestGetPDFSameContentConvertedOnce(t *testing.T) {
+	serverMock := &sMock.Server{}
+	serverMock.On("Identity").Return("Test")
+	apiMock := memkv.New()
+	for _, id := range []string{"file-a", "file-b"} {
+		apiMock.On("GetFileInfo", id).Once().Return(&model.FileInfo{Id: id, PostId: "2", Name: "3", Extension: "docx"}, nil)
+		apiMock.On("GetFile", id).Once().Return([]byte{3}, nil)
+	}
+	apiMock.On("GetPost", "2").Twice().Return(&model.Post{ChannelId: "5"}, nil)
+	apiMock.On("HasPermissionToChannel", "user-id", "5", model.PERMISSION_READ_CHANNEL).Twice().Return(true)
+	serverMock.On("Convert", mock.Anything, "3", "docx", mock.Anything, mock.Anything).Once().
+		Return(ioutil.NopCloser(bytes.NewReader([]byte{6})), nil)
+	apiMock.On("UploadFile", []byte{6}, "5", "3.pdf").Once().Return(&model.FileInfo{Id: "7"}, nil)
+	apiMock.On("GetFile", "7").Once().Return([]byte{6}, nil)
+	app := New(apiMock, serverMock)
+	for _, id := range []string{"file-a", "file-b"} {
+		pdf, err := app.GetPDF(context.Background(), "user-id", id, "")
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(pdf)
+		require.NoError(t, err)
+		require.Equal(t, []byte{6}, content)
+	}
+	// the hash of second file is only known once its content is fetched, PDF of the first one is
+	// used for it instead of converting it again.
+	require.Equal(t, "7", requireCached(t, apiMock, "file-a", []byte{3}, "Test", "", 0).PDFFileID)
+	serverMock.AssertExpectations(t)
+	apiMock.AssertExpectations(t)
+}
+
+func TestGetPDFBackendChanged(t *testing.T) {
+	serverMock := &sMock.Server{}
+	apiMock := memkv.New()
+	mockConvertible(apiMock, []byte{3})
+	serverMock.On("Convert", mock.Anything, "3", "docx", mock.Anything, mock.Anything).Return(
+		func(context.Context, string, string, io.Reader, pdfserver.ConvertOptions) io.ReadCloser {
+			return ioutil.NopCloser(bytes.NewReader([]byte{6}))
+		}, nil)
+	apiMock.On("UploadFile", []byte{6}, "5", "3.pdf").Once().Return(&model.FileInfo{Id: "7"}, nil)
+	apiMock.On("UploadFile", []byte{6}, "5", "3.pdf").Once().Return(&model.FileInfo{Id: "8"}, nil)
+
+	serverMock.On("Identity").Once().Return("Gotenberg/5.0.0")
+	_, err := New(apiMock, serverMock).GetPDF(context.Background(), "user-id", "file-id", "")
+	require.NoError(t, err)
+
+	// an upgraded PDF server converts the file again.
+	serverMock.On("Identity").Once().Return("Gotenberg/6.0.0")
+	_, err = New(apiMock, serverMock).GetPDF(context.Background(), "user-id", "file-id", "")
+	require.NoError(t, err)
+	require.Equal(t, "7", requireCached(t, apiMock, "file-id", []byte{3}, "Gotenberg/5.0.0", "", 0).PDFFileID)
+	require.Equal(t, "8", requireCached(t, apiMock, "file-id", []byte{3}, "Gotenberg/6.0.0", "", 0).PDFFileID)
+	serverMock.AssertNumberOfCalls(t, "Convert", 2)
+	apiMock.AssertExpectations(t)
+}
+
+// sanitizerMock replaces PDFs with sanitized and reports a removed script.
+type sanitizerMock struct {
+	sanitized []byte
+}
+
+func (s sanitizerMock) Sanitize(pdf []byte) ([]byte, sanitize.Report, error) {
+	return s.sanitized, sanitize.Report{JavaScript: 1}, nil
+}
+
+func (s sanitizerMock) Identity() string {
+	return "sanitizer"
+}
+
+func TestGetPDFSanitized(t *testing.T) {
+	serverMock := &sMock.Server{}
+	serverMock.On("Identity").Return("Test")
+	apiMock := memkv.New()
+	mockConvertible(apiMock, []byte{3})
+	// PDFs cached without sanitizing are not used.
+	apiMock.KVSet("pdf:file-id", []byte("1"))
+	unsanitized := CacheEntry{PDFFileID: "1", SourceFileID: "file-id", SourceHash: sourceHash([]byte{3}), Backend: "Test"}
+	data, err := json.Marshal(unsanitized)
+	require.NoError(t, err)
+	apiMock.KVSet(unsanitized.key(), data)
+	apiMock.KVSet(hashPrefix+"file-id", []byte(unsanitized.SourceHash))
+	serverMock.On("Convert", mock.Anything, "3", "docx", mock.Anything, mock.Anything).Once().Return(ioutil.NopCloser(bytes.NewReader([]byte{6})), nil)
+	apiMock.On("UploadFile", []byte{7}, "5", "3.pdf").Once().Return(&model.FileInfo{Id: "8"}, nil)
+
+	pdf, err := New(apiMock, serverMock, SanitizerOption(sanitizerMock{sanitized: []byte{7}})).GetPDF(context.Background(), "user-id", "file-id", "")
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(pdf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{7}, content)
+	k := CacheEntry{SourceHash: unsanitized.SourceHash, Backend: "Test", Sanitizer: "sanitizer"}.key()
+	require.NotEqual(t, unsanitized.key(), k)
+	raw, _ := apiMock.KVGet(k)
+	var entry CacheEntry
+	require.NoError(t, json.Unmarshal(raw, &entry))
+	require.Equal(t, "8", entry.PDFFileID)
+	require.Equal(t, "sanitizer", entry.Sanitizer)
+	require.Equal(t, &sanitize.Report{JavaScript: 1}, entry.Sanitized)
+	serverMock.AssertExpectations(t)
+	apiMock.AssertExpectations(t)
+}
+
+func TestInvalidateCache(t *testing.T) {
+	serverMock := &sMock.Server{}
+	serverMock.On("Identity").Return("Test")
+	apiMock := memkv.New()
+	mockConvertible(apiMock, []byte{3})
+	// PDF cached with the old format key in generation zero.
+	apiMock.KVSet("pdf:file-id", []byte("1"))
+	serverMock.On("Convert", mock.Anything, "3", "docx", mock.Anything, mock.Anything).Once().Return(ioutil.NopCloser(bytes.NewReader([]byte{6})), nil)
+	apiMock.On("UploadFile", []byte{6}, "5", "3.pdf").Once().Return(&model.FileInfo{Id: "7"}, nil)
+	apiMock.On("GetFile", "7").Once().Return([]byte{6}, nil)
+	app := New(apiMock, serverMock)
+
+	generation, err := app.CacheGeneration()
+	require.NoError(t, err)
+	require.Zero(t, generation)
+	generation, err = app.InvalidateCache()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), generation)
+
+	// legacy entry is not used anymore, file is converted and cached in the new generation.
+	_, err = app.GetPDF(context.Background(), "user-id", "file-id", "")
+	require.NoError(t, err)
+	requireCached(t, apiMock, "file-id", []byte{3}, "Test", "", 1)
+	_, err = app.GetPDF(context.Background(), "user-id", "file-id", "")
+	require.NoError(t, err)
+
+	generation, err = app.InvalidateCache()
+	require.NoError(t, err)
+	require.Equal(t, int64(2), generation)
+	serverMock.AssertExpectations(t)
+	apiMock.AssertExpectations(t)
+}
+
+func TestCacheEntries(t *testing.T) {
+	apiMock := memkv.New()
+	entry := CacheEntry{PDFFileID: "7", SourceFileID: "file-id", SourceHash: sourceHash([]byte{3}), Backend: "Test", Generation: 1,
+		Extension: "docx", ChannelID: "5", Size: 10, CreatedAt: 1000}
+	data, err := json.Marshal(entry)
+	require.NoError(t, err)
+	apiMock.KVSet(entry.key(), data)
+	newer := CacheEntry{PDFFileID: "9", SourceFileID: "file-id-2", SourceHash: sourceHash([]byte{4}), Backend: "Test", Generation: 1,
+		Extension: "pptx", ChannelID: "6", Size: 20, CreatedAt: 2000}
+	data, err = json.Marshal(newer)
+	require.NoError(t, err)
+	apiMock.KVSet(newer.key(), data)
+	apiMock.KVSet("pdf:legacy-id:abc", []byte("8"))
+	// hashes, generation and other plugin data are not cache entries.
+	apiMock.KVSet(hashPrefix+"file-id", []byte(entry.SourceHash))
+	apiMock.KVSet(generationKey, []byte("1"))
+	apiMock.KVSet("job:1", []byte("{}"))
+	pdfData, err := ioutil.ReadFile("../pdf/testdata/classic.pdf")
+	require.NoError(t, err)
+	apiMock.On("GetFile", "7").Return(pdfData, nil)
+	apiMock.On("GetFile", "8").Return(nil, model.NewAppError("GetFile", "", nil, "not found", 404))
+	app := New(apiMock, &sMock.Server{})
+
+	// entries are listed from the newest to oldest.
+	entries, totals, err := app.CacheEntries(CacheFilter{}, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []CachedPDF{
+		{Key: newer.key(), CacheEntry: newer},
+		{Key: entry.key(), CacheEntry: entry},
+		{Key: "pdf:legacy-id:abc", Legacy: true, CacheEntry: CacheEntry{PDFFileID: "8", SourceFileID: "legacy-id"}},
+	}, entries)
+	require.Equal(t, CacheTotals{Entries: 3, Size: 30}, totals)
+
+	// only the entries in page are read.
+	entries, totals, err = app.CacheEntries(CacheFilter{}, 1, 1)
+	require.NoError(t, err)
+	require.Equal(t, []CachedPDF{{Key: entry.key(), CacheEntry: entry}}, entries)
+	require.Equal(t, CacheTotals{Entries: 3, Size: 30}, totals)
+	entries, _, err = app.CacheEntries(CacheFilter{}, 3, 1)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+
+	for _, tt := range []struct {
+		filter CacheFilter
+		keys   []string
+	}{
+		{CacheFilter{ChannelID: "5"}, []string{entry.key()}},
+		{CacheFilter{Extension: "PPTX"}, []string{newer.key()}},
+		{CacheFilter{Since: 1000}, []string{newer.key(), entry.key()}},
+		{CacheFilter{Until: 1500}, []string{entry.key(), "pdf:legacy-id:abc"}},
+		{CacheFilter{ChannelID: "6", Until: 1500}, nil},
+	} {
+		entries, _, err := app.CacheEntries(tt.filter, 0, 10)
+		require.NoError(t, err)
+		var keys []string
+		for _, e := range entries {
+			keys = append(keys, e.Key)
+		}
+		require.Equal(t, tt.keys, keys, "%+v", tt.filter)
+	}
+
+	pages, err := app.VerifyCacheEntry(entry.key())
+	require.NoError(t, err)
+	require.NotZero(t, pages)
+	_, err = app.VerifyCacheEntry("pdf:legacy-id:abc")
+	require.IsType(t, &BrokenCacheEntry{}, err)
+	_, err = app.VerifyCacheEntry(generationKey)
+	require.Equal(t, ErrCacheEntryNotFound, err)
+
+	require.NoError(t, app.DeleteCacheEntry("pdf:legacy-id:abc"))
+	require.Equal(t, ErrCacheEntryNotFound, app.DeleteCacheEntry("pdf:legacy-id:abc"))
+	require.Equal(t, ErrCacheEntryNotFound, app.DeleteCacheEntry("job:1"))
+	entries, totals, err = app.CacheEntries(CacheFilter{}, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	require.Equal(t, 2, totals.Entries)
+}
+
+func TestEvictStaleCache(t *testing.T) {
+	apiMock := memkv.New()
+	app := New(apiMock, &sMock.Server{})
+	old := CacheEntry{PDFFileID: "7", SourceFileID: "file-id", SourceHash: sourceHash([]byte{3}), Backend: "Test", Generation: 0}
+	data, err := json.Marshal(old)
+	require.NoError(t, err)
+	apiMock.KVSet(old.key(), data)
+	apiMock.KVSet("pdf:legacy-id:abc", []byte("8"))
+	apiMock.KVSet(hashPrefix+"file-id", []byte(old.SourceHash))
+
+	// nothing is stale before cache generation is bumped.
+	evicted, err := app.EvictStaleCache()
+	require.NoError(t, err)
+	require.Zero(t, evicted)
+
+	_, err = app.InvalidateCache()
+	require.NoError(t, err)
+	current := old
+	current.Generation = 1
+	data, err = json.Marshal(current)
+	require.NoError(t, err)
+	apiMock.KVSet(current.key(), data)
+
+	evicted, err = app.EvictStaleCache()
+	require.NoError(t, err)
+	require.Equal(t, 2, evicted)
+	entries, _, err := app.CacheEntries(CacheFilter{}, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, []CachedPDF{{Key: current.key(), CacheEntry: current}}, entries)
+	// hashes are kept.
+	hash, _ := apiMock.KVGet(hashPrefix + "file-id")
+	require.Equal(t, old.SourceHash, string(hash))
+}
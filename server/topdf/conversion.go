@@ -0,0 +1,92 @@
+package topdf
+
+import (
+	"context"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xcontext"
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// conversion is an in-flight conversion of a file that shared by the requests waiting for it.
+type conversion struct {
+	// done is closed once conversion is finished with pdf that is cached as file with pid or err.
+	done chan struct{}
+	pdf  []byte
+	pid  string
+	err  error
+
+	// cancel aborts conversion while PDF server converts the file, it's nil once PDF server
+	// responds with PDF. it's guarded by TOPDF.mu.
+	cancel context.CancelFunc
+
+	// waiters is the number of requests waiting for conversion, it's guarded by TOPDF.mu.
+	waiters int
+
+	// generation is the cache generation that PDF is cached in.
+	generation int64
+}
+
+// convert converts the file with fileInfo to PDF and caches it like createAndSavePDF. concurrent
+// requests for the same file share a single conversion.
+// a request stops waiting once its ctx is canceled, the conversion is aborted when no other
+// requests wait for it. once PDF server responds with PDF, it's cached even if nobody waits
+// for it anymore since the costly part is already done.
+func (t *TOPDF) convert(ctx context.Context, fileInfo *model.FileInfo, filePost *model.Post, ref cacheRef, limits Limits) (pdf []byte, pid string, err error) {
+	c := t.joinConversion(ctx, fileInfo, filePost, ref, limits)
+	select {
+	case <-c.done:
+		return c.pdf, c.pid, c.err
+	case <-ctx.Done():
+		t.leaveConversion(fileInfo.Id, c)
+		t.logger(ctx).Info("request is canceled while waiting for conversion")
+		return nil, "", ctx.Err()
+	}
+}
+
+// joinConversion gets the in-flight conversion of file with fileInfo or starts a new one.
+// conversions are identified by file ids since the rest of cache keys, like conversion options,
+// are the same for a file in t. conversions started before cache is invalidated, possibly on
+// another node, are not joined since their PDFs are cached in the old generation.
+func (t *TOPDF) joinConversion(ctx context.Context, fileInfo *model.FileInfo, filePost *model.Post, ref cacheRef, limits Limits) *conversion {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c, ok := t.conversions[fileInfo.Id]; ok && c.generation == ref.entry.Generation {
+		c.waiters++
+		return c
+	}
+	// conversion outlives the request that started it when others still wait for it, so it's
+	// only canceled by its waiters. request scoped logger and span are kept.
+	cctx, cancel := context.WithCancel(xcontext.Detach(ctx))
+	c := &conversion{done: make(chan struct{}), cancel: cancel, waiters: 1, generation: ref.entry.Generation}
+	t.conversions[fileInfo.Id] = c
+	go func() {
+		defer cancel()
+		converted := func() {
+			t.mu.Lock()
+			c.cancel = nil
+			t.mu.Unlock()
+		}
+		c.pdf, c.pid, c.err = t.createAndSavePDF(cctx, fileInfo, filePost, &ref, limits, converted)
+		t.mu.Lock()
+		if t.conversions[fileInfo.Id] == c {
+			delete(t.conversions, fileInfo.Id)
+		}
+		t.mu.Unlock()
+		close(c.done)
+	}()
+	return c
+}
+
+// leaveConversion stops waiting for c of file with fileID and cancels it if there are no waiters
+// left and PDF server is still converting the file. requests made after that start a new
+// conversion.
+func (t *TOPDF) leaveConversion(fileID string, c *conversion) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c.waiters--; c.waiters == 0 && c.cancel != nil {
+		c.cancel()
+		if t.conversions[fileID] == c {
+			delete(t.conversions, fileID)
+		}
+	}
+}
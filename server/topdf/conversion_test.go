@@ -0,0 +1,193 @@
+package topdf
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver"
+	sMock "github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver/mocks"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/memkv"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// blockConvert makes serverMock block conversions until release is closed or their context is
+// canceled, contexts of conversions are sent to started.
+func blockConvert(serverMock *sMock.Server, started chan<- context.Context, release <-chan struct{}) {
+	serverMock.On("Convert", mock.Anything, "3", "docx", mock.Anything, mock.Anything).
+		Run(func(args mock.Arguments) {
+			convertCtx := args.Get(0).(context.Context)
+			started <- convertCtx
+			select {
+			case <-release:
+			case <-convertCtx.Done():
+			}
+		}).
+		Return(func(ctx context.Context, _, _ string, _ io.Reader, _ pdfserver.ConvertOptions) io.ReadCloser {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return ioutil.NopCloser(bytes.NewReader([]byte{6}))
+		}, func(ctx context.Context, _, _ string, _ io.Reader, _ pdfserver.ConvertOptions) error {
+			return ctx.Err()
+		})
+}
+
+func TestGetPDFCanceled(t *testing.T) {
+	serverMock := &sMock.Server{}
+	serverMock.On("Identity").Return("Test")
+	apiMock := memkv.New()
+	mockConvertible(apiMock, []byte{3})
+	started := make(chan context.Context, 1)
+	blockConvert(serverMock, started, nil)
+	app := New(apiMock, serverMock)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := app.GetPDF(ctx, "user-id", "file-id", "")
+		done <- err
+	}()
+	convertCtx := <-started
+	cancel()
+	require.Equal(t, context.Canceled, <-done)
+	// nobody else waits for the conversion so it's aborted.
+	select {
+	case <-convertCtx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("conversion is not canceled")
+	}
+	apiMock.AssertNotCalled(t, "UploadFile", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestGetPDFSharedConversion(t *testing.T) {
+	serverMock := &sMock.Server{}
+	serverMock.On("Identity").Return("Test")
+	apiMock := memkv.New()
+	mockConvertible(apiMock, []byte{3})
+	apiMock.On("UploadFile", []byte{6}, "5", "3.pdf").Once().Return(&model.FileInfo{Id: "7"}, nil)
+	started := make(chan context.Context, 2)
+	release := make(chan struct{})
+	blockConvert(serverMock, started, release)
+	app := New(apiMock, serverMock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := app.GetPDF(ctx, "user-id", "file-id", "")
+		first <- err
+	}()
+	convertCtx := <-started
+	second := make(chan []byte)
+	go func() {
+		pdf, err := app.GetPDF(context.Background(), "user-id", "file-id", "")
+		if err != nil {
+			second <- nil
+			return
+		}
+		data, _ := ioutil.ReadAll(pdf)
+		second <- data
+	}()
+	// wait for the second request to join the conversion.
+	for waiters := 0; waiters != 2; time.Sleep(time.Millisecond) {
+		app.mu.Lock()
+		if c := app.conversions["file-id"]; c != nil {
+			waiters = c.waiters
+		}
+		app.mu.Unlock()
+	}
+
+	// the conversion goes on for the second request when the first one is canceled.
+	cancel()
+	require.Equal(t, context.Canceled, <-first)
+	require.NoError(t, convertCtx.Err())
+	close(release)
+	require.Equal(t, []byte{6}, <-second)
+	serverMock.AssertNumberOfCalls(t, "Convert", 1)
+	require.Equal(t, "7", requireCached(t, apiMock, "file-id", []byte{3}, "Test", "", 0).PDFFileID)
+	apiMock.AssertExpectations(t)
+}
+
+func TestGetPDFInvalidatedDuringConversion(t *testing.T) {
+	serverMock := &sMock.Server{}
+	serverMock.On("Identity").Return("Test")
+	apiMock := memkv.New()
+	mockConvertible(apiMock, []byte{3})
+	apiMock.On("UploadFile", []byte{6}, "5", "3.pdf").Once().Return(&model.FileInfo{Id: "7"}, nil)
+	apiMock.On("UploadFile", []byte{6}, "5", "3.pdf").Once().Return(&model.FileInfo{Id: "8"}, nil)
+	started := make(chan context.Context, 2)
+	release := make(chan struct{})
+	blockConvert(serverMock, started, release)
+	app := New(apiMock, serverMock)
+
+	first := make(chan error)
+	go func() {
+		_, err := app.GetPDF(context.Background(), "user-id", "file-id", "")
+		first <- err
+	}()
+	<-started
+	_, err := app.InvalidateCache()
+	require.NoError(t, err)
+
+	// requests made after invalidation don't join the conversion of the old generation.
+	second := make(chan error)
+	go func() {
+		_, err := app.GetPDF(context.Background(), "user-id", "file-id", "")
+		second <- err
+	}()
+	<-started
+	close(release)
+	require.NoError(t, <-first)
+	require.NoError(t, <-second)
+	serverMock.AssertNumberOfCalls(t, "Convert", 2)
+	requireCached(t, apiMock, "file-id", []byte{3}, "Test", "", 0)
+	requireCached(t, apiMock, "file-id", []byte{3}, "Test", "", 1)
+	apiMock.AssertExpectations(t)
+}
+
+func TestGetPDFCanceledAfterConverted(t *testing.T) {
+	serverMock := &sMock.Server{}
+	serverMock.On("Identity").Return("Test")
+	apiMock := memkv.New()
+	mockConvertible(apiMock, []byte{3})
+	apiMock.On("UploadFile", []byte{6}, "5", "3.pdf").Once().Return(&model.FileInfo{Id: "7"}, nil)
+	pr, pw := io.Pipe()
+	var convertCtx context.Context
+	serverMock.On("Convert", mock.Anything, "3", "docx", mock.Anything, mock.Anything).Once().
+		Run(func(args mock.Arguments) { convertCtx = args.Get(0).(context.Context) }).
+		Return(pr, nil)
+	app := New(apiMock, serverMock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := app.GetPDF(ctx, "user-id", "file-id", "")
+		done <- err
+	}()
+	// wait for PDF server to respond.
+	for converting := true; converting; time.Sleep(time.Millisecond) {
+		app.mu.Lock()
+		if c := app.conversions["file-id"]; c != nil {
+			converting = c.cancel != nil
+		}
+		app.mu.Unlock()
+	}
+
+	// PDF is still read and cached after the only request waiting for it is canceled.
+	cancel()
+	require.Equal(t, context.Canceled, <-done)
+	require.NoError(t, convertCtx.Err())
+	pw.Write([]byte{6})
+	pw.Close()
+	for cached := false; !cached; time.Sleep(time.Millisecond) {
+		app.mu.Lock()
+		cached = app.conversions["file-id"] == nil
+		app.mu.Unlock()
+	}
+	require.Equal(t, "7", requireCached(t, apiMock, "file-id", []byte{3}, "Test", "", 0).PDFFileID)
+	apiMock.AssertExpectations(t)
+}
@@ -0,0 +1,330 @@
+package topdf
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	// indexPrefix used as a prefix for keys of cache index.
+	indexPrefix = toPDFPrefix + "index:"
+
+	// indexMetaKey is the KV key of cache index's metadata.
+	indexMetaKey = indexPrefix + "meta"
+
+	// indexBucketSize is the number of items kept in each bucket of cache index.
+	indexBucketSize = 500
+
+	// maxIndexRetries is the number of attempts made to modify cache index when it's
+	// concurrently modified by other plugin instances.
+	maxIndexRetries = 10
+)
+
+// errIndexConflict returned when cache index modified concurrently too many times.
+var errIndexConflict = errors.New("cache index is modified concurrently")
+
+// indexItem is the summary of a cache entry in cache index, it keeps what's needed to filter and
+// total cache entries without reading them.
+type indexItem struct {
+	Key        string `json:"k"`
+	CreatedAt  int64  `json:"t,omitempty"`
+	ChannelID  string `json:"c,omitempty"`
+	Extension  string `json:"e,omitempty"`
+	Size       int64  `json:"s,omitempty"`
+	Generation int64  `json:"g,omitempty"`
+	Legacy     bool   `json:"l,omitempty"`
+}
+
+// newIndexItem creates the index item of entry.
+func newIndexItem(entry CachedPDF) indexItem {
+	return indexItem{
+		Key:        entry.Key,
+		CreatedAt:  entry.CreatedAt,
+		ChannelID:  entry.ChannelID,
+		Extension:  entry.Extension,
+		Size:       entry.Size,
+		Generation: entry.Generation,
+		Legacy:     entry.Legacy,
+	}
+}
+
+// entry converts item to a cache entry that only has the fields of item.
+func (i indexItem) entry() CachedPDF {
+	return CachedPDF{
+		Key:    i.Key,
+		Legacy: i.Legacy,
+		CacheEntry: CacheEntry{
+			CreatedAt:  i.CreatedAt,
+			ChannelID:  i.ChannelID,
+			Extension:  i.Extension,
+			Size:       i.Size,
+			Generation: i.Generation,
+		},
+	}
+}
+
+// indexMeta keeps the range of buckets that currently exist in cache index.
+type indexMeta struct {
+	// Head is the bucket that new items are added to.
+	Head int `json:"head"`
+
+	// Tail is the oldest bucket that might have items.
+	Tail int `json:"tail"`
+
+	// Built is true once the entries cached before cache index existed are added to it.
+	Built bool `json:"built,omitempty"`
+}
+
+// index adds items to cache index. items are appended to the head bucket and a new bucket is
+// started once it's full.
+func (t *TOPDF) index(items ...indexItem) error {
+	for conflicts := 0; len(items) > 0; {
+		n, err := t.appendIndex(items)
+		if err == errIndexConflict {
+			if conflicts++; conflicts >= maxIndexRetries {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		items, conflicts = items[n:], 0
+	}
+	return nil
+}
+
+// appendIndex tries to append items to the head bucket once and returns the number of appended ones.
+func (t *TOPDF) appendIndex(items []indexItem) (n int, err error) {
+	m, rawMeta, err := t.indexMeta()
+	if err != nil {
+		return 0, err
+	}
+	bucket, rawBucket, err := t.indexBucket(m.Head)
+	if err != nil {
+		return 0, err
+	}
+	if len(bucket) >= indexBucketSize {
+		next := m
+		next.Head++
+		if err := t.compareAndSetIndex(indexMetaKey, rawMeta, next); err != nil {
+			return 0, err
+		}
+		// retry to append items to the new head bucket.
+		return 0, errIndexConflict
+	}
+	n = indexBucketSize - len(bucket)
+	if n > len(items) {
+		n = len(items)
+	}
+	return n, t.compareAndSetIndex(indexBucketKey(m.Head), rawBucket, append(bucket, items[:n]...))
+}
+
+// unindex removes the items with keys from cache index.
+func (t *TOPDF) unindex(keys ...string) error {
+	remove := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		remove[key] = true
+	}
+	m, _, err := t.indexMeta()
+	if err != nil {
+		return err
+	}
+	for b := m.Tail; b <= m.Head; b++ {
+		err := errIndexConflict
+		for i := 0; i < maxIndexRetries && err == errIndexConflict; i++ {
+			err = t.unindexBucket(b, remove)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return t.trimIndex()
+}
+
+// unindexBucket tries to remove the items with keys in remove from bucket b once.
+func (t *TOPDF) unindexBucket(b int, remove map[string]bool) error {
+	bucket, raw, err := t.indexBucket(b)
+	if err != nil {
+		return err
+	}
+	kept := []indexItem{}
+	for _, item := range bucket {
+		if !remove[item.Key] {
+			kept = append(kept, item)
+		}
+	}
+	if len(kept) == len(bucket) {
+		return nil
+	}
+	return t.compareAndSetIndex(indexBucketKey(b), raw, kept)
+}
+
+// trimIndex drops the empty buckets at the tail of cache index so they're not read again.
+func (t *TOPDF) trimIndex() error {
+	for i := 0; i < maxIndexRetries; i++ {
+		m, rawMeta, err := t.indexMeta()
+		if err != nil {
+			return err
+		}
+		next := m
+		for ; next.Tail < next.Head; next.Tail++ {
+			bucket, _, err := t.indexBucket(next.Tail)
+			if err != nil {
+				return err
+			}
+			if len(bucket) != 0 {
+				break
+			}
+		}
+		if next.Tail == m.Tail {
+			return nil
+		}
+		err = t.compareAndSetIndex(indexMetaKey, rawMeta, next)
+		if err == errIndexConflict {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for b := m.Tail; b < next.Tail; b++ {
+			if aerr := t.mapi.KVDelete(indexBucketKey(b)); aerr != nil {
+				return normalizeAppErr(aerr)
+			}
+		}
+		return nil
+	}
+	return errIndexConflict
+}
+
+// indexItems gets all items in cache index, only the latest item of a key is kept when it's
+// indexed more than once. entries cached before cache index existed are indexed first.
+func (t *TOPDF) indexItems() ([]indexItem, error) {
+	m, _, err := t.indexMeta()
+	if err != nil {
+		return nil, err
+	}
+	if !m.Built {
+		if err := t.buildIndex(); err != nil {
+			return nil, err
+		}
+		if m, _, err = t.indexMeta(); err != nil {
+			return nil, err
+		}
+	}
+	var items []indexItem
+	seen := make(map[string]int)
+	for b := m.Tail; b <= m.Head; b++ {
+		bucket, _, err := t.indexBucket(b)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range bucket {
+			if i, ok := seen[item.Key]; ok {
+				if item.CreatedAt >= items[i].CreatedAt {
+					items[i] = item
+				}
+				continue
+			}
+			seen[item.Key] = len(items)
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+// buildIndex indexes the entries that cached before cache index existed. it lists all KV keys
+// of plugin so it's only done once, entries cached in the meantime might be indexed twice.
+func (t *TOPDF) buildIndex() error {
+	var items []indexItem
+	for page := 0; ; page++ {
+		keys, aerr := t.mapi.KVList(page, listPageSize)
+		if aerr != nil {
+			return normalizeAppErr(aerr)
+		}
+		for _, key := range keys {
+			if !isCacheKey(key) {
+				continue
+			}
+			entry, err := t.GetCacheEntry(key)
+			if err == ErrCacheEntryNotFound {
+				// deleted in the meantime.
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			items = append(items, newIndexItem(entry))
+		}
+		if len(keys) < listPageSize {
+			break
+		}
+	}
+	if err := t.index(items...); err != nil {
+		return err
+	}
+	for i := 0; i < maxIndexRetries; i++ {
+		m, rawMeta, err := t.indexMeta()
+		if err != nil || m.Built {
+			return err
+		}
+		m.Built = true
+		if err := t.compareAndSetIndex(indexMetaKey, rawMeta, m); err != errIndexConflict {
+			return err
+		}
+	}
+	return errIndexConflict
+}
+
+// indexMeta gets cache index's metadata together with its raw value.
+func (t *TOPDF) indexMeta() (m indexMeta, raw []byte, err error) {
+	raw, aerr := t.mapi.KVGet(indexMetaKey)
+	if aerr != nil {
+		return m, nil, normalizeAppErr(aerr)
+	}
+	if len(raw) == 0 {
+		return m, nil, nil
+	}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return m, nil, err
+	}
+	return m, raw, nil
+}
+
+// indexBucket gets the items in bucket b of cache index together with its raw value.
+func (t *TOPDF) indexBucket(b int) (items []indexItem, raw []byte, err error) {
+	raw, aerr := t.mapi.KVGet(indexBucketKey(b))
+	if aerr != nil {
+		return nil, nil, normalizeAppErr(aerr)
+	}
+	if len(raw) == 0 {
+		return nil, nil, nil
+	}
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, nil, err
+	}
+	return items, raw, nil
+}
+
+// compareAndSetIndex sets key to v in JSON when its current value is old.
+// errIndexConflict is returned when current value is changed.
+func (t *TOPDF) compareAndSetIndex(key string, old []byte, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	ok, aerr := t.mapi.KVCompareAndSet(key, old, data)
+	if aerr != nil {
+		return normalizeAppErr(aerr)
+	}
+	if !ok {
+		return errIndexConflict
+	}
+	return nil
+}
+
+// indexBucketKey builds the KV key of bucket b of cache index.
+func indexBucketKey(b int) string {
+	return fmt.Sprintf("%sbucket:%d", indexPrefix, b)
+}
@@ -0,0 +1,63 @@
+package topdf
+
+import (
+	"fmt"
+	"testing"
+
+	sMock "github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver/mocks"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/memkv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheIndex(t *testing.T) {
+	api := memkv.New()
+	app := New(api, &sMock.Server{})
+	_, totals, err := app.CacheEntries(CacheFilter{}, 0, 10)
+	require.NoError(t, err)
+	require.Zero(t, totals.Entries)
+
+	total := indexBucketSize + 5
+	for i := 0; i < total; i++ {
+		ref := cacheRef{key: fmt.Sprintf("%s%d", cachePrefix, i), entry: CacheEntry{Extension: "docx"}}
+		require.NoError(t, app.setCached(ref, fmt.Sprint(i), "c", 10))
+	}
+	// entries are indexed into a new bucket once the head one is full.
+	m, _, err := app.indexMeta()
+	require.NoError(t, err)
+	require.Equal(t, indexMeta{Head: 1, Built: true}, m)
+	entries, totals, err := app.CacheEntries(CacheFilter{Extension: "DOCX"}, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 10)
+	require.Equal(t, CacheTotals{Entries: total, Size: int64(total) * 10}, totals)
+
+	// cache index is not rebuilt from all keys once it's built.
+	api.KVSet(cachePrefix+"unindexed", []byte("{}"))
+	_, totals, err = app.CacheEntries(CacheFilter{}, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, total, totals.Entries)
+
+	// buckets are dropped once all of their entries are deleted.
+	for i := 0; i < indexBucketSize; i++ {
+		require.NoError(t, app.DeleteCacheEntry(fmt.Sprintf("%s%d", cachePrefix, i)))
+	}
+	m, _, err = app.indexMeta()
+	require.NoError(t, err)
+	require.Equal(t, indexMeta{Head: 1, Tail: 1, Built: true}, m)
+	raw, _ := api.KVGet(indexBucketKey(0))
+	require.Empty(t, raw)
+	entries, totals, err = app.CacheEntries(CacheFilter{}, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, entries, 5)
+	require.Equal(t, 5, totals.Entries)
+}
+
+func TestCacheIndexReindexed(t *testing.T) {
+	app := New(memkv.New(), &sMock.Server{})
+	ref := cacheRef{key: cachePrefix + "a", entry: CacheEntry{CreatedAt: 1}}
+	require.NoError(t, app.index(newIndexItem(CachedPDF{Key: ref.key, CacheEntry: CacheEntry{Size: 5, CreatedAt: 1}})))
+	// an entry that cached again by another node is only counted once.
+	require.NoError(t, app.setCached(ref, "1", "c", 10))
+	_, totals, err := app.CacheEntries(CacheFilter{}, 0, 10)
+	require.NoError(t, err)
+	require.Equal(t, CacheTotals{Entries: 1, Size: 10}, totals)
+}
@@ -0,0 +1,113 @@
+package topdf
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/pdf"
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// Codes of exceeded limits.
+const (
+	// CodeSourceTooLarge is the code of errors returned when a file is too large to be converted.
+	CodeSourceTooLarge = "source_too_large"
+
+	// CodePDFTooLarge is the code of errors returned when a converted PDF is too large.
+	CodePDFTooLarge = "pdf_too_large"
+
+	// CodeTooManyPages is the code of errors returned when a converted PDF has too many pages.
+	CodeTooManyPages = "too_many_pages"
+)
+
+// LimitExceeded error returned when a file or its PDF version exceeds a conversion limit.
+type LimitExceeded struct {
+	// Code identifies the exceeded limit.
+	Code string
+
+	// Limit is the value of exceeded limit.
+	Limit int64
+}
+
+func (e *LimitExceeded) Error() string {
+	switch e.Code {
+	case CodeSourceTooLarge:
+		return fmt.Sprintf("file is larger than the maximum convertible size of %d bytes", e.Limit)
+	case CodePDFTooLarge:
+		return fmt.Sprintf("converted pdf is larger than the maximum size of %d bytes", e.Limit)
+	case CodeTooManyPages:
+		return fmt.Sprintf("converted pdf has more than the maximum of %d pages", e.Limit)
+	}
+	return fmt.Sprintf("conversion limit %s exceeded", e.Code)
+}
+
+// Limits are the limits of conversions. zero values are unlimited.
+type Limits struct {
+	// MaxSourceSize is the maximum size of files in bytes.
+	MaxSourceSize int64
+
+	// MaxPDFSize is the maximum size of converted PDFs in bytes.
+	MaxPDFSize int64
+
+	// MaxPages is the maximum number of pages of converted PDFs.
+	MaxPages int
+}
+
+// checkSource checks if the file with fileInfo is within the limits.
+func (l Limits) checkSource(fileInfo *model.FileInfo) error {
+	if l.MaxSourceSize > 0 && fileInfo.Size > l.MaxSourceSize {
+		return &LimitExceeded{Code: CodeSourceTooLarge, Limit: l.MaxSourceSize}
+	}
+	return nil
+}
+
+// readPDF reads a converted PDF from r and checks if it's within the limits.
+func (l Limits) readPDF(r io.Reader) (data []byte, err error) {
+	if l.MaxPDFSize > 0 {
+		// read one more byte than the limit to know if PDF exceeds it without reading all of it.
+		r = io.LimitReader(r, l.MaxPDFSize+1)
+	}
+	data, err = ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if l.MaxPDFSize > 0 && int64(len(data)) > l.MaxPDFSize {
+		return nil, &LimitExceeded{Code: CodePDFTooLarge, Limit: l.MaxPDFSize}
+	}
+	if l.MaxPages > 0 {
+		doc, err := pdf.Parse(data)
+		if err != nil {
+			return nil, err
+		}
+		count, err := doc.PageCount()
+		if err != nil {
+			return nil, err
+		}
+		if count > l.MaxPages {
+			return nil, &LimitExceeded{Code: CodeTooManyPages, Limit: int64(l.MaxPages)}
+		}
+	}
+	return data, nil
+}
+
+// LimitsOption sets conversion limits. defaults are applied to all files and perExtension
+// replaces them for files with a given extension.
+func LimitsOption(defaults Limits, perExtension map[string]Limits) Option {
+	return func(t *TOPDF) {
+		t.limits = defaults
+		t.extensionLimits = make(map[string]Limits, len(perExtension))
+		for ext, l := range perExtension {
+			t.extensionLimits[strings.ToLower(ext)] = l
+		}
+	}
+}
+
+// limitsFor gets conversion limits for files with extension.
+func (t *TOPDF) limitsFor(extension string) Limits {
+	if l, ok := t.extensionLimits[strings.ToLower(extension)]; ok {
+		return l
+	}
+	return t.limits
+}
@@ -0,0 +1,53 @@
+package topdf
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	sMock "github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver/mocks"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/memkv"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetPDFSourceTooLarge(t *testing.T) {
+	serverMock := &sMock.Server{}
+	serverMock.On("Identity").Return("Test")
+	apiMock := memkv.New()
+	apiMock.On("GetFileInfo", "file-id").Once().Return(&model.FileInfo{Id: "file-id", PostId: "2", Extension: "XLSX", Size: 11}, nil)
+	apiMock.On("GetPost", "2").Once().Return(&model.Post{ChannelId: "5"}, nil)
+	apiMock.On("HasPermissionToChannel", "user-id", "5", model.PERMISSION_READ_CHANNEL).Once().Return(true)
+	app := New(apiMock, serverMock, LimitsOption(Limits{MaxSourceSize: 100}, map[string]Limits{"xlsx": {MaxSourceSize: 10}}))
+	_, err := app.GetPDF(context.Background(), "user-id", "file-id", "")
+	require.Equal(t, &LimitExceeded{Code: CodeSourceTooLarge, Limit: 10}, err)
+	require.Equal(t, "file is larger than the maximum convertible size of 10 bytes", err.Error())
+	// file's content is never fetched.
+	apiMock.AssertExpectations(t)
+	serverMock.AssertExpectations(t)
+}
+
+func TestLimitsFor(t *testing.T) {
+	app := New(nil, nil, LimitsOption(Limits{MaxSourceSize: 100, MaxPages: 5}, map[string]Limits{"XLS": {MaxPages: 2}}))
+	require.Equal(t, Limits{MaxPages: 2}, app.limitsFor("xls"))
+	require.Equal(t, Limits{MaxSourceSize: 100, MaxPages: 5}, app.limitsFor("docx"))
+}
+
+func TestReadPDFLimits(t *testing.T) {
+	data, err := ioutil.ReadFile("../pdf/testdata/classic.pdf")
+	require.NoError(t, err)
+
+	read, err := Limits{}.readPDF(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, data, read)
+
+	_, err = Limits{MaxPDFSize: 100}.readPDF(bytes.NewReader(data))
+	require.Equal(t, &LimitExceeded{Code: CodePDFTooLarge, Limit: 100}, err)
+
+	_, err = Limits{MaxPages: 1}.readPDF(bytes.NewReader(data))
+	require.Equal(t, &LimitExceeded{Code: CodeTooManyPages, Limit: 1}, err)
+
+	_, err = Limits{MaxPages: 2}.readPDF(bytes.NewReader(data))
+	require.NoError(t, err)
+}
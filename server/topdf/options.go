@@ -0,0 +1,45 @@
+package topdf
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver"
+)
+
+// optionsHashSize is the size of options hash in cache keys, Mattermost limits sizes of KV keys
+// so options are not directly included.
+const optionsHashSize = 12
+
+// ConvertOptionsOption sets conversion options. defaults are used for all files and perExtension
+// replaces them for files with a given extension.
+func ConvertOptionsOption(defaults pdfserver.ConvertOptions, perExtension map[string]pdfserver.ConvertOptions) Option {
+	return func(t *TOPDF) {
+		t.options = defaults
+		t.extensionOptions = make(map[string]pdfserver.ConvertOptions, len(perExtension))
+		for ext, o := range perExtension {
+			t.extensionOptions[strings.ToLower(ext)] = o
+		}
+	}
+}
+
+// optionsFor gets conversion options for files with extension.
+func (t *TOPDF) optionsFor(extension string) pdfserver.ConvertOptions {
+	if o, ok := t.extensionOptions[strings.ToLower(extension)]; ok {
+		return o
+	}
+	return t.options
+}
+
+// key builds the old format KV key for PDF of fileID that converted with options, it's only
+// read to keep using PDFs cached before versioned cache keys.
+// PDFs converted with default options keep using plain keys of fileIDs.
+func key(fileID string, options pdfserver.ConvertOptions) string {
+	k := options.Key()
+	if k == "" {
+		return toPDFPrefix + fileID
+	}
+	sum := sha256.Sum256([]byte(k))
+	return toPDFPrefix + fileID + ":" + hex.EncodeToString(sum[:])[:optionsHashSize]
+}
@@ -0,0 +1,53 @@
+package topdf
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver"
+	sMock "github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver/mocks"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/memkv"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOptionsFor(t *testing.T) {
+	defaults := pdfserver.ConvertOptions{Format: pdfserver.FormatPDFA2b}
+	landscape := pdfserver.ConvertOptions{Landscape: true}
+	app := New(nil, nil, ConvertOptionsOption(defaults, map[string]pdfserver.ConvertOptions{"XLSX": landscape}))
+	require.Equal(t, landscape, app.optionsFor("xlsx"))
+	require.Equal(t, defaults, app.optionsFor("docx"))
+}
+
+func TestKey(t *testing.T) {
+	require.Equal(t, "pdf:file-id", key("file-id", pdfserver.ConvertOptions{}))
+	landscape := key("file-id", pdfserver.ConvertOptions{Landscape: true})
+	require.Equal(t, "pdf:file-id:", landscape[:len("pdf:file-id:")])
+	require.Len(t, landscape, len("pdf:file-id:")+optionsHashSize)
+	require.NotEqual(t, landscape, key("file-id", pdfserver.ConvertOptions{PageRanges: "1"}))
+}
+
+func TestGetPDFWithOptions(t *testing.T) {
+	serverMock := &sMock.Server{}
+	serverMock.On("Identity").Return("Test")
+	apiMock := memkv.New()
+	options := pdfserver.ConvertOptions{Landscape: true}
+	// PDF cached with default options is not used.
+	apiMock.KVSet("pdf:file-id", []byte("1"))
+	apiMock.On("GetFileInfo", "file-id").Once().Return(&model.FileInfo{Id: "file-id", PostId: "2", Name: "3", Extension: "xlsx"}, nil)
+	apiMock.On("GetPost", "2").Once().Return(&model.Post{ChannelId: "5"}, nil)
+	apiMock.On("HasPermissionToChannel", "user-id", "5", model.PERMISSION_READ_CHANNEL).Once().Return(true)
+	apiMock.On("GetFile", "file-id").Once().Return([]byte{3}, nil)
+	serverMock.On("Convert", mock.Anything, "3", "xlsx", bytes.NewReader([]byte{3}), options).Once().Return(ioutil.NopCloser(bytes.NewReader([]byte{6})), nil)
+	apiMock.On("UploadFile", []byte{6}, "5", "3.pdf").Once().Return(&model.FileInfo{Id: "7"}, nil)
+	app := New(apiMock, serverMock, ConvertOptionsOption(pdfserver.ConvertOptions{}, map[string]pdfserver.ConvertOptions{"xlsx": options}))
+	_, err := app.GetPDF(context.Background(), "user-id", "file-id", "")
+	require.NoError(t, err)
+	entry := requireCached(t, apiMock, "file-id", []byte{3}, "Test", options.Key(), 0)
+	require.Equal(t, "7", entry.PDFFileID)
+	serverMock.AssertExpectations(t)
+	apiMock.AssertExpectations(t)
+}
@@ -4,21 +4,23 @@
 
 package mocks
 
+import context "context"
 import io "io"
 import mock "github.com/stretchr/testify/mock"
+import pdfserver "github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver"
 
 // Server is an autogenerated mock type for the Server type
 type Server struct {
 	mock.Mock
 }
 
-// Convert provides a mock function with given fields: name, extension, file
-func (_m *Server) Convert(name string, extension string, file io.Reader) (io.ReadCloser, error) {
-	ret := _m.Called(name, extension, file)
+// Convert provides a mock function with given fields: ctx, name, extension, file, options
+func (_m *Server) Convert(ctx context.Context, name string, extension string, file io.Reader, options pdfserver.ConvertOptions) (io.ReadCloser, error) {
+	ret := _m.Called(ctx, name, extension, file, options)
 
 	var r0 io.ReadCloser
-	if rf, ok := ret.Get(0).(func(string, string, io.Reader) io.ReadCloser); ok {
-		r0 = rf(name, extension, file)
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, io.Reader, pdfserver.ConvertOptions) io.ReadCloser); ok {
+		r0 = rf(ctx, name, extension, file, options)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).(io.ReadCloser)
@@ -26,8 +28,8 @@ func (_m *Server) Convert(name string, extension string, file io.Reader) (io.Rea
 	}
 
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, string, io.Reader) error); ok {
-		r1 = rf(name, extension, file)
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, io.Reader, pdfserver.ConvertOptions) error); ok {
+		r1 = rf(ctx, name, extension, file, options)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -35,13 +37,27 @@ func (_m *Server) Convert(name string, extension string, file io.Reader) (io.Rea
 	return r0, r1
 }
 
-// Status provides a mock function with given fields:
-func (_m *Server) Status() error {
+// Identity provides a mock function with given fields:
+func (_m *Server) Identity() string {
 	ret := _m.Called()
 
-	var r0 error
-	if rf, ok := ret.Get(0).(func() error); ok {
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
 		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	return r0
+}
+
+// Status provides a mock function with given fields: ctx
+func (_m *Server) Status(ctx context.Context) error {
+	ret := _m.Called(ctx)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(ctx)
 	} else {
 		r0 = ret.Error(0)
 	}
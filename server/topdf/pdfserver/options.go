@@ -0,0 +1,68 @@
+package pdfserver
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PDFFormat is the format of PDFs.
+type PDFFormat string
+
+// PDF formats.
+const (
+	// FormatDefault is the regular PDF format.
+	FormatDefault PDFFormat = ""
+
+	// FormatPDFA1a is the PDF/A-1a archiving format.
+	FormatPDFA1a PDFFormat = "PDF/A-1a"
+
+	// FormatPDFA2b is the PDF/A-2b archiving format.
+	FormatPDFA2b PDFFormat = "PDF/A-2b"
+
+	// FormatPDFA3b is the PDF/A-3b archiving format.
+	FormatPDFA3b PDFFormat = "PDF/A-3b"
+)
+
+// pageRangesPattern matches page ranges like `1-3,5`.
+var pageRangesPattern = regexp.MustCompile(`^[0-9]+(-[0-9]+)?(,[0-9]+(-[0-9]+)?)*$`)
+
+// ConvertOptions are the options of conversions, zero value converts with defaults of PDF server.
+// paper sizes are not among them since Office documents are always converted with their own.
+type ConvertOptions struct {
+	// Landscape sets landscape orientation for pages.
+	Landscape bool
+
+	// PageRanges are the pages that included in PDF, like `1-3,5`. all pages are included when empty.
+	PageRanges string
+
+	// Format is the format of PDF, PDF servers that cannot create PDF/A formats reject them.
+	Format PDFFormat
+}
+
+// Validate checks if options are valid.
+func (o ConvertOptions) Validate() error {
+	switch o.Format {
+	case FormatDefault, FormatPDFA1a, FormatPDFA2b, FormatPDFA3b:
+	default:
+		return fmt.Errorf("invalid pdf format %q, it must be PDF/A-1a, PDF/A-2b or PDF/A-3b", o.Format)
+	}
+	if o.PageRanges != "" && !pageRangesPattern.MatchString(o.PageRanges) {
+		return fmt.Errorf("invalid page ranges %q, it must be like 1-3,5", o.PageRanges)
+	}
+	return nil
+}
+
+// Key is a canonical representation of options that can be used in cache keys.
+// it's empty for default options so PDFs converted with defaults keep their keys.
+func (o ConvertOptions) Key() string {
+	if o == (ConvertOptions{}) {
+		return ""
+	}
+	return strings.Join([]string{
+		strconv.FormatBool(o.Landscape),
+		o.PageRanges,
+		string(o.Format),
+	}, "|")
+}
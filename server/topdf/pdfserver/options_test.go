@@ -0,0 +1,23 @@
+package pdfserver
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConvertOptionsValidate(t *testing.T) {
+	require.NoError(t, ConvertOptions{}.Validate())
+	require.NoError(t, ConvertOptions{Landscape: true, PageRanges: "1-3,5", Format: FormatPDFA2b}.Validate())
+	require.NoError(t, ConvertOptions{Format: FormatPDFA1a}.Validate())
+	require.NoError(t, ConvertOptions{Format: FormatPDFA3b}.Validate())
+	// PDF/A-1b is not supported by LibreOffice that converts Office documents.
+	require.EqualError(t, ConvertOptions{Format: "PDF/A-1b"}.Validate(), `invalid pdf format "PDF/A-1b", it must be PDF/A-1a, PDF/A-2b or PDF/A-3b`)
+	require.EqualError(t, ConvertOptions{PageRanges: "1-"}.Validate(), `invalid page ranges "1-", it must be like 1-3,5`)
+}
+
+func TestConvertOptionsKey(t *testing.T) {
+	require.Empty(t, ConvertOptions{}.Key())
+	require.Equal(t, "true|1-3|PDF/A-1a", ConvertOptions{Landscape: true, PageRanges: "1-3", Format: FormatPDFA1a}.Key())
+	require.NotEqual(t, ConvertOptions{Landscape: true}.Key(), ConvertOptions{PageRanges: "1"}.Key())
+}
@@ -0,0 +1,327 @@
+// Package resilient decorates PDF servers with retries and a circuit breaker so short outages
+// of a PDF server don't fail conversions and long ones fail them fast.
+package resilient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/trace"
+)
+
+const (
+	// defaultMaxRetries is the default number of retries made after a transient error.
+	defaultMaxRetries = 3
+
+	// defaultBaseBackoff is the default backoff before the first retry, it's doubled for each retry.
+	defaultBaseBackoff = 200 * time.Millisecond
+
+	// defaultMaxBackoff is the default maximum backoff between retries.
+	defaultMaxBackoff = 5 * time.Second
+
+	// defaultFailureThreshold is the default number of consecutive failed requests that opens the circuit.
+	defaultFailureThreshold = 5
+
+	// defaultOpenDuration is the default duration that circuit kept open before a probe is allowed.
+	defaultOpenDuration = 30 * time.Second
+)
+
+// ErrCircuitOpen is the reason of pdfserver.NotReachable errors returned while circuit is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open after consecutive failures")
+
+// State is the state of circuit breaker.
+type State string
+
+// States of circuit breaker.
+const (
+	// StateClosed is the state where requests are sent to PDF server.
+	StateClosed State = "closed"
+
+	// StateOpen is the state where requests fail fast without reaching to PDF server.
+	StateOpen State = "open"
+
+	// StateHalfOpen is the state where a single probe request is allowed to see if PDF server recovered.
+	StateHalfOpen State = "half_open"
+)
+
+// Status is the status of circuit breaker.
+type Status struct {
+	// State is the current state of circuit breaker.
+	State State `json:"state"`
+
+	// Failures is the number of consecutive failed requests.
+	Failures int `json:"failures"`
+
+	// OpenedAt is the time in milliseconds since epoch when circuit opened, it's zero when it's closed.
+	OpenedAt int64 `json:"openedAt,omitempty"`
+}
+
+// Server is a PDF server decorated with retries and a circuit breaker.
+// it also implements pdfserver.WebhookServer, ErrWebhookNotSupported is returned when
+// decorated server is not a webhook server.
+type Server struct {
+	// name is the name of PDF server.
+	name string
+
+	// server is the decorated PDF server.
+	server pdfserver.Server
+
+	maxRetries       int
+	baseBackoff      time.Duration
+	maxBackoff       time.Duration
+	failureThreshold int
+	openDuration     time.Duration
+
+	// sleep and now are replaced in tests.
+	sleep func(ctx context.Context, d time.Duration) error
+	now   func() time.Time
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+	// probing is true while the probe request of half-open state is in progress.
+	probing bool
+}
+
+// Option used to customize Server defaults.
+type Option func(*Server)
+
+// RetryOption sets the maximum number of retries made after transient errors and the backoff
+// before the first retry, backoff is doubled for each retry up to maxBackoff with a random jitter.
+func RetryOption(maxRetries int, baseBackoff, maxBackoff time.Duration) Option {
+	return func(s *Server) {
+		s.maxRetries = maxRetries
+		s.baseBackoff = baseBackoff
+		s.maxBackoff = maxBackoff
+	}
+}
+
+// CircuitBreakerOption sets the number of consecutive failed requests that opens the circuit
+// and the duration that circuit kept open before a probe request is allowed.
+func CircuitBreakerOption(failureThreshold int, openDuration time.Duration) Option {
+	return func(s *Server) {
+		s.failureThreshold = failureThreshold
+		s.openDuration = openDuration
+	}
+}
+
+// New decorates server that named as name with options.
+func New(name string, server pdfserver.Server, options ...Option) *Server {
+	s := &Server{
+		name:             name,
+		server:           server,
+		maxRetries:       defaultMaxRetries,
+		baseBackoff:      defaultBaseBackoff,
+		maxBackoff:       defaultMaxBackoff,
+		failureThreshold: defaultFailureThreshold,
+		openDuration:     defaultOpenDuration,
+		sleep:            sleep,
+		now:              time.Now,
+		state:            StateClosed,
+	}
+	for _, o := range options {
+		o(s)
+	}
+	return s
+}
+
+// Status checks if PDF server is running and ready. it fails fast while circuit is open and
+// it's used as a probe when circuit is half-open.
+func (s *Server) Status(ctx context.Context) error {
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	err := s.server.Status(ctx)
+	if ctx.Err() != nil {
+		s.abandon()
+		return ctx.Err()
+	}
+	s.release(err != nil)
+	return err
+}
+
+// Convert converts file to PDF by retrying transient errors.
+func (s *Server) Convert(ctx context.Context, name, extension string, file io.Reader, options pdfserver.ConvertOptions) (pdf io.ReadCloser, err error) {
+	err = s.do(ctx, file, func(file io.Reader) (err error) {
+		pdf, err = s.server.Convert(ctx, name, extension, file, options)
+		return err
+	})
+	return pdf, err
+}
+
+// ConvertToWebhook starts converting file to PDF by retrying transient errors where PDF is
+// delivered to webhookURL.
+func (s *Server) ConvertToWebhook(ctx context.Context, name, extension string, file io.Reader, options pdfserver.ConvertOptions, webhookURL string) error {
+	server, ok := s.server.(pdfserver.WebhookServer)
+	if !ok {
+		return pdfserver.ErrWebhookNotSupported
+	}
+	return s.do(ctx, file, func(file io.Reader) error {
+		return server.ConvertToWebhook(ctx, name, extension, file, options, webhookURL)
+	})
+}
+
+// Identity identifies the decorated PDF server.
+func (s *Server) Identity() string {
+	return s.server.Identity()
+}
+
+// CircuitStatus gets the status of circuit breaker.
+func (s *Server) CircuitStatus() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.halfOpenIfExpired()
+	status := Status{State: s.state, Failures: s.failures}
+	if s.state != StateClosed {
+		status.OpenedAt = s.openedAt.UnixNano() / int64(time.Millisecond)
+	}
+	return status
+}
+
+// do makes request with the content of file through circuit breaker and retries it on
+// transient errors with backoff.
+func (s *Server) do(ctx context.Context, file io.Reader, request func(file io.Reader) error) error {
+	// file is kept in memory to be able to send it again on retries.
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	if err := s.acquire(); err != nil {
+		return err
+	}
+	attempt := 0
+	for ; ; attempt++ {
+		err = request(bytes.NewReader(data))
+		// canceled requests tell nothing about the health of PDF server.
+		if ctx.Err() != nil {
+			s.abandon()
+			return ctx.Err()
+		}
+		if !IsTransient(err) || attempt == s.maxRetries {
+			break
+		}
+		// backoff is cut short when request is canceled while waiting for the retry.
+		if err := s.sleep(ctx, s.backoff(attempt)); err != nil {
+			s.abandon()
+			return err
+		}
+	}
+	// retries are recorded in the span of request so slow conversions can be explained.
+	if attempt > 0 {
+		trace.FromContext(ctx).SetAttributes("retries", attempt)
+	}
+	// only transient errors are failures of PDF server, others are about the request itself.
+	s.release(IsTransient(err))
+	return err
+}
+
+// sleep waits for d unless ctx is canceled before, ctx's error is returned when it's canceled.
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// backoff calculates the backoff with a random jitter before the retry after attempt.
+func (s *Server) backoff(attempt int) time.Duration {
+	backoff := s.baseBackoff << uint(attempt)
+	if backoff > s.maxBackoff || backoff <= 0 {
+		backoff = s.maxBackoff
+	}
+	// equal jitter keeps at least half of the backoff while spreading retries of concurrent requests.
+	half := int64(backoff / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// acquire checks if a request can be made, pdfserver.NotReachable is returned while circuit is
+// open or a probe request is already in progress in half-open state.
+func (s *Server) acquire() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.halfOpenIfExpired()
+	switch {
+	case s.state == StateOpen, s.state == StateHalfOpen && s.probing:
+		return &pdfserver.NotReachable{ServerName: s.name, Reason: ErrCircuitOpen}
+	case s.state == StateHalfOpen:
+		s.probing = true
+	}
+	return nil
+}
+
+// release records the result of a request that acquired before. successful requests close the
+// circuit, failed ones open it when threshold is reached or when it was the probe of half-open state.
+func (s *Server) release(failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	probe := s.probing
+	s.probing = false
+	if !failed {
+		s.state = StateClosed
+		s.failures = 0
+		return
+	}
+	s.failures++
+	if probe || s.failures >= s.failureThreshold {
+		s.state = StateOpen
+		s.openedAt = s.now()
+	}
+}
+
+// abandon releases a request that acquired before without recording a result, a probe of
+// half-open state is allowed again.
+func (s *Server) abandon() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.probing = false
+}
+
+// halfOpenIfExpired moves an open circuit to half-open when its open duration is passed.
+func (s *Server) halfOpenIfExpired() {
+	if s.state == StateOpen && s.now().Sub(s.openedAt) >= s.openDuration {
+		s.state = StateHalfOpen
+	}
+}
+
+// IsTransient checks if err is a temporary failure of PDF server that may succeed when retried,
+// like refused and reset connections or bad gateway and unavailable responses.
+func IsTransient(err error) bool {
+	for err != nil {
+		switch e := err.(type) {
+		case *pdfserver.NotReachable:
+			return e.Reason != ErrCircuitOpen
+		case *pdfserver.ResponseError:
+			return e.StatusCode == http.StatusBadGateway ||
+				e.StatusCode == http.StatusServiceUnavailable ||
+				e.StatusCode == http.StatusGatewayTimeout
+		case *url.Error:
+			err = e.Err
+		case *net.OpError:
+			err = e.Err
+		case *os.SyscallError:
+			err = e.Err
+		case syscall.Errno:
+			return e == syscall.ECONNREFUSED || e == syscall.ECONNRESET
+		default:
+			return err == io.ErrUnexpectedEOF || err == io.EOF
+		}
+	}
+	return false
+}
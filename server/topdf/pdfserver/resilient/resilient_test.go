@@ -0,0 +1,174 @@
+package resilient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver"
+	sMock "github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver/mocks"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestServer decorates server with a fake clock and without waiting between retries.
+func newTestServer(server pdfserver.Server, options ...Option) (s *Server, now *time.Time) {
+	s = New("Test", server, options...)
+	clock := time.Unix(1000, 0)
+	s.now = func() time.Time { return clock }
+	s.sleep = func(context.Context, time.Duration) error { return nil }
+	return s, &clock
+}
+
+var errRefused = &url.Error{Op: "Post", URL: "http://test", Err: &net.OpError{
+	Op: "dial", Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED},
+}}
+
+func TestConvertRetried(t *testing.T) {
+	serverMock := &sMock.Server{}
+	s, _ := newTestServer(serverMock)
+	serverMock.On("Convert", mock.Anything, "a", "docx", mock.Anything, pdfserver.ConvertOptions{}).Twice().Return(nil, errRefused)
+	serverMock.On("Convert", mock.Anything, "a", "docx", mock.Anything, pdfserver.ConvertOptions{}).Once().Return(ioutil.NopCloser(strings.NewReader("pdf")), nil)
+	pdf, err := s.Convert(context.Background(), "a", "docx", strings.NewReader("docx"), pdfserver.ConvertOptions{})
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(pdf)
+	require.NoError(t, err)
+	require.Equal(t, "pdf", string(data))
+	// file is sent completely on each retry.
+	for _, call := range serverMock.Calls {
+		data, err := ioutil.ReadAll(call.Arguments.Get(3).(io.Reader))
+		require.NoError(t, err)
+		require.Equal(t, "docx", string(data))
+	}
+	require.Equal(t, Status{State: StateClosed}, s.CircuitStatus())
+	serverMock.AssertExpectations(t)
+}
+
+func TestConvertNotRetried(t *testing.T) {
+	serverMock := &sMock.Server{}
+	s, _ := newTestServer(serverMock)
+	err := &pdfserver.ResponseError{ServerName: "Test", StatusCode: 400, Message: "bad file"}
+	serverMock.On("Convert", mock.Anything, "a", "docx", mock.Anything, pdfserver.ConvertOptions{}).Once().Return(nil, err)
+	_, cerr := s.Convert(context.Background(), "a", "docx", strings.NewReader("docx"), pdfserver.ConvertOptions{})
+	require.Equal(t, err, cerr)
+	require.Equal(t, Status{State: StateClosed}, s.CircuitStatus())
+	serverMock.AssertExpectations(t)
+}
+
+func TestConvertCanceled(t *testing.T) {
+	serverMock := &sMock.Server{}
+	s, _ := newTestServer(serverMock, CircuitBreakerOption(1, time.Minute))
+	ctx, cancel := context.WithCancel(context.Background())
+	serverMock.On("Convert", mock.Anything, "a", "docx", mock.Anything, pdfserver.ConvertOptions{}).Once().
+		Run(func(mock.Arguments) { cancel() }).
+		Return(nil, errRefused)
+	// canceled requests are neither retried nor counted as failures of PDF server.
+	_, err := s.Convert(ctx, "a", "docx", strings.NewReader("docx"), pdfserver.ConvertOptions{})
+	require.Equal(t, context.Canceled, err)
+	require.Equal(t, Status{State: StateClosed}, s.CircuitStatus())
+	serverMock.AssertExpectations(t)
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	serverMock := &sMock.Server{}
+	s, now := newTestServer(serverMock, RetryOption(1, time.Millisecond, time.Millisecond), CircuitBreakerOption(2, time.Minute))
+	unavailable := &pdfserver.ResponseError{ServerName: "Test", StatusCode: 503}
+	serverMock.On("Convert", mock.Anything, "a", "docx", mock.Anything, pdfserver.ConvertOptions{}).Times(4).Return(nil, unavailable)
+	for i := 0; i < 2; i++ {
+		_, err := s.Convert(context.Background(), "a", "docx", strings.NewReader("docx"), pdfserver.ConvertOptions{})
+		require.Equal(t, unavailable, err)
+	}
+	require.Equal(t, Status{State: StateOpen, Failures: 2, OpenedAt: 1000000}, s.CircuitStatus())
+
+	// requests fail fast while circuit is open.
+	_, err := s.Convert(context.Background(), "a", "docx", strings.NewReader("docx"), pdfserver.ConvertOptions{})
+	require.Equal(t, &pdfserver.NotReachable{ServerName: "Test", Reason: ErrCircuitOpen}, err)
+	require.Equal(t, &pdfserver.NotReachable{ServerName: "Test", Reason: ErrCircuitOpen}, s.Status(context.Background()))
+
+	// a failed probe opens the circuit again.
+	*now = now.Add(time.Minute)
+	require.Equal(t, StateHalfOpen, s.CircuitStatus().State)
+	serverMock.On("Status", mock.Anything).Once().Return(&pdfserver.NotReachable{ServerName: "Test", Reason: errors.New("down")})
+	require.Error(t, s.Status(context.Background()))
+	require.Equal(t, StateOpen, s.CircuitStatus().State)
+
+	// a successful probe closes it.
+	*now = now.Add(time.Minute)
+	serverMock.On("Status", mock.Anything).Once().Return(nil)
+	require.NoError(t, s.Status(context.Background()))
+	require.Equal(t, Status{State: StateClosed}, s.CircuitStatus())
+	serverMock.AssertExpectations(t)
+}
+
+func TestConvertToWebhookNotSupported(t *testing.T) {
+	s, _ := newTestServer(&sMock.Server{})
+	err := s.ConvertToWebhook(context.Background(), "a", "docx", strings.NewReader("docx"), pdfserver.ConvertOptions{}, "http://webhook")
+	require.Equal(t, pdfserver.ErrWebhookNotSupported, err)
+}
+
+func TestConvertToWebhookRetried(t *testing.T) {
+	serverMock := &sMock.WebhookServer{}
+	s, _ := newTestServer(serverMock)
+	serverMock.On("ConvertToWebhook", mock.Anything, "a", "docx", mock.Anything, pdfserver.ConvertOptions{}, "http://webhook").Once().Return(io.ErrUnexpectedEOF)
+	serverMock.On("ConvertToWebhook", mock.Anything, "a", "docx", mock.Anything, pdfserver.ConvertOptions{}, "http://webhook").Once().Return(nil)
+	require.NoError(t, s.ConvertToWebhook(context.Background(), "a", "docx", strings.NewReader("docx"), pdfserver.ConvertOptions{}, "http://webhook"))
+	serverMock.AssertExpectations(t)
+}
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		err       error
+		transient bool
+	}{
+		{nil, false},
+		{errRefused, true},
+		{&net.OpError{Op: "read", Err: &os.SyscallError{Syscall: "read", Err: syscall.ECONNRESET}}, true},
+		{&pdfserver.ResponseError{StatusCode: 502}, true},
+		{&pdfserver.ResponseError{StatusCode: 500}, false},
+		{&pdfserver.NotReachable{Reason: errors.New("down")}, true},
+		{&pdfserver.NotReachable{Reason: ErrCircuitOpen}, false},
+		{errors.New("file extension `txt` is not supported by the PDF server"), false},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.transient, IsTransient(tt.err), "%v", tt.err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	s := New("Test", &sMock.Server{}, RetryOption(10, 100*time.Millisecond, time.Second))
+	for attempt, max := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		backoff := s.backoff(attempt)
+		require.True(t, backoff >= max*time.Millisecond/2 && backoff <= max*time.Millisecond, "%d: %s", attempt, backoff)
+	}
+}
+
+func TestBackoffCanceled(t *testing.T) {
+	serverMock := &sMock.Server{}
+	s, _ := newTestServer(serverMock)
+	ctx, cancel := context.WithCancel(context.Background())
+	s.sleep = func(ctx context.Context, d time.Duration) error {
+		cancel()
+		return sleep(ctx, time.Hour)
+	}
+	serverMock.On("Convert", mock.Anything, "a", "docx", mock.Anything, pdfserver.ConvertOptions{}).Once().Return(nil, errRefused)
+	_, err := s.Convert(ctx, "a", "docx", strings.NewReader("docx"), pdfserver.ConvertOptions{})
+	require.Equal(t, context.Canceled, err)
+	// canceled requests are not failures of PDF server.
+	require.Equal(t, StateClosed, s.CircuitStatus().State)
+	serverMock.AssertExpectations(t)
+}
+
+func TestSleep(t *testing.T) {
+	require.NoError(t, sleep(context.Background(), time.Millisecond))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.Equal(t, context.Canceled, sleep(ctx, time.Hour))
+}
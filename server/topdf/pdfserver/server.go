@@ -1,20 +1,30 @@
 package pdfserver
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 )
 
+// ErrWebhookNotSupported returned when PDF server cannot deliver PDFs to webhooks.
+var ErrWebhookNotSupported = errors.New("pdf server does not support webhooks")
+
 // Server is a PDF server that converts files to PDFs.
 type Server interface {
 	// Status checks Server to see if it's running and ready.
 	// err is returned when PDF server is not running nor ready or can be related
 	// to anything else.
-	Status() (err error)
+	Status(ctx context.Context) (err error)
 
 	// Convert converts file to pdf.
 	// if file type is not supported or anyting related convert fails an err will be returned.
-	Convert(name, extension string, file io.Reader) (pdf io.ReadCloser, err error)
+	// canceling ctx aborts the conversion, it also carries the trace of conversion.
+	Convert(ctx context.Context, name, extension string, file io.Reader, options ConvertOptions) (pdf io.ReadCloser, err error)
+
+	// Identity identifies the PDF server and its version. PDFs converted by different PDF servers
+	// or versions are cached separately.
+	Identity() string
 }
 
 // NotReachable error is returned when PDF server is not running nor ready.
@@ -29,3 +39,28 @@ type NotReachable struct {
 func (e *NotReachable) Error() string {
 	return fmt.Sprintf("PDF server %q is not running, reason: %s", e.ServerName, e.Reason.Error())
 }
+
+// ResponseError is returned when PDF server responds to a request with a non-successful status code.
+type ResponseError struct {
+	// ServerName is the name of PDF Server.
+	ServerName string
+
+	// StatusCode is the HTTP status code of response.
+	StatusCode int
+
+	// Message is the error message sent by PDF server.
+	Message string
+}
+
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("error from %s with '%d' code: %s", e.ServerName, e.StatusCode, e.Message)
+}
+
+// WebhookServer is a Server that can deliver converted PDFs to a webhook instead of keeping
+// the convert request open until the conversion is finished.
+type WebhookServer interface {
+	Server
+
+	// ConvertToWebhook starts converting file to pdf, pdf is POSTed to webhookURL once it's ready.
+	ConvertToWebhook(ctx context.Context, name, extension string, file io.Reader, options ConvertOptions, webhookURL string) (err error)
+}
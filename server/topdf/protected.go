@@ -0,0 +1,116 @@
+package topdf
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"unicode/utf16"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// ErrPasswordProtected returned when a file is password protected and cannot be converted to PDF.
+var ErrPasswordProtected = errors.New("document is password protected")
+
+// CodePasswordProtected is the code of ErrPasswordProtected for clients.
+const CodePasswordProtected = "password_protected"
+
+const (
+	// odfManifest is the path of manifest in ODF packages that describes encryption of their files.
+	odfManifest = "META-INF/manifest.xml"
+
+	// maxManifestSize limits the size of ODF manifests that read.
+	maxManifestSize = 1 << 20
+)
+
+var (
+	// cfbMagic is the signature of OLE compound files. encrypted OOXML documents are stored in
+	// compound files instead of ZIP packages.
+	cfbMagic = []byte{0xd0, 0xcf, 0x11, 0xe0, 0xa1, 0xb1, 0x1a, 0xe1}
+
+	// encryptedPackage is the name of compound file stream that keeps encrypted OOXML packages,
+	// names are UTF-16 encoded in compound files.
+	encryptedPackage = utf16le("EncryptedPackage")
+
+	// zipMagic is the signature of ZIP files that ODF packages are stored in.
+	zipMagic = []byte("PK\x03\x04")
+
+	// odfEncryptionData is the manifest element that ODF packages describe encrypted files with.
+	odfEncryptionData = []byte("encryption-data")
+)
+
+// isPasswordProtected checks if source is an encrypted OOXML document or a password protected
+// ODF document. password protected documents in legacy binary formats are not detected.
+func isPasswordProtected(source []byte) bool {
+	if bytes.HasPrefix(source, cfbMagic) {
+		return bytes.Contains(source, encryptedPackage)
+	}
+	if bytes.HasPrefix(source, zipMagic) {
+		return isEncryptedODF(source)
+	}
+	return false
+}
+
+// isEncryptedODF checks if ODF package in source has encrypted files.
+func isEncryptedODF(source []byte) bool {
+	r, err := zip.NewReader(bytes.NewReader(source), int64(len(source)))
+	if err != nil {
+		return false
+	}
+	for _, f := range r.File {
+		if f.Name != odfManifest {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return false
+		}
+		defer rc.Close()
+		manifest, err := ioutil.ReadAll(io.LimitReader(rc, maxManifestSize))
+		if err != nil {
+			return false
+		}
+		return bytes.Contains(manifest, odfEncryptionData)
+	}
+	return false
+}
+
+// utf16le encodes s in UTF-16 little endian.
+func utf16le(s string) []byte {
+	codes := utf16.Encode([]rune(s))
+	b := make([]byte, 0, len(codes)*2)
+	for _, c := range codes {
+		b = append(b, byte(c), byte(c>>8))
+	}
+	return b
+}
+
+// getConvertible gets the content of file with fileInfo like getSource and checks if it can be
+// converted. password protected files are cached with a verdict so they're not fetched again.
+// files with the same content share their cache entries, pid is the id of PDF that is already
+// cached for another file with the same content once the hash of file is known, the file doesn't
+// need a conversion then.
+func (t *TOPDF) getConvertible(ctx context.Context, fileInfo *model.FileInfo, ref *cacheRef) (source []byte, pid string, err error) {
+	// the file is hashed by getSource when the cache key of its content isn't known yet.
+	needsHash := ref.key == ""
+	source, err = t.getSource(ctx, fileInfo, ref)
+	if err != nil {
+		return nil, "", err
+	}
+	if needsHash {
+		if pid, err = t.getCached(*ref); err != nil || pid != "" {
+			return nil, pid, err
+		}
+	}
+	if !isPasswordProtected(source) {
+		return source, "", nil
+	}
+	t.logger(ctx).Info("document is password protected, it's not converted")
+	if err := t.setProtected(*ref); err != nil {
+		return nil, "", err
+	}
+	return nil, "", ErrPasswordProtected
+}
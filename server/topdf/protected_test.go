@@ -0,0 +1,83 @@
+package topdf
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	sMock "github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver/mocks"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/memkv"
+	"github.com/stretchr/testify/require"
+)
+
+// odfPackage creates an ODF package with manifest.
+func odfPackage(t *testing.T, manifest string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"mimetype":    "application/vnd.oasis.opendocument.text",
+		odfManifest:   manifest,
+		"content.xml": "<office:document-content/>",
+	} {
+		f, err := w.Create(name)
+		require.NoError(t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestIsPasswordProtected(t *testing.T) {
+	encryptedOOXML := append(append([]byte{}, cfbMagic...), make([]byte, 504)...)
+	encryptedOOXML = append(encryptedOOXML, utf16le("EncryptionInfo")...)
+	encryptedOOXML = append(encryptedOOXML, utf16le("EncryptedPackage")...)
+	legacyDoc := append(append([]byte{}, cfbMagic...), utf16le("WordDocument")...)
+
+	for _, tt := range []struct {
+		name      string
+		source    []byte
+		protected bool
+	}{
+		{"encrypted ooxml", encryptedOOXML, true},
+		{"legacy binary", legacyDoc, false},
+		{"encrypted odf", odfPackage(t, `<manifest:file-entry manifest:full-path="content.xml"><manifest:encryption-data/></manifest:file-entry>`), true},
+		{"odf", odfPackage(t, `<manifest:file-entry manifest:full-path="content.xml"/>`), false},
+		{"plain", []byte("hello"), false},
+		{"broken zip", []byte("PK\x03\x04broken"), false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.protected, isPasswordProtected(tt.source))
+		})
+	}
+}
+
+func TestGetPDFPasswordProtected(t *testing.T) {
+	serverMock := &sMock.Server{}
+	serverMock.On("Identity").Return("Test")
+	apiMock := memkv.New()
+	source := odfPackage(t, `<manifest:encryption-data/>`)
+	mockConvertible(apiMock, source)
+	app := New(apiMock, serverMock)
+
+	_, err := app.GetPDF(context.Background(), "user-id", "file-id", "")
+	require.Equal(t, ErrPasswordProtected, err)
+	entry := requireCached(t, apiMock, "file-id", source, "Test", "", 0)
+	require.True(t, entry.PasswordProtected)
+	require.Empty(t, entry.PDFFileID)
+
+	// the verdict is served from cache without fetching the file again.
+	_, err = app.GetPDF(context.Background(), "user-id", "file-id", "")
+	require.Equal(t, ErrPasswordProtected, err)
+	apiMock.AssertNumberOfCalls(t, "GetFile", 1)
+	serverMock.AssertNotCalled(t, "Convert")
+
+	pages, err := app.VerifyCacheEntry(CacheEntry{SourceHash: sourceHash(source), Backend: "Test"}.key())
+	require.NoError(t, err)
+	require.Zero(t, pages)
+	data, err := json.Marshal(entry)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"passwordProtected":true`)
+}
@@ -2,11 +2,22 @@ package topdf
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"math"
+	"path"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/ilgooz/mattermost-plugin-topdf/server/audit"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/logger"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/sanitize"
 	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/trace"
 	"github.com/mattermost/mattermost-server/model"
 	"github.com/mattermost/mattermost-server/plugin"
 )
@@ -14,9 +25,63 @@ import (
 // toPDFPrefix used as a prefix while using KV store to access PDF files' fileID.
 const toPDFPrefix = "pdf:"
 
+// PDF is the PDF version of a file.
+type PDF struct {
+	io.ReadCloser
+
+	// Name is the file name of PDF that derived from the name of source file.
+	Name string
+
+	// Size is the size of PDF in bytes.
+	Size int64
+}
+
+// newPDF creates a PDF with data for the source file with fileInfo.
+func newPDF(fileInfo *model.FileInfo, data []byte) *PDF {
+	return &PDF{
+		ReadCloser: ioutil.NopCloser(bytes.NewReader(data)),
+		Name:       pdfName(fileInfo.Name),
+		Size:       int64(len(data)),
+	}
+}
+
+// PDFFile is the cached file of the PDF version of a file.
+type PDFFile struct {
+	// ID is the id of cached PDF file.
+	ID string
+
+	// Name is the file name of PDF that derived from the name of source file. cached PDFs are
+	// shared by files with the same content so the cached file is named after the file
+	// converted first, it might have another name.
+	Name string
+}
+
+// pdfName derives the file name of PDF from the name of its source file by replacing its
+// extension, for ex: report.docx becomes report.pdf.
+func pdfName(sourceName string) string {
+	base := strings.TrimSuffix(path.Base(sourceName), path.Ext(sourceName))
+	if base == "" || base == "." || base == "/" {
+		base = "document"
+	}
+	return base + ".pdf"
+}
+
 // ErrUnauthorizedUser returned when user has no access to a file that requested to be converted to PDF.
 var ErrUnauthorizedUser = errors.New("user is not authorized to access pdf")
 
+// ErrWebhookNotSupported returned when PDF server cannot deliver PDFs to webhooks.
+var ErrWebhookNotSupported = pdfserver.ErrWebhookNotSupported
+
+// RateLimited error returned when a conversion is rejected because of rate limits.
+type RateLimited struct {
+	// RetryAfter is the time to wait before trying again.
+	RetryAfter time.Duration
+}
+
+func (e *RateLimited) Error() string {
+	return fmt.Sprintf("too many conversion requests, retry after %d seconds", int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
 // TOPDF is an application that converts files to PDFs and permanently caches them by using Mattermost APIs.
 type TOPDF struct {
 	// mapi is Mattermost's Plugin API.
@@ -24,25 +89,169 @@ type TOPDF struct {
 
 	// server used to convert files to PDF.
 	server pdfserver.Server
+
+	// auditor records accesses to PDFs, it's optional.
+	auditor Auditor
+
+	// limiter limits conversions of files that have no cached PDFs, it's optional.
+	limiter RateLimiter
+
+	// limits are the default conversion limits.
+	limits Limits
+
+	// extensionLimits are the conversion limits that replace defaults for file extensions.
+	extensionLimits map[string]Limits
+
+	// options are the default conversion options.
+	options pdfserver.ConvertOptions
+
+	// extensionOptions are the conversion options that replace defaults for file extensions.
+	extensionOptions map[string]pdfserver.ConvertOptions
+
+	// sanitizer sanitizes PDFs before they're cached, it's optional.
+	sanitizer Sanitizer
+
+	// log is used when there is no request scoped logger, it's optional.
+	log *logger.Logger
+
+	// tracer starts traces of operations that are not part of a traced request, it's optional.
+	tracer *trace.Tracer
+
+	// owner gets the user that owns cached PDFs, it's optional.
+	owner func() (userID string)
+
+	// mu protects conversions.
+	mu sync.Mutex
+
+	// conversions are the in-flight conversions by file ids.
+	conversions map[string]*conversion
+}
+
+// Auditor records audit events.
+type Auditor interface {
+	Record(e audit.Event) error
+}
+
+// RateLimiter limits requests made by users.
+type RateLimiter interface {
+	// Allow takes a token for userID, non-zero retryAfter is returned if request is not allowed.
+	Allow(userID string) (retryAfter time.Duration, err error)
+}
+
+// Sanitizer removes unsafe content from PDFs.
+type Sanitizer interface {
+	// Sanitize sanitizes pdf and reports what removed.
+	Sanitize(pdf []byte) (sanitized []byte, report sanitize.Report, err error)
+
+	// Identity identifies the sanitizer and its settings, it's included in cache keys.
+	Identity() string
+}
+
+// New creates a new TOPDF app with mapi, PDF server and options.
+func New(mapi plugin.API, server pdfserver.Server, options ...Option) *TOPDF {
+	t := &TOPDF{
+		mapi:        mapi,
+		server:      server,
+		conversions: make(map[string]*conversion),
+	}
+	for _, o := range options {
+		o(t)
+	}
+	return t
+}
+
+// Option used to customize TOPDF defaults.
+type Option func(*TOPDF)
+
+// AuditOption sets an auditor to record accesses to PDFs.
+func AuditOption(auditor Auditor) Option {
+	return func(t *TOPDF) {
+		t.auditor = auditor
+	}
+}
+
+// RateLimitOption sets a rate limiter for conversions.
+// PDFs that served from cache are not limited.
+func RateLimitOption(limiter RateLimiter) Option {
+	return func(t *TOPDF) {
+		t.limiter = limiter
+	}
+}
+
+// SanitizerOption sets a sanitizer that sanitizes converted PDFs before they're cached.
+func SanitizerOption(sanitizer Sanitizer) Option {
+	return func(t *TOPDF) {
+		t.sanitizer = sanitizer
+	}
+}
+
+// LoggerOption sets the logger that used when contexts carry no request scoped loggers.
+func LoggerOption(l *logger.Logger) Option {
+	return func(t *TOPDF) {
+		t.log = l
+	}
+}
+
+// OwnerOption sets owner that gets the user that owns cached PDFs, PDFs have no creator otherwise.
+// it's called for each upload so owner can be known after TOPDF is created.
+func OwnerOption(owner func() (userID string)) Option {
+	return func(t *TOPDF) {
+		t.owner = owner
+	}
+}
+
+// TracerOption sets the tracer that starts traces when contexts carry no spans.
+func TracerOption(tracer *trace.Tracer) Option {
+	return func(t *TOPDF) {
+		t.tracer = tracer
+	}
 }
 
-// New creates a new TOPDF app with mapi and PDF server.
-func New(mapi plugin.API, server pdfserver.Server) *TOPDF {
-	return &TOPDF{
-		mapi:   mapi,
-		server: server,
+// logger gets the request scoped logger from ctx or the default one.
+func (t *TOPDF) logger(ctx context.Context) *logger.Logger {
+	if l := logger.FromContext(ctx); l != nil {
+		return l
 	}
+	return t.log
+}
+
+// withFile returns a copy of ctx with a logger that adds details of file with fileInfo and the
+// PDF server of its ref to messages.
+func (t *TOPDF) withFile(ctx context.Context, fileInfo *model.FileInfo, ref cacheRef) context.Context {
+	l := t.logger(ctx).With("fileId", fileInfo.Id, "extension", fileInfo.Extension, "backend", ref.entry.Backend)
+	return logger.NewContext(ctx, l)
 }
 
 // CheckServerStatus checks if underlying PDF server is running and ready to accept requests.
-func (t *TOPDF) CheckServerStatus() error {
-	return t.server.Status()
+func (t *TOPDF) CheckServerStatus(ctx context.Context) error {
+	return t.server.Status(ctx)
 }
 
 // GetPDF gets PDF for fileID that belongs userID. user has to have access to the file
 // otherwise ErrUnauthorizedUser is returned.
-func (t *TOPDF) GetPDF(userID, fileID string) (pdf io.ReadCloser, err error) {
-	pdf, err = t.getPDF(userID, fileID)
+// clientIP is the address of client that requested the PDF, it's recorded for auditing.
+// stages of conversion are logged with the request scoped logger carried by ctx.
+func (t *TOPDF) GetPDF(ctx context.Context, userID, fileID, clientIP string) (pdf *PDF, err error) {
+	return t.getPDFWithAccess(ctx, access{userID: userID, clientIP: clientIP}, fileID)
+}
+
+// GetPublicPDF gets PDF for fileID that accessed through its public link with hash, see GetPDF.
+// ErrUnauthorizedUser is returned when Mattermost does not serve the public link with hash, like
+// when public links are disabled or hash is not valid.
+func (t *TOPDF) GetPublicPDF(ctx context.Context, fileID, hash, clientIP string) (pdf *PDF, err error) {
+	return t.getPDFWithAccess(ctx, access{publicLinkHash: hash, clientIP: clientIP}, fileID)
+}
+
+// getPDFWithAccess gets PDF for fileID with a, the access is audited.
+func (t *TOPDF) getPDFWithAccess(ctx context.Context, a access, fileID string) (pdf *PDF, err error) {
+	ctx, span := t.tracer.Start(ctx, "topdf.GetPDF", "userId", a.userID, "fileId", fileID, "publicLink", a.isPublic())
+	defer func() { span.EndWithError(err) }()
+	event := audit.Event{UserID: a.userID, FileID: fileID, ClientIP: a.clientIP, PublicLink: a.isPublic()}
+	defer func() {
+		span.SetAttributes("cacheHit", event.CacheHit)
+		t.audit(ctx, event, err)
+	}()
+	pdf, err = t.getPDF(ctx, a, fileID, &event)
 	if err != nil {
 		// return an authorization error if we got an err from Plugin's API.
 		if _, ok := err.(*model.AppError); ok {
@@ -53,96 +262,412 @@ func (t *TOPDF) GetPDF(userID, fileID string) (pdf io.ReadCloser, err error) {
 	return pdf, nil
 }
 
-// getPDF gets PDF for fileID that belongs to userID.
+// GetPDFFile gets the cached file of PDF for fileID that belongs userID like GetPDF does, without
+// reading its content. PDF is converted and cached first when it's not cached yet.
+func (t *TOPDF) GetPDFFile(ctx context.Context, userID, fileID string) (file *PDFFile, err error) {
+	a := access{userID: userID}
+	ctx, span := t.tracer.Start(ctx, "topdf.GetPDFFile", "userId", userID, "fileId", fileID)
+	defer func() { span.EndWithError(err) }()
+	event := audit.Event{UserID: userID, FileID: fileID}
+	defer func() {
+		span.SetAttributes("cacheHit", event.CacheHit)
+		t.audit(ctx, event, err)
+	}()
+	fileInfo, _, pid, err := t.findPDF(ctx, a, fileID, &event, false)
+	if err != nil {
+		// return an authorization error if we got an err from Plugin's API.
+		if _, ok := err.(*model.AppError); ok {
+			return nil, ErrUnauthorizedUser
+		}
+		return nil, err
+	}
+	return &PDFFile{ID: pid, Name: pdfName(fileInfo.Name)}, nil
+}
+
+// audit records event with the outcome of err when there is an auditor.
+func (t *TOPDF) audit(ctx context.Context, event audit.Event, err error) {
+	if t.auditor == nil {
+		return
+	}
+	event.Outcome = audit.OutcomeSuccess
+	if err == ErrUnauthorizedUser {
+		event.Outcome = audit.OutcomeUnauthorized
+	} else if _, ok := err.(*RateLimited); ok {
+		event.Outcome = audit.OutcomeRateLimited
+	} else if err != nil {
+		event.Outcome = audit.OutcomeFailure
+		event.Error = err.Error()
+	}
+	if err := t.auditor.Record(event); err != nil {
+		t.logger(ctx).Error("cannot record audit event", "fileId", event.FileID, "err", err.Error())
+	}
+}
+
+// getPDF gets PDF for fileID with a and fills details of the access into event.
 // notes:
 // - Mattermost's Plugin API does not implement io.Reader while dealing with files but this might
 //   be improved in future since large files can pump memory usage. TOPDF created streams in mind,
-//   this is why we use ioutil.NopCloser and bytes.Reader in the code below, to even with current Plugin API.
+//   this is why we use ioutil.NopCloser and bytes.Reader in newPDF(), to even with current Plugin API.
 // - Mattermost's Plugin API does not return errors as `error`s but returns them as *model.AppError,
 //   this needs to be improved since it causes issues while dealing with errors. For more info
 //   please see: https://golang.org/doc/faq#nil_error
 //   to workaround this, Plugin errors are normalized with normalizeAppErr().
-func (t *TOPDF) getPDF(userID, fileID string) (pdf io.ReadCloser, err error) {
+func (t *TOPDF) getPDF(ctx context.Context, a access, fileID string, event *audit.Event) (pdf *PDF, err error) {
+	fileInfo, data, _, err := t.findPDF(ctx, a, fileID, event, true)
+	if err != nil {
+		return nil, err
+	}
+	// PDFs are named after the requested file since cached ones are shared by files with the
+	// same content.
+	return newPDF(fileInfo, data), nil
+}
+
+// findPDF gets the id of cached PDF file, pid, for fileID with a and fills details of the access
+// into event. PDF is converted and cached when it's not cached yet. data is the content of PDF,
+// it's only read from cache when read is true.
+func (t *TOPDF) findPDF(ctx context.Context, a access, fileID string, event *audit.Event, read bool) (fileInfo *model.FileInfo, data []byte, pid string, err error) {
+	fileInfo, filePost, err := t.authorize(ctx, a, fileID, event)
+	if err != nil {
+		return nil, nil, "", err
+	}
 	// try to get id of PDF file that possibly generated and cached for fileID before.
-	pid, aerr := t.mapi.KVGet(key(fileID))
-	if aerr != nil {
-		return nil, normalizeAppErr(aerr)
+	start := time.Now()
+	ref, pid, err := t.lookUpCache(ctx, fileInfo)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	ctx = t.withFile(ctx, fileInfo, ref)
+	t.logger(ctx).Debug("cache looked up", "hit", len(pid) != 0, "durationMs", logger.Millis(start))
+	// we have the PDF version in cache, directly return it back.
+	if len(pid) != 0 {
+		event.CacheHit = true
+		if read {
+			if data, err = t.getCachedPDF(ctx, pid); err != nil {
+				return nil, nil, "", err
+			}
+		}
+		return fileInfo, data, pid, nil
+	}
+	// if there is no PDF file cached, create it, cache and use its content.
+	// check file size before consuming rate limits or fetching file's content.
+	limits := t.limitsFor(fileInfo.Extension)
+	if err := limits.checkSource(fileInfo); err != nil {
+		return nil, nil, "", err
+	}
+	if err := t.allow(ctx, a.limitKey()); err != nil {
+		return nil, nil, "", err
+	}
+	data, pid, err = t.convert(ctx, fileInfo, filePost, ref, limits)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return fileInfo, data, pid, nil
+}
+
+// lookUpCache gets the cache ref of file with fileInfo and the id of its cached PDF, pid is empty
+// when it's not cached.
+func (t *TOPDF) lookUpCache(ctx context.Context, fileInfo *model.FileInfo) (ref cacheRef, pid string, err error) {
+	_, span := t.tracer.Start(ctx, "topdf.lookUpCache")
+	defer func() {
+		span.SetAttributes("hit", pid != "")
+		span.EndWithError(err)
+	}()
+	if ref, err = t.cacheRefFor(fileInfo); err != nil {
+		return ref, "", err
 	}
+	pid, err = t.getCached(ref)
+	return ref, pid, err
+}
+
+// authorize checks if a grants access to fileID and gets file's info and associated post.
+// details of the access are filled into event.
+func (t *TOPDF) authorize(ctx context.Context, a access, fileID string, event *audit.Event) (fileInfo *model.FileInfo, filePost *model.Post, err error) {
+	_, span := t.tracer.Start(ctx, "topdf.authorize")
+	defer func() { span.EndWithError(err) }()
 	// get file's info.
 	fileInfo, aerr := t.mapi.GetFileInfo(fileID)
 	if aerr != nil {
-		return nil, normalizeAppErr(aerr)
+		return nil, nil, normalizeAppErr(aerr)
 	}
 	// get associated post for the file.
+	filePost, aerr = t.mapi.GetPost(fileInfo.PostId)
+	if aerr != nil {
+		return nil, nil, normalizeAppErr(aerr)
+	}
+	event.ChannelID = filePost.ChannelId
+	if a.isPublic() {
+		valid, err := t.isValidPublicLink(ctx, fileInfo.Id, a.publicLinkHash)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !valid {
+			return nil, nil, ErrUnauthorizedUser
+		}
+		return fileInfo, filePost, nil
+	}
+	// check if the user can read the file like it's checked by Mattermost.
+	if !t.canAccess(a.userID, fileInfo, filePost) {
+		return nil, nil, ErrUnauthorizedUser
+	}
+	return fileInfo, filePost, nil
+}
+
+// ConvertToWebhook starts converting fileID that belongs to userID to PDF where the PDF server
+// delivers the PDF to webhookURL, it should be cached with SavePDF() once it's received.
+// cached is true when PDF for fileID is already cached and there is no need for a conversion.
+// user has to have access to the file otherwise ErrUnauthorizedUser is returned.
+// ErrWebhookNotSupported is returned when PDF server is not a pdfserver.WebhookServer.
+func (t *TOPDF) ConvertToWebhook(ctx context.Context, userID, fileID, clientIP, webhookURL string) (cached bool, err error) {
+	ctx, span := t.tracer.Start(ctx, "topdf.ConvertToWebhook", "userId", userID, "fileId", fileID)
+	defer func() { span.EndWithError(err) }()
+	event := audit.Event{UserID: userID, FileID: fileID, ClientIP: clientIP}
+	defer func() { t.audit(ctx, event, err) }()
+	cached, err = t.convertToWebhook(ctx, userID, fileID, webhookURL, &event)
+	if err != nil {
+		// return an authorization error if we got an err from Plugin's API.
+		if _, ok := err.(*model.AppError); ok {
+			return false, ErrUnauthorizedUser
+		}
+		return false, err
+	}
+	return cached, nil
+}
+
+// convertToWebhook starts converting fileID that belongs to userID to PDF where the PDF is
+// delivered to webhookURL, details of the access are filled into event.
+func (t *TOPDF) convertToWebhook(ctx context.Context, userID, fileID, webhookURL string, event *audit.Event) (cached bool, err error) {
+	server, ok := t.server.(pdfserver.WebhookServer)
+	if !ok {
+		return false, ErrWebhookNotSupported
+	}
+	fileInfo, _, err := t.authorize(ctx, access{userID: userID}, fileID, event)
+	if err != nil {
+		return false, err
+	}
+	ref, pid, err := t.lookUpCache(ctx, fileInfo)
+	if err != nil {
+		return false, err
+	}
+	ctx = t.withFile(ctx, fileInfo, ref)
+	if len(pid) != 0 {
+		event.CacheHit = true
+		return true, nil
+	}
+	limits := t.limitsFor(fileInfo.Extension)
+	if err := limits.checkSource(fileInfo); err != nil {
+		return false, err
+	}
+	if err := t.allow(ctx, userID); err != nil {
+		return false, err
+	}
+	// the hash of file's content is saved while getting the source so SavePDF() can cache the PDF
+	// under the same key.
+	fileBytes, pid, err := t.getConvertible(ctx, fileInfo, &ref)
+	if err != nil {
+		return false, err
+	}
+	if pid != "" {
+		event.CacheHit = true
+		return true, nil
+	}
+	options := t.optionsFor(fileInfo.Extension)
+	start := time.Now()
+	sctx, span := t.tracer.Start(ctx, "pdfserver.ConvertToWebhook", "backend", ref.entry.Backend)
+	err = server.ConvertToWebhook(sctx, fileInfo.Name, fileInfo.Extension, bytes.NewReader(fileBytes), options, webhookURL)
+	span.EndWithError(err)
+	if err != nil {
+		return false, err
+	}
+	t.logger(ctx).Debug("conversion to webhook started", "durationMs", logger.Millis(start))
+	return false, nil
+}
+
+// SavePDF caches pdf that converted for fileID and delivered by the PDF server to a webhook.
+// PDFs that exceed limits are not cached.
+func (t *TOPDF) SavePDF(ctx context.Context, fileID string, pdf io.Reader) (err error) {
+	ctx, span := t.tracer.Start(ctx, "topdf.SavePDF", "fileId", fileID)
+	defer func() { span.EndWithError(err) }()
+	fileInfo, aerr := t.mapi.GetFileInfo(fileID)
+	if aerr != nil {
+		return normalizeAppErr(aerr)
+	}
 	filePost, aerr := t.mapi.GetPost(fileInfo.PostId)
 	if aerr != nil {
-		return nil, normalizeAppErr(aerr)
+		return normalizeAppErr(aerr)
 	}
-	// check if the user has access to the channel where associated post submitted.
-	if _, aerr := t.mapi.GetChannelMember(filePost.ChannelId, userID); aerr != nil {
-		return nil, normalizeAppErr(aerr)
+	ref, err := t.cacheRefFor(fileInfo)
+	if err != nil {
+		return err
 	}
-	// if there is no PDF file cached, create it, cache and use its content.
-	if len(pid) == 0 {
-		data, err := t.createAndSavePDF(fileInfo, filePost)
-		if err != nil {
-			return nil, err
+	ctx = t.withFile(ctx, fileInfo, ref)
+	// hash of the file is normally saved when the conversion is started.
+	if ref.key == "" {
+		if _, err := t.getSource(ctx, fileInfo, &ref); err != nil {
+			return err
 		}
-		return ioutil.NopCloser(bytes.NewReader(data)), nil
 	}
-	// we have the PDF version in cache, directly return it back.
-	data, err := t.getCachedPDF(string(pid))
+	_, _, err = t.savePDF(ctx, filePost, ref, t.limitsFor(fileInfo.Extension), pdf)
+	return err
+}
+
+// WarmUp converts fileID to PDF and caches it when it's not cached yet. it's used to convert
+// files in background without a requesting user so access checks and rate limits are not applied.
+// converted is false when PDF is already cached.
+func (t *TOPDF) WarmUp(fileID string) (converted bool, err error) {
+	ctx, span := t.tracer.Start(context.Background(), "topdf.WarmUp", "fileId", fileID)
+	defer func() { span.EndWithError(err) }()
+	fileInfo, aerr := t.mapi.GetFileInfo(fileID)
+	if aerr != nil {
+		return false, normalizeAppErr(aerr)
+	}
+	filePost, aerr := t.mapi.GetPost(fileInfo.PostId)
+	if aerr != nil {
+		return false, normalizeAppErr(aerr)
+	}
+	ref, pid, err := t.lookUpCache(ctx, fileInfo)
 	if err != nil {
-		return nil, err
+		return false, err
+	}
+	ctx = t.withFile(ctx, fileInfo, ref)
+	if len(pid) != 0 {
+		return false, nil
+	}
+	limits := t.limitsFor(fileInfo.Extension)
+	if err := limits.checkSource(fileInfo); err != nil {
+		return false, err
+	}
+	if _, _, err := t.convert(ctx, fileInfo, filePost, ref, limits); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// allow checks if userID is allowed to make a conversion, conversions admitted by Admit are
+// already allowed.
+func (t *TOPDF) allow(ctx context.Context, userID string) error {
+	if t.limiter == nil || ctx.Value(admittedKey{}) != nil {
+		return nil
+	}
+	retryAfter, err := t.limiter.Allow(userID)
+	if err != nil {
+		return fmt.Errorf("cannot check rate limits: %s", err)
+	}
+	if retryAfter > 0 {
+		return &RateLimited{RetryAfter: retryAfter}
 	}
-	return ioutil.NopCloser(bytes.NewReader(data)), nil
+	return nil
 }
 
 // createAndSavePDF creates a PDF version of fileID and caches on Mattermost server and returns
-// the pdf data back.
-func (t *TOPDF) createAndSavePDF(fileInfo *model.FileInfo, filePost *model.Post) (pdf []byte, err error) {
+// the pdf data and the id of cached PDF file back. PDFs that exceed limits are not cached.
+// converted is called once PDF server responds with PDF.
+func (t *TOPDF) createAndSavePDF(ctx context.Context, fileInfo *model.FileInfo, filePost *model.Post, ref *cacheRef, limits Limits, converted func()) (pdf []byte, pid string, err error) {
+	ctx, span := t.tracer.Start(ctx, "topdf.createAndSavePDF")
+	defer func() { span.EndWithError(err) }()
 	// get file's content by fileID.
-	fileBytes, aerr := t.mapi.GetFile(fileInfo.Id)
-	if aerr != nil {
-		return nil, normalizeAppErr(aerr)
+	fileBytes, pid, err := t.getConvertible(ctx, fileInfo, ref)
+	if err != nil {
+		return nil, "", err
+	}
+	if pid != "" {
+		t.logger(ctx).Debug("PDF is cached for the same content", "pdfFileId", pid)
+		pdf, err = t.getCachedPDF(ctx, pid)
+		return pdf, pid, err
 	}
 	// convert file to PDF by using PDF server.
-	r, err := t.server.Convert(fileInfo.Name, fileInfo.Extension, bytes.NewReader(fileBytes))
+	start := time.Now()
+	sctx, sspan := t.tracer.Start(ctx, "pdfserver.Convert", "backend", ref.entry.Backend)
+	r, err := t.server.Convert(sctx, fileInfo.Name, fileInfo.Extension, bytes.NewReader(fileBytes), t.optionsFor(fileInfo.Extension))
+	sspan.EndWithError(err)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer r.Close()
-	data, err := ioutil.ReadAll(r)
+	converted()
+	t.logger(ctx).Debug("conversion started", "durationMs", logger.Millis(start))
+	return t.savePDF(ctx, filePost, *ref, limits, r)
+}
+
+// savePDF reads PDF of a file from r and caches it on Mattermost server for ref and returns
+// the pdf data and the id of cached PDF file back. PDFs that exceed limits are not cached.
+func (t *TOPDF) savePDF(ctx context.Context, filePost *model.Post, ref cacheRef, limits Limits, r io.Reader) (pdf []byte, pid string, err error) {
+	l := t.logger(ctx)
+	start := time.Now()
+	_, span := t.tracer.Start(ctx, "topdf.readPDF")
+	data, err := limits.readPDF(r)
+	span.SetAttributes("bytes", len(data))
+	span.EndWithError(err)
 	if err != nil {
-		return nil, err
+		return nil, "", err
+	}
+	l.Debug("PDF received", "bytes", len(data), "durationMs", logger.Millis(start))
+	// remove unsafe content before PDF is cached and served.
+	if t.sanitizer != nil {
+		start = time.Now()
+		_, span = t.tracer.Start(ctx, "topdf.sanitize")
+		var report sanitize.Report
+		data, report, err = t.sanitizer.Sanitize(data)
+		span.SetAttributes("bytes", len(data), "clean", report.Clean())
+		span.EndWithError(err)
+		if err != nil {
+			return nil, "", err
+		}
+		ref.entry.Sanitized = &report
+		l.Debug("PDF sanitized", "bytes", len(data), "clean", report.Clean(), "durationMs", logger.Millis(start))
 	}
 	// cache PDF file on Mattermost.
-	inf, aerr := t.mapi.UploadFile(data, filePost.ChannelId, "pdf")
+	start = time.Now()
+	pid, err = t.uploadPDF(ctx, filePost, ref, data)
 	if err != nil {
-		return nil, normalizeAppErr(aerr)
-	}
-	// save PDF file's id by associating it with fileID.
-	if aerr := t.mapi.KVSet(key(fileInfo.Id), []byte(inf.Id)); err != nil {
-		return nil, normalizeAppErr(aerr)
+		return nil, "", err
 	}
+	l.Debug("PDF cached", "pdfFileId", pid, "bytes", len(data), "durationMs", logger.Millis(start))
 	// return PDF file's content.
-	return data, nil
+	return data, pid, nil
+}
+
+// uploadPDF uploads pdf to the channel of filePost and saves its id to cache for ref.
+func (t *TOPDF) uploadPDF(ctx context.Context, filePost *model.Post, ref cacheRef, pdf []byte) (pid string, err error) {
+	_, span := t.tracer.Start(ctx, "topdf.uploadPDF", "bytes", len(pdf))
+	defer func() { span.EndWithError(err) }()
+	inf, aerr := t.mapi.UploadFile(pdf, filePost.ChannelId, ref.entry.Name)
+	if aerr != nil {
+		return "", normalizeAppErr(aerr)
+	}
+	pid = inf.Id
+	// Plugin API cannot upload files on behalf of users so uploaded files have no creator, the
+	// info of PDF file is copied once for the owner and only the owned copy is cached.
+	if t.owner != nil {
+		if owner := t.owner(); owner != "" {
+			ids, aerr := t.mapi.CopyFileInfos(owner, []string{pid})
+			if aerr != nil {
+				return "", normalizeAppErr(aerr)
+			}
+			pid = ids[0]
+		}
+	}
+	// save PDF file's id by associating it with the content of file, PDF server, conversion
+	// options and cache generation.
+	if err := t.setCached(ref, pid, filePost.ChannelId, len(pdf)); err != nil {
+		return "", err
+	}
+	return pid, nil
 }
 
 // getCachedPDF gets cached PDF data from file store.
-func (t *TOPDF) getCachedPDF(fileID string) (pdf []byte, err error) {
-	data, aerr := t.mapi.GetFile(string(fileID))
+func (t *TOPDF) getCachedPDF(ctx context.Context, fileID string) (pdf []byte, err error) {
+	start := time.Now()
+	_, span := t.tracer.Start(ctx, "topdf.getCachedPDF", "pdfFileId", fileID)
+	defer func() { span.EndWithError(err) }()
+	data, aerr := t.mapi.GetFile(fileID)
 	if aerr != nil {
 		return nil, normalizeAppErr(aerr)
 	}
+	span.SetAttributes("bytes", len(data))
+	t.logger(ctx).Debug("cached PDF fetched", "pdfFileId", fileID, "bytes", len(data), "durationMs", logger.Millis(start))
 	return data, nil
 }
 
-// key builds a KV key for fileID.
-func key(fileID string) string {
-	return toPDFPrefix + fileID
-}
-
 // normalize error normalizes Plugin API's errors.
 // please see this docs to know more about what this normalization do: https://golang.org/doc/faq#nil_error
 func normalizeAppErr(err *model.AppError) error {
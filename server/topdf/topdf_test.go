@@ -2,69 +2,126 @@ package topdf
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io/ioutil"
 	"testing"
+	"time"
 
+	"github.com/ilgooz/mattermost-plugin-topdf/server/audit"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver"
 	sMock "github.com/ilgooz/mattermost-plugin-topdf/server/topdf/pdfserver/mocks"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/trace"
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/memkv"
 	pMock "github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/mocks"
 	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
 
 func TestCheckServerStatusRunning(t *testing.T) {
 	serverMock := &sMock.Server{}
-	serverMock.On("Status").Once().Return(nil)
+	serverMock.On("Status", mock.Anything).Once().Return(nil)
 	app := New(nil, serverMock)
-	err := app.CheckServerStatus()
+	err := app.CheckServerStatus(context.Background())
 	require.NoError(t, err)
 	serverMock.AssertExpectations(t)
 }
 
 func TestCheckServerStatusError(t *testing.T) {
 	serverMock := &sMock.Server{}
-	serverMock.On("Status").Once().Return(errors.New("ops!"))
+	serverMock.On("Status", mock.Anything).Once().Return(errors.New("ops!"))
 	app := New(nil, serverMock)
-	err := app.CheckServerStatus()
+	err := app.CheckServerStatus(context.Background())
 	require.Equal(t, "ops!", err.Error())
 	serverMock.AssertExpectations(t)
 }
 
 func TestCheckServerConvertCached(t *testing.T) {
 	serverMock := &sMock.Server{}
-	apiMock := &pMock.API{}
-	apiMock.On("KVGet", "pdf:file-id").Once().Return([]byte("1"), nil)
-	apiMock.On("GetFileInfo", "file-id").Once().Return(&model.FileInfo{PostId: "2", Name: "3", Extension: "4"}, nil)
+	serverMock.On("Identity").Return("Test")
+	apiMock := memkv.New()
+	apiMock.KVSet("pdf:file-id", []byte("1"))
+	apiMock.On("GetFileInfo", "file-id").Once().Return(&model.FileInfo{Id: "file-id", PostId: "2", Name: "3", Extension: "4"}, nil)
 	apiMock.On("GetPost", "2").Once().Return(&model.Post{ChannelId: "5"}, nil)
-	apiMock.On("GetChannelMember", "5", "user-id").Once().Return(nil, nil)
+	apiMock.On("HasPermissionToChannel", "user-id", "5", model.PERMISSION_READ_CHANNEL).Once().Return(true)
 	apiMock.On("GetFile", "1").Once().Return([]byte{2}, nil)
 	app := New(apiMock, serverMock)
-	pdf, err := app.GetPDF("user-id", "file-id")
+	pdf, err := app.GetPDF(context.Background(), "user-id", "file-id", "")
 	require.NoError(t, err)
 	data, err := ioutil.ReadAll(pdf)
 	require.NoError(t, err)
 	require.Equal(t, []byte{2}, data)
+	require.Equal(t, "3.pdf", pdf.Name)
+	require.Equal(t, int64(1), pdf.Size)
+	serverMock.AssertExpectations(t)
+	apiMock.AssertExpectations(t)
+}
+
+func TestGetPDFTraced(t *testing.T) {
+	serverMock := &sMock.Server{}
+	serverMock.On("Identity").Return("Test")
+	apiMock := memkv.New()
+	apiMock.On("GetFileInfo", "file-id").Once().Return(&model.FileInfo{Id: "file-id", PostId: "2", Name: "3", Extension: "4"}, nil)
+	apiMock.On("GetPost", "2").Once().Return(&model.Post{ChannelId: "5"}, nil)
+	apiMock.On("HasPermissionToChannel", "user-id", "5", model.PERMISSION_READ_CHANNEL).Once().Return(true)
+	apiMock.On("GetFile", "file-id").Once().Return([]byte{3}, nil)
+	serverMock.On("Convert", mock.Anything, "3", "4", mock.Anything, pdfserver.ConvertOptions{}).Once().Return(ioutil.NopCloser(bytes.NewReader([]byte{6})), nil)
+	apiMock.On("UploadFile", []byte{6}, "5", "3.pdf").Once().Return(&model.FileInfo{Id: "7"}, nil)
+	exporter := trace.NewMemoryExporter()
+	app := New(apiMock, serverMock, TracerOption(trace.New(exporter)))
+	_, err := app.GetPDF(context.Background(), "user-id", "file-id", "")
+	require.NoError(t, err)
+
+	root, ok := exporter.Span("topdf.GetPDF")
+	require.True(t, ok)
+	require.False(t, root.ParentSpanID.IsValid())
+	require.Equal(t, false, root.Attribute("cacheHit"))
+	create, ok := exporter.Span("topdf.createAndSavePDF")
+	require.True(t, ok)
+	require.Equal(t, root.SpanID, create.ParentSpanID)
+	for _, name := range []string{"topdf.getSource", "pdfserver.Convert", "topdf.readPDF", "topdf.uploadPDF"} {
+		span, ok := exporter.Span(name)
+		require.True(t, ok, name)
+		require.Equal(t, root.TraceID, span.TraceID)
+		require.Equal(t, create.SpanID, span.ParentSpanID, name)
+	}
+	// the span of conversion is given to PDF server so it can trace its requests.
+	convert, _ := exporter.Span("pdfserver.Convert")
+	for _, call := range serverMock.Calls {
+		if call.Method == "Convert" {
+			ctx := call.Arguments.Get(0).(context.Context)
+			require.Equal(t, convert.SpanID, trace.FromContext(ctx).Data().SpanID)
+		}
+	}
 	serverMock.AssertExpectations(t)
 	apiMock.AssertExpectations(t)
 }
 
 func TestCheckServerConvertNonCached(t *testing.T) {
 	serverMock := &sMock.Server{}
-	apiMock := &pMock.API{}
-	apiMock.On("KVGet", "pdf:file-id").Once().Return([]byte{}, nil)
+	serverMock.On("Identity").Return("Test")
+	apiMock := memkv.New()
 	apiMock.On("GetFileInfo", "file-id").Once().Return(&model.FileInfo{Id: "file-id", PostId: "2", Name: "3", Extension: "4"}, nil)
 	apiMock.On("GetPost", "2").Once().Return(&model.Post{ChannelId: "5"}, nil)
-	apiMock.On("GetChannelMember", "5", "user-id").Once().Return(nil, nil)
+	apiMock.On("HasPermissionToChannel", "user-id", "5", model.PERMISSION_READ_CHANNEL).Once().Return(true)
 	apiMock.On("GetFile", "file-id").Once().Return([]byte{3}, nil)
-	serverMock.On("Convert", "3", "4", bytes.NewReader([]byte{3})).Once().Return(ioutil.NopCloser(bytes.NewReader([]byte{6})), nil)
-	apiMock.On("UploadFile", []byte{6}, "5", "pdf").Once().Return(&model.FileInfo{Id: "7"}, nil)
-	apiMock.On("KVSet", "pdf:file-id", []byte("7")).Once().Return(nil)
+	serverMock.On("Convert", mock.Anything, "3", "4", bytes.NewReader([]byte{3}), pdfserver.ConvertOptions{}).Once().Return(ioutil.NopCloser(bytes.NewReader([]byte{6})), nil)
+	apiMock.On("UploadFile", []byte{6}, "5", "3.pdf").Once().Return(&model.FileInfo{Id: "7"}, nil)
 	app := New(apiMock, serverMock)
-	pdf, err := app.GetPDF("user-id", "file-id")
+	pdf, err := app.GetPDF(context.Background(), "user-id", "file-id", "")
 	require.NoError(t, err)
 	data, err := ioutil.ReadAll(pdf)
 	require.NoError(t, err)
 	require.Equal(t, []byte{6}, data)
+	entry := requireCached(t, apiMock, "file-id", []byte{3}, "Test", "", 0)
+	require.Equal(t, "7", entry.PDFFileID)
+	require.Equal(t, "4", entry.Extension)
+	require.Equal(t, "5", entry.ChannelID)
+	require.Equal(t, int64(1), entry.Size)
+	// old format keys are not written anymore.
+	legacy, _ := apiMock.KVGet("pdf:file-id")
+	require.Empty(t, legacy)
 	serverMock.AssertExpectations(t)
 	apiMock.AssertExpectations(t)
 }
@@ -72,9 +129,198 @@ func TestCheckServerConvertNonCached(t *testing.T) {
 func TestCheckServerConvertUnauthorized(t *testing.T) {
 	serverMock := &sMock.Server{}
 	apiMock := &pMock.API{}
-	apiMock.On("KVGet", "pdf:pdf-id").Once().Return(nil, &model.AppError{})
+	apiMock.On("GetFileInfo", "pdf-id").Once().Return(nil, &model.AppError{})
 	app := New(apiMock, serverMock)
-	_, err := app.GetPDF("user-id", "pdf-id")
+	_, err := app.GetPDF(context.Background(), "user-id", "pdf-id", "")
+	require.Equal(t, ErrUnauthorizedUser, err)
+	apiMock.AssertExpectations(t)
+}
+
+func TestGetPDFAudited(t *testing.T) {
+	serverMock := &sMock.Server{}
+	apiMock := &pMock.API{}
+	auditor := &auditorMock{}
+	apiMock.On("GetFileInfo", "file-id").Once().Return(&model.FileInfo{PostId: "2", Name: "3", Extension: "4"}, nil)
+	apiMock.On("GetPost", "2").Once().Return(&model.Post{ChannelId: "5"}, nil)
+	apiMock.On("HasPermissionToChannel", "user-id", "5", model.PERMISSION_READ_CHANNEL).Once().Return(false)
+	apiMock.On("HasPermissionTo", "user-id", mock.Anything).Return(false)
+	app := New(apiMock, serverMock, AuditOption(auditor))
+	_, err := app.GetPDF(context.Background(), "user-id", "file-id", "10.0.0.1")
 	require.Equal(t, ErrUnauthorizedUser, err)
+	require.Equal(t, []audit.Event{{
+		UserID:    "user-id",
+		FileID:    "file-id",
+		ChannelID: "5",
+		Outcome:   audit.OutcomeUnauthorized,
+		ClientIP:  "10.0.0.1",
+	}}, auditor.events)
+	apiMock.AssertExpectations(t)
+}
+
+// auditorMock keeps recorded events in memory.
+type auditorMock struct {
+	events []audit.Event
+}
+
+func (a *auditorMock) Record(e audit.Event) error {
+	a.events = append(a.events, e)
+	return nil
+}
+
+func TestGetPDFRateLimited(t *testing.T) {
+	serverMock := &sMock.Server{}
+	serverMock.On("Identity").Return("Test")
+	apiMock := memkv.New()
+	limiter := &limiterMock{retryAfter: time.Second * 5}
+	apiMock.On("GetFileInfo", "file-id").Once().Return(&model.FileInfo{Id: "file-id", PostId: "2", Name: "3", Extension: "4"}, nil)
+	apiMock.On("GetPost", "2").Once().Return(&model.Post{ChannelId: "5"}, nil)
+	apiMock.On("HasPermissionToChannel", "user-id", "5", model.PERMISSION_READ_CHANNEL).Once().Return(true)
+	app := New(apiMock, serverMock, RateLimitOption(limiter))
+	_, err := app.GetPDF(context.Background(), "user-id", "file-id", "")
+	require.Equal(t, &RateLimited{RetryAfter: time.Second * 5}, err)
+	require.Equal(t, "too many conversion requests, retry after 5 seconds", err.Error())
+	require.Equal(t, []string{"user-id"}, limiter.users)
+	serverMock.AssertExpectations(t)
+	apiMock.AssertExpectations(t)
+}
+
+func TestGetPDFCachedNotRateLimited(t *testing.T) {
+	serverMock := &sMock.Server{}
+	serverMock.On("Identity").Return("Test")
+	apiMock := memkv.New()
+	limiter := &limiterMock{retryAfter: time.Second * 5}
+	apiMock.KVSet("pdf:file-id", []byte("1"))
+	apiMock.On("GetFileInfo", "file-id").Once().Return(&model.FileInfo{Id: "file-id", PostId: "2", Name: "3", Extension: "4"}, nil)
+	apiMock.On("GetPost", "2").Once().Return(&model.Post{ChannelId: "5"}, nil)
+	apiMock.On("HasPermissionToChannel", "user-id", "5", model.PERMISSION_READ_CHANNEL).Once().Return(true)
+	apiMock.On("GetFile", "1").Once().Return([]byte{2}, nil)
+	app := New(apiMock, serverMock, RateLimitOption(limiter))
+	_, err := app.GetPDF(context.Background(), "user-id", "file-id", "")
+	require.NoError(t, err)
+	require.Empty(t, limiter.users)
+	apiMock.AssertExpectations(t)
+}
+
+// limiterMock limits all requests with retryAfter.
+type limiterMock struct {
+	retryAfter time.Duration
+	users      []string
+}
+
+func (l *limiterMock) Allow(userID string) (time.Duration, error) {
+	l.users = append(l.users, userID)
+	return l.retryAfter, nil
+}
+
+func TestConvertToWebhook(t *testing.T) {
+	serverMock := &sMock.WebhookServer{}
+	serverMock.On("Identity").Return("Test")
+	apiMock := memkv.New()
+	apiMock.On("GetFileInfo", "file-id").Once().Return(&model.FileInfo{Id: "file-id", PostId: "2", Name: "3", Extension: "4"}, nil)
+	apiMock.On("GetPost", "2").Once().Return(&model.Post{ChannelId: "5"}, nil)
+	apiMock.On("HasPermissionToChannel", "user-id", "5", model.PERMISSION_READ_CHANNEL).Once().Return(true)
+	apiMock.On("GetFile", "file-id").Once().Return([]byte{3}, nil)
+	serverMock.On("ConvertToWebhook", mock.Anything, "3", "4", bytes.NewReader([]byte{3}), pdfserver.ConvertOptions{}, "http://webhook").Once().Return(nil)
+	app := New(apiMock, serverMock)
+	cached, err := app.ConvertToWebhook(context.Background(), "user-id", "file-id", "", "http://webhook")
+	require.NoError(t, err)
+	require.False(t, cached)
+	// hash of the file is saved for SavePDF().
+	hash, _ := apiMock.KVGet(hashPrefix + "file-id")
+	require.Equal(t, sourceHash([]byte{3}), string(hash))
+	serverMock.AssertExpectations(t)
+	apiMock.AssertExpectations(t)
+}
+
+func TestConvertToWebhookCached(t *testing.T) {
+	serverMock := &sMock.WebhookServer{}
+	serverMock.On("Identity").Return("Test")
+	apiMock := memkv.New()
+	apiMock.KVSet("pdf:file-id", []byte("1"))
+	apiMock.On("GetFileInfo", "file-id").Once().Return(&model.FileInfo{Id: "file-id", PostId: "2"}, nil)
+	apiMock.On("GetPost", "2").Once().Return(&model.Post{ChannelId: "5"}, nil)
+	apiMock.On("HasPermissionToChannel", "user-id", "5", model.PERMISSION_READ_CHANNEL).Once().Return(true)
+	app := New(apiMock, serverMock)
+	cached, err := app.ConvertToWebhook(context.Background(), "user-id", "file-id", "", "http://webhook")
+	require.NoError(t, err)
+	require.True(t, cached)
+	serverMock.AssertExpectations(t)
+	apiMock.AssertExpectations(t)
+}
+
+func TestConvertToWebhookNotSupported(t *testing.T) {
+	app := New(&pMock.API{}, &sMock.Server{})
+	_, err := app.ConvertToWebhook(context.Background(), "user-id", "file-id", "", "http://webhook")
+	require.Equal(t, ErrWebhookNotSupported, err)
+}
+
+func TestSavePDF(t *testing.T) {
+	serverMock := &sMock.WebhookServer{}
+	serverMock.On("Identity").Return("Test")
+	apiMock := memkv.New()
+	apiMock.KVSet(hashPrefix+"file-id", []byte(sourceHash([]byte{3})))
+	apiMock.On("GetFileInfo", "file-id").Once().Return(&model.FileInfo{Id: "file-id", PostId: "2", Name: "report.docx", Extension: "docx"}, nil)
+	apiMock.On("GetPost", "2").Once().Return(&model.Post{ChannelId: "5"}, nil)
+	apiMock.On("UploadFile", []byte{6}, "5", "report.pdf").Once().Return(&model.FileInfo{Id: "7"}, nil)
+	app := New(apiMock, serverMock)
+	require.NoError(t, app.SavePDF(context.Background(), "file-id", bytes.NewReader([]byte{6})))
+	entry := requireCached(t, apiMock, "file-id", []byte{3}, "Test", "", 0)
+	require.Equal(t, "7", entry.PDFFileID)
+	require.Equal(t, "report.pdf", entry.Name)
+	apiMock.AssertExpectations(t)
+}
+
+func TestSavePDFOwned(t *testing.T) {
+	serverMock := &sMock.WebhookServer{}
+	serverMock.On("Identity").Return("Test")
+	apiMock := memkv.New()
+	apiMock.KVSet(hashPrefix+"file-id", []byte(sourceHash([]byte{3})))
+	apiMock.On("GetFileInfo", "file-id").Once().Return(&model.FileInfo{Id: "file-id", PostId: "2", Name: "report.docx", Extension: "docx"}, nil)
+	apiMock.On("GetPost", "2").Once().Return(&model.Post{ChannelId: "5"}, nil)
+	apiMock.On("UploadFile", []byte{6}, "5", "report.pdf").Once().Return(&model.FileInfo{Id: "7"}, nil)
+	apiMock.On("CopyFileInfos", "bot-id", []string{"7"}).Once().Return([]string{"8"}, nil)
+	app := New(apiMock, serverMock, OwnerOption(func() string { return "bot-id" }))
+	require.NoError(t, app.SavePDF(context.Background(), "file-id", bytes.NewReader([]byte{6})))
+	// the copy owned by bot is cached.
+	entry := requireCached(t, apiMock, "file-id", []byte{3}, "Test", "", 0)
+	require.Equal(t, "8", entry.PDFFileID)
+	apiMock.AssertExpectations(t)
+}
+
+func TestPDFName(t *testing.T) {
+	for source, name := range map[string]string{
+		"report.docx":        "report.pdf",
+		"archive.tar.gz":     "archive.tar.pdf",
+		"notes":              "notes.pdf",
+		"rapor çalışma.xlsx": "rapor çalışma.pdf",
+		".docx":              "document.pdf",
+		"":                   "document.pdf",
+	} {
+		require.Equal(t, name, pdfName(source), source)
+	}
+}
+
+func TestWarmUp(t *testing.T) {
+	serverMock := &sMock.Server{}
+	serverMock.On("Identity").Return("Test")
+	apiMock := memkv.New()
+	apiMock.On("GetFileInfo", "file-id").Return(&model.FileInfo{Id: "file-id", PostId: "2", Name: "3", Extension: "4"}, nil)
+	apiMock.On("GetPost", "2").Return(&model.Post{ChannelId: "5"}, nil)
+	apiMock.On("GetFile", "file-id").Once().Return([]byte{3}, nil)
+	serverMock.On("Convert", mock.Anything, "3", "4", bytes.NewReader([]byte{3}), pdfserver.ConvertOptions{}).Once().Return(ioutil.NopCloser(bytes.NewReader([]byte{6})), nil)
+	apiMock.On("UploadFile", []byte{6}, "5", "3.pdf").Once().Return(&model.FileInfo{Id: "7"}, nil)
+	// rate limits are not applied to warm-ups.
+	limiter := &limiterMock{retryAfter: time.Second * 5}
+	app := New(apiMock, serverMock, RateLimitOption(limiter))
+	converted, err := app.WarmUp("file-id")
+	require.NoError(t, err)
+	require.True(t, converted)
+	require.Equal(t, "7", requireCached(t, apiMock, "file-id", []byte{3}, "Test", "", 0).PDFFileID)
+
+	converted, err = app.WarmUp("file-id")
+	require.NoError(t, err)
+	require.False(t, converted)
+	require.Empty(t, limiter.users)
+	serverMock.AssertExpectations(t)
 	apiMock.AssertExpectations(t)
 }
@@ -0,0 +1,41 @@
+package trace
+
+import "sync"
+
+// MemoryExporter keeps exported spans in memory, it's useful for tests.
+type MemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+// NewMemoryExporter creates a new MemoryExporter.
+func NewMemoryExporter() *MemoryExporter {
+	return &MemoryExporter{}
+}
+
+// Export keeps span.
+func (e *MemoryExporter) Export(span SpanData) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+}
+
+// Spans gets the exported spans in the order they're ended.
+func (e *MemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	spans := make([]SpanData, len(e.spans))
+	copy(spans, e.spans)
+	return spans
+}
+
+// Span gets the last exported span with name, ok is false when there is none.
+func (e *MemoryExporter) Span(name string) (span SpanData, ok bool) {
+	spans := e.Spans()
+	for i := len(spans) - 1; i >= 0; i-- {
+		if spans[i].Name == name {
+			return spans[i], true
+		}
+	}
+	return SpanData{}, false
+}
@@ -0,0 +1,287 @@
+package trace
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/logger"
+)
+
+const (
+	// otlpTracesPath is the path of OTLP/HTTP endpoint that receives spans.
+	otlpTracesPath = "/v1/traces"
+
+	// defaultServiceName is the name of service that exported spans belong to.
+	defaultServiceName = "mattermost-plugin-topdf"
+
+	// defaultBatchSize is the maximum number of spans exported in a request.
+	defaultBatchSize = 512
+
+	// defaultFlushInterval is the interval that spans are exported at.
+	defaultFlushInterval = 5 * time.Second
+
+	// otlpTimeout is the timeout of export requests.
+	otlpTimeout = 10 * time.Second
+)
+
+// queueSize is the maximum number of spans waiting to be exported, spans are dropped when the
+// collector cannot keep up.
+const queueSize = 4 * defaultBatchSize
+
+// OTLPExporter exports spans in batches to an OpenTelemetry collector over OTLP/HTTP with the
+// JSON encoding.
+type OTLPExporter struct {
+	// url is the address of collector's traces endpoint.
+	url string
+
+	// service is the name of service in exported spans.
+	service string
+
+	// flushInterval is the interval that spans are exported at.
+	flushInterval time.Duration
+
+	// log logs failed exports.
+	log *logger.Logger
+
+	client *http.Client
+	queue  chan SpanData
+	done   chan struct{}
+	closed chan struct{}
+	once   sync.Once
+}
+
+// NewOTLPExporter creates a new OTLPExporter that exports spans to the collector at endpoint,
+// like `http://localhost:4318`, and starts exporting in background. it should be closed once
+// it's no longer used.
+func NewOTLPExporter(endpoint string, options ...OTLPOption) *OTLPExporter {
+	e := &OTLPExporter{
+		url:           strings.TrimSuffix(endpoint, "/") + otlpTracesPath,
+		service:       defaultServiceName,
+		flushInterval: defaultFlushInterval,
+		client:        &http.Client{Timeout: otlpTimeout},
+		queue:         make(chan SpanData, queueSize),
+		done:          make(chan struct{}),
+		closed:        make(chan struct{}),
+	}
+	for _, o := range options {
+		o(e)
+	}
+	go e.run()
+	return e
+}
+
+// OTLPOption used to customize OTLPExporter defaults.
+type OTLPOption func(*OTLPExporter)
+
+// ServiceNameOption sets the name of service in exported spans.
+func ServiceNameOption(name string) OTLPOption {
+	return func(e *OTLPExporter) {
+		e.service = name
+	}
+}
+
+// FlushIntervalOption sets the interval that spans are exported at.
+func FlushIntervalOption(interval time.Duration) OTLPOption {
+	return func(e *OTLPExporter) {
+		e.flushInterval = interval
+	}
+}
+
+// LoggerOption sets the logger that logs failed exports.
+func LoggerOption(log *logger.Logger) OTLPOption {
+	return func(e *OTLPExporter) {
+		e.log = log
+	}
+}
+
+// Export queues span to be exported, it's dropped when the queue is full or e is closed.
+func (e *OTLPExporter) Export(span SpanData) {
+	select {
+	case <-e.done:
+	case e.queue <- span:
+	default:
+	}
+}
+
+// Close exports the queued spans and stops e.
+func (e *OTLPExporter) Close() {
+	e.once.Do(func() { close(e.done) })
+	<-e.closed
+}
+
+// run exports queued spans in batches until e is closed.
+func (e *OTLPExporter) run() {
+	defer close(e.closed)
+	ticker := time.NewTicker(e.flushInterval)
+	defer ticker.Stop()
+	var batch []SpanData
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			e.log.Warn("cannot export spans", "spans", len(batch), "err", err.Error())
+		}
+		batch = nil
+	}
+	for {
+		select {
+		case span := <-e.queue:
+			if batch = append(batch, span); len(batch) >= defaultBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.done:
+			for {
+				select {
+				case span := <-e.queue:
+					batch = append(batch, span)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// send exports spans to collector.
+func (e *OTLPExporter) send(spans []SpanData) error {
+	data, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector responded with %d status code", resp.StatusCode)
+	}
+	return nil
+}
+
+// request creates an OTLP export request for spans.
+func (e *OTLPExporter) request(spans []SpanData) otlpRequest {
+	ospans := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		o := otlpSpan{
+			TraceID:           s.TraceID.String(),
+			SpanID:            s.SpanID.String(),
+			Name:              s.Name,
+			Kind:              int(s.Kind),
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attributes),
+		}
+		if s.ParentSpanID.IsValid() {
+			o.ParentSpanID = s.ParentSpanID.String()
+		}
+		if s.Error != "" {
+			o.Status = &otlpStatus{Code: otlpStatusError, Message: s.Error}
+		}
+		ospans[i] = o
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: otlpAttributes([]Attribute{{Key: "service.name", Value: e.service}})},
+		ScopeSpans: []otlpScopeSpans{{
+			Scope: otlpScope{Name: defaultServiceName},
+			Spans: ospans,
+		}},
+	}}}
+}
+
+// otlpAttributes converts attrs to OTLP attributes.
+func otlpAttributes(attrs []Attribute) []otlpAttribute {
+	oattrs := make([]otlpAttribute, len(attrs))
+	for i, a := range attrs {
+		oattrs[i] = otlpAttribute{Key: a.Key, Value: otlpValue(a.Value)}
+	}
+	return oattrs
+}
+
+// otlpValue converts v to an OTLP attribute value, types other than strings, integers, floats and
+// booleans are formatted as strings.
+func otlpValue(v interface{}) otlpAnyValue {
+	switch v := v.(type) {
+	case string:
+		return otlpAnyValue{StringValue: &v}
+	case bool:
+		return otlpAnyValue{BoolValue: &v}
+	case int:
+		s := strconv.FormatInt(int64(v), 10)
+		return otlpAnyValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return otlpAnyValue{IntValue: &s}
+	case float64:
+		return otlpAnyValue{DoubleValue: &v}
+	default:
+		s := fmt.Sprint(v)
+		return otlpAnyValue{StringValue: &s}
+	}
+}
+
+// otlpStatusError is the status code of failed spans.
+const otlpStatusError = 2
+
+// types below are the JSON encoding of OTLP's ExportTraceServiceRequest.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
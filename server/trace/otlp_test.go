@@ -0,0 +1,58 @@
+package trace
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOTLPExporter(t *testing.T) {
+	requests := make(chan otlpRequest, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/traces", r.URL.Path)
+		require.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		var req otlpRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		requests <- req
+	}))
+	defer ts.Close()
+	exporter := NewOTLPExporter(ts.URL+"/", ServiceNameOption("test"), FlushIntervalOption(time.Hour))
+	tracer := New(exporter)
+	ctx, root := tracer.Start(context.Background(), "root")
+	_, child := Start(ctx, "child", "bytes", 3)
+	child.EndWithError(errors.New("failed"))
+	root.End()
+	// spans are exported on close even if flush interval is not passed.
+	exporter.Close()
+	exporter.Close()
+
+	req := <-requests
+	require.Len(t, req.ResourceSpans, 1)
+	require.Equal(t, "service.name", req.ResourceSpans[0].Resource.Attributes[0].Key)
+	require.Equal(t, "test", *req.ResourceSpans[0].Resource.Attributes[0].Value.StringValue)
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+	require.Equal(t, "child", spans[0].Name)
+	require.Equal(t, spans[1].SpanID, spans[0].ParentSpanID)
+	require.Equal(t, spans[1].TraceID, spans[0].TraceID)
+	require.Len(t, spans[0].TraceID, 32)
+	require.Equal(t, "3", *spans[0].Attributes[0].Value.IntValue)
+	require.Equal(t, &otlpStatus{Code: otlpStatusError, Message: "failed"}, spans[0].Status)
+	require.Empty(t, spans[1].ParentSpanID)
+	require.Nil(t, spans[1].Status)
+
+	// spans are dropped once exporter is closed.
+	_, span := tracer.Start(context.Background(), "late")
+	span.End()
+	select {
+	case <-requests:
+		t.Fatal("span exported after close")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,275 @@
+// Package trace records spans of operations so the time spent in each stage of a request can be
+// seen. spans are carried in contexts, propagated to other services with W3C Trace Context
+// headers and exported in OpenTelemetry's format.
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// traceparentHeader is the W3C Trace Context header that propagates spans to other services.
+const traceparentHeader = "traceparent"
+
+// TraceID identifies a trace, all spans of a request share the same TraceID.
+type TraceID [16]byte
+
+// String gets the hex representation of id.
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanID identifies a span in a trace.
+type SpanID [8]byte
+
+// String gets the hex representation of id.
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid checks if id is set.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// Kind is the kind of span.
+type Kind int
+
+// Kinds of spans, values are the same with OpenTelemetry's.
+const (
+	// KindInternal is an operation in the plugin.
+	KindInternal Kind = 1
+
+	// KindServer is a request received by the plugin.
+	KindServer Kind = 2
+
+	// KindClient is a request made by the plugin to another service.
+	KindClient Kind = 3
+)
+
+// Attribute is a key/value pair that describes a span.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// SpanData is a finished span.
+type SpanData struct {
+	// Name is the name of operation.
+	Name string
+
+	// Kind is the kind of span.
+	Kind Kind
+
+	// TraceID is the trace that span belongs to.
+	TraceID TraceID
+
+	// SpanID identifies span.
+	SpanID SpanID
+
+	// ParentSpanID is the parent of span, it's not valid for root spans.
+	ParentSpanID SpanID
+
+	// Start and End are the times that operation started and ended at.
+	Start time.Time
+	End   time.Time
+
+	// Attributes describes span.
+	Attributes []Attribute
+
+	// Error is the error that operation failed with, it's empty for successful operations.
+	Error string
+}
+
+// Attribute gets the value of attribute with key, it's nil when there is none.
+func (d SpanData) Attribute(key string) interface{} {
+	for _, a := range d.Attributes {
+		if a.Key == key {
+			return a.Value
+		}
+	}
+	return nil
+}
+
+// Exporter exports finished spans.
+type Exporter interface {
+	// Export exports span, it shouldn't block.
+	Export(span SpanData)
+}
+
+// Tracer creates spans and exports them once they're ended.
+// a nil Tracer only creates spans that are children of the ones in contexts.
+type Tracer struct {
+	// exporter exports ended spans.
+	exporter Exporter
+}
+
+// New creates a new Tracer that exports spans with exporter.
+func New(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// Start starts a span with name and attributes in keyValuePairs. span is a child of the one
+// carried by ctx if there is any, otherwise it's the root of a new trace. returned context
+// carries span so stages of operation can have their own spans.
+// span is nil when neither t nor ctx has a tracer and nil spans can be used as no-ops.
+func (t *Tracer) Start(ctx context.Context, name string, keyValuePairs ...interface{}) (context.Context, *Span) {
+	parent := FromContext(ctx)
+	tracer := t
+	if parent != nil {
+		tracer = parent.tracer
+	}
+	if tracer == nil {
+		return ctx, nil
+	}
+	s := &Span{
+		tracer: tracer,
+		data: SpanData{
+			Name:       name,
+			Kind:       KindInternal,
+			SpanID:     newSpanID(),
+			Start:      time.Now(),
+			Attributes: attributes(keyValuePairs),
+		},
+	}
+	if parent != nil {
+		s.data.TraceID = parent.data.TraceID
+		s.data.ParentSpanID = parent.data.SpanID
+	} else {
+		s.data.TraceID = newTraceID()
+	}
+	return NewContext(ctx, s), s
+}
+
+// Start starts a span that is a child of the one carried by ctx, see Tracer.Start.
+func Start(ctx context.Context, name string, keyValuePairs ...interface{}) (context.Context, *Span) {
+	return (*Tracer)(nil).Start(ctx, name, keyValuePairs...)
+}
+
+// Span is an operation in a trace.
+// all methods of a nil Span are no-ops.
+type Span struct {
+	// tracer exports span once it's ended.
+	tracer *Tracer
+
+	// mu protects data since attributes can be set from multiple goroutines.
+	mu   sync.Mutex
+	data SpanData
+
+	// ended is true once span is ended.
+	ended bool
+}
+
+// Data gets a copy of span's data.
+func (s *Span) Data() SpanData {
+	if s == nil {
+		return SpanData{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data
+}
+
+// SetKind sets the kind of span, spans are internal by default.
+func (s *Span) SetKind(kind Kind) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Kind = kind
+}
+
+// SetAttributes adds attributes in keyValuePairs to span.
+func (s *Span) SetAttributes(keyValuePairs ...interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Attributes = append(s.data.Attributes, attributes(keyValuePairs)...)
+}
+
+// SetError marks span as failed with err, nil errors are ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Error = err.Error()
+}
+
+// End ends span and exports it, calls after the first one are ignored.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+	s.tracer.exporter.Export(data)
+}
+
+// EndWithError ends span after marking it as failed with err when it's not nil. it's handy to
+// end spans in defers of functions with named error results.
+func (s *Span) EndWithError(err error) {
+	s.SetError(err)
+	s.End()
+}
+
+// contextKey is the key of Span in contexts.
+type contextKey struct{}
+
+// NewContext returns a copy of ctx that carries s.
+func NewContext(ctx context.Context, s *Span) context.Context {
+	return context.WithValue(ctx, contextKey{}, s)
+}
+
+// FromContext gets the Span carried by ctx, it's nil when there is none.
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(contextKey{}).(*Span)
+	return s
+}
+
+// Inject sets trace headers of the span carried by ctx to header so the service that receives
+// them can continue the trace. header is not changed when there is no span.
+func Inject(ctx context.Context, header http.Header) {
+	s := FromContext(ctx)
+	if s == nil {
+		return
+	}
+	header.Set(traceparentHeader, fmt.Sprintf("00-%s-%s-01", s.data.TraceID, s.data.SpanID))
+}
+
+// attributes creates attributes from keyValuePairs, a key without a value is ignored.
+func attributes(keyValuePairs []interface{}) []Attribute {
+	attrs := make([]Attribute, 0, len(keyValuePairs)/2)
+	for i := 0; i+1 < len(keyValuePairs); i += 2 {
+		attrs = append(attrs, Attribute{Key: fmt.Sprint(keyValuePairs[i]), Value: keyValuePairs[i+1]})
+	}
+	return attrs
+}
+
+// newTraceID generates a random TraceID.
+func newTraceID() (id TraceID) {
+	rand.Read(id[:])
+	return id
+}
+
+// newSpanID generates a random SpanID.
+func newSpanID() (id SpanID) {
+	rand.Read(id[:])
+	return id
+}
@@ -0,0 +1,54 @@
+package trace
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStart(t *testing.T) {
+	exporter := NewMemoryExporter()
+	tracer := New(exporter)
+	ctx, root := tracer.Start(context.Background(), "root", "a", 1)
+	_, child := Start(ctx, "child")
+	child.SetKind(KindClient)
+	child.SetAttributes("b", "2", "ignored")
+	child.EndWithError(errors.New("failed"))
+	root.End()
+	root.End()
+
+	spans := exporter.Spans()
+	require.Len(t, spans, 2)
+	c, r := spans[0], spans[1]
+	require.Equal(t, "child", c.Name)
+	require.Equal(t, KindClient, c.Kind)
+	require.Equal(t, r.TraceID, c.TraceID)
+	require.Equal(t, r.SpanID, c.ParentSpanID)
+	require.Equal(t, []Attribute{{Key: "b", Value: "2"}}, c.Attributes)
+	require.Equal(t, "failed", c.Error)
+	require.Equal(t, "root", r.Name)
+	require.Equal(t, KindInternal, r.Kind)
+	require.False(t, r.ParentSpanID.IsValid())
+	require.Equal(t, 1, r.Attribute("a"))
+	require.False(t, r.End.Before(r.Start))
+}
+
+func TestStartWithoutTracer(t *testing.T) {
+	ctx, span := Start(context.Background(), "noop")
+	require.Nil(t, span)
+	span.SetAttributes("a", 1)
+	span.EndWithError(errors.New("failed"))
+	header := http.Header{}
+	Inject(ctx, header)
+	require.Empty(t, header)
+}
+
+func TestInject(t *testing.T) {
+	ctx, span := New(NewMemoryExporter()).Start(context.Background(), "root")
+	header := http.Header{}
+	Inject(ctx, header)
+	require.Equal(t, "00-"+span.data.TraceID.String()+"-"+span.data.SpanID.String()+"-01", header.Get("traceparent"))
+}
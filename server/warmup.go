@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/warmup"
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// defaultWarmUpPeriod is the default period of posts that walked by warm-ups.
+const defaultWarmUpPeriod = 30 * 24 * time.Hour
+
+// warmUpCommandUsage is the usage of warmup slash command.
+const warmUpCommandUsage = "usage: /topdf warmup start|status|stop|resume"
+
+// handOverWarmUp stops the warm-up running with old components, if any, and continues it with
+// the new ones when this node is the leader of cluster.
+func (p *Plugin) handOverWarmUp(old, comps *components) {
+	if old != nil && old.warmer != nil {
+		old.warmer.Close()
+	}
+	if err := comps.warmer.ResumeInterrupted(); err != nil {
+		p.API.LogError("cannot resume warm-up", "err", err.Error())
+	}
+}
+
+// executeWarmUpCommand executes warmup slash command with params and returns its output.
+func (p *Plugin) executeWarmUpCommand(args *model.CommandArgs, params []string) string {
+	if !p.isAdmin(args.UserId) {
+		return errForbidden.Error()
+	}
+	if len(params) == 0 {
+		return warmUpCommandUsage
+	}
+	warmer := p.load().warmer
+	var (
+		progress *warmup.Progress
+		err      error
+	)
+	switch params[0] {
+	case "start":
+		r, perr := p.parseWarmUpRequest(params[1:])
+		if perr != nil {
+			return perr.Error()
+		}
+		progress, err = warmer.Start(args.UserId, r)
+	case "status":
+		progress, err = warmer.Status()
+	case "stop":
+		progress, err = warmer.Stop()
+	case "resume":
+		progress, err = warmer.Resume()
+	default:
+		return warmUpCommandUsage
+	}
+	switch err {
+	case nil:
+		return formatWarmUpProgress(progress)
+	case warmup.ErrNotFound, warmup.ErrRunning, warmup.ErrNoChannels:
+		return err.Error()
+	default:
+		p.logError(context.Background(), "cannot execute warm-up command", err, "command", params[0])
+		return "cannot execute warm-up command: " + err.Error()
+	}
+}
+
+// parseWarmUpRequest parses params of warm-up start command as a warm-up request.
+// teams are given with their names and channels with their ids, since and until are durations
+// before now.
+func (p *Plugin) parseWarmUpRequest(params []string) (r warmup.Request, err error) {
+	now := time.Now()
+	since, until := defaultWarmUpPeriod, time.Duration(0)
+	for key, value := range parseCommandParams(params) {
+		switch key {
+		case "team":
+			for _, name := range strings.Split(value, ",") {
+				team, aerr := p.API.GetTeamByName(name)
+				if aerr != nil {
+					return r, fmt.Errorf("team %s not found", name)
+				}
+				r.TeamIDs = append(r.TeamIDs, team.Id)
+			}
+		case "channel":
+			r.ChannelIDs = append(r.ChannelIDs, strings.Split(value, ",")...)
+		case "since", "until":
+			d, err := time.ParseDuration(value)
+			if err != nil || d < 0 {
+				return r, fmt.Errorf("invalid %s %q, it must be a duration like 720h", key, value)
+			}
+			if key == "since" {
+				since = d
+			} else {
+				until = d
+			}
+		default:
+			return r, fmt.Errorf("unknown parameter %q\n%s", key, commandHelp)
+		}
+	}
+	if until >= since {
+		return r, fmt.Errorf("until must be more recent than since")
+	}
+	r.Since = now.Add(-since).UnixNano() / int64(time.Millisecond)
+	r.Until = now.Add(-until).UnixNano() / int64(time.Millisecond)
+	return r, nil
+}
+
+// formatWarmUpProgress formats p for slash command output.
+func formatWarmUpProgress(p *warmup.Progress) string {
+	channel := p.ChannelIndex + 1
+	if channel > len(p.Channels) {
+		channel = len(p.Channels)
+	}
+	text := fmt.Sprintf("Warm-up `%s` is %s: channel %d of %d, %d posts walked, %d files converted, %d already cached, %d skipped, %d failed.",
+		p.ID, p.State, channel, len(p.Channels), p.Posts, p.Converted, p.Cached, p.Skipped, p.Failed)
+	if p.Error != "" {
+		text += " error: " + p.Error
+	}
+	return text
+}
@@ -0,0 +1,549 @@
+// Package warmup converts attachments of historical posts to PDFs in background so they're
+// served from cache when they're accessed. warm-ups are resumable, their progress and cursor
+// are kept in Mattermost's KV store.
+package warmup
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin"
+)
+
+const (
+	// progressKey is the KV key of the progress of last warm-up.
+	progressKey = "warmup:progress"
+
+	// defaultRatePerMinute is the default number of conversions made per minute.
+	defaultRatePerMinute = 6
+
+	// defaultPageSize is the default number of posts fetched at once.
+	defaultPageSize = 100
+
+	// maxChannelsPerTeam is the maximum number of public channels walked for a team.
+	maxChannelsPerTeam = 10000
+)
+
+var (
+	// ErrRunning returned when a warm-up is started while another one is running.
+	ErrRunning = errors.New("a warm-up is already running")
+
+	// ErrNotFound returned when there is no warm-up to resume or stop.
+	ErrNotFound = errors.New("no warm-up found")
+
+	// ErrNoChannels returned when a warm-up is requested without any teams or channels.
+	ErrNoChannels = errors.New("at least one team or channel is required")
+)
+
+// State is the state of a warm-up.
+type State string
+
+// States of warm-ups.
+const (
+	// StateRunning is the state of warm-ups that walking posts. warm-ups that are interrupted by
+	// a restart keep this state until they're resumed.
+	StateRunning State = "running"
+
+	// StateDone is the state of warm-ups that walked all posts.
+	StateDone State = "done"
+
+	// StateFailed is the state of warm-ups that stopped with an error, they can be resumed.
+	StateFailed State = "failed"
+
+	// StateStopped is the state of warm-ups that stopped by an admin, they can be resumed.
+	StateStopped State = "stopped"
+)
+
+// Request is a warm-up request. times are in milliseconds since epoch.
+type Request struct {
+	// TeamIDs are the teams whose public channels are walked.
+	TeamIDs []string `json:"teamIds,omitempty"`
+
+	// ChannelIDs are the channels that walked in addition to teams' channels.
+	ChannelIDs []string `json:"channelIds,omitempty"`
+
+	// Since is the creation time of oldest posts that walked.
+	Since int64 `json:"since"`
+
+	// Until is the creation time of newest posts that walked, zero is the time when warm-up is started.
+	Until int64 `json:"until"`
+}
+
+// Progress is the progress of a warm-up.
+type Progress struct {
+	// ID is the unique id of warm-up.
+	ID string `json:"id"`
+
+	// Request is the request of warm-up.
+	Request
+
+	// UserID is the admin who started the warm-up.
+	UserID string `json:"userId"`
+
+	// State is the current state of warm-up.
+	State State `json:"state"`
+
+	// Error is the error message of failed warm-ups.
+	Error string `json:"error,omitempty"`
+
+	// Channels are all channels walked.
+	Channels []string `json:"channels"`
+
+	// ChannelIndex and BeforePostID are the cursor of warm-up: posts of the channel at
+	// ChannelIndex that created before the post with BeforePostID are walked next.
+	ChannelIndex int    `json:"channelIndex"`
+	BeforePostID string `json:"beforePostId,omitempty"`
+
+	// Posts is the number of walked posts.
+	Posts int `json:"posts"`
+
+	// Converted is the number of files converted to PDF.
+	Converted int `json:"converted"`
+
+	// Cached is the number of files that already had cached PDFs.
+	Cached int `json:"cached"`
+
+	// Skipped is the number of files that cannot be converted because of their types.
+	Skipped int `json:"skipped"`
+
+	// Failed is the number of files failed to be converted.
+	Failed int `json:"failed"`
+
+	// StartedAt, UpdatedAt and FinishedAt are the times of warm-up in milliseconds since epoch.
+	StartedAt  int64 `json:"startedAt"`
+	UpdatedAt  int64 `json:"updatedAt"`
+	FinishedAt int64 `json:"finishedAt,omitempty"`
+}
+
+// Converter converts files to PDFs in background.
+type Converter interface {
+	// WarmUp converts fileID to PDF when it's not cached yet, converted is false if it's cached.
+	WarmUp(fileID string) (converted bool, err error)
+}
+
+// Warmer runs warm-ups.
+type Warmer struct {
+	// mapi is Mattermost's Plugin API.
+	mapi plugin.API
+
+	// converter converts files to PDFs.
+	converter Converter
+
+	// supported checks if files with an extension can be converted, all files are converted when it's nil.
+	supported func(extension string) bool
+
+	// interval is the duration waited after each conversion to throttle them.
+	interval time.Duration
+
+	// pageSize is the number of posts fetched at once.
+	pageSize int
+
+	// leader checks if this node runs scheduled work of the cluster, warm-ups are run on all
+	// nodes when it's nil.
+	leader func() bool
+
+	// after is replaced in tests.
+	after func(time.Duration) <-chan time.Time
+
+	mu sync.Mutex
+	// stop is closed to stop the running warm-up, it's nil when there is none.
+	stop chan struct{}
+	// done is closed when the running warm-up is stopped.
+	done chan struct{}
+}
+
+// Option used to customize Warmer defaults.
+type Option func(*Warmer)
+
+// RateOption sets the maximum number of conversions made per minute.
+func RateOption(perMinute int) Option {
+	return func(w *Warmer) {
+		w.interval = time.Minute / time.Duration(perMinute)
+	}
+}
+
+// SupportedOption sets a check for file extensions that can be converted, files with other
+// extensions are skipped.
+func SupportedOption(supported func(extension string) bool) Option {
+	return func(w *Warmer) {
+		w.supported = supported
+	}
+}
+
+// LeaderOption sets a check for the node that runs warm-ups in a cluster. warm-ups started
+// on other nodes are only saved, the leader picks them up with ResumeInterrupted().
+func LeaderOption(leader func() bool) Option {
+	return func(w *Warmer) {
+		w.leader = leader
+	}
+}
+
+// New creates a new Warmer that converts files with converter by using mapi.
+func New(mapi plugin.API, converter Converter, options ...Option) *Warmer {
+	w := &Warmer{
+		mapi:      mapi,
+		converter: converter,
+		interval:  time.Minute / defaultRatePerMinute,
+		pageSize:  defaultPageSize,
+		after:     time.After,
+	}
+	for _, o := range options {
+		o(w)
+	}
+	return w
+}
+
+// Start starts a warm-up for r by userID in background and returns its initial progress.
+func (w *Warmer) Start(userID string, r Request) (*Progress, error) {
+	if len(r.TeamIDs) == 0 && len(r.ChannelIDs) == 0 {
+		return nil, ErrNoChannels
+	}
+	if w.isRunning() {
+		return nil, ErrRunning
+	}
+	current, err := w.Status()
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	if current != nil && current.State == StateRunning {
+		return nil, ErrRunning
+	}
+	now := model.GetMillis()
+	if r.Until == 0 {
+		r.Until = now
+	}
+	channels, err := w.channels(r)
+	if err != nil {
+		return nil, err
+	}
+	p := &Progress{
+		ID:        model.NewId(),
+		Request:   r,
+		UserID:    userID,
+		State:     StateRunning,
+		Channels:  channels,
+		StartedAt: now,
+	}
+	if err := w.save(p); err != nil {
+		return nil, err
+	}
+	if err := w.runIfLeader(*p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Resume resumes the last warm-up from its cursor if it's not done.
+// it's also used to continue warm-ups that interrupted by a restart.
+func (w *Warmer) Resume() (*Progress, error) {
+	if w.isRunning() {
+		return nil, ErrRunning
+	}
+	p, err := w.Status()
+	if err != nil {
+		return nil, err
+	}
+	if p.State == StateDone {
+		return nil, ErrNotFound
+	}
+	p.State = StateRunning
+	p.Error = ""
+	p.FinishedAt = 0
+	if err := w.save(p); err != nil {
+		return nil, err
+	}
+	if err := w.runIfLeader(*p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ResumeInterrupted resumes the last warm-up if it's interrupted while running, like by a restart
+// or a configuration change. stopped and failed warm-ups are not resumed and nothing is resumed
+// when this node is not the leader.
+func (w *Warmer) ResumeInterrupted() error {
+	if w.isRunning() || w.leader != nil && !w.leader() {
+		return nil
+	}
+	p, err := w.Status()
+	if err == ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if p.State != StateRunning {
+		return nil
+	}
+	_, err = w.Resume()
+	return err
+}
+
+// Stop stops the running warm-up and marks it as stopped, it can be resumed later.
+func (w *Warmer) Stop() (*Progress, error) {
+	w.Close()
+	p, err := w.Status()
+	if err != nil {
+		return nil, err
+	}
+	if p.State != StateRunning {
+		return nil, ErrNotFound
+	}
+	p.State = StateStopped
+	if err := w.save(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Close stops the running warm-up in this process without changing its state so it's resumed
+// with Resume() later. it waits until the warm-up is stopped.
+func (w *Warmer) Close() {
+	w.mu.Lock()
+	stop, done := w.stop, w.done
+	w.stop = nil
+	w.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
+
+// Status gets the progress of last warm-up.
+func (w *Warmer) Status() (*Progress, error) {
+	data, aerr := w.mapi.KVGet(progressKey)
+	if aerr != nil {
+		return nil, aerr
+	}
+	if len(data) == 0 {
+		return nil, ErrNotFound
+	}
+	var p Progress
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// isRunning checks if a warm-up is running in this process.
+func (w *Warmer) isRunning() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.stop != nil
+}
+
+// runIfLeader runs p if this node is the leader of cluster.
+func (w *Warmer) runIfLeader(p Progress) error {
+	if w.leader != nil && !w.leader() {
+		return nil
+	}
+	return w.run(p)
+}
+
+// run walks posts of p in background.
+func (w *Warmer) run(p Progress) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.stop != nil {
+		return ErrRunning
+	}
+	w.stop = make(chan struct{})
+	w.done = make(chan struct{})
+	go w.walk(&p, w.stop, w.done)
+	return nil
+}
+
+// walk walks posts of p from its cursor and converts their files until all channels are walked
+// or stop is closed. done is closed when walking is finished.
+func (w *Warmer) walk(p *Progress, stop, done chan struct{}) {
+	defer close(done)
+	defer func() {
+		w.mu.Lock()
+		if w.done == done {
+			w.stop = nil
+		}
+		w.mu.Unlock()
+	}()
+	for p.ChannelIndex < len(p.Channels) {
+		finished, err := w.walkPage(p, stop)
+		if err == errStopped {
+			return
+		}
+		if err != nil {
+			p.State = StateFailed
+			p.Error = err.Error()
+			p.FinishedAt = model.GetMillis()
+			w.saveIfCurrent(p)
+			return
+		}
+		if finished {
+			p.ChannelIndex++
+			p.BeforePostID = ""
+		}
+		if !w.saveIfCurrent(p) {
+			return
+		}
+	}
+	p.State = StateDone
+	p.FinishedAt = model.GetMillis()
+	w.saveIfCurrent(p)
+}
+
+// errStopped returned while walking posts when warm-up is stopped.
+var errStopped = errors.New("warm-up is stopped")
+
+// walkPage walks the next page of posts in current channel of p and moves its cursor.
+// finished is true when there are no more posts to walk in the channel.
+func (w *Warmer) walkPage(p *Progress, stop chan struct{}) (finished bool, err error) {
+	channelID := p.Channels[p.ChannelIndex]
+	var (
+		list *model.PostList
+		aerr *model.AppError
+	)
+	if p.BeforePostID == "" {
+		list, aerr = w.mapi.GetPostsForChannel(channelID, 0, w.pageSize)
+	} else {
+		list, aerr = w.mapi.GetPostsBefore(channelID, p.BeforePostID, 0, w.pageSize)
+	}
+	if aerr != nil {
+		return false, aerr
+	}
+	// posts are ordered from newest to oldest.
+	for _, id := range list.Order {
+		post, ok := list.Posts[id]
+		if !ok {
+			continue
+		}
+		if post.CreateAt < p.Since {
+			return true, nil
+		}
+		if post.CreateAt > p.Until || post.DeleteAt != 0 {
+			continue
+		}
+		p.Posts++
+		for _, fileID := range post.FileIds {
+			if err := w.warmUp(p, fileID, stop); err != nil {
+				return false, err
+			}
+		}
+	}
+	if len(list.Order) == 0 {
+		return true, nil
+	}
+	p.BeforePostID = list.Order[len(list.Order)-1]
+	return len(list.Order) < w.pageSize, nil
+}
+
+// warmUp converts fileID if it's supported and not cached yet, conversions are throttled.
+func (w *Warmer) warmUp(p *Progress, fileID string, stop chan struct{}) error {
+	select {
+	case <-stop:
+		return errStopped
+	default:
+	}
+	if w.supported != nil {
+		info, aerr := w.mapi.GetFileInfo(fileID)
+		if aerr != nil {
+			// a file that cannot be looked up does not fail the warm-up either.
+			p.Failed++
+			w.mapi.LogWarn("cannot get file info to warm up PDF", "fileId", fileID, "err", aerr.Error())
+			return nil
+		}
+		if !w.supported(info.Extension) {
+			p.Skipped++
+			return nil
+		}
+	}
+	converted, err := w.converter.WarmUp(fileID)
+	switch {
+	case err != nil:
+		// a single file does not fail the warm-up.
+		p.Failed++
+		w.mapi.LogWarn("cannot warm up PDF", "fileId", fileID, "err", err.Error())
+	case converted:
+		p.Converted++
+	default:
+		p.Cached++
+		// no conversion is made so there is nothing to throttle.
+		return nil
+	}
+	select {
+	case <-stop:
+		return errStopped
+	case <-w.after(w.interval):
+		return nil
+	}
+}
+
+// channels gets ids of all channels to walk for r.
+func (w *Warmer) channels(r Request) (channelIDs []string, err error) {
+	seen := make(map[string]bool)
+	add := func(id string) {
+		if !seen[id] {
+			seen[id] = true
+			channelIDs = append(channelIDs, id)
+		}
+	}
+	for _, teamID := range r.TeamIDs {
+		for page := 0; page*w.pageSize < maxChannelsPerTeam; page++ {
+			channels, aerr := w.mapi.GetPublicChannelsForTeam(teamID, page, w.pageSize)
+			if aerr != nil {
+				return nil, aerr
+			}
+			for _, c := range channels {
+				add(c.Id)
+			}
+			if len(channels) < w.pageSize {
+				break
+			}
+		}
+	}
+	for _, id := range r.ChannelIDs {
+		add(id)
+	}
+	return channelIDs, nil
+}
+
+// save saves p to KV store.
+func (w *Warmer) save(p *Progress) error {
+	p.UpdatedAt = model.GetMillis()
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if aerr := w.mapi.KVSet(progressKey, data); aerr != nil {
+		return aerr
+	}
+	return nil
+}
+
+// saveIfCurrent saves p while it's still the running warm-up, it might be stopped or replaced
+// by another one on another node in the meantime. ok is false when p is not saved and walking
+// should be stopped, errors are logged.
+func (w *Warmer) saveIfCurrent(p *Progress) (ok bool) {
+	current, aerr := w.mapi.KVGet(progressKey)
+	if aerr != nil {
+		w.mapi.LogError("cannot get warm-up progress", "err", aerr.Error())
+		return false
+	}
+	var c Progress
+	if err := json.Unmarshal(current, &c); err != nil || c.ID != p.ID || c.State != StateRunning {
+		return false
+	}
+	p.UpdatedAt = model.GetMillis()
+	data, err := json.Marshal(p)
+	if err != nil {
+		w.mapi.LogError("cannot save warm-up progress", "err", err.Error())
+		return false
+	}
+	ok, aerr = w.mapi.KVCompareAndSet(progressKey, current, data)
+	if aerr != nil {
+		w.mapi.LogError("cannot save warm-up progress", "err", aerr.Error())
+		return false
+	}
+	return ok
+}
@@ -0,0 +1,258 @@
+package warmup
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/memkv"
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// converterMock converts files once and keeps them as cached.
+type converterMock struct {
+	mu        sync.Mutex
+	cached    map[string]bool
+	converted chan string
+}
+
+func newConverterMock() *converterMock {
+	return &converterMock{cached: make(map[string]bool), converted: make(chan string, 10)}
+}
+
+func (c *converterMock) WarmUp(fileID string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if fileID == "broken" {
+		return false, errors.New("cannot convert")
+	}
+	if c.cached[fileID] {
+		return false, nil
+	}
+	c.cached[fileID] = true
+	c.converted <- fileID
+	return true, nil
+}
+
+// newTestWarmer creates a Warmer with small pages that only converts docx files.
+func newTestWarmer(api *memkv.API, converter Converter) *Warmer {
+	w := New(api, converter, SupportedOption(func(ext string) bool { return ext == "docx" }), RateOption(60))
+	w.pageSize = 2
+	return w
+}
+
+// wait waits until the running warm-up of w is finished.
+func wait(t *testing.T, w *Warmer) {
+	w.mu.Lock()
+	done := w.done
+	w.mu.Unlock()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("warm-up is not finished")
+	}
+}
+
+// mockPosts mocks two pages of posts in channel c1, a single post before since and one after until.
+func mockPosts(api *memkv.API) {
+	api.On("GetPostsForChannel", "c1", 0, 2).Return(&model.PostList{
+		Order: []string{"p4", "p3"},
+		Posts: map[string]*model.Post{
+			"p4": {Id: "p4", CreateAt: 400, FileIds: []string{"f4"}},
+			"p3": {Id: "p3", CreateAt: 300, FileIds: []string{"f3", "broken"}},
+		},
+	}, nil)
+	api.On("GetPostsBefore", "c1", "p3", 0, 2).Return(&model.PostList{
+		Order: []string{"p2", "p1"},
+		Posts: map[string]*model.Post{
+			"p2": {Id: "p2", CreateAt: 200, FileIds: []string{"f2"}},
+			"p1": {Id: "p1", CreateAt: 100, FileIds: []string{"f1"}},
+		},
+	}, nil)
+	api.On("GetFileInfo", "f3").Return(&model.FileInfo{Id: "f3", Extension: "docx"}, nil)
+	api.On("GetFileInfo", "broken").Return(&model.FileInfo{Id: "broken", Extension: "docx"}, nil)
+	api.On("GetFileInfo", "f2").Return(&model.FileInfo{Id: "f2", Extension: "txt"}, nil)
+	api.On("LogWarn", "cannot warm up PDF", "fileId", "broken", "err", "cannot convert")
+}
+
+func TestWarmUp(t *testing.T) {
+	api := memkv.New()
+	converter := newConverterMock()
+	w := newTestWarmer(api, converter)
+	var waited []time.Duration
+	w.after = func(d time.Duration) <-chan time.Time {
+		waited = append(waited, d)
+		return time.After(0)
+	}
+	mockPosts(api)
+	api.On("GetPublicChannelsForTeam", "t1", 0, 2).Once().Return([]*model.Channel{{Id: "c1"}}, nil)
+	api.On("GetPostsForChannel", "c2", 0, 2).Once().Return(&model.PostList{}, nil)
+
+	p, err := w.Start("admin", Request{TeamIDs: []string{"t1"}, ChannelIDs: []string{"c2", "c1"}, Since: 150, Until: 350})
+	require.NoError(t, err)
+	require.Equal(t, StateRunning, p.State)
+	require.Equal(t, []string{"c1", "c2"}, p.Channels)
+	wait(t, w)
+
+	p, err = w.Status()
+	require.NoError(t, err)
+	require.Equal(t, StateDone, p.State)
+	require.Equal(t, 2, p.ChannelIndex)
+	require.Equal(t, 2, p.Posts)
+	require.Equal(t, 1, p.Converted)
+	require.Equal(t, 1, p.Failed)
+	require.Equal(t, 1, p.Skipped)
+	require.NotZero(t, p.FinishedAt)
+	require.Equal(t, "f3", <-converter.converted)
+	// conversions and failed attempts are throttled.
+	require.Equal(t, []time.Duration{time.Second, time.Second}, waited)
+	api.AssertExpectations(t)
+}
+
+func TestStartRequiresChannels(t *testing.T) {
+	_, err := New(memkv.New(), newConverterMock()).Start("admin", Request{})
+	require.Equal(t, ErrNoChannels, err)
+}
+
+func TestStartWhileRunning(t *testing.T) {
+	api := memkv.New()
+	data, err := json.Marshal(Progress{ID: "1", State: StateRunning})
+	require.NoError(t, err)
+	api.KVSet(progressKey, data)
+	_, err = New(api, newConverterMock()).Start("admin", Request{ChannelIDs: []string{"c1"}})
+	require.Equal(t, ErrRunning, err)
+}
+
+func TestResumeInterrupted(t *testing.T) {
+	api := memkv.New()
+	converter := newConverterMock()
+	w := newTestWarmer(api, converter)
+	w.after = func(time.Duration) <-chan time.Time { return time.After(0) }
+	mockPosts(api)
+
+	// stopped warm-ups are not resumed automatically.
+	stopped := Progress{ID: "1", Request: Request{Since: 150, Until: 350}, State: StateStopped, Channels: []string{"c1"}, BeforePostID: "p3", Posts: 1}
+	data, err := json.Marshal(stopped)
+	require.NoError(t, err)
+	api.KVSet(progressKey, data)
+	require.NoError(t, w.ResumeInterrupted())
+	require.False(t, w.isRunning())
+
+	// interrupted ones continue from their cursor.
+	interrupted := stopped
+	interrupted.State = StateRunning
+	data, err = json.Marshal(interrupted)
+	require.NoError(t, err)
+	api.KVSet(progressKey, data)
+	require.NoError(t, w.ResumeInterrupted())
+	wait(t, w)
+	p, err := w.Status()
+	require.NoError(t, err)
+	require.Equal(t, StateDone, p.State)
+	require.Equal(t, 2, p.Posts)
+	require.Equal(t, 1, p.Skipped)
+	require.Zero(t, p.Converted)
+	api.AssertNotCalled(t, "GetPostsForChannel", "c1", 0, 2)
+}
+
+func TestStopAndResume(t *testing.T) {
+	api := memkv.New()
+	converter := newConverterMock()
+	w := newTestWarmer(api, converter)
+	// throttling blocks until warm-up is stopped.
+	w.after = func(time.Duration) <-chan time.Time { return nil }
+	mockPosts(api)
+
+	_, err := w.Start("admin", Request{ChannelIDs: []string{"c1"}, Since: 150, Until: 350})
+	require.NoError(t, err)
+	require.Equal(t, "f3", <-converter.converted)
+	p, err := w.Stop()
+	require.NoError(t, err)
+	require.Equal(t, StateStopped, p.State)
+	require.False(t, w.isRunning())
+	_, err = w.Stop()
+	require.Equal(t, ErrNotFound, err)
+
+	// the unfinished page is walked again after resuming, converted files are already cached.
+	w.after = func(time.Duration) <-chan time.Time { return time.After(0) }
+	p, err = w.Resume()
+	require.NoError(t, err)
+	require.Equal(t, StateRunning, p.State)
+	wait(t, w)
+	p, err = w.Status()
+	require.NoError(t, err)
+	require.Equal(t, StateDone, p.State)
+	require.Equal(t, 1, p.Cached)
+	require.Equal(t, 2, p.Posts)
+
+	_, err = w.Resume()
+	require.Equal(t, ErrNotFound, err)
+	api.AssertCalled(t, "GetPostsForChannel", "c1", 0, 2)
+	api.AssertNumberOfCalls(t, "GetPostsForChannel", 2)
+	api.AssertExpectations(t)
+}
+
+func TestStoppedOnAnotherNode(t *testing.T) {
+	api := memkv.New()
+	converter := newConverterMock()
+	leader := newTestWarmer(api, converter)
+	other := newTestWarmer(api, converter)
+	other.leader = func() bool { return false }
+	converting := make(chan struct{})
+	leader.after = func(time.Duration) <-chan time.Time {
+		<-converting
+		return time.After(0)
+	}
+	mockPosts(api)
+
+	// warm-ups started on other nodes are picked up by the leader.
+	_, err := other.Start("admin", Request{ChannelIDs: []string{"c1"}, Since: 150, Until: 350})
+	require.NoError(t, err)
+	require.False(t, other.isRunning())
+	require.NoError(t, leader.ResumeInterrupted())
+	require.True(t, leader.isRunning())
+
+	// leader stops walking once the warm-up is stopped on another node.
+	p, err := other.Stop()
+	require.NoError(t, err)
+	require.Equal(t, StateStopped, p.State)
+	close(converting)
+	wait(t, leader)
+	p, err = leader.Status()
+	require.NoError(t, err)
+	require.Equal(t, StateStopped, p.State)
+	api.AssertNotCalled(t, "GetPostsBefore", "c1", "p3", 0, 2)
+}
+
+func TestWarmUpMissingFileInfo(t *testing.T) {
+	api := memkv.New()
+	converter := newConverterMock()
+	w := newTestWarmer(api, converter)
+	w.after = func(time.Duration) <-chan time.Time { return time.After(0) }
+	api.On("GetPostsForChannel", "c1", 0, 2).Return(&model.PostList{
+		Order: []string{"p1"},
+		Posts: map[string]*model.Post{"p1": {Id: "p1", CreateAt: 100, FileIds: []string{"f1", "missing", "f2"}}},
+	}, nil)
+	api.On("GetFileInfo", "f1").Return(&model.FileInfo{Id: "f1", Extension: "docx"}, nil)
+	api.On("GetFileInfo", "missing").Return(nil, &model.AppError{Message: "not found"})
+	api.On("GetFileInfo", "f2").Return(&model.FileInfo{Id: "f2", Extension: "docx"}, nil)
+	api.On("LogWarn", "cannot get file info to warm up PDF", "fileId", "missing", "err", mock.Anything).Once()
+
+	_, err := w.Start("admin", Request{ChannelIDs: []string{"c1"}})
+	require.NoError(t, err)
+	wait(t, w)
+
+	// files after the missing one are still converted.
+	p, err := w.Status()
+	require.NoError(t, err)
+	require.Equal(t, StateDone, p.State)
+	require.Equal(t, 2, p.Converted)
+	require.Equal(t, 1, p.Failed)
+	require.Equal(t, "f1", <-converter.converted)
+	require.Equal(t, "f2", <-converter.converted)
+	api.AssertExpectations(t)
+}
@@ -0,0 +1,319 @@
+// Package watermark stamps pages of PDF documents with text watermarks.
+package watermark
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/pdf"
+)
+
+// Position is the position of watermark on a page.
+type Position string
+
+// Positions of watermark.
+const (
+	// Diagonal places watermark across the page from its bottom left to its top right corner.
+	Diagonal Position = "diagonal"
+
+	// Center places watermark horizontally at the center of page.
+	Center Position = "center"
+
+	// Top places watermark at the top margin of page.
+	Top Position = "top"
+
+	// Bottom places watermark at the bottom margin of page.
+	Bottom Position = "bottom"
+)
+
+const (
+	// defaultOpacity is the default opacity of watermarks.
+	defaultOpacity = 0.3
+
+	// maxFontSize is the maximum font size used for watermarks placed at diagonal and center.
+	maxFontSize = 48
+
+	// marginFontSize is the font size used for watermarks placed at top and bottom margins.
+	marginFontSize = 10
+
+	// margin is the distance of top and bottom watermarks to edges of page.
+	margin = 20
+)
+
+const (
+	// fontName is the resource name of watermark's font.
+	fontName = "TopdfWatermarkFont"
+
+	// stateName is the resource name of watermark's graphics state that sets its opacity.
+	stateName = "TopdfWatermarkState"
+)
+
+// Watermark stamps watermarks on PDF documents.
+type Watermark struct {
+	// opacity of watermark text, from 0 (invisible) to 1 (opaque).
+	opacity float64
+
+	// position of watermark on pages.
+	position Position
+}
+
+// New creates a new Watermark with options.
+func New(options ...Option) *Watermark {
+	w := &Watermark{
+		opacity:  defaultOpacity,
+		position: Diagonal,
+	}
+	for _, o := range options {
+		o(w)
+	}
+	return w
+}
+
+// Option used to customize Watermark defaults.
+type Option func(*Watermark)
+
+// OpacityOption sets the opacity of watermarks.
+func OpacityOption(opacity float64) Option {
+	return func(w *Watermark) {
+		w.opacity = opacity
+	}
+}
+
+// PositionOption sets the position of watermarks.
+func PositionOption(position Position) Option {
+	return func(w *Watermark) {
+		w.position = position
+	}
+}
+
+// IsValidPosition checks if position is a known watermark position.
+func IsValidPosition(position Position) bool {
+	switch position {
+	case Diagonal, Center, Top, Bottom:
+		return true
+	}
+	return false
+}
+
+// Stamp stamps every page of document with text and returns the stamped document.
+// original content of document is kept untouched and watermarks are appended to it as an
+// incremental update.
+func (w *Watermark) Stamp(document []byte, text string) (stamped []byte, err error) {
+	doc, err := pdf.Parse(document)
+	if err != nil {
+		return nil, err
+	}
+	if doc.IsEncrypted() {
+		return nil, pdf.ErrEncrypted
+	}
+	pages, err := doc.Pages()
+	if err != nil {
+		return nil, err
+	}
+	u := doc.NewUpdate()
+	font := u.Add(pdf.Dict{
+		"Type":     pdf.Name("Font"),
+		"Subtype":  pdf.Name("Type1"),
+		"BaseFont": pdf.Name("Helvetica"),
+		"Encoding": pdf.Name("WinAnsiEncoding"),
+	})
+	state := u.Add(pdf.Dict{
+		"Type": pdf.Name("ExtGState"),
+		"ca":   w.opacity,
+		"CA":   w.opacity,
+	})
+	// save the graphics state before page's original content and restore it afterwards, so
+	// watermark is not affected by any state left behind by the original content.
+	save := u.Add(&pdf.Stream{Dict: pdf.Dict{}, Data: []byte("q\n")})
+	encoded := encodeWinAnsi(text)
+	for _, page := range pages {
+		box, err := pageBox(doc, page)
+		if err != nil {
+			return nil, err
+		}
+		resources, err := w.resources(doc, page, font, state)
+		if err != nil {
+			return nil, err
+		}
+		contents, err := doc.Resolve(page.Dict["Contents"])
+		if err != nil {
+			return nil, err
+		}
+		// page contents can be a single stream or an array of streams.
+		newContents := pdf.Array{save}
+		switch c := contents.(type) {
+		case pdf.Array:
+			newContents = append(newContents, c...)
+		case *pdf.Stream:
+			newContents = append(newContents, page.Dict["Contents"])
+		}
+		mark := u.Add(&pdf.Stream{Dict: pdf.Dict{}, Data: w.content(box, encoded)})
+		newContents = append(newContents, mark)
+		newPage := page.Dict.Copy()
+		newPage["Resources"] = resources
+		newPage["Contents"] = newContents
+		u.Set(page.Ref, newPage)
+	}
+	return u.Bytes()
+}
+
+// resources creates a copy of page's resources that contains watermark's font and graphics state.
+func (w *Watermark) resources(doc *pdf.Document, page *pdf.Page, font, state pdf.Ref) (pdf.Dict, error) {
+	resources, err := doc.ResolveDict(page.Attr("Resources"))
+	if err != nil {
+		return nil, err
+	}
+	resources = resources.Copy()
+	for _, r := range []struct {
+		category pdf.Name
+		name     pdf.Name
+		ref      pdf.Ref
+	}{
+		{"Font", fontName, font},
+		{"ExtGState", stateName, state},
+	} {
+		category, err := doc.ResolveDict(resources[r.category])
+		if err != nil {
+			return nil, err
+		}
+		category = category.Copy()
+		category[r.name] = r.ref
+		resources[r.category] = category
+	}
+	return resources, nil
+}
+
+// content creates a content stream that draws text at watermark's position inside box.
+// it starts by restoring the graphics state saved before page's original content.
+func (w *Watermark) content(box [4]float64, text []byte) []byte {
+	var (
+		llx, lly   = box[0], box[1]
+		width      = box[2] - box[0]
+		height     = box[3] - box[1]
+		textWidth  = textWidth(text)
+		size       float64
+		angle      float64
+		cx, cy     float64
+		fitToWidth float64
+	)
+	switch w.position {
+	case Top, Bottom:
+		size = marginFontSize
+		fitToWidth = width - 2*margin
+		cx = llx + width/2
+		cy = lly + margin
+		if w.position == Top {
+			cy = lly + height - margin - size
+		}
+	case Center:
+		size = maxFontSize
+		fitToWidth = width * 0.8
+		cx, cy = llx+width/2, lly+height/2
+	default:
+		size = maxFontSize
+		angle = math.Atan2(height, width)
+		fitToWidth = math.Hypot(width, height) * 0.8
+		cx, cy = llx+width/2, lly+height/2
+	}
+	// shrink font size until text fits into the available space.
+	if textWidth > 0 && textWidth*size > fitToWidth {
+		size = fitToWidth / textWidth
+	}
+	sin, cos := math.Sincos(angle)
+	// move the text to left by half of its width and down by half of its height along its
+	// baseline so it's centered around (cx, cy).
+	halfWidth := textWidth * size / 2
+	halfHeight := size * 0.35
+	x := cx - halfWidth*cos + halfHeight*sin
+	y := cy - halfWidth*sin - halfHeight*cos
+	if w.position == Top || w.position == Bottom {
+		y = cy
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Q\nq /%s gs 0.5 g BT /%s %.2f Tf %.4f %.4f %.4f %.4f %.2f %.2f Tm (",
+		stateName, fontName, size, cos, sin, -sin, cos, x, y)
+	for _, c := range text {
+		switch {
+		case c == '(' || c == ')' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteString(") Tj ET Q\n")
+	return []byte(b.String())
+}
+
+// pageBox gets the visible area of page.
+func pageBox(doc *pdf.Document, page *pdf.Page) (box [4]float64, err error) {
+	// default to US Letter when a page has no boxes at all.
+	box = [4]float64{0, 0, 612, 792}
+	for _, key := range []pdf.Name{"MediaBox", "CropBox"} {
+		o, err := doc.Resolve(page.Attr(key))
+		if err != nil {
+			return box, err
+		}
+		a, ok := o.(pdf.Array)
+		if !ok || len(a) != 4 {
+			continue
+		}
+		var b [4]float64
+		valid := true
+		for i, v := range a {
+			v, err := doc.Resolve(v)
+			if err != nil {
+				return box, err
+			}
+			if b[i], ok = pdf.Number(v); !ok {
+				valid = false
+			}
+		}
+		if valid {
+			box = [4]float64{math.Min(b[0], b[2]), math.Min(b[1], b[3]), math.Max(b[0], b[2]), math.Max(b[1], b[3])}
+		}
+	}
+	return box, nil
+}
+
+// encodeWinAnsi encodes text with WinAnsiEncoding used by watermark's font.
+// characters that cannot be encoded are replaced with '?'.
+func encodeWinAnsi(text string) []byte {
+	b := make([]byte, 0, utf8.RuneCountInString(text))
+	for _, r := range text {
+		switch {
+		case r >= ' ' && r <= '~', r >= 0xA0 && r <= 0xFF:
+			b = append(b, byte(r))
+		default:
+			b = append(b, '?')
+		}
+	}
+	return b
+}
+
+// helveticaWidths are the widths of Helvetica's printable ASCII characters in 1/1000 units.
+var helveticaWidths = [...]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278, // ' ' - '/'
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556, // '0' - '?'
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778, // '@' - 'O'
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556, // 'P' - '_'
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556, // '`' - 'o'
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584, // 'p' - '~'
+}
+
+// textWidth calculates the width of text for a font size of 1.
+func textWidth(text []byte) float64 {
+	var w int
+	for _, c := range text {
+		if c >= ' ' && c <= '~' {
+			w += helveticaWidths[c-' ']
+		} else {
+			w += 556
+		}
+	}
+	return float64(w) / 1000
+}
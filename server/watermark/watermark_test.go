@@ -0,0 +1,75 @@
+package watermark
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/pdf"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStamp(t *testing.T) {
+	for _, name := range []string{"classic.pdf", "compressed.pdf"} {
+		t.Run(name, func(t *testing.T) {
+			data, err := ioutil.ReadFile("../pdf/testdata/" + name)
+			require.NoError(t, err)
+			w := New(OpacityOption(0.5), PositionOption(Bottom))
+			stamped, err := w.Stamp(data, "@john · (secret)")
+			require.NoError(t, err)
+			// original document is kept as is.
+			require.True(t, bytes.HasPrefix(stamped, data))
+
+			doc, err := pdf.Parse(stamped)
+			require.NoError(t, err)
+			pages, err := doc.Pages()
+			require.NoError(t, err)
+			for _, page := range pages {
+				contents, ok := page.Dict["Contents"].(pdf.Array)
+				require.True(t, ok)
+				mark, err := doc.Resolve(contents[len(contents)-1])
+				require.NoError(t, err)
+				require.Contains(t, string(mark.(*pdf.Stream).Data), `(@john \267 \(secret\)) Tj`)
+
+				resources, err := doc.ResolveDict(page.Dict["Resources"])
+				require.NoError(t, err)
+				fonts, err := doc.ResolveDict(resources["Font"])
+				require.NoError(t, err)
+				require.Contains(t, fonts, pdf.Name(fontName))
+				states, err := doc.ResolveDict(resources["ExtGState"])
+				require.NoError(t, err)
+				state, err := doc.ResolveDict(states[stateName])
+				require.NoError(t, err)
+				require.Equal(t, 0.5, state["ca"])
+			}
+		})
+	}
+}
+
+func TestStampKeepsExistingResources(t *testing.T) {
+	data, err := ioutil.ReadFile("../pdf/testdata/classic.pdf")
+	require.NoError(t, err)
+	stamped, err := New().Stamp(data, "text")
+	require.NoError(t, err)
+	doc, err := pdf.Parse(stamped)
+	require.NoError(t, err)
+	pages, err := doc.Pages()
+	require.NoError(t, err)
+	fonts, err := doc.ResolveDict(pages[0].Dict["Resources"].(pdf.Dict)["Font"])
+	require.NoError(t, err)
+	require.Equal(t, pdf.Ref{Num: 5}, fonts["F1"])
+}
+
+func TestStampInvalidDocument(t *testing.T) {
+	_, err := New().Stamp([]byte("not a pdf"), "text")
+	require.Error(t, err)
+}
+
+func TestContentPositions(t *testing.T) {
+	box := [4]float64{0, 0, 600, 800}
+	text := []byte("abc")
+	require.Contains(t, string(New(PositionOption(Center)).content(box, text)), " 1.0000 0.0000 -0.0000 1.0000 ")
+	require.Contains(t, string(New(PositionOption(Diagonal)).content(box, text)), " 0.6000 0.8000 -0.8000 0.6000 ")
+	require.Contains(t, string(New(PositionOption(Top)).content(box, text)), " 770.00 Tm")
+	require.Contains(t, string(New(PositionOption(Bottom)).content(box, text)), " 20.00 Tm")
+}
@@ -0,0 +1,160 @@
+// Package webhook issues signed, one-time tokens that authenticate webhook requests made to the plugin.
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin"
+)
+
+const (
+	// keyPrefix used as a prefix for all webhook keys in KV store.
+	keyPrefix = "webhook:"
+
+	// secretKey is the KV key of the secret that tokens are signed with.
+	// it's kept in KV store so tokens issued by any plugin instance can be redeemed by the others.
+	secretKey = keyPrefix + "secret"
+
+	// tokenKeyPrefix used as a prefix for keys of issued tokens.
+	tokenKeyPrefix = keyPrefix + "token:"
+
+	// secretSize is the size of secret in bytes.
+	secretSize = 32
+
+	// defaultTTL is the default duration that tokens can be redeemed in.
+	defaultTTL = time.Hour
+)
+
+// ErrInvalidToken returned when a token is not signed by Tokens, it's expired or already redeemed.
+var ErrInvalidToken = errors.New("invalid webhook token")
+
+// Tokens issues and redeems webhook tokens.
+type Tokens struct {
+	// mapi is Mattermost's Plugin API.
+	mapi plugin.API
+
+	// ttl is the duration that tokens can be redeemed in.
+	ttl time.Duration
+
+	// secret is the cached secret that tokens are signed with.
+	secret []byte
+	mu     sync.Mutex
+}
+
+// Option used to customize Tokens defaults.
+type Option func(*Tokens)
+
+// TTLOption sets the duration that tokens can be redeemed in.
+func TTLOption(ttl time.Duration) Option {
+	return func(t *Tokens) {
+		t.ttl = ttl
+	}
+}
+
+// New creates a new Tokens with mapi and options.
+func New(mapi plugin.API, options ...Option) *Tokens {
+	t := &Tokens{
+		mapi: mapi,
+		ttl:  defaultTTL,
+	}
+	for _, o := range options {
+		o(t)
+	}
+	return t
+}
+
+// Issue issues a new token for id, id is given back when token is redeemed.
+func (t *Tokens) Issue(id string) (token string, err error) {
+	secret, err := t.getSecret()
+	if err != nil {
+		return "", err
+	}
+	nonce := model.NewId()
+	if aerr := t.mapi.KVSetWithExpiry(tokenKeyPrefix+nonce, []byte(id), int64(t.ttl/time.Second)); aerr != nil {
+		return "", aerr
+	}
+	return nonce + "." + sign(secret, nonce), nil
+}
+
+// Redeem redeems token and returns the id that it's issued for.
+// a token can only be redeemed once.
+func (t *Tokens) Redeem(token string) (id string, err error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return "", ErrInvalidToken
+	}
+	nonce, signature := parts[0], parts[1]
+	secret, err := t.getSecret()
+	if err != nil {
+		return "", err
+	}
+	if !hmac.Equal([]byte(signature), []byte(sign(secret, nonce))) {
+		return "", ErrInvalidToken
+	}
+	key := tokenKeyPrefix + nonce
+	value, aerr := t.mapi.KVGet(key)
+	if aerr != nil {
+		return "", aerr
+	}
+	if len(value) == 0 {
+		return "", ErrInvalidToken
+	}
+	// only one of the concurrent redeems can empty the token.
+	ok, aerr := t.mapi.KVCompareAndSet(key, value, []byte{})
+	if aerr != nil {
+		return "", aerr
+	}
+	if !ok {
+		return "", ErrInvalidToken
+	}
+	if aerr := t.mapi.KVDelete(key); aerr != nil {
+		t.mapi.LogWarn("cannot delete redeemed webhook token", "error", aerr.Error())
+	}
+	return string(value), nil
+}
+
+// getSecret gets the secret that tokens are signed with, it's created on first use.
+func (t *Tokens) getSecret() ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.secret != nil {
+		return t.secret, nil
+	}
+	secret, aerr := t.mapi.KVGet(secretKey)
+	if aerr != nil {
+		return nil, aerr
+	}
+	if len(secret) == 0 {
+		secret = make([]byte, secretSize)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		ok, aerr := t.mapi.KVCompareAndSet(secretKey, nil, secret)
+		if aerr != nil {
+			return nil, aerr
+		}
+		// another plugin instance created the secret first, use that one.
+		if !ok {
+			if secret, aerr = t.mapi.KVGet(secretKey); aerr != nil {
+				return nil, aerr
+			}
+		}
+	}
+	t.secret = secret
+	return secret, nil
+}
+
+// sign signs nonce with secret.
+func sign(secret []byte, nonce string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(nonce))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
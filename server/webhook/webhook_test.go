@@ -0,0 +1,40 @@
+package webhook
+
+import (
+	"testing"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/memkv"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssueRedeem(t *testing.T) {
+	tokens := New(memkv.New())
+	token, err := tokens.Issue("job-id")
+	require.NoError(t, err)
+	id, err := tokens.Redeem(token)
+	require.NoError(t, err)
+	require.Equal(t, "job-id", id)
+
+	// tokens can only be redeemed once.
+	_, err = tokens.Redeem(token)
+	require.Equal(t, ErrInvalidToken, err)
+}
+
+func TestRedeemSharedSecret(t *testing.T) {
+	api := memkv.New()
+	token, err := New(api).Issue("job-id")
+	require.NoError(t, err)
+	id, err := New(api).Redeem(token)
+	require.NoError(t, err)
+	require.Equal(t, "job-id", id)
+}
+
+func TestRedeemInvalid(t *testing.T) {
+	tokens := New(memkv.New())
+	token, err := tokens.Issue("job-id")
+	require.NoError(t, err)
+	for _, invalid := range []string{"", "nonce", token + "x", "x" + token} {
+		_, err := tokens.Redeem(invalid)
+		require.Equal(t, ErrInvalidToken, err, invalid)
+	}
+}
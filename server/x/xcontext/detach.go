@@ -0,0 +1,35 @@
+// Package xcontext extends context package.
+package xcontext
+
+import (
+	"context"
+	"time"
+)
+
+// Detach returns a context that carries the values of ctx without being canceled with it. it's
+// used for work that should outlive the request that started it, like a conversion that other
+// requests wait for, while keeping request scoped values like loggers and spans.
+func Detach(ctx context.Context) context.Context {
+	return detached{ctx}
+}
+
+// detached is a context with the values of parent that is never canceled.
+type detached struct {
+	parent context.Context
+}
+
+func (detached) Deadline() (deadline time.Time, ok bool) {
+	return time.Time{}, false
+}
+
+func (detached) Done() <-chan struct{} {
+	return nil
+}
+
+func (detached) Err() error {
+	return nil
+}
+
+func (d detached) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
+}
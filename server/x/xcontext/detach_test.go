@@ -0,0 +1,22 @@
+package xcontext
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type key struct{}
+
+func TestDetach(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "value"))
+	detached := Detach(ctx)
+	cancel()
+	require.Error(t, ctx.Err())
+	require.NoError(t, detached.Err())
+	require.Nil(t, detached.Done())
+	require.Equal(t, "value", detached.Value(key{}))
+	_, ok := detached.Deadline()
+	require.False(t, ok)
+}
@@ -0,0 +1,48 @@
+package xhttp
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ContentDisposition creates a Content-Disposition header value with dispositionType, for ex:
+// attachment or inline, for filename.
+// non-ASCII file names are RFC 5987 encoded in filename* parameter and an ASCII fallback is kept
+// in filename parameter for clients that don't support it.
+func ContentDisposition(dispositionType, filename string) string {
+	var fallback strings.Builder
+	ascii := true
+	for _, r := range filename {
+		switch {
+		case r >= utf8.RuneSelf || r < ' ' || r == 0x7f:
+			ascii = false
+			fallback.WriteByte('_')
+		case r == '"' || r == '\\':
+			fallback.WriteByte('\\')
+			fallback.WriteRune(r)
+		default:
+			fallback.WriteRune(r)
+		}
+	}
+	value := fmt.Sprintf("%s; filename=\"%s\"", dispositionType, fallback.String())
+	if !ascii {
+		value += "; filename*=UTF-8''" + encodeRFC5987(filename)
+	}
+	return value
+}
+
+// encodeRFC5987 percent-encodes s as an RFC 5987 ext-value, only attr-chars are kept as is.
+func encodeRFC5987(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+			strings.IndexByte("!#$&+-.^_`|~", c) != -1 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,15 @@
+package xhttp
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentDisposition(t *testing.T) {
+	require.Equal(t, `attachment; filename="report.pdf"`, ContentDisposition("attachment", "report.pdf"))
+	require.Equal(t, `inline; filename="a \"quoted\" name.pdf"`, ContentDisposition("inline", `a "quoted" name.pdf`))
+	require.Equal(t, `attachment; filename="__ rapor.pdf"; filename*=UTF-8''%C3%A7%C3%B6%20rapor.pdf`,
+		ContentDisposition("attachment", "çö rapor.pdf"))
+	require.Equal(t, `attachment; filename="a_b.pdf"; filename*=UTF-8''a%0Ab.pdf`, ContentDisposition("attachment", "a\nb.pdf"))
+}
@@ -0,0 +1,64 @@
+package xhttp
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseCIDRs([]string{"10.0.0.0/24", "192.168.1.1"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		realIP     string
+		forwarded  string
+		ip         string
+	}{
+		{"direct", "10.0.0.1:1234", "", "", "10.0.0.1"},
+		{"untrusted remote with headers", "203.0.113.1:1234", "10.0.0.2", "10.0.0.3", "203.0.113.1"},
+		{"trusted remote with real IP", "10.0.0.1:1234", "203.0.113.2", "", "203.0.113.2"},
+		{"trusted remote with forwarded", "10.0.0.1:1234", "203.0.113.2", "203.0.113.3", "203.0.113.3"},
+		{"spoofed forwarded", "10.0.0.1:1234", "", "1.1.1.1, 203.0.113.3, 192.168.1.1", "203.0.113.3"},
+		{"all hops trusted", "10.0.0.1:1234", "", "10.0.0.5, 10.0.0.6", "10.0.0.5"},
+		{"remote without port", "203.0.113.1", "", "10.0.0.3", "203.0.113.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.realIP != "" {
+				r.Header.Set("X-Real-IP", tt.realIP)
+			}
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			require.Equal(t, tt.ip, ClientIP(r, trusted))
+		})
+	}
+}
+
+func TestClientIPNoTrustedProxies(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Real-IP", "10.0.0.2")
+	r.Header.Set("X-Forwarded-For", "10.0.0.3")
+	require.Equal(t, "10.0.0.1", ClientIP(r, nil))
+}
+
+func TestParseCIDRs(t *testing.T) {
+	nets, err := ParseCIDRs([]string{"10.0.0.0/8", "192.168.1.1", "::1"})
+	require.NoError(t, err)
+	require.Len(t, nets, 3)
+	require.Equal(t, "10.0.0.0/8", nets[0].String())
+	require.Equal(t, "192.168.1.1/32", nets[1].String())
+	require.Equal(t, "::1/128", nets[2].String())
+
+	_, err = ParseCIDRs([]string{"not-an-ip"})
+	require.Error(t, err)
+	_, err = ParseCIDRs([]string{"10.0.0.0/33"})
+	require.Error(t, err)
+}
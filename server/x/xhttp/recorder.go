@@ -0,0 +1,40 @@
+package xhttp
+
+import "net/http"
+
+// StatusRecorder is an http.ResponseWriter that records the status code and the number of body
+// bytes written to the underlying http.ResponseWriter.
+type StatusRecorder struct {
+	http.ResponseWriter
+
+	// Status is the status code of response, it's 200 when it's not explicitly written.
+	Status int
+
+	// Bytes is the number of body bytes written.
+	Bytes int64
+}
+
+// NewStatusRecorder creates a new StatusRecorder for w.
+func NewStatusRecorder(w http.ResponseWriter) *StatusRecorder {
+	return &StatusRecorder{ResponseWriter: w, Status: http.StatusOK}
+}
+
+// WriteHeader records status and writes it to the underlying http.ResponseWriter.
+func (r *StatusRecorder) WriteHeader(status int) {
+	r.Status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the length of data and writes it to the underlying http.ResponseWriter.
+func (r *StatusRecorder) Write(data []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(data)
+	r.Bytes += int64(n)
+	return n, err
+}
+
+// Flush flushes the underlying http.ResponseWriter if it supports flushing.
+func (r *StatusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
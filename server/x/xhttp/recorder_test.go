@@ -0,0 +1,22 @@
+package xhttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusRecorder(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := NewStatusRecorder(w)
+	require.Equal(t, http.StatusOK, r.Status)
+	r.WriteHeader(http.StatusNotFound)
+	r.Write([]byte("not "))
+	r.Write([]byte("found"))
+	require.Equal(t, http.StatusNotFound, r.Status)
+	require.Equal(t, int64(9), r.Bytes)
+	require.Equal(t, http.StatusNotFound, w.Code)
+	require.Equal(t, "not found", w.Body.String())
+}
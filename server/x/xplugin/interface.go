@@ -5,3 +5,7 @@ import "github.com/mattermost/mattermost-server/plugin"
 type API interface {
 	plugin.API
 }
+
+type Helpers interface {
+	plugin.Helpers
+}
@@ -0,0 +1,96 @@
+// Package memkv provides a plugin API with an in-memory KV store for tests.
+package memkv
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/x/xplugin/mocks"
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// API is a mocked plugin API with a working in-memory KV store.
+// calls to non-KV methods are handled by the embedded mock.
+type API struct {
+	*mocks.API
+
+	mu   sync.Mutex
+	data map[string][]byte
+}
+
+// New creates a new API with an empty KV store.
+func New() *API {
+	return &API{
+		API:  &mocks.API{},
+		data: make(map[string][]byte),
+	}
+}
+
+// KVGet gets the value of key, nil is returned for missing keys.
+func (a *API) KVGet(key string) ([]byte, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.data[key], nil
+}
+
+// KVSet sets the value of key.
+func (a *API) KVSet(key string, value []byte) *model.AppError {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.data[key] = value
+	return nil
+}
+
+// KVSetWithExpiry sets the value of key, expiry is ignored.
+func (a *API) KVSetWithExpiry(key string, value []byte, expireInSeconds int64) *model.AppError {
+	return a.KVSet(key, value)
+}
+
+// KVCompareAndSet sets key to newValue when its current value is oldValue.
+// a nil oldValue requires key to be missing.
+func (a *API) KVCompareAndSet(key string, oldValue, newValue []byte) (bool, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	current, ok := a.data[key]
+	if oldValue == nil && ok || oldValue != nil && string(current) != string(oldValue) {
+		return false, nil
+	}
+	a.data[key] = newValue
+	return true, nil
+}
+
+// KVDelete deletes key.
+func (a *API) KVDelete(key string) *model.AppError {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.data, key)
+	return nil
+}
+
+// KVDeleteAll deletes all keys.
+func (a *API) KVDeleteAll() *model.AppError {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.data = make(map[string][]byte)
+	return nil
+}
+
+// KVList lists keys in sorted order with paging.
+func (a *API) KVList(page, perPage int) ([]string, *model.AppError) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	keys := make([]string, 0, len(a.data))
+	for key := range a.data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	start := page * perPage
+	if start >= len(keys) {
+		return []string{}, nil
+	}
+	end := start + perPage
+	if end > len(keys) {
+		end = len(keys)
+	}
+	return keys[start:end], nil
+}
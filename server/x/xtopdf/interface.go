@@ -1,8 +1,26 @@
 package xplugin
 
-import "io"
+import (
+	"context"
+	"io"
+
+	"github.com/ilgooz/mattermost-plugin-topdf/server/topdf"
+)
 
 type TOPDF interface {
-	CheckServerStatus() (err error)
-	GetPDF(userID, fileID string) (pdf io.ReadCloser, err error)
+	CheckServerStatus(ctx context.Context) (err error)
+	Authorize(ctx context.Context, userID, fileID string) (err error)
+	Admit(ctx context.Context, userID, fileID string) (admitted context.Context, err error)
+	GetPDF(ctx context.Context, userID, fileID, clientIP string) (pdf *topdf.PDF, err error)
+	GetPDFFile(ctx context.Context, userID, fileID string) (file *topdf.PDFFile, err error)
+	GetPublicPDF(ctx context.Context, fileID, hash, clientIP string) (pdf *topdf.PDF, err error)
+	ConvertToWebhook(ctx context.Context, userID, fileID, clientIP, webhookURL string) (cached bool, err error)
+	SavePDF(ctx context.Context, fileID string, pdf io.Reader) (err error)
+	InvalidateCache() (generation int64, err error)
+	WarmUp(fileID string) (converted bool, err error)
+	CacheEntries(filter topdf.CacheFilter, page, perPage int) (entries []topdf.CachedPDF, totals topdf.CacheTotals, err error)
+	GetCacheEntry(key string) (entry topdf.CachedPDF, err error)
+	VerifyCacheEntry(key string) (pages int, err error)
+	DeleteCacheEntry(key string) error
+	EvictStaleCache() (evicted int, err error)
 }
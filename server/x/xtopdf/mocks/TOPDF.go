@@ -4,21 +4,46 @@
 
 package mocks
 
+import context "context"
 import io "io"
 import mock "github.com/stretchr/testify/mock"
+import topdf "github.com/ilgooz/mattermost-plugin-topdf/server/topdf"
 
 // TOPDF is an autogenerated mock type for the TOPDF type
 type TOPDF struct {
 	mock.Mock
 }
 
-// CheckServerStatus provides a mock function with given fields:
-func (_m *TOPDF) CheckServerStatus() error {
-	ret := _m.Called()
+// Admit provides a mock function with given fields: ctx, userID, fileID
+func (_m *TOPDF) Admit(ctx context.Context, userID string, fileID string) (context.Context, error) {
+	ret := _m.Called(ctx, userID, fileID)
+
+	var r0 context.Context
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) context.Context); ok {
+		r0 = rf(ctx, userID, fileID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(context.Context)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, userID, fileID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Authorize provides a mock function with given fields: ctx, userID, fileID
+func (_m *TOPDF) Authorize(ctx context.Context, userID string, fileID string) error {
+	ret := _m.Called(ctx, userID, fileID)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func() error); ok {
-		r0 = rf()
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, userID, fileID)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -26,22 +51,266 @@ func (_m *TOPDF) CheckServerStatus() error {
 	return r0
 }
 
-// GetPDF provides a mock function with given fields: userID, fileID
-func (_m *TOPDF) GetPDF(userID string, fileID string) (io.ReadCloser, error) {
-	ret := _m.Called(userID, fileID)
+// CacheEntries provides a mock function with given fields: filter, page, perPage
+func (_m *TOPDF) CacheEntries(filter topdf.CacheFilter, page int, perPage int) ([]topdf.CachedPDF, topdf.CacheTotals, error) {
+	ret := _m.Called(filter, page, perPage)
 
-	var r0 io.ReadCloser
-	if rf, ok := ret.Get(0).(func(string, string) io.ReadCloser); ok {
-		r0 = rf(userID, fileID)
+	var r0 []topdf.CachedPDF
+	if rf, ok := ret.Get(0).(func(topdf.CacheFilter, int, int) []topdf.CachedPDF); ok {
+		r0 = rf(filter, page, perPage)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(io.ReadCloser)
+			r0 = ret.Get(0).([]topdf.CachedPDF)
 		}
 	}
 
+	var r1 topdf.CacheTotals
+	if rf, ok := ret.Get(1).(func(topdf.CacheFilter, int, int) topdf.CacheTotals); ok {
+		r1 = rf(filter, page, perPage)
+	} else {
+		r1 = ret.Get(1).(topdf.CacheTotals)
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(topdf.CacheFilter, int, int) error); ok {
+		r2 = rf(filter, page, perPage)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// CheckServerStatus provides a mock function with given fields: ctx
+func (_m *TOPDF) CheckServerStatus(ctx context.Context) error {
+	ret := _m.Called(ctx)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context) error); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ConvertToWebhook provides a mock function with given fields: ctx, userID, fileID, clientIP, webhookURL
+func (_m *TOPDF) ConvertToWebhook(ctx context.Context, userID string, fileID string, clientIP string, webhookURL string) (bool, error) {
+	ret := _m.Called(ctx, userID, fileID, clientIP, webhookURL)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string, string) bool); ok {
+		r0 = rf(ctx, userID, fileID, clientIP, webhookURL)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, string, string) error); ok {
+		r1 = rf(ctx, userID, fileID, clientIP, webhookURL)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DeleteCacheEntry provides a mock function with given fields: key
+func (_m *TOPDF) DeleteCacheEntry(key string) error {
+	ret := _m.Called(key)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(key)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// EvictStaleCache provides a mock function with given fields:
+func (_m *TOPDF) EvictStaleCache() (int, error) {
+	ret := _m.Called()
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func() int); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetCacheEntry provides a mock function with given fields: key
+func (_m *TOPDF) GetCacheEntry(key string) (topdf.CachedPDF, error) {
+	ret := _m.Called(key)
+
+	var r0 topdf.CachedPDF
+	if rf, ok := ret.Get(0).(func(string) topdf.CachedPDF); ok {
+		r0 = rf(key)
+	} else {
+		r0 = ret.Get(0).(topdf.CachedPDF)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(key)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetPDF provides a mock function with given fields: ctx, userID, fileID, clientIP
+func (_m *TOPDF) GetPDF(ctx context.Context, userID string, fileID string, clientIP string) (*topdf.PDF, error) {
+	ret := _m.Called(ctx, userID, fileID, clientIP)
+
+	var r0 *topdf.PDF
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) *topdf.PDF); ok {
+		r0 = rf(ctx, userID, fileID, clientIP)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*topdf.PDF)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, string) error); ok {
+		r1 = rf(ctx, userID, fileID, clientIP)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetPDFFile provides a mock function with given fields: ctx, userID, fileID
+func (_m *TOPDF) GetPDFFile(ctx context.Context, userID string, fileID string) (*topdf.PDFFile, error) {
+	ret := _m.Called(ctx, userID, fileID)
+
+	var r0 *topdf.PDFFile
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *topdf.PDFFile); ok {
+		r0 = rf(ctx, userID, fileID)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*topdf.PDFFile)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, userID, fileID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetPublicPDF provides a mock function with given fields: ctx, fileID, hash, clientIP
+func (_m *TOPDF) GetPublicPDF(ctx context.Context, fileID string, hash string, clientIP string) (*topdf.PDF, error) {
+	ret := _m.Called(ctx, fileID, hash, clientIP)
+
+	var r0 *topdf.PDF
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) *topdf.PDF); ok {
+		r0 = rf(ctx, fileID, hash, clientIP)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*topdf.PDF)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, string) error); ok {
+		r1 = rf(ctx, fileID, hash, clientIP)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// InvalidateCache provides a mock function with given fields:
+func (_m *TOPDF) InvalidateCache() (int64, error) {
+	ret := _m.Called()
+
+	var r0 int64
+	if rf, ok := ret.Get(0).(func() int64); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SavePDF provides a mock function with given fields: ctx, fileID, pdf
+func (_m *TOPDF) SavePDF(ctx context.Context, fileID string, pdf io.Reader) error {
+	ret := _m.Called(ctx, fileID, pdf)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, io.Reader) error); ok {
+		r0 = rf(ctx, fileID, pdf)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// VerifyCacheEntry provides a mock function with given fields: key
+func (_m *TOPDF) VerifyCacheEntry(key string) (int, error) {
+	ret := _m.Called(key)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(string) int); ok {
+		r0 = rf(key)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(key)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// WarmUp provides a mock function with given fields: fileID
+func (_m *TOPDF) WarmUp(fileID string) (bool, error) {
+	ret := _m.Called(fileID)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(string) bool); ok {
+		r0 = rf(fileID)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
 	var r1 error
-	if rf, ok := ret.Get(1).(func(string, string) error); ok {
-		r1 = rf(userID, fileID)
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(fileID)
 	} else {
 		r1 = ret.Error(1)
 	}